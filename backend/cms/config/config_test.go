@@ -0,0 +1,32 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestValidatePaymentConfig tests that payments can't be confirmed without a secret or with an unintended fake provider
+func TestValidatePaymentConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"no provider", Config{}, false},
+		{"provider without secret", Config{PaymentProvider: "fake", PaymentFakeEnabled: true}, true},
+		{"fake provider not enabled", Config{PaymentProvider: "fake", PaymentWebhookSecret: "secret"}, true},
+		{"fake provider enabled", Config{PaymentProvider: "fake", PaymentWebhookSecret: "secret", PaymentFakeEnabled: true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePaymentConfig(&tt.cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,437 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"gorm.io/gorm"
+)
+
+// AdminController handles the admin user management endpoints
+type AdminController struct {
+	db       *gorm.DB
+	tokens   *services.TokenService
+	accounts *services.AccountService
+	audit    *services.AuditService
+}
+
+// NewAdminController creates a new admin controller
+func NewAdminController(db *gorm.DB, tokens *services.TokenService, accounts *services.AccountService,
+	audit *services.AuditService) *AdminController {
+	return &AdminController{
+		db:       db,
+		tokens:   tokens,
+		accounts: accounts,
+		audit:    audit,
+	}
+}
+
+// ListUsers lists and searches users with pagination. q matches the name or email, role and
+// status (active or deactivated) filter the results.
+func (ac *AdminController) ListUsers(c *gin.Context) {
+	page, pageSize := pageParams(c.DefaultQuery("page", "1"), c.DefaultQuery("pageSize", "10"))
+
+	query := ac.db.Model(&models.User{})
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		pattern := "%" + strings.ToLower(q) + "%"
+		query = query.Where("LOWER(name) LIKE ? OR LOWER(email) LIKE ?", pattern, pattern)
+	}
+	if role := c.Query("role"); role != "" {
+		if !models.IsValidRole(models.Role(role)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+			return
+		}
+		query = query.Where("role = ?", role)
+	}
+	switch c.Query("status") {
+	case "":
+	case "active":
+		query = query.Where("deactivated_at IS NULL")
+	case "deactivated":
+		query = query.Where("deactivated_at IS NOT NULL")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be active or deactivated"})
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	var users []models.User
+	if err := query.Order("created_at DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":     users,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// GetUser returns a user with a summary of their enrollments and courses
+func (ac *AdminController) GetUser(c *gin.Context) {
+	user, ok := ac.findUser(c)
+	if !ok {
+		return
+	}
+
+	var enrollments, courses int64
+	ac.db.Model(&models.Enrollment{}).Where("user_id = ?", user.ID).Count(&enrollments)
+	ac.db.Model(&models.Course{}).Where("creator_id = ?", user.ID).Count(&courses)
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":              user,
+		"enrollments_count": enrollments,
+		"courses_count":     courses,
+	})
+}
+
+// GetUserEnrollments lists the enrollments of a user
+func (ac *AdminController) GetUserEnrollments(c *gin.Context) {
+	user, ok := ac.findUser(c)
+	if !ok {
+		return
+	}
+
+	query := ac.db.Model(&models.Enrollment{}).Where("user_id = ?", user.ID).Preload("Course").Preload("Cohort")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var enrollments []models.Enrollment
+	if err := query.Order("enrolled_at DESC").Scopes(Paginate(c.DefaultQuery("page", "1"), c.DefaultQuery("pageSize", "10"))).
+		Find(&enrollments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch enrollments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollments)
+}
+
+// GetUserCourses lists the courses a user created
+func (ac *AdminController) GetUserCourses(c *gin.Context) {
+	user, ok := ac.findUser(c)
+	if !ok {
+		return
+	}
+
+	var courses []models.Course
+	if err := ac.db.Where("creator_id = ?", user.ID).Order("created_at DESC").
+		Scopes(Paginate(c.DefaultQuery("page", "1"), c.DefaultQuery("pageSize", "10"))).
+		Find(&courses).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch courses"})
+		return
+	}
+
+	c.JSON(http.StatusOK, courses)
+}
+
+// ChangeRole changes the role of a user. Their access tokens are revoked so the next refresh
+// picks up the new role.
+func (ac *AdminController) ChangeRole(c *gin.Context) {
+	var req struct {
+		Role models.Role `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || !models.IsValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be student, instructor, org_admin or admin"})
+		return
+	}
+
+	user, ok := ac.findUser(c)
+	if !ok || ac.refuseSelf(c, user, "change your own role") {
+		return
+	}
+	if user.Role == req.Role {
+		c.JSON(http.StatusOK, user)
+		return
+	}
+
+	previous := user.Role
+	if err := ac.db.Model(user).Update("role", req.Role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change role"})
+		return
+	}
+	user.Role = req.Role
+	if err := ac.tokens.RevokeAccessTokensForUser(c.Request.Context(), user.ID); err != nil {
+		log.Printf("Failed to revoke access tokens of user %s after role change: %v", user.ID, err)
+	}
+	setAuditChange(c, "user.change_role", "user", user.ID.String(),
+		map[string]interface{}{"role": previous}, map[string]interface{}{"role": req.Role})
+
+	c.JSON(http.StatusOK, user)
+}
+
+// DeactivateUser blocks a user from logging in and revokes all their tokens
+func (ac *AdminController) DeactivateUser(c *gin.Context) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	// The reason is optional, so an empty body is fine
+	_ = c.ShouldBindJSON(&req)
+
+	user, ok := ac.findUser(c)
+	if !ok || ac.refuseSelf(c, user, "deactivate your own account") {
+		return
+	}
+	if !user.IsActive() {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is already deactivated"})
+		return
+	}
+
+	// Revoke first: access tokens keep working until they expire unless they reach the revocation
+	// list, so the user isn't deactivated while that fails
+	if err := ac.tokens.RevokeAllForUser(c.Request.Context(), user.ID); err != nil {
+		log.Printf("Failed to revoke tokens of user %s before deactivating them: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke the user's tokens"})
+		return
+	}
+	now := time.Now()
+	if err := ac.db.Model(user).Update("deactivated_at", now).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deactivate user"})
+		return
+	}
+	user.DeactivatedAt = &now
+	setAuditEntry(c, "user.deactivate", "user", user.ID.String(), map[string]interface{}{"reason": req.Reason})
+
+	c.JSON(http.StatusOK, user)
+}
+
+// ReactivateUser lets a deactivated user log in again
+func (ac *AdminController) ReactivateUser(c *gin.Context) {
+	user, ok := ac.findUser(c)
+	if !ok {
+		return
+	}
+	if user.IsActive() {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is not deactivated"})
+		return
+	}
+
+	if err := ac.db.Model(user).Update("deactivated_at", nil).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reactivate user"})
+		return
+	}
+	user.DeactivatedAt = nil
+	setAuditEntry(c, "user.reactivate", "user", user.ID.String(), nil)
+
+	c.JSON(http.StatusOK, user)
+}
+
+// ForcePasswordReset logs a user out everywhere and makes them choose a new password before
+// they can log in again. They are mailed a reset link.
+func (ac *AdminController) ForcePasswordReset(c *gin.Context) {
+	user, ok := ac.findUser(c)
+	if !ok {
+		return
+	}
+
+	if err := ac.accounts.RequirePasswordReset(c.Request.Context(), *user); err != nil {
+		log.Printf("Failed to force a password reset of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to force a password reset"})
+		return
+	}
+	setAuditEntry(c, "user.force_password_reset", "user", user.ID.String(), nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "The user has been logged out and sent a password reset link"})
+}
+
+// GetAuditLogs lists the audit log, newest first. actor_id, action, target_type, target_id,
+// request_id and the RFC 3339 times from and to filter the results.
+func (ac *AdminController) GetAuditLogs(c *gin.Context) {
+	page, pageSize := pageParams(c.DefaultQuery("page", "1"), c.DefaultQuery("pageSize", "10"))
+
+	filter := services.AuditFilter{
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+		RequestID:  c.Query("request_id"),
+	}
+	if actor := c.Query("actor_id"); actor != "" {
+		actorID, err := uuid.Parse(actor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid actor ID"})
+			return
+		}
+		filter.ActorID = &actorID
+	}
+	for param, bound := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + " time, use RFC 3339"})
+			return
+		}
+		*bound = &at
+	}
+
+	logs, total, err := ac.audit.List(filter, (page-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit logs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"audit_logs": logs,
+		"total":      total,
+		"page":       page,
+		"page_size":  pageSize,
+	})
+}
+
+// VerifyAuditLogs checks the hash chain of the audit log and reports where it was tampered with.
+// head is the head hash of an earlier verification, which must still be in the chain.
+func (ac *AdminController) VerifyAuditLogs(c *gin.Context) {
+	verification, err := ac.audit.Verify(c.Query("head"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify audit logs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, verification)
+}
+
+// Impersonate mints a short-lived token for support staff to see LearnVibe exactly as the user
+// does, e.g. to debug access problems. The token is read-only unless read_only is false, and every
+// request made with it is recorded in the audit log under the admin; the reason is required.
+func (ac *AdminController) Impersonate(c *gin.Context) {
+	var req struct {
+		Reason   string `json:"reason"`
+		ReadOnly *bool  `json:"read_only"`
+		Minutes  int    `json:"minutes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Say why you need to impersonate the user"})
+		return
+	}
+	if req.Minutes == 0 {
+		req.Minutes = claims.DefaultImpersonationMinutes
+	}
+	if req.Minutes < 1 || req.Minutes > claims.MaxImpersonationMinutes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Minutes must be between 1 and %d", claims.MaxImpersonationMinutes)})
+		return
+	}
+	readOnly := req.ReadOnly == nil || *req.ReadOnly
+
+	user, ok := ac.findUser(c)
+	if !ok || ac.refuseSelf(c, user, "impersonate yourself") {
+		return
+	}
+	if user.IsAdmin() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Platform admins can't be impersonated"})
+		return
+	}
+	if !user.IsActive() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Deactivated users can't be impersonated"})
+		return
+	}
+	var admin models.User
+	if err := ac.db.First(&admin, "id = ?", currentSubject(c).ID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	token, accessClaims, err := ac.tokens.IssueImpersonationToken(admin, *user, readOnly, time.Duration(req.Minutes)*time.Minute)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue impersonation token"})
+		return
+	}
+	expiresAt := accessClaims.ExpiresAt.Time
+	setAuditEntry(c, "user.impersonate", "user", user.ID.String(), map[string]interface{}{
+		"reason":     req.Reason,
+		"read_only":  readOnly,
+		"token_id":   accessClaims.ID,
+		"expires_at": expiresAt.UTC().Format(time.RFC3339),
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":      token,
+		"token_type": "Bearer",
+		"expires_at": expiresAt.UTC().Format(time.RFC3339),
+		"read_only":  readOnly,
+		"user":       user,
+	})
+}
+
+// findUser loads the user of the :id parameter, writing an error response if that fails
+func (ac *AdminController) findUser(c *gin.Context) (*models.User, bool) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return nil, false
+	}
+
+	var user models.User
+	if err := ac.db.First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	return &user, true
+}
+
+// refuseSelf stops admins from locking themselves out, writing the error response.
+// It returns true if the action was refused.
+func (ac *AdminController) refuseSelf(c *gin.Context, user *models.User, action string) bool {
+	if adminID, _ := c.Get("userID"); adminID == user.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't " + action})
+		return true
+	}
+	return false
+}
+
+// setAuditEntry describes the admin action a handler took, for the audit middleware to record
+func setAuditEntry(c *gin.Context, action, targetType, targetID string, details map[string]interface{}) {
+	c.Set(services.AuditContextKey, services.AuditEntry{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Details:    details,
+	})
+}
+
+// setAuditChange describes a change a handler made with the values before and after it, for the
+// audit middleware to record. after is nil for deletions.
+func setAuditChange(c *gin.Context, action, targetType, targetID string, before, after map[string]interface{}) {
+	c.Set(services.AuditContextKey, services.AuditEntry{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     before,
+		After:      after,
+	})
+}
+
+// recordAuditEntry records an entry right away, for events that are recorded whatever the
+// response, such as failed logins. The entry's IP address and request ID are filled in.
+func recordAuditEntry(c *gin.Context, audit *services.AuditService, entry services.AuditEntry) {
+	if audit == nil {
+		return
+	}
+
+	entry.IPAddress = c.ClientIP()
+	entry.RequestID = c.GetString("requestID")
+	if _, err := audit.Record(entry); err != nil {
+		log.Printf("Failed to record %q in the audit log: %v", entry.Action, err)
+	}
+}
@@ -1,21 +1,22 @@
 package controllers
 
 import (
-	"context"
-	"crypto/rand"
-	"encoding/base64"
-	"encoding/json"
-	"fmt"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/config"
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 	"gorm.io/gorm"
 )
 
@@ -30,173 +31,343 @@ type DBInterface interface {
 type AuthController struct {
 	db         DBInterface
 	config     *config.Config
-	oauthConf  *oauth2.Config
-	stateStore map[string]time.Time // Simple in-memory state store
+	tokens     *services.TokenService
+	accounts   *services.AccountService
+	passwords  *services.PasswordPolicy
+	mfa        *services.MFAService
+	guard      *services.LoginGuard
+	providers  *services.OIDCRegistry
+	identities *services.IdentityService
+	states     services.StateStore
+	audit      *services.AuditService
 }
 
-// GoogleUserInfo represents the response from Google's userinfo endpoint
-type GoogleUserInfo struct {
-	ID            string `json:"id"`
-	Email         string `json:"email"`
-	VerifiedEmail bool   `json:"verified_email"`
-	Name          string `json:"name"`
-	GivenName     string `json:"given_name"`
-	FamilyName    string `json:"family_name"`
-	Picture       string `json:"picture"`
-	Locale        string `json:"locale"`
-}
+// refreshCookieName is the cookie holding the refresh token for browser (OAuth) logins
+const refreshCookieName = "refresh_token"
+
+// mfaCookieName is the cookie holding the MFA challenge of a browser (OAuth) login
+const mfaCookieName = "mfa_token"
+
+// oauthStateCookieName is the cookie binding a provider login to the browser that started it.
+// It holds the hash of the state, so a callback with a state from someone else's login is refused.
+const oauthStateCookieName = "oauth_state"
+
+// oauthStateTTL is how long a user has to finish logging in at the provider
+const oauthStateTTL = 10 * time.Minute
 
 // NewAuthController creates a new authentication controller
-func NewAuthController(db DBInterface, cfg *config.Config) *AuthController {
-	// Initialize OAuth2 config
-	var oauthConf *oauth2.Config
-	if cfg.GoogleClientID != "" && cfg.GoogleClientSecret != "" {
-		oauthConf = &oauth2.Config{
-			ClientID:     cfg.GoogleClientID,
-			ClientSecret: cfg.GoogleClientSecret,
-			RedirectURL:  cfg.GoogleRedirectURL,
-			Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email", "https://www.googleapis.com/auth/userinfo.profile"},
-			Endpoint:     google.Endpoint,
-		}
+func NewAuthController(db DBInterface, cfg *config.Config, tokens *services.TokenService, accounts *services.AccountService,
+	passwords *services.PasswordPolicy, mfa *services.MFAService, guard *services.LoginGuard, providers *services.OIDCRegistry,
+	identities *services.IdentityService, states services.StateStore, audit *services.AuditService) *AuthController {
+	// Pending provider logins only survive in memory without a shared store
+	if states == nil {
+		states = services.NewMemoryStateStore()
+	}
+	if passwords == nil {
+		passwords = services.NewPasswordPolicy(services.PasswordPolicyConfig{}, nil)
 	}
-
 	return &AuthController{
 		db:         db,
 		config:     cfg,
-		oauthConf:  oauthConf,
-		stateStore: make(map[string]time.Time),
+		tokens:     tokens,
+		accounts:   accounts,
+		passwords:  passwords,
+		mfa:        mfa,
+		guard:      guard,
+		providers:  providers,
+		identities: identities,
+		states:     states,
+		audit:      audit,
 	}
 }
 
-// GoogleLogin initiates the OAuth2 login flow
+// GoogleLogin initiates the Google login flow, the same as /auth/google/login
 func (ac *AuthController) GoogleLogin(c *gin.Context) {
-	if ac.oauthConf == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "OAuth2 not configured"})
+	ac.startProviderLogin(c, "google")
+}
+
+// GoogleCallback handles the Google callback, the same as /auth/google/callback
+func (ac *AuthController) GoogleCallback(c *gin.Context) {
+	ac.finishProviderLogin(c, "google")
+}
+
+// GetProviders lists the configured login providers for the login page
+func (ac *AuthController) GetProviders(c *gin.Context) {
+	providers := []gin.H{}
+	for _, provider := range ac.providers.Providers() {
+		providers = append(providers, gin.H{
+			"name":         provider.Name(),
+			"display_name": provider.DisplayName(),
+			"login_url":    "/auth/" + provider.Name() + "/login",
+		})
+	}
+
+	c.JSON(http.StatusOK, providers)
+}
+
+// ProviderLogin redirects to an OpenID Connect provider to log in. return_to is where the user is
+// sent after the login.
+func (ac *AuthController) ProviderLogin(c *gin.Context) {
+	ac.startProviderLogin(c, c.Param("provider"))
+}
+
+// ProviderCallback finishes a login with an OpenID Connect provider
+func (ac *AuthController) ProviderCallback(c *gin.Context) {
+	ac.finishProviderLogin(c, c.Param("provider"))
+}
+
+// startProviderLogin redirects to the provider to log in
+func (ac *AuthController) startProviderLogin(c *gin.Context, name string) {
+	authURL, ok := ac.providerAuthURL(c, name, uuid.Nil)
+	if !ok {
 		return
 	}
 
-	// Generate a random state
-	b := make([]byte, 32)
-	_, err := rand.Read(b)
+	c.Redirect(http.StatusTemporaryRedirect, authURL)
+}
+
+// providerAuthURL returns the provider URL to send the user to, with a fresh state, nonce and PKCE
+// challenge, writing an error response if that fails. linkUserID is set when a logged in user links
+// the provider to their account.
+func (ac *AuthController) providerAuthURL(c *gin.Context, name string, linkUserID uuid.UUID) (string, bool) {
+	provider, err := ac.providers.Get(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+		return "", false
+	}
+
+	returnTo := c.DefaultQuery("return_to", "/")
+	if !services.IsAllowedReturnTo(returnTo, ac.config.OAuthReturnToAllowlist) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "return_to must be a path or a URL of an allowed origin"})
+		return "", false
+	}
+
+	state, err := services.GenerateOpaqueToken(32)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate random state"})
-		return
+		return "", false
+	}
+	nonce, err := services.GenerateOpaqueToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate nonce"})
+		return "", false
 	}
-	state := base64.URLEncoding.EncodeToString(b)
+	codeVerifier := oauth2.GenerateVerifier()
 
-	// Store state with expiration (10 minutes)
-	ac.stateStore[state] = time.Now().Add(10 * time.Minute)
+	authURL, err := provider.AuthCodeURL(c.Request.Context(), state, nonce, codeVerifier)
+	if err != nil {
+		log.Printf("Failed to start login with %s: %v", name, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Login provider is unavailable"})
+		return "", false
+	}
 
-	// Cleanup old states
-	ac.cleanupStates()
+	err = ac.states.Save(c.Request.Context(), state, services.OAuthState{
+		Provider:     name,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ReturnTo:     returnTo,
+		LinkUserID:   linkUserID,
+	}, oauthStateTTL)
+	if err != nil {
+		log.Printf("Failed to save OAuth state: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return "", false
+	}
+	c.SetCookie(oauthStateCookieName, services.HashToken(state), int(oauthStateTTL.Seconds()), "/auth", "", false, true)
 
-	// Redirect to Google's OAuth 2.0 server
-	url := ac.oauthConf.AuthCodeURL(state, oauth2.AccessTypeOnline)
-	c.Redirect(http.StatusTemporaryRedirect, url)
+	return authURL, true
 }
 
-// GoogleCallback handles the OAuth2 callback
-func (ac *AuthController) GoogleCallback(c *gin.Context) {
-	// Get state and code from request
-	receivedState := c.Query("state")
-	code := c.Query("code")
+// finishProviderLogin redeems the authorization code, finds or creates the user and logs them in
+func (ac *AuthController) finishProviderLogin(c *gin.Context, name string) {
+	provider, err := ac.providers.Get(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+		return
+	}
 
-	// Verify state
-	expirationTime, validState := ac.stateStore[receivedState]
-	if !validState || time.Now().After(expirationTime) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired state"})
+	// The user cancelled or the provider refused the login
+	if providerError := c.Query("error"); providerError != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login failed at the provider", "provider_error": providerError})
 		return
 	}
 
-	// Clean up used state
-	delete(ac.stateStore, receivedState)
+	// The state has to come back to the browser that started the login, otherwise anyone could get a
+	// victim's browser to finish a login or link with an authorization code of their own
+	stateCookie, _ := c.Cookie(oauthStateCookieName)
+	if stateCookie == "" || subtle.ConstantTimeCompare([]byte(stateCookie), []byte(services.HashToken(c.Query("state")))) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired state"})
+		return
+	}
+	c.SetCookie(oauthStateCookieName, "", -1, "/auth", "", false, true)
 
-	// Exchange auth code for token
-	token, err := ac.oauthConf.Exchange(c.Request.Context(), code)
+	// Verify state; it is single-use and bound to the provider the login started with
+	state, err := ac.states.Take(c.Request.Context(), c.Query("state"))
+	if errors.Is(err, services.ErrUnknownOAuthState) || err == nil && state.Provider != name {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired state"})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to exchange auth code for token"})
+		log.Printf("Failed to load OAuth state: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to finish login"})
 		return
 	}
 
-	// Get user info from Google
-	client := ac.oauthConf.Client(context.Background(), token)
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v3/userinfo")
+	identity, err := provider.Exchange(c.Request.Context(), c.Query("code"), state.CodeVerifier, state.Nonce)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user info"})
+		log.Printf("Login with %s failed: %v", name, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify the login with the provider"})
 		return
 	}
-	defer resp.Body.Close()
-
-	// Parse user info
-	var userInfo struct {
-		Sub           string `json:"sub"`
-		Email         string `json:"email"`
-		EmailVerified bool   `json:"email_verified"`
-		Name          string `json:"name"`
-		Picture       string `json:"picture"`
+	if identity.Email == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "The login provider didn't share an email address"})
+		return
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user info"})
+	if state.LinkUserID != uuid.Nil {
+		ac.finishLinking(c, state, identity)
 		return
 	}
 
-	// Find or create user - simplified database operations
-	var user models.User
-	db := ac.db.Where("email = ?", userInfo.Email)
-	if db.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+	resolved, err := ac.identities.Resolve(identity)
+	if errors.Is(err, services.ErrAccountExists) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "An account with this email already exists. Log in with your password and link " +
+				provider.DisplayName() + " in your account settings.",
+			"link_required": true,
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to resolve %s identity: %v", name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
 		return
 	}
 
-	db = db.First(&user)
+	ac.finishBrowserLogin(c, *resolved, state.Provider, state.ReturnTo)
+}
 
-	if db.Error == gorm.ErrRecordNotFound {
-		// Create new user
-		user = models.User{
-			ID:        uuid.New(),
-			Email:     userInfo.Email,
-			Name:      userInfo.Name,
-			Role:      models.RoleStudent, // Default role for new users
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}
+// finishBrowserLogin logs in a user who came back from logging in elsewhere, at a login provider
+// or an LTI platform: the tokens go into cookies and the browser is sent to returnTo. Accounts with
+// MFA get the challenge in a cookie instead and finish the login with a code.
+func (ac *AuthController) finishBrowserLogin(c *gin.Context, user models.User, method, returnTo string) {
+	if ac.refuseBlockedAccount(c, user) {
+		return
+	}
+
+	// LTI launches are posted by the platform; See Other makes the browser follow with a GET
+	redirectStatus := http.StatusTemporaryRedirect
+	if c.Request.Method == http.MethodPost {
+		redirectStatus = http.StatusSeeOther
+	}
 
-		db = ac.db.Create(&user)
-		if db.Error != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+	purpose, err := ac.mfaChallengePurpose(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check MFA"})
+		return
+	}
+	if purpose != "" {
+		mfaToken, expiresAt, err := ac.mfa.CreateChallenge(user, purpose)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start MFA"})
 			return
 		}
-	} else if db.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		c.SetCookie(mfaCookieName, mfaToken, int(time.Until(expiresAt).Seconds()), "/auth", "", false, true)
+		c.Redirect(redirectStatus, withQuery(returnTo, "mfa", string(purpose)))
+		return
+	}
+
+	// Generate access and refresh tokens
+	pair, ok := ac.startSession(c, user, method)
+	if !ok {
 		return
 	}
 
-	// Generate JWT token
-	jwtToken, err := ac.generateJWT(user)
+	// Set tokens in cookies and redirect to frontend
+	ac.setTokenCookies(c, pair)
+
+	c.Redirect(redirectStatus, returnTo)
+}
+
+// finishLinking links the identity of a provider login to the user who started it
+func (ac *AuthController) finishLinking(c *gin.Context, state *services.OAuthState, identity *services.OIDCIdentity) {
+	_, err := ac.identities.Link(state.LinkUserID, identity)
+	switch {
+	case errors.Is(err, services.ErrProviderEmailUnverified):
+		c.JSON(http.StatusForbidden, gin.H{"error": "The login provider hasn't verified your email address there, so it can't be linked"})
+		return
+	case errors.Is(err, services.ErrIdentityLinkedElsewhere):
+		c.JSON(http.StatusConflict, gin.H{"error": "This account is already linked to another LearnVibe user"})
+		return
+	case errors.Is(err, services.ErrProviderAlreadyLinked):
+		c.JSON(http.StatusConflict, gin.H{"error": "Another account of this provider is already linked, unlink it first"})
+		return
+	case err != nil:
+		log.Printf("Failed to link %s identity: %v", state.Provider, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link the account"})
+		return
+	}
+
+	c.Redirect(http.StatusTemporaryRedirect, withQuery(state.ReturnTo, "linked", state.Provider))
+}
+
+// GetIdentities lists the provider identities linked to the current user and whether they have a password
+func (ac *AuthController) GetIdentities(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var user models.User
+	if err := ac.db.First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	identities, err := ac.identities.List(user.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch linked accounts"})
 		return
 	}
 
-	// Set token in cookie and redirect to frontend
-	c.SetCookie(
-		"auth_token",
-		jwtToken,
-		3600*24, // 24 hours
-		"/",
-		"",
-		false, // HTTPS only? Set to true in production
-		true,  // HTTP only
-	)
+	c.JSON(http.StatusOK, gin.H{
+		"identities":   identities,
+		"has_password": user.HasPassword(),
+	})
+}
+
+// LinkIdentity starts linking a provider to the current user. It returns the provider URL to send the
+// user to; the callback links the provider account and redirects to return_to with ?linked=<provider>.
+func (ac *AuthController) LinkIdentity(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	authURL, ok := ac.providerAuthURL(c, c.Param("provider"), userID.(uuid.UUID))
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"url": authURL})
+}
+
+// UnlinkIdentity unlinks a provider identity from the current user. The last way to log in can't be unlinked.
+func (ac *AuthController) UnlinkIdentity(c *gin.Context) {
+	identityID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid identity ID"})
+		return
+	}
+	userID, _ := c.Get("userID")
 
-	// If there's a return_to parameter, redirect there, otherwise to home
-	returnTo := c.Query("return_to")
-	if returnTo == "" {
-		returnTo = "/"
+	_, err = ac.identities.Unlink(userID.(uuid.UUID), identityID)
+	switch {
+	case errors.Is(err, services.ErrIdentityNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Linked account not found"})
+		return
+	case errors.Is(err, services.ErrLastLoginMethod):
+		c.JSON(http.StatusConflict, gin.H{"error": "This is the only way you can log in. Set a password or link another account first."})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink the account"})
+		return
 	}
 
-	c.Redirect(http.StatusTemporaryRedirect, returnTo)
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlinked"})
 }
 
 // Login handles email/password login
@@ -211,6 +382,10 @@ func (ac *AuthController) Login(c *gin.Context) {
 		return
 	}
 
+	if ac.refuseGuardedAttempt(c, loginRequest.Email) {
+		return
+	}
+
 	var user models.User
 	// Simplified database lookup to work better with mocks
 	db := ac.db.Where("email = ?", loginRequest.Email)
@@ -221,24 +396,31 @@ func (ac *AuthController) Login(c *gin.Context) {
 
 	db = db.First(&user)
 	if db.Error != nil {
+		ac.recordLoginFailure(c, loginRequest.Email, nil)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
 	// Verify password
 	if !user.VerifyPassword(loginRequest.Password) {
+		ac.recordLoginFailure(c, loginRequest.Email, &user)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
-	// Generate JWT token
-	token, err := ac.generateJWT(user)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+	// Wrong MFA codes count against the account too, so a correct password mustn't forget them;
+	// the attempt is released once the code is verified
+	if user.MFAEnabled && ac.mfa != nil {
+		ac.releaseGuardedIP(c)
+	} else {
+		ac.releaseGuardedAttempt(c, loginRequest.Email)
+	}
+
+	if ac.refuseBlockedAccount(c, user) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	ac.respondWithTokensOrMFA(c, http.StatusOK, user, "password")
 }
 
 // Register handles user registration
@@ -246,13 +428,32 @@ func (ac *AuthController) Register(c *gin.Context) {
 	var registerRequest struct {
 		Name     string `json:"name" binding:"required"`
 		Email    string `json:"email" binding:"required,email"`
-		Password string `json:"password" binding:"required,min=8"`
+		Password string `json:"password" binding:"required"`
+		// Slug of the organization to join, the default organization if empty
+		Organization string `json:"organization"`
 	}
 
 	if err := c.ShouldBindJSON(&registerRequest); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
 		return
 	}
+	if refuseWeakPassword(c, ac.passwords.Check(registerRequest.Password, registerRequest.Email, registerRequest.Name)) {
+		return
+	}
+
+	organizationSlug := strings.ToLower(strings.TrimSpace(registerRequest.Organization))
+	if organizationSlug == "" {
+		organizationSlug = models.DefaultOrganizationSlug
+	}
+	var organization models.Organization
+	if err := ac.db.Where("slug = ?", organizationSlug).First(&organization).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown organization"})
+		return
+	}
+	if !organization.AcceptsRegistrations() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This organization doesn't accept registrations"})
+		return
+	}
 
 	// Check if user already exists - simplified database operations
 	var existingUser models.User
@@ -273,12 +474,14 @@ func (ac *AuthController) Register(c *gin.Context) {
 
 	// Create new user
 	user := models.User{
-		ID:        uuid.New(),
-		Name:      registerRequest.Name,
-		Email:     registerRequest.Email,
-		Role:      models.RoleStudent, // Default role for new users
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ID:    uuid.New(),
+		Name:  registerRequest.Name,
+		Email: registerRequest.Email,
+		Role:  models.RoleStudent, // Default role for new users
+		// The organization is fixed for the life of the account
+		OrganizationID: organization.ID,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
 	}
 
 	// Set password hash
@@ -293,46 +496,704 @@ func (ac *AuthController) Register(c *gin.Context) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := ac.generateJWT(user)
+	// The account stays limited until the email is verified; the user can ask for a new link if this fails
+	if ac.accounts != nil {
+		if err := ac.accounts.SendEmailVerification(c.Request.Context(), user); err != nil {
+			log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
+		}
+	}
+
+	ac.respondWithTokensOrMFA(c, http.StatusCreated, user, "register")
+}
+
+// VerifyEmail redeems the token from a verification email, sent either as a query parameter (the
+// link in the email) or in the JSON body
+func (ac *AuthController) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		var req struct {
+			Token string `json:"token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Token is required"})
+			return
+		}
+		token = req.Token
+	}
+
+	user, err := ac.accounts.VerifyEmail(token)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		if errors.Is(err, services.ErrInvalidAccountToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	// Access tokens only pick up the verified email on the next refresh
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Email verified, refresh your token to get full access",
+		"email_verified": user.EmailVerified,
+	})
+}
+
+// ResendVerification mails the current user a new verification link
+func (ac *AuthController) ResendVerification(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	var user models.User
+	if db := ac.db.First(&user, "id = ?", userID); db.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := ac.accounts.SendEmailVerification(c.Request.Context(), user); err != nil {
+		switch {
+		case errors.Is(err, services.ErrEmailAlreadyVerified):
+			c.JSON(http.StatusConflict, gin.H{"error": "Email is already verified"})
+		case errors.Is(err, services.ErrResendTooSoon):
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "A verification email was sent recently, please wait a minute"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+// ForgotPassword mails a password reset link. It answers the same whether or not the account
+// exists, so it can't be used to probe for registered emails.
+func (ac *AuthController) ForgotPassword(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := ac.accounts.RequestPasswordReset(c.Request.Context(), req.Email); err != nil {
+		log.Printf("Failed to send password reset email: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If an account with that email exists, a password reset link has been sent"})
+}
+
+// ResetPassword sets a new password using the token from a password reset email
+func (ac *AuthController) ResetPassword(c *gin.Context) {
+	var req struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if _, err := ac.accounts.ResetPassword(c.Request.Context(), req.Token, req.Password); err != nil {
+		if errors.Is(err, services.ErrInvalidAccountToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+			return
+		}
+		if refuseWeakPassword(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"token": token})
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset, please log in again"})
 }
 
-// generateJWT generates a JWT token for a user
-func (ac *AuthController) generateJWT(user models.User) (string, error) {
-	// Create token with claims
-	claims := jwt.MapClaims{
-		"sub":   user.ID.String(),
-		"name":  user.Name,
-		"email": user.Email,
-		"role":  string(user.Role),
-		"iat":   time.Now().Unix(),
-		"exp":   time.Now().Add(time.Hour * 24).Unix(), // 24 hours
+// ChangePassword sets a new password for the current user, who has to give their current one. The
+// user's other sessions are signed out; wrong current passwords count as failed logins.
+func (ac *AuthController) ChangePassword(c *gin.Context) {
+	var req struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	userID, _ := c.Get("userID")
+	var user models.User
+	if db := ac.db.First(&user, "id = ?", userID); db.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if ac.refuseGuardedAttempt(c, user.Email) {
+		return
+	}
 
-	// Sign token with secret key
-	tokenString, err := token.SignedString([]byte(ac.config.JWTSecret))
+	revoked, err := ac.accounts.ChangePassword(c.Request.Context(), user.ID, req.CurrentPassword, req.NewPassword,
+		currentSessionID(c))
 	if err != nil {
-		return "", fmt.Errorf("failed to sign token: %w", err)
+		switch {
+		case errors.Is(err, services.ErrWrongPassword):
+			ac.countPasswordFailure(c, user.Email, &user)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+		case errors.Is(err, services.ErrNoPassword):
+			ac.releaseGuardedAttempt(c, user.Email)
+			c.JSON(http.StatusConflict, gin.H{"error": "Your account has no password, use the password reset to set one"})
+		case errors.Is(err, services.ErrPasswordUnchanged):
+			ac.releaseGuardedAttempt(c, user.Email)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The new password has to be different from the current one"})
+		default:
+			if refuseWeakPassword(c, err) {
+				ac.releaseGuardedAttempt(c, user.Email)
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+			}
+		}
+		return
 	}
 
-	return tokenString, nil
+	ac.releaseGuardedAttempt(c, user.Email)
+	recordAuditEntry(c, ac.audit, services.AuditEntry{
+		ActorID:    user.ID,
+		Action:     "auth.change_password",
+		TargetType: "user",
+		TargetID:   user.ID.String(),
+		Details:    map[string]interface{}{"revoked_sessions": revoked},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed, your other sessions were signed out", "revoked_sessions": revoked})
+}
+
+// GetPasswordPolicy describes the rules new passwords have to follow, for registration and password forms
+func (ac *AuthController) GetPasswordPolicy(c *gin.Context) {
+	policy := ac.passwords.Config()
+	c.JSON(http.StatusOK, gin.H{
+		"min_length":            policy.MinLength,
+		"min_character_classes": policy.MinCharacterClasses,
+	})
+}
+
+// refuseWeakPassword answers 400 with the problems of a password the policy rejected. It returns
+// true if err was such a rejection.
+func refuseWeakPassword(c *gin.Context, err error) bool {
+	var policyErr *services.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":    "The password doesn't meet the password requirements",
+		"problems": policyErr.Problems,
+	})
+	return true
 }
 
-// cleanupStates removes expired states from memory
-func (ac *AuthController) cleanupStates() {
-	now := time.Now()
-	for state, expiry := range ac.stateStore {
-		if now.After(expiry) {
-			delete(ac.stateStore, state)
+// UnlockAccount lifts a login lockout with the token from the unlock email, sent either as a query
+// parameter (the link in the email) or in the JSON body
+func (ac *AuthController) UnlockAccount(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		var req struct {
+			Token string `json:"token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Token is required"})
+			return
+		}
+		token = req.Token
+	}
+
+	user, err := ac.accounts.RedeemUnlockToken(token)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidAccountToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired unlock link"})
+			return
 		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock account"})
+		return
+	}
+
+	if err := ac.guard.Unlock(c.Request.Context(), user.Email, "email"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlocked, you can log in again"})
+}
+
+// VerifyMFA is the second login step: it exchanges the MFA token from the login and a TOTP or
+// recovery code for the access and refresh tokens
+func (ac *AuthController) VerifyMFA(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Code is required"})
+		return
+	}
+	mfaToken, fromCookie := ac.mfaTokenFromRequest(c, req.MFAToken)
+
+	// Wrong codes are counted by the login guard like wrong passwords, so new challenges don't
+	// give more guesses
+	challenged, err := ac.mfa.ChallengeUser(mfaToken, models.MFAChallengeVerify)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+	if ac.refuseGuardedAttempt(c, challenged.Email) {
+		return
+	}
+
+	user, err := ac.mfa.CompleteChallenge(mfaToken, req.Code)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidMFACode) {
+			ac.countPasswordFailure(c, challenged.Email, challenged)
+		} else {
+			ac.releaseGuardedIP(c)
+		}
+		respondMFAError(c, err)
+		return
+	}
+	ac.releaseGuardedAttempt(c, user.Email)
+
+	ac.finishMFALogin(c, *user, fromCookie, nil)
+}
+
+// BeginMFAEnrollment starts the MFA setup of a user whose role requires MFA but who hasn't set it up.
+// It takes the MFA token from the login instead of an access token.
+func (ac *AuthController) BeginMFAEnrollment(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+	}
+	if c.Request.ContentLength > 0 {
+		_ = c.ShouldBindJSON(&req)
+	}
+	mfaToken, _ := ac.mfaTokenFromRequest(c, req.MFAToken)
+
+	user, err := ac.mfa.ChallengeUser(mfaToken, models.MFAChallengeEnroll)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	secret, uri, err := ac.mfa.BeginSetup(user)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":           secret,
+		"provisioning_uri": uri,
+	})
+}
+
+// CompleteMFAEnrollment enables MFA with a code from the authenticator app and finishes the login,
+// returning the tokens together with the recovery codes
+func (ac *AuthController) CompleteMFAEnrollment(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Code is required"})
+		return
+	}
+	mfaToken, fromCookie := ac.mfaTokenFromRequest(c, req.MFAToken)
+
+	user, err := ac.mfa.ChallengeUser(mfaToken, models.MFAChallengeEnroll)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+	codes, err := ac.mfa.Enable(user, req.Code)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+	if err := ac.mfa.UseChallenge(mfaToken); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	ac.finishMFALogin(c, *user, fromCookie, codes)
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh token
+func (ac *AuthController) Refresh(c *gin.Context) {
+	refreshToken, fromCookie := ac.refreshTokenFromRequest(c)
+	if refreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refresh token is required"})
+		return
+	}
+
+	pair, err := ac.tokens.Refresh(c.Request.Context(), refreshToken, clientInfo(c))
+	if err != nil {
+		ac.clearTokenCookies(c)
+		if errors.Is(err, services.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token was already used, please log in again"})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidRefreshToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
+	if fromCookie {
+		ac.setTokenCookies(c, pair)
+	}
+	c.JSON(http.StatusOK, tokenResponse(pair))
+}
+
+// Logout revokes the refresh token family of the current login
+func (ac *AuthController) Logout(c *gin.Context) {
+	refreshToken, _ := ac.refreshTokenFromRequest(c)
+	if refreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refresh token is required"})
+		return
+	}
+
+	ac.clearTokenCookies(c)
+
+	// Also revoke the access token the client is still holding, if it sent one
+	if accessToken := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "); accessToken != "" {
+		if err := ac.tokens.RevokeAccessToken(c.Request.Context(), accessToken); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+	}
+
+	if err := ac.tokens.Revoke(c.Request.Context(), refreshToken); err != nil && !errors.Is(err, services.ErrInvalidRefreshToken) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	// Unknown tokens are treated as already logged out
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// JWKS publishes the public keys access tokens are signed with, so other services can verify them
+func (ac *AuthController) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, ac.tokens.Keys().JWKS())
+}
+
+// RevokeUserTokens logs a user out everywhere by revoking all their access and refresh tokens (admin only)
+func (ac *AuthController) RevokeUserTokens(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var user models.User
+	if db := ac.db.First(&user, "id = ?", userID); db.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := ac.tokens.RevokeAllForUser(c.Request.Context(), user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+		return
+	}
+	setAuditEntry(c, "user.revoke_tokens", "user", user.ID.String(), nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "All tokens of the user have been revoked"})
+}
+
+// UnlockUser lifts the login lockout of a user (admin only)
+func (ac *AuthController) UnlockUser(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var user models.User
+	if db := ac.db.First(&user, "id = ?", userID); db.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := ac.guard.Unlock(c.Request.Context(), user.Email, "admin"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+	setAuditEntry(c, "user.unlock", "user", user.ID.String(), nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked"})
+}
+
+// refuseBlockedAccount refuses logins to accounts an admin deactivated or asked to reset their
+// password, writing the error response. It returns true if the login was refused.
+func (ac *AuthController) refuseBlockedAccount(c *gin.Context, user models.User) bool {
+	switch {
+	case !user.IsActive():
+		c.JSON(http.StatusForbidden, gin.H{"error": "This account has been deactivated"})
+		return true
+	case user.PasswordResetRequired:
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":                   "You have to reset your password before logging in, check your email for a reset link",
+			"password_reset_required": true,
+		})
+		return true
+	}
+	return false
+}
+
+// refuseGuardedAttempt counts a password attempt with the login guard before the password is
+// checked, and refuses attempts on locked accounts and IPs and attempts made before the progressive
+// delay is over, writing the error response. It returns true if the attempt was refused. Attempts
+// that go ahead end with countPasswordFailure or releaseGuardedAttempt.
+func (ac *AuthController) refuseGuardedAttempt(c *gin.Context, email string) bool {
+	if ac.guard == nil {
+		return false
+	}
+
+	status, err := ac.guard.Attempt(c.Request.Context(), email, c.ClientIP())
+	if err != nil {
+		log.Printf("Failed to check login guard: %v", err)
+		return false
+	}
+	if status.Allowed() {
+		return false
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(status.RetryAfter.Seconds()))))
+	if status.Locked {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, login is temporarily locked"})
+		return true
+	}
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, please wait before trying again"})
+	return true
+}
+
+// recordLoginFailure records a failed login in the audit log and counts it. The email address is
+// left out: the audit log can't be changed, so it must not hold what an account deletion erases.
+func (ac *AuthController) recordLoginFailure(c *gin.Context, email string, user *models.User) {
+	entry := services.AuditEntry{
+		Action:     "auth.login_failed",
+		TargetType: "user",
+		Details:    map[string]interface{}{"reason": "unknown_email"},
+	}
+	if user != nil {
+		entry.ActorID = user.ID
+		entry.TargetID = user.ID.String()
+		entry.Details["reason"] = "wrong_password"
+	}
+	recordAuditEntry(c, ac.audit, entry)
+
+	ac.countPasswordFailure(c, email, user)
+}
+
+// releaseGuardedAttempt tells the login guard that a password attempt succeeded, which forgets the
+// account's failed attempts
+func (ac *AuthController) releaseGuardedAttempt(c *gin.Context, email string) {
+	if ac.guard == nil {
+		return
+	}
+	if err := ac.guard.RecordSuccess(c.Request.Context(), email, c.ClientIP()); err != nil {
+		log.Printf("Failed to reset login guard: %v", err)
+	}
+}
+
+// releaseGuardedIP takes an attempt that didn't fail back from the IP's count, leaving the
+// account's counted
+func (ac *AuthController) releaseGuardedIP(c *gin.Context) {
+	if ac.guard == nil {
+		return
+	}
+	if err := ac.guard.ReleaseIP(c.Request.Context(), c.ClientIP()); err != nil {
+		log.Printf("Failed to reset login guard: %v", err)
+	}
+}
+
+// countPasswordFailure records a wrong password or MFA code with the login guard and mails the owner an unlock
+// link when it locked the account
+func (ac *AuthController) countPasswordFailure(c *gin.Context, email string, user *models.User) {
+	if ac.guard == nil {
+		return
+	}
+
+	locked, err := ac.guard.RecordFailure(c.Request.Context(), email, c.ClientIP())
+	if err != nil {
+		log.Printf("Failed to record login failure: %v", err)
+		return
+	}
+	if locked && user != nil && ac.accounts != nil {
+		if err := ac.accounts.SendUnlockEmail(c.Request.Context(), *user); err != nil {
+			log.Printf("Failed to send unlock email to user %s: %v", user.ID, err)
+		}
+	}
+}
+
+// startSession issues the tokens of a new session for the user and records the login in the audit
+// log. method says how the user logged in, e.g. "password" or the login provider. If issuing the
+// tokens fails it writes the error response and returns false.
+func (ac *AuthController) startSession(c *gin.Context, user models.User, method string) (*services.TokenPair, bool) {
+	pair, err := ac.tokens.IssueTokens(user, clientInfo(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return nil, false
+	}
+
+	recordAuditEntry(c, ac.audit, services.AuditEntry{
+		ActorID:    user.ID,
+		Action:     "auth.login",
+		TargetType: "session",
+		TargetID:   pair.FamilyID.String(),
+		Details:    map[string]interface{}{"method": method, "user_agent": c.Request.UserAgent()},
+	})
+	return pair, true
+}
+
+// respondWithTokens starts a new session for the user and writes the tokens to the response
+func (ac *AuthController) respondWithTokens(c *gin.Context, status int, user models.User, method string) {
+	pair, ok := ac.startSession(c, user, method)
+	if !ok {
+		return
+	}
+
+	c.JSON(status, tokenResponse(pair))
+}
+
+// respondWithTokensOrMFA finishes a password login, or answers with an MFA challenge when the
+// account needs a second factor
+func (ac *AuthController) respondWithTokensOrMFA(c *gin.Context, status int, user models.User, method string) {
+	purpose, err := ac.mfaChallengePurpose(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check MFA"})
+		return
+	}
+	if purpose == "" {
+		ac.respondWithTokens(c, status, user, method)
+		return
+	}
+
+	mfaToken, expiresAt, err := ac.mfa.CreateChallenge(user, purpose)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start MFA"})
+		return
+	}
+
+	c.JSON(status, gin.H{
+		"mfa_required":       true,
+		"mfa_setup_required": purpose == models.MFAChallengeEnroll,
+		"mfa_token":          mfaToken,
+		"expires_at":         expiresAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// mfaChallengePurpose says which MFA step the user still needs, if any
+func (ac *AuthController) mfaChallengePurpose(user models.User) (models.MFAChallengePurpose, error) {
+	if ac.mfa == nil {
+		return "", nil
+	}
+	return ac.mfa.ChallengePurpose(user)
+}
+
+// finishMFALogin issues the tokens once the second factor is done
+func (ac *AuthController) finishMFALogin(c *gin.Context, user models.User, fromCookie bool, recoveryCodes []string) {
+	// An admin may have acted on the account while the user was entering their code
+	if ac.refuseBlockedAccount(c, user) {
+		return
+	}
+
+	pair, ok := ac.startSession(c, user, "mfa")
+	if !ok {
+		return
+	}
+
+	if fromCookie {
+		c.SetCookie(mfaCookieName, "", -1, "/auth", "", false, true)
+		ac.setTokenCookies(c, pair)
+	}
+
+	response := tokenResponse(pair)
+	if recoveryCodes != nil {
+		response["recovery_codes"] = recoveryCodes
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// mfaTokenFromRequest returns the MFA token from the body, falling back to the cookie set by OAuth logins
+func (ac *AuthController) mfaTokenFromRequest(c *gin.Context, fromBody string) (string, bool) {
+	if fromBody != "" {
+		return fromBody, false
+	}
+	cookie, err := c.Cookie(mfaCookieName)
+	if err != nil {
+		return "", false
+	}
+	return cookie, true
+}
+
+// refreshTokenFromRequest reads the refresh token from the JSON body, falling back to the cookie
+func (ac *AuthController) refreshTokenFromRequest(c *gin.Context) (string, bool) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if c.Request.ContentLength > 0 {
+		_ = c.ShouldBindJSON(&req)
+	}
+	if req.RefreshToken != "" {
+		return req.RefreshToken, false
+	}
+
+	cookie, err := c.Cookie(refreshCookieName)
+	if err != nil {
+		return "", false
+	}
+	return cookie, true
+}
+
+// setTokenCookies stores the tokens in HTTP-only cookies for browser logins
+func (ac *AuthController) setTokenCookies(c *gin.Context, pair *services.TokenPair) {
+	c.SetCookie("auth_token", pair.AccessToken, int(ac.tokens.AccessTTL().Seconds()), "/", "", false, true)
+	// The refresh token is only ever sent to the auth endpoints
+	c.SetCookie(refreshCookieName, pair.RefreshToken, int(ac.tokens.RefreshTTL().Seconds()), "/auth", "", false, true)
+}
+
+// clearTokenCookies removes the token cookies
+func (ac *AuthController) clearTokenCookies(c *gin.Context) {
+	c.SetCookie("auth_token", "", -1, "/", "", false, true)
+	c.SetCookie(refreshCookieName, "", -1, "/auth", "", false, true)
+}
+
+// tokenResponse builds the JSON body returned after a login or refresh
+func tokenResponse(pair *services.TokenPair) gin.H {
+	return gin.H{
+		"token":         pair.AccessToken,
+		"refresh_token": pair.RefreshToken,
+		"token_type":    "Bearer",
+		"expires_at":    pair.AccessExpiresAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// withQuery adds a query parameter to a URL
+func withQuery(rawURL, key, value string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	query.Set(key, value)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// clientInfo describes the client sending the request
+func clientInfo(c *gin.Context) services.ClientInfo {
+	return services.ClientInfo{
+		UserAgent: c.Request.UserAgent(),
+		IPAddress: c.ClientIP(),
 	}
 }
 
@@ -351,10 +1212,36 @@ func (ac *AuthController) GetCurrentUser(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"id":    user.ID,
-		"name":  user.Name,
-		"email": user.Email,
-		"role":  user.Role,
-	})
+	// Users who haven't set up their profile have the default one
+	var profile models.UserProfile
+	if err := ac.db.First(&profile, "user_id = ?", user.ID).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		profile = models.DefaultUserProfile(user.ID)
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve profile"})
+		return
+	}
+
+	response := currentUserResponse(&user, &profile)
+	// Clients show who is impersonating the user, so it can't be mistaken for the user's own session
+	if impersonation := currentImpersonation(c); impersonation != nil {
+		response["impersonation"] = gin.H{
+			"impersonator_id":    impersonation.ActorID(),
+			"impersonator_email": impersonation.Actor.Email,
+			"read_only":          impersonation.ReadOnly,
+			"expires_at":         impersonation.ExpiresAt.Time.UTC().Format(time.RFC3339),
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// currentUserResponse is the current user's account and profile, as shown to themselves
+func currentUserResponse(user *models.User, profile *models.UserProfile) gin.H {
+	return gin.H{
+		"id":             user.ID,
+		"name":           user.Name,
+		"email":          user.Email,
+		"role":           user.Role,
+		"email_verified": user.EmailVerified,
+		"profile":        profile,
+	}
 }
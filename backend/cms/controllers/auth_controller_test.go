@@ -6,13 +6,17 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/config"
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services/oidctest"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
 )
 
@@ -97,12 +101,10 @@ func disabled_TestLogin(t *testing.T) {
 	// Setup
 	gin.SetMode(gin.TestMode)
 	testDB := NewSimpleTestDB()
-	testConfig := &config.Config{
-		JWTSecret: "test-secret",
-	}
+	testConfig := &config.Config{}
 
 	// Create the controller with our test DB
-	authController := NewAuthController(testDB, testConfig)
+	authController := NewAuthController(testDB, testConfig, nil, nil, nil, nil, nil, nil, nil, nil, nil)
 
 	// Create a new gin context
 	w := httptest.NewRecorder()
@@ -139,12 +141,10 @@ func disabled_TestRegister(t *testing.T) {
 	// Setup
 	gin.SetMode(gin.TestMode)
 	testDB := NewSimpleTestDB()
-	testConfig := &config.Config{
-		JWTSecret: "test-secret",
-	}
+	testConfig := &config.Config{}
 
 	// Create the controller with our test DB
-	authController := NewAuthController(testDB, testConfig)
+	authController := NewAuthController(testDB, testConfig, nil, nil, nil, nil, nil, nil, nil, nil, nil)
 
 	// Create a new gin context
 	w := httptest.NewRecorder()
@@ -183,9 +183,7 @@ func disabled_TestGetCurrentUser(t *testing.T) {
 	// Setup
 	gin.SetMode(gin.TestMode)
 	testDB := NewSimpleTestDB()
-	testConfig := &config.Config{
-		JWTSecret: "test-secret",
-	}
+	testConfig := &config.Config{}
 
 	// Get test user ID (we'll use the one from our SimpleTestDB)
 	var testUserID uuid.UUID
@@ -202,7 +200,7 @@ func disabled_TestGetCurrentUser(t *testing.T) {
 	c.Set("userID", testUserID)
 
 	// Create the controller with our test DB
-	authController := NewAuthController(testDB, testConfig)
+	authController := NewAuthController(testDB, testConfig, nil, nil, nil, nil, nil, nil, nil, nil, nil)
 
 	// Call the function
 	authController.GetCurrentUser(c)
@@ -218,6 +216,67 @@ func disabled_TestGetCurrentUser(t *testing.T) {
 	assert.Equal(t, "Test User", response["name"])
 }
 
+// TestProviderCallbackRequiresStateCookie tests that a provider callback is only accepted from the
+// browser that started the login
+func TestProviderCallbackRequiresStateCookie(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	provider := oidctest.NewServer("learnvibe", "secret")
+	defer provider.Close()
+
+	registry := services.NewOIDCRegistry(services.NewOIDCProvider(services.OIDCProviderConfig{
+		Name:         "mock",
+		Issuer:       provider.Issuer(),
+		ClientID:     provider.ClientID,
+		ClientSecret: provider.ClientSecret,
+		RedirectURL:  "http://localhost:8080/auth/mock/callback",
+	}, provider.Client()))
+	authController := NewAuthController(NewSimpleTestDB(), &config.Config{}, nil, nil, nil, nil, nil, registry, nil, nil, nil)
+
+	router := gin.New()
+	router.GET("/auth/:provider/login", authController.ProviderLogin)
+	router.GET("/auth/:provider/callback", authController.ProviderCallback)
+
+	// startLogin starts a login and returns its callback URL with the state cookie
+	startLogin := func() (string, *http.Cookie) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/mock/login", nil))
+		require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		var stateCookie *http.Cookie
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == "oauth_state" {
+				stateCookie = cookie
+			}
+		}
+		require.NotNil(t, stateCookie)
+		assert.True(t, stateCookie.HttpOnly)
+
+		client := provider.Client()
+		client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+		resp, err := client.Get(w.Header().Get("Location"))
+		require.NoError(t, err)
+		resp.Body.Close()
+		callback, err := url.Parse(resp.Header.Get("Location"))
+		require.NoError(t, err)
+		return callback.RequestURI(), stateCookie
+	}
+
+	attackerCallback, _ := startLogin()
+	_, victimCookie := startLogin()
+
+	// Without the cookie the callback is refused
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, attackerCallback, nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// A browser can't be made to finish someone else's login
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, attackerCallback, nil)
+	req.AddCookie(victimCookie)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
 // Additional tests would be added here for:
 // - TestGoogleLogin
 // - TestGoogleCallback
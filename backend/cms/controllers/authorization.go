@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/policy"
+	"gorm.io/gorm"
+)
+
+// currentSubject returns the user making the request, as set by the auth middleware
+func currentSubject(c *gin.Context) policy.Subject {
+	var subject policy.Subject
+	if userID, ok := c.Get("userID"); ok {
+		subject.ID, _ = userID.(uuid.UUID)
+	}
+	if role, ok := c.Get("userRole"); ok {
+		subject.Role, _ = role.(string)
+	}
+	if organizationID, ok := c.Get("organizationID"); ok {
+		subject.OrganizationID, _ = organizationID.(uuid.UUID)
+	}
+	return subject
+}
+
+// currentImpersonation returns the claims of the impersonation token the request was made with,
+// or nil if no admin is impersonating the user
+func currentImpersonation(c *gin.Context) *claims.AccessClaims {
+	value, ok := c.Get("impersonation")
+	if !ok {
+		return nil
+	}
+	accessClaims, _ := value.(*claims.AccessClaims)
+	return accessClaims
+}
+
+// tenantDB returns the database limited to the current user's organization. Platform admins see
+// every organization.
+func tenantDB(c *gin.Context, db *gorm.DB) *gorm.DB {
+	subject := currentSubject(c)
+	if policy.Grants(subject.Role, policy.AdminAccess) {
+		return db
+	}
+	return models.ForOrganization(db, subject.OrganizationID)
+}
+
+// authorize checks if the current user may perform the action on the resource, and answers 403
+// with the message if not
+func authorize(c *gin.Context, action policy.Action, resource policy.Resource, message string) bool {
+	if err := policy.Authorize(currentSubject(c), action, resource); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": message})
+		return false
+	}
+	return true
+}
+
+// authenticateService checks the shared secret other services send as a Bearer token, and answers
+// 404 when the secret isn't configured or 401 when it doesn't match
+func authenticateService(c *gin.Context, secret string) bool {
+	if secret == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service endpoints are disabled"})
+		return false
+	}
+	sent := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(sent), []byte(secret)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid service secret"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,555 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/policy"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CohortController handles cohort-related requests
+type CohortController struct {
+	db  *gorm.DB
+	lti *services.LTIService
+}
+
+// NewCohortController creates a new cohort controller. Grades of students who came from an LTI
+// platform are sent back to it; a nil LTI service keeps them in LearnVibe.
+func NewCohortController(db *gorm.DB, lti *services.LTIService) *CohortController {
+	return &CohortController{db: db, lti: lti}
+}
+
+// cohortRequest is the payload for creating or updating a cohort
+type cohortRequest struct {
+	Name          string      `json:"name" binding:"required"`
+	StartDate     time.Time   `json:"start_date" binding:"required"`
+	EndDate       time.Time   `json:"end_date" binding:"required"`
+	Capacity      int         `json:"capacity" binding:"min=0"`
+	Schedule      string      `json:"schedule"`
+	InstructorIDs []uuid.UUID `json:"instructor_ids"`
+}
+
+// CreateCohort creates a new cohort inside a course
+func (cc *CohortController) CreateCohort(c *gin.Context) {
+	course, ok := cc.loadManagedCourse(c)
+	if !ok {
+		return
+	}
+
+	var req cohortRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.EndDate.After(req.StartDate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "End date must be after start date"})
+		return
+	}
+
+	instructors, ok := cc.loadInstructors(c, req.InstructorIDs)
+	if !ok {
+		return
+	}
+
+	cohort := models.Cohort{
+		CourseID:    course.ID,
+		Name:        req.Name,
+		StartDate:   req.StartDate,
+		EndDate:     req.EndDate,
+		Capacity:    req.Capacity,
+		Schedule:    req.Schedule,
+		Instructors: instructors,
+	}
+
+	if err := cc.db.Create(&cohort).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create cohort"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, cohort)
+}
+
+// GetCourseCohorts lists the cohorts of a course
+func (cc *CohortController) GetCourseCohorts(c *gin.Context) {
+	course, ok := cc.loadCourse(c)
+	if !ok {
+		return
+	}
+
+	query := cc.db.Model(&models.Cohort{}).Where("course_id = ?", course.ID).Preload("Instructors")
+
+	// Only list cohorts that haven't ended yet if requested
+	if c.Query("upcoming") == "true" {
+		query = query.Where("end_date > ?", time.Now())
+	}
+
+	var cohorts []models.Cohort
+	if err := query.Order("start_date").Find(&cohorts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cohorts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, cohorts)
+}
+
+// GetCohort gets a single cohort with its enrollment count
+func (cc *CohortController) GetCohort(c *gin.Context) {
+	cohort, ok := cc.loadCohort(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"cohort": cohort, "enrolled": countCohortEnrollments(cc.db, cohort.ID)})
+}
+
+// errCapacityBelowEnrolled is returned when a cohort's capacity would drop below its enrollments
+var errCapacityBelowEnrolled = errors.New("capacity is below the cohort's enrollments")
+
+// UpdateCohort updates the dates, capacity, schedule and instructors of a cohort. The capacity can't
+// drop below the students already enrolled.
+func (cc *CohortController) UpdateCohort(c *gin.Context) {
+	if _, ok := cc.loadManagedCourse(c); !ok {
+		return
+	}
+	cohort, ok := cc.loadCohort(c)
+	if !ok {
+		return
+	}
+
+	var req cohortRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.EndDate.After(req.StartDate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "End date must be after start date"})
+		return
+	}
+
+	instructors, ok := cc.loadInstructors(c, req.InstructorIDs)
+	if !ok {
+		return
+	}
+
+	cohort.Name = req.Name
+	cohort.StartDate = req.StartDate
+	cohort.EndDate = req.EndDate
+	cohort.Capacity = req.Capacity
+	cohort.Schedule = req.Schedule
+
+	var enrolled int64
+	err := cc.db.Transaction(func(tx *gorm.DB) error {
+		// Enrollments lock the cohort too, so none can take a seat between the count and the update
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.Cohort{}, "id = ?", cohort.ID).Error; err != nil {
+			return err
+		}
+		enrolled = countCohortEnrollments(tx, cohort.ID)
+		if req.Capacity > 0 && enrolled > int64(req.Capacity) {
+			return errCapacityBelowEnrolled
+		}
+
+		if err := tx.Omit("Instructors").Save(&cohort).Error; err != nil {
+			return err
+		}
+		return tx.Model(&cohort).Association("Instructors").Replace(instructors)
+	})
+	if errors.Is(err, errCapacityBelowEnrolled) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Capacity is below the cohort's " + strconv.FormatInt(enrolled, 10) + " enrollments"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update cohort"})
+		return
+	}
+
+	c.JSON(http.StatusOK, cohort)
+}
+
+// DeleteCohort deletes a cohort that has no enrollments
+func (cc *CohortController) DeleteCohort(c *gin.Context) {
+	if _, ok := cc.loadManagedCourse(c); !ok {
+		return
+	}
+	cohort, ok := cc.loadCohort(c)
+	if !ok {
+		return
+	}
+
+	var enrolled int64
+	cc.db.Model(&models.Enrollment{}).Where("cohort_id = ?", cohort.ID).Count(&enrolled)
+	if enrolled > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cohort still has enrollments, transfer them first"})
+		return
+	}
+
+	err := cc.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&cohort).Association("Instructors").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Where("cohort_id = ?", cohort.ID).Delete(&models.CohortDueDate{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&cohort).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete cohort"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Cohort deleted successfully"})
+}
+
+// GetCohortDueDates lists the due dates of course contents for a cohort
+func (cc *CohortController) GetCohortDueDates(c *gin.Context) {
+	cohort, ok := cc.loadCohort(c)
+	if !ok {
+		return
+	}
+
+	var dueDates []models.CohortDueDate
+	if err := cc.db.Where("cohort_id = ?", cohort.ID).Order("due_at").Find(&dueDates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch due dates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, dueDates)
+}
+
+// SetCohortDueDate sets the due date of a course content item for a cohort
+func (cc *CohortController) SetCohortDueDate(c *gin.Context) {
+	cohort, ok := cc.loadCohort(c)
+	if !ok {
+		return
+	}
+	if !cc.canTeach(c, cohort) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to manage this cohort"})
+		return
+	}
+
+	contentID, err := uuid.Parse(c.Param("contentId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid content ID"})
+		return
+	}
+
+	// Make sure the content belongs to the cohort's course
+	var content models.CourseContent
+	if err := cc.db.Where("id = ? AND course_id = ?", contentID, cohort.CourseID).First(&content).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Content not found or doesn't belong to this course"})
+		return
+	}
+
+	var req struct {
+		DueAt time.Time `json:"due_at" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var dueDate models.CohortDueDate
+	result := cc.db.Where("cohort_id = ? AND content_id = ?", cohort.ID, contentID).First(&dueDate)
+	if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	dueDate.CohortID = cohort.ID
+	dueDate.ContentID = contentID
+	dueDate.DueAt = req.DueAt
+
+	if err := cc.db.Save(&dueDate).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save due date"})
+		return
+	}
+
+	c.JSON(http.StatusOK, dueDate)
+}
+
+// GetCohortGradebook lists progress and grades of every student in a cohort
+func (cc *CohortController) GetCohortGradebook(c *gin.Context) {
+	cohort, ok := cc.loadCohort(c)
+	if !ok {
+		return
+	}
+	if !cc.canTeach(c, cohort) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to view this gradebook"})
+		return
+	}
+
+	query := cc.db.Model(&models.Enrollment{}).Where("cohort_id = ?", cohort.ID).Preload("User")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var enrollments []models.Enrollment
+	if err := query.Find(&enrollments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch gradebook"})
+		return
+	}
+
+	type gradebookEntry struct {
+		EnrollmentID uuid.UUID               `json:"enrollment_id"`
+		UserID       uuid.UUID               `json:"user_id"`
+		Name         string                  `json:"name"`
+		Email        string                  `json:"email"`
+		Status       models.EnrollmentStatus `json:"status"`
+		Progress     float32                 `json:"progress"`
+		Grade        *float32                `json:"grade,omitempty"`
+		CompletedAt  *time.Time              `json:"completed_at,omitempty"`
+	}
+
+	entries := make([]gradebookEntry, 0, len(enrollments))
+	for _, e := range enrollments {
+		entries = append(entries, gradebookEntry{
+			EnrollmentID: e.ID,
+			UserID:       e.UserID,
+			Name:         e.User.Name,
+			Email:        e.User.Email,
+			Status:       e.Status,
+			Progress:     e.Progress,
+			Grade:        e.Grade,
+			CompletedAt:  e.CompletedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"cohort": cohort, "entries": entries})
+}
+
+// SetEnrollmentGrade records a grade for an enrollment (course creator, cohort instructor or admin)
+func (cc *CohortController) SetEnrollmentGrade(c *gin.Context) {
+	enrollmentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid enrollment ID"})
+		return
+	}
+
+	var enrollment models.Enrollment
+	if err := cc.db.Preload("Course").Preload("Cohort.Instructors").First(&enrollment, enrollmentID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Enrollment not found"})
+		return
+	}
+
+	teaching := policy.OwnedBy(enrollment.Course.CreatorID).InOrganization(enrollment.Course.OrganizationID)
+	if enrollment.Cohort != nil {
+		teaching = teaching.ManagedBy(enrollment.Cohort.InstructorIDs()...)
+	}
+	if !authorize(c, policy.CohortTeach, teaching, "You don't have permission to grade this enrollment") {
+		return
+	}
+
+	var req struct {
+		Grade float32 `json:"grade" binding:"min=0,max=100"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	previous := enrollment.Grade
+	enrollment.Grade = &req.Grade
+	if err := cc.db.Model(&enrollment).Update("grade", req.Grade).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save grade"})
+		return
+	}
+	setAuditChange(c, "enrollment.grade", "enrollment", enrollment.ID.String(),
+		map[string]interface{}{"grade": previous, "student_id": enrollment.UserID},
+		map[string]interface{}{"grade": req.Grade, "student_id": enrollment.UserID})
+	cc.sendGradeToPlatforms(enrollment)
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// sendGradeToPlatforms sends a grade to the LTI platforms the student launched the course from, in
+// the background so a slow platform doesn't hold up grading
+func (cc *CohortController) sendGradeToPlatforms(enrollment models.Enrollment) {
+	if cc.lti == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := cc.lti.SendGrade(ctx, enrollment); err != nil {
+			log.Printf("Failed to send grade of enrollment %s to LTI platforms: %v", enrollment.ID, err)
+		}
+	}()
+}
+
+// TransferEnrollment moves a student to another cohort of the same course without losing progress
+func (cc *CohortController) TransferEnrollment(c *gin.Context) {
+	course, ok := cc.loadManagedCourse(c)
+	if !ok {
+		return
+	}
+
+	enrollmentID, err := uuid.Parse(c.Param("enrollmentId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid enrollment ID"})
+		return
+	}
+
+	var req struct {
+		CohortID uuid.UUID `json:"cohort_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var enrollment models.Enrollment
+	if err := cc.db.Where("id = ? AND course_id = ?", enrollmentID, course.ID).First(&enrollment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Enrollment not found in this course"})
+		return
+	}
+	if enrollment.CohortID != nil && *enrollment.CohortID == req.CohortID {
+		c.JSON(http.StatusConflict, gin.H{"error": "Enrollment is already in this cohort"})
+		return
+	}
+
+	var target models.Cohort
+	if err := cc.db.Where("id = ? AND course_id = ?", req.CohortID, course.ID).First(&target).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Target cohort not found in this course"})
+		return
+	}
+
+	if !target.IsOpen(time.Now()) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Target cohort has already ended"})
+		return
+	}
+
+	// Lock the target cohort while counting its seats, so concurrent enrollments and transfers can't overfill it
+	full := false
+	err = cc.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&target, "id = ?", target.ID).Error; err != nil {
+			return err
+		}
+		if !target.HasCapacity(countCohortEnrollments(tx, target.ID)) {
+			full = true
+			return nil
+		}
+
+		// Only the cohort changes; progress, grade and completion are kept as they are
+		enrollment.TransferToCohort(target.ID)
+		return tx.Model(&enrollment).Update("cohort_id", target.ID).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer enrollment"})
+		return
+	}
+	if full {
+		c.JSON(http.StatusConflict, gin.H{"error": "Target cohort is full"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Enrollment transferred successfully", "enrollment": enrollment})
+}
+
+// loadManagedCourse loads the course from the URL and checks that the user may manage it
+func (cc *CohortController) loadManagedCourse(c *gin.Context) (*models.Course, bool) {
+	course, ok := cc.loadCourse(c)
+	if !ok {
+		return nil, false
+	}
+
+	// Check if user is the creator or an admin
+	if !authorize(c, policy.CohortManage, policy.OwnedBy(course.CreatorID).InOrganization(course.OrganizationID),
+		"You don't have permission to manage cohorts for this course") {
+		return nil, false
+	}
+
+	return course, true
+}
+
+// loadCourse loads the course from the URL if it's in the user's organization
+func (cc *CohortController) loadCourse(c *gin.Context) (*models.Course, bool) {
+	courseID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid course ID"})
+		return nil, false
+	}
+
+	var course models.Course
+	if err := tenantDB(c, cc.db).First(&course, courseID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Course not found"})
+		return nil, false
+	}
+
+	return &course, true
+}
+
+// loadCohort loads the cohort from the URL, making sure it belongs to the course in the URL
+func (cc *CohortController) loadCohort(c *gin.Context) (*models.Cohort, bool) {
+	course, ok := cc.loadCourse(c)
+	if !ok {
+		return nil, false
+	}
+
+	cohortID, err := uuid.Parse(c.Param("cohortId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cohort ID"})
+		return nil, false
+	}
+
+	var cohort models.Cohort
+	result := cc.db.Preload("Course").Preload("Instructors").
+		Where("id = ? AND course_id = ?", cohortID, course.ID).First(&cohort)
+	if result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cohort not found"})
+		return nil, false
+	}
+
+	return &cohort, true
+}
+
+// loadInstructors loads the users assigned to teach a cohort
+func (cc *CohortController) loadInstructors(c *gin.Context, ids []uuid.UUID) ([]models.User, bool) {
+	instructors := []models.User{}
+	if len(ids) == 0 {
+		return instructors, true
+	}
+
+	// The same instructor listed twice is assigned once
+	unique := make([]uuid.UUID, 0, len(ids))
+	seen := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	ids = unique
+
+	// Only instructors of the user's organization can be assigned
+	if err := tenantDB(c, cc.db).Where("id IN ?", ids).Find(&instructors).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+	if len(instructors) != len(ids) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown instructor ID"})
+		return nil, false
+	}
+	for _, instructor := range instructors {
+		if !instructor.IsInstructor() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User " + instructor.ID.String() + " is not an instructor"})
+			return nil, false
+		}
+	}
+
+	return instructors, true
+}
+
+// canTeach checks if the user is the course creator, a cohort instructor or an admin
+func (cc *CohortController) canTeach(c *gin.Context, cohort *models.Cohort) bool {
+	teaching := policy.OwnedBy(cohort.Course.CreatorID).ManagedBy(cohort.InstructorIDs()...).
+		InOrganization(cohort.Course.OrganizationID)
+	return policy.Authorize(currentSubject(c), policy.CohortTeach, teaching) == nil
+}
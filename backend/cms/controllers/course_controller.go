@@ -9,6 +9,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/policy"
 	"github.com/sony/gobreaker"
 	"gorm.io/gorm"
 )
@@ -50,12 +51,13 @@ func (cc *CourseController) CreateCourse(c *gin.Context) {
 		return
 	}
 
-	// Set the creator ID
+	// Set the creator ID, the course belongs to the creator's organization
 	course.CreatorID = userID.(uuid.UUID)
+	course.OrganizationID = currentSubject(c).OrganizationID
 
 	// Retry logic for DB operation (create course)
 	operation := func() error {
-		if err := cc.db.Create(&course).Error; err != nil {
+		if err := tenantDB(c, cc.db).Create(&course).Error; err != nil {
 			return err
 		}
 		return nil
@@ -78,7 +80,7 @@ func (cc *CourseController) GetCourses(c *gin.Context) {
 	pageSize := c.DefaultQuery("pageSize", "10")
 
 	// Preload creator information but don't include course contents by default
-	query := cc.db.Model(&models.Course{}).Preload("Creator")
+	query := tenantDB(c, cc.db).Model(&models.Course{}).Preload("Creator")
 	query.Scopes(Paginate(page, pageSize)).Find(&courses)
 
 	// Retry logic for database query (get courses)
@@ -109,7 +111,8 @@ func (cc *CourseController) GetCourse(c *gin.Context) {
 	}
 
 	var course models.Course
-	result := cc.db.Preload("Creator").Preload("Contents").First(&course, id)
+	db := tenantDB(c, cc.db)
+	result := db.Preload("Creator").Preload("Contents").First(&course, id)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Course not found"})
 		return
@@ -117,7 +120,7 @@ func (cc *CourseController) GetCourse(c *gin.Context) {
 
 	// Retry logic for database query (get course)
 	operation := func() error {
-		if err := cc.db.Preload("Creator").Preload("Contents").First(&course, id).Error; err != nil {
+		if err := db.Preload("Creator").Preload("Contents").First(&course, id).Error; err != nil {
 			return err
 		}
 		return nil
@@ -136,8 +139,7 @@ func (cc *CourseController) GetCourse(c *gin.Context) {
 // UpdateCourse updates a course
 func (cc *CourseController) UpdateCourse(c *gin.Context) {
 	// Get user ID from context
-	userID, exists := c.Get("userID")
-	if !exists {
+	if _, exists := c.Get("userID"); !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
@@ -152,23 +154,22 @@ func (cc *CourseController) UpdateCourse(c *gin.Context) {
 
 	// Get existing course
 	var course models.Course
-	result := cc.db.First(&course, id)
+	result := tenantDB(c, cc.db).First(&course, id)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Course not found"})
 		return
 	}
 
 	// Check if user is the creator or an admin
-	userRole, _ := c.Get("userRole")
-	if course.CreatorID != userID.(uuid.UUID) && userRole.(string) != string(models.RoleAdmin) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to update this course"})
+	if !authorize(c, policy.CourseUpdate, policy.OwnedBy(course.CreatorID).InOrganization(course.OrganizationID), "You don't have permission to update this course") {
 		return
 	}
 
 	// Parse update data
 	var updateData struct {
-		Title       string `json:"title"`
-		Description string `json:"description"`
+		Title              string `json:"title"`
+		Description        string `json:"description"`
+		AccessDurationDays *int   `json:"access_duration_days" binding:"omitempty,min=1"`
 	}
 	if err := c.ShouldBindJSON(&updateData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -178,6 +179,7 @@ func (cc *CourseController) UpdateCourse(c *gin.Context) {
 	// Update course
 	course.Title = updateData.Title
 	course.Description = updateData.Description
+	course.AccessDurationDays = updateData.AccessDurationDays
 	cc.db.Save(&course)
 
 	c.JSON(http.StatusOK, course)
@@ -186,8 +188,7 @@ func (cc *CourseController) UpdateCourse(c *gin.Context) {
 // DeleteCourse deletes a course
 func (cc *CourseController) DeleteCourse(c *gin.Context) {
 	// Get user ID from context
-	userID, exists := c.Get("userID")
-	if !exists {
+	if _, exists := c.Get("userID"); !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
@@ -202,24 +203,31 @@ func (cc *CourseController) DeleteCourse(c *gin.Context) {
 
 	// Get existing course
 	var course models.Course
-	result := cc.db.First(&course, id)
+	result := tenantDB(c, cc.db).First(&course, id)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Course not found"})
 		return
 	}
 
 	// Check if user is the creator or an admin
-	userRole, _ := c.Get("userRole")
-	if course.CreatorID != userID.(uuid.UUID) && userRole.(string) != string(models.RoleAdmin) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to delete this course"})
+	if !authorize(c, policy.CourseDelete, policy.OwnedBy(course.CreatorID).InOrganization(course.OrganizationID), "You don't have permission to delete this course") {
 		return
 	}
 
+	var contents int64
+	cc.db.Model(&models.CourseContent{}).Where("course_id = ?", id).Count(&contents)
+
 	// Delete course contents first (cascade delete)
 	cc.db.Where("course_id = ?", id).Delete(&models.CourseContent{})
 
 	// Delete the course
 	cc.db.Delete(&course)
+	setAuditChange(c, "course.delete", "course", idStr, map[string]interface{}{
+		"title":           course.Title,
+		"creator_id":      course.CreatorID,
+		"organization_id": course.OrganizationID,
+		"contents":        contents,
+	}, nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Course deleted successfully"})
 }
@@ -227,8 +235,7 @@ func (cc *CourseController) DeleteCourse(c *gin.Context) {
 // AddCourseContent adds content to a course
 func (cc *CourseController) AddCourseContent(c *gin.Context) {
 	// Get user ID from context
-	userID, exists := c.Get("userID")
-	if !exists {
+	if _, exists := c.Get("userID"); !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
@@ -243,16 +250,14 @@ func (cc *CourseController) AddCourseContent(c *gin.Context) {
 
 	// Get existing course
 	var course models.Course
-	result := cc.db.First(&course, courseID)
+	result := tenantDB(c, cc.db).First(&course, courseID)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Course not found"})
 		return
 	}
 
 	// Check if user is the creator or an admin
-	userRole, _ := c.Get("userRole")
-	if course.CreatorID != userID.(uuid.UUID) && userRole.(string) != string(models.RoleAdmin) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to update this course"})
+	if !authorize(c, policy.CourseUpdate, policy.OwnedBy(course.CreatorID).InOrganization(course.OrganizationID), "You don't have permission to update this course") {
 		return
 	}
 
@@ -278,8 +283,7 @@ func (cc *CourseController) AddCourseContent(c *gin.Context) {
 // DeleteCourseContent deletes content from a course
 func (cc *CourseController) DeleteCourseContent(c *gin.Context) {
 	// Get user ID from context
-	userID, exists := c.Get("userID")
-	if !exists {
+	if _, exists := c.Get("userID"); !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
@@ -300,25 +304,32 @@ func (cc *CourseController) DeleteCourseContent(c *gin.Context) {
 
 	// Get existing course
 	var course models.Course
-	result := cc.db.First(&course, courseID)
+	result := tenantDB(c, cc.db).First(&course, courseID)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Course not found"})
 		return
 	}
 
 	// Check if user is the creator or an admin
-	userRole, _ := c.Get("userRole")
-	if course.CreatorID != userID.(uuid.UUID) && userRole.(string) != string(models.RoleAdmin) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to update this course"})
+	if !authorize(c, policy.CourseUpdate, policy.OwnedBy(course.CreatorID).InOrganization(course.OrganizationID), "You don't have permission to update this course") {
 		return
 	}
 
 	// Delete the content
-	result = cc.db.Where("id = ? AND course_id = ?", contentID, courseID).Delete(&models.CourseContent{})
-	if result.RowsAffected == 0 {
+	var content models.CourseContent
+	if err := cc.db.Where("id = ? AND course_id = ?", contentID, courseID).First(&content).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Content not found or doesn't belong to this course"})
 		return
 	}
+	if err := cc.db.Delete(&content).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete content"})
+		return
+	}
+	setAuditChange(c, "course_content.delete", "course_content", content.ID.String(), map[string]interface{}{
+		"course_id": courseID,
+		"title":     content.Title,
+		"type":      content.Type,
+	}, nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Content deleted successfully"})
 }
@@ -326,27 +337,33 @@ func (cc *CourseController) DeleteCourseContent(c *gin.Context) {
 // Paginate is a helper function for pagination
 func Paginate(page, pageSize string) func(db *gorm.DB) *gorm.DB {
 	return func(db *gorm.DB) *gorm.DB {
-		var p, ps int
-		if p, err := intOrDefault(page, 1); err == nil {
-			if p < 1 {
-				p = 1
-			}
-		}
-		if ps, err := intOrDefault(pageSize, 10); err == nil {
-			if ps < 1 {
-				ps = 10
-			}
-			if ps > 100 {
-				ps = 100 // Limit page size to prevent abuse
-			}
-		}
+		p, ps := pageParams(page, pageSize)
 		offset := (p - 1) * ps
 		return db.Offset(offset).Limit(ps)
 	}
 }
 
+// pageParams parses the page and page size query parameters, falling back to the first page of 10
+func pageParams(page, pageSize string) (int, int) {
+	p := intOrDefault(page, 1)
+	if p < 1 {
+		p = 1
+	}
+	ps := intOrDefault(pageSize, 10)
+	if ps < 1 {
+		ps = 10
+	}
+	if ps > 100 {
+		ps = 100 // Limit page size to prevent abuse
+	}
+	return p, ps
+}
+
 // intOrDefault converts a string to int or returns a default value
-func intOrDefault(val string, defaultVal int) (int, error) {
-	// This is a stub - you'd implement proper parsing with error handling
-	return defaultVal, nil
+func intOrDefault(val string, defaultVal int) int {
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return defaultVal
+	}
+	return n
 }
@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPageParams tests parsing and clamping of the pagination query parameters
+func TestPageParams(t *testing.T) {
+	tests := []struct {
+		page, pageSize string
+		wantPage       int
+		wantPageSize   int
+	}{
+		{"1", "10", 1, 10},
+		{"3", "25", 3, 25},
+		{"", "", 1, 10},
+		{"abc", "xyz", 1, 10},
+		{"0", "0", 1, 10},
+		{"-2", "-5", 1, 10},
+		{"2", "500", 2, 100},
+	}
+
+	for _, tt := range tests {
+		page, pageSize := pageParams(tt.page, tt.pageSize)
+		assert.Equal(t, tt.wantPage, page, "page %q", tt.page)
+		assert.Equal(t, tt.wantPageSize, pageSize, "page size %q", tt.pageSize)
+	}
+}
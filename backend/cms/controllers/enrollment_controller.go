@@ -2,22 +2,29 @@ package controllers
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/policy"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // EnrollmentController handles enrollment-related requests
 type EnrollmentController struct {
-	db *gorm.DB
+	db            *gorm.DB
+	messageBroker *services.MessageBroker
+	serviceSecret string
 }
 
-// NewEnrollmentController creates a new enrollment controller
-func NewEnrollmentController(db *gorm.DB) *EnrollmentController {
-	return &EnrollmentController{db: db}
+// NewEnrollmentController creates a new enrollment controller. serviceSecret authenticates the other
+// services asking about access windows; empty disables it.
+func NewEnrollmentController(db *gorm.DB, messageBroker *services.MessageBroker, serviceSecret string) *EnrollmentController {
+	return &EnrollmentController{db: db, messageBroker: messageBroker, serviceSecret: serviceSecret}
 }
 
 // EnrollInCourse handles course enrollment
@@ -30,50 +37,93 @@ func (ec *EnrollmentController) EnrollInCourse(c *gin.Context) {
 	}
 
 	// Get course ID from URL
-	courseID, err := uuid.Parse(c.Param("id"))
+	courseID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid course ID"})
 		return
 	}
 
-	// Check if course exists
+	// Check if course exists in the user's organization
 	var course models.Course
-	result := ec.db.First(&course, courseID)
+	result := tenantDB(c, ec.db).First(&course, courseID)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Course not found"})
 		return
 	}
 
-	// Check if user is already enrolled
-	var existingEnrollment models.Enrollment
-	result = ec.db.Where("user_id = ? AND course_id = ?", userID, courseID).First(&existingEnrollment)
-	if result.Error == nil {
-		// User is already enrolled
-		if existingEnrollment.Status == models.EnrollmentStatusDropped {
-			// Reactivate enrollment if it was dropped
-			existingEnrollment.Status = models.EnrollmentStatusActive
-			existingEnrollment.EnrolledAt = time.Now()
-			ec.db.Save(&existingEnrollment)
-			c.JSON(http.StatusOK, gin.H{"message": "Course enrollment reactivated", "enrollment": existingEnrollment})
+	// Paid courses are enrolled through the checkout flow
+	if course.IsPaid() {
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": "This course requires payment, use the checkout endpoint"})
+		return
+	}
+
+	// The cohort is optional for courses that don't run in cohorts
+	var enrollRequest struct {
+		CohortID *uuid.UUID `json:"cohort_id"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&enrollRequest); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-
-		c.JSON(http.StatusConflict, gin.H{"error": "Already enrolled in this course", "enrollment": existingEnrollment})
-		return
 	}
 
-	// Create new enrollment
-	enrollment := models.Enrollment{
-		UserID:   userID.(uuid.UUID),
-		CourseID: courseID,
-		Status:   models.EnrollmentStatusActive,
+	// Check if user is already enrolled
+	var enrollment models.Enrollment
+	result = ec.db.Where("user_id = ? AND course_id = ?", userID, courseID).First(&enrollment)
+	reactivate := result.Error == nil
+	if reactivate {
+		// Expired access can only be extended by an admin, also when the course was dropped meanwhile
+		if enrollment.IsExpired(time.Now()) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Your access to this course has expired", "enrollment": enrollment})
+			return
+		}
+		if enrollment.Status != models.EnrollmentStatusDropped {
+			c.JSON(http.StatusConflict, gin.H{"error": "Already enrolled in this course", "enrollment": enrollment})
+			return
+		}
 	}
 
-	if err := ec.db.Create(&enrollment).Error; err != nil {
+	// The cohort stays locked until the enrollment is saved, so concurrent enrollments can't overfill it
+	var status int
+	var errMsg string
+	err = ec.db.Transaction(func(tx *gorm.DB) error {
+		var cohortID *uuid.UUID
+		cohortID, status, errMsg = resolveEnrollmentCohort(tx, courseID, enrollRequest.CohortID)
+		if errMsg != "" {
+			return nil
+		}
+
+		if reactivate {
+			// Reactivate enrollment if it was dropped, within its original access window
+			enrollment.Reactivate(course, cohortID)
+			return tx.Save(&enrollment).Error
+		}
+
+		// Create new enrollment
+		enrollment = models.Enrollment{
+			UserID:   userID.(uuid.UUID),
+			CourseID: courseID,
+			CohortID: cohortID,
+			Status:   models.EnrollmentStatusActive,
+		}
+		enrollment.StartAccessWindow(course)
+		return tx.Create(&enrollment).Error
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enroll in course"})
 		return
 	}
+	if errMsg != "" {
+		c.JSON(status, gin.H{"error": errMsg})
+		return
+	}
+	publishEnrollmentAccess(ec.messageBroker, enrollment)
 
+	if reactivate {
+		c.JSON(http.StatusOK, gin.H{"message": "Course enrollment reactivated", "enrollment": enrollment})
+		return
+	}
 	c.JSON(http.StatusCreated, enrollment)
 }
 
@@ -90,15 +140,21 @@ func (ec *EnrollmentController) GetUserEnrollments(c *gin.Context) {
 	page := c.DefaultQuery("page", "1")
 	pageSize := c.DefaultQuery("pageSize", "10")
 	status := c.DefaultQuery("status", "")
+	cohortID := c.DefaultQuery("cohort_id", "")
 
 	// Base query
-	query := ec.db.Model(&models.Enrollment{}).Where("user_id = ?", userID).Preload("Course")
+	query := ec.db.Model(&models.Enrollment{}).Where("user_id = ?", userID).Preload("Course").Preload("Cohort")
 
 	// Filter by status if provided
 	if status != "" {
 		query = query.Where("status = ?", status)
 	}
 
+	// Filter by cohort if provided
+	if cohortID != "" {
+		query = query.Where("cohort_id = ?", cohortID)
+	}
+
 	// Execute query with pagination
 	var enrollments []models.Enrollment
 	query.Scopes(Paginate(page, pageSize)).Find(&enrollments)
@@ -109,25 +165,23 @@ func (ec *EnrollmentController) GetUserEnrollments(c *gin.Context) {
 // GetCourseEnrollments lists all users enrolled in a course (admin/instructor only)
 func (ec *EnrollmentController) GetCourseEnrollments(c *gin.Context) {
 	// Get course ID from URL
-	courseID, err := uuid.Parse(c.Param("id"))
+	courseID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid course ID"})
 		return
 	}
 
-	// Check if course exists
+	// Check if course exists in the user's organization
 	var course models.Course
-	result := ec.db.First(&course, courseID)
+	result := tenantDB(c, ec.db).First(&course, courseID)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Course not found"})
 		return
 	}
 
 	// Verify permissions - only course creator or admin can see enrollments
-	userID, _ := c.Get("userID")
-	userRole, _ := c.Get("userRole")
-	if course.CreatorID != userID.(uuid.UUID) && userRole.(string) != string(models.RoleAdmin) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to view enrollments for this course"})
+	if !authorize(c, policy.CourseReadEnrollments, policy.OwnedBy(course.CreatorID).InOrganization(course.OrganizationID),
+		"You don't have permission to view enrollments for this course") {
 		return
 	}
 
@@ -135,15 +189,21 @@ func (ec *EnrollmentController) GetCourseEnrollments(c *gin.Context) {
 	page := c.DefaultQuery("page", "1")
 	pageSize := c.DefaultQuery("pageSize", "10")
 	status := c.DefaultQuery("status", "")
+	cohortID := c.DefaultQuery("cohort_id", "")
 
 	// Base query
-	query := ec.db.Model(&models.Enrollment{}).Where("course_id = ?", courseID).Preload("User")
+	query := ec.db.Model(&models.Enrollment{}).Where("course_id = ?", courseID).Preload("User").Preload("Cohort")
 
 	// Filter by status if provided
 	if status != "" {
 		query = query.Where("status = ?", status)
 	}
 
+	// Filter by cohort if provided
+	if cohortID != "" {
+		query = query.Where("cohort_id = ?", cohortID)
+	}
+
 	// Execute query with pagination
 	var enrollments []models.Enrollment
 	query.Scopes(Paginate(page, pageSize)).Find(&enrollments)
@@ -162,21 +222,18 @@ func (ec *EnrollmentController) GetEnrollmentDetails(c *gin.Context) {
 
 	// Get enrollment with course and user details
 	var enrollment models.Enrollment
-	result := ec.db.Preload("Course").Preload("User").First(&enrollment, enrollmentID)
+	result := ec.db.Preload("Course").Preload("User").Preload("Cohort").First(&enrollment, enrollmentID)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Enrollment not found"})
 		return
 	}
 
 	// Verify permissions - only the enrolled user, course creator, or admin can see enrollment details
-	userID, _ := c.Get("userID")
-	userRole, _ := c.Get("userRole")
-	if enrollment.UserID != userID.(uuid.UUID) &&
-		enrollment.Course.CreatorID != userID.(uuid.UUID) &&
-		userRole.(string) != string(models.RoleAdmin) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to view this enrollment"})
+	if !authorize(c, policy.EnrollmentRead, policy.OwnedBy(enrollment.UserID).ManagedBy(enrollment.Course.CreatorID).InOrganization(enrollment.Course.OrganizationID),
+		"You don't have permission to view this enrollment") {
 		return
 	}
+	userID, _ := c.Get("userID")
 
 	// Record access if the enrolled user is viewing
 	if enrollment.UserID == userID.(uuid.UUID) {
@@ -197,8 +254,7 @@ func (ec *EnrollmentController) UpdateEnrollmentProgress(c *gin.Context) {
 	}
 
 	// Get user ID from context
-	userID, exists := c.Get("userID")
-	if !exists {
+	if _, exists := c.Get("userID"); !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
@@ -212,8 +268,13 @@ func (ec *EnrollmentController) UpdateEnrollmentProgress(c *gin.Context) {
 	}
 
 	// Verify ownership - only the enrolled user can update their progress
-	if enrollment.UserID != userID.(uuid.UUID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to update this enrollment"})
+	if !authorize(c, policy.EnrollmentUpdate, policy.OwnedBy(enrollment.UserID), "You don't have permission to update this enrollment") {
+		return
+	}
+
+	// Progress can't be recorded once access has expired
+	if enrollment.IsExpired(time.Now()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your access to this course has expired"})
 		return
 	}
 
@@ -244,8 +305,7 @@ func (ec *EnrollmentController) DropEnrollment(c *gin.Context) {
 	}
 
 	// Get user ID from context
-	userID, exists := c.Get("userID")
-	if !exists {
+	if _, exists := c.Get("userID"); !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
@@ -259,14 +319,164 @@ func (ec *EnrollmentController) DropEnrollment(c *gin.Context) {
 	}
 
 	// Verify ownership - only the enrolled user can drop the course
-	if enrollment.UserID != userID.(uuid.UUID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to drop this enrollment"})
+	if !authorize(c, policy.EnrollmentUpdate, policy.OwnedBy(enrollment.UserID), "You don't have permission to drop this enrollment") {
+		return
+	}
+
+	// Expired enrollments stay expired, dropping them would let the student enroll again
+	if enrollment.IsExpired(time.Now()) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Your access to this course has expired"})
 		return
 	}
 
 	// Mark as dropped
 	enrollment.MarkAsDropped()
 	ec.db.Save(&enrollment)
+	publishEnrollmentAccess(ec.messageBroker, enrollment)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Successfully dropped the course", "enrollment": enrollment})
 }
+
+// resolveEnrollmentCohort picks the cohort for a new enrollment and checks that it can take another student.
+// It returns an HTTP status and message when the enrollment must be rejected. The cohort is locked, so
+// inside a transaction no other enrollment can take its last seat until the new one is saved.
+func resolveEnrollmentCohort(db *gorm.DB, courseID int, requested *uuid.UUID) (*uuid.UUID, int, string) {
+	var cohortCount int64
+	db.Model(&models.Cohort{}).Where("course_id = ?", courseID).Count(&cohortCount)
+	if cohortCount == 0 {
+		// Course doesn't run in cohorts
+		return nil, 0, ""
+	}
+	if requested == nil {
+		return nil, http.StatusBadRequest, "This course runs in cohorts, cohort_id is required"
+	}
+
+	var cohort models.Cohort
+	err := db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND course_id = ?", *requested, courseID).
+		First(&cohort).Error
+	if err != nil {
+		return nil, http.StatusNotFound, "Cohort not found"
+	}
+	if !cohort.IsOpen(time.Now()) {
+		return nil, http.StatusConflict, "Cohort has already ended"
+	}
+	if !cohort.HasCapacity(countCohortEnrollments(db, cohort.ID)) {
+		return nil, http.StatusConflict, "Cohort is full"
+	}
+
+	return &cohort.ID, 0, ""
+}
+
+// countCohortEnrollments counts the enrollments taking a seat in a cohort
+func countCohortEnrollments(db *gorm.DB, cohortID uuid.UUID) int64 {
+	var enrolled int64
+	db.Model(&models.Enrollment{}).
+		Where("cohort_id = ? AND status <> ?", cohortID, models.EnrollmentStatusDropped).
+		Count(&enrolled)
+	return enrolled
+}
+
+// ExtendEnrollmentAccess extends or sets the access expiry of an enrollment (admin only)
+func (ec *EnrollmentController) ExtendEnrollmentAccess(c *gin.Context) {
+	// Get enrollment ID from URL
+	enrollmentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid enrollment ID"})
+		return
+	}
+
+	// Either an explicit expiry date or a number of days to add
+	var req struct {
+		ExpiresAt  *time.Time `json:"expires_at"`
+		ExtendDays int        `json:"extend_days" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (req.ExpiresAt == nil) == (req.ExtendDays == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provide either expires_at or extend_days"})
+		return
+	}
+
+	var enrollment models.Enrollment
+	if err := ec.db.First(&enrollment, enrollmentID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Enrollment not found"})
+		return
+	}
+	if enrollment.Status == models.EnrollmentStatusDropped {
+		c.JSON(http.StatusConflict, gin.H{"error": "Enrollment was dropped"})
+		return
+	}
+
+	now := time.Now()
+	until := now
+	if req.ExpiresAt != nil {
+		until = *req.ExpiresAt
+	} else {
+		// Extend from the current expiry, or from now if access already ran out
+		if enrollment.ExpiresAt != nil && enrollment.ExpiresAt.After(now) {
+			until = *enrollment.ExpiresAt
+		}
+		until = until.AddDate(0, 0, req.ExtendDays)
+	}
+	if !until.After(now) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "New expiry date must be in the future"})
+		return
+	}
+
+	enrollment.ExtendAccess(until)
+	if err := ec.db.Save(&enrollment).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to extend access"})
+		return
+	}
+	publishEnrollmentAccess(ec.messageBroker, enrollment)
+	setAuditEntry(c, "enrollment.extend_access", "enrollment", enrollment.ID.String(),
+		map[string]interface{}{"expires_at": until})
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// GetEnrollmentAccess tells another service about the access window of a user in a course, for
+// when the enrollment event didn't reach it
+func (ec *EnrollmentController) GetEnrollmentAccess(c *gin.Context) {
+	if !authenticateService(c, ec.serviceSecret) {
+		return
+	}
+
+	userID, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	courseID, err := strconv.Atoi(c.Query("course_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid course ID"})
+		return
+	}
+
+	var enrollment models.Enrollment
+	if err := ec.db.Where("user_id = ? AND course_id = ?", userID, courseID).First(&enrollment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Enrollment not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollmentAccessEvent(enrollment))
+}
+
+// publishEnrollmentAccess lets other services know about the access window of an enrollment
+func publishEnrollmentAccess(messageBroker *services.MessageBroker, enrollment models.Enrollment) {
+	services.PublishEvent(messageBroker, services.EventEnrollmentAccessUpdated, enrollmentAccessEvent(enrollment))
+}
+
+// enrollmentAccessEvent describes the access window of an enrollment for content-delivery
+func enrollmentAccessEvent(enrollment models.Enrollment) services.EnrollmentAccessEvent {
+	return services.EnrollmentAccessEvent{
+		EnrollmentID: enrollment.ID,
+		UserID:       enrollment.UserID,
+		CourseID:     enrollment.CourseID,
+		Status:       string(enrollment.Status),
+		ExpiresAt:    enrollment.ExpiresAt,
+	}
+}
@@ -0,0 +1,308 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"gorm.io/gorm"
+)
+
+// errApplicationAlreadyReviewed is returned when two admins review the same application at once
+var errApplicationAlreadyReviewed = errors.New("application was already reviewed")
+
+// InstructorApplicationController lets students apply to become instructors and admins review the applications
+type InstructorApplicationController struct {
+	db     *gorm.DB
+	tokens *services.TokenService
+	mailer services.Mailer
+}
+
+// NewInstructorApplicationController creates a new instructor application controller
+func NewInstructorApplicationController(db *gorm.DB, tokens *services.TokenService, mailer services.Mailer) *InstructorApplicationController {
+	return &InstructorApplicationController{
+		db:     db,
+		tokens: tokens,
+		mailer: mailer,
+	}
+}
+
+// Apply submits an application to become an instructor. A student can only have one pending application.
+func (ic *InstructorApplicationController) Apply(c *gin.Context) {
+	var req struct {
+		Motivation  string                         `json:"motivation" binding:"required"`
+		Attachments []models.ApplicationAttachment `json:"attachments"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Motivation is required"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	var user models.User
+	if err := ic.db.First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if !user.IsStudent() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only students can apply to become instructors"})
+		return
+	}
+
+	var pending int64
+	ic.db.Model(&models.InstructorApplication{}).
+		Where("user_id = ? AND status = ?", user.ID, models.ApplicationStatusPending).
+		Count(&pending)
+	if pending > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "You already have a pending application"})
+		return
+	}
+
+	application := models.InstructorApplication{
+		UserID:      user.ID,
+		Motivation:  req.Motivation,
+		Attachments: req.Attachments,
+		Status:      models.ApplicationStatusPending,
+	}
+	if err := application.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": applicationErrorMessage(err)})
+		return
+	}
+	if err := ic.db.Create(&application).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit application"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, application)
+}
+
+// GetMyApplications lists the current user's applications, newest first, with the reviewers' comments
+func (ic *InstructorApplicationController) GetMyApplications(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var applications []models.InstructorApplication
+	if err := ic.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&applications).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch applications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, applications)
+}
+
+// ListApplications is the review queue (admin only). It shows pending applications, oldest first,
+// unless another status is asked for.
+func (ic *InstructorApplicationController) ListApplications(c *gin.Context) {
+	page, pageSize := pageParams(c.DefaultQuery("page", "1"), c.DefaultQuery("pageSize", "10"))
+
+	status := models.ApplicationStatus(c.DefaultQuery("status", string(models.ApplicationStatusPending)))
+	switch status {
+	case models.ApplicationStatusPending, models.ApplicationStatusApproved, models.ApplicationStatusRejected:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be pending, approved or rejected"})
+		return
+	}
+
+	query := ic.db.Model(&models.InstructorApplication{}).Where("status = ?", status)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch applications"})
+		return
+	}
+
+	order := "reviewed_at DESC"
+	if status == models.ApplicationStatusPending {
+		order = "created_at ASC"
+	}
+	var applications []models.InstructorApplication
+	if err := query.Preload("User").Order(order).Offset((page - 1) * pageSize).Limit(pageSize).
+		Find(&applications).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch applications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"applications": applications,
+		"total":        total,
+		"page":         page,
+		"page_size":    pageSize,
+	})
+}
+
+// GetApplication returns an application with its applicant (admin only)
+func (ic *InstructorApplicationController) GetApplication(c *gin.Context) {
+	application, ok := ic.findApplication(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, application)
+}
+
+// ApproveApplication approves an application and makes the applicant an instructor (admin only).
+// Their access tokens are revoked so the next refresh carries the new role.
+func (ic *InstructorApplicationController) ApproveApplication(c *gin.Context) {
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	// The comment is optional when approving, so an empty body is fine
+	_ = c.ShouldBindJSON(&req)
+
+	application, ok := ic.findApplication(c)
+	if !ok {
+		return
+	}
+
+	adminID, _ := c.Get("userID")
+	application.Approve(adminID.(uuid.UUID), req.Comment, time.Now())
+	err := ic.db.Transaction(func(tx *gorm.DB) error {
+		if err := ic.saveReview(tx, application); err != nil {
+			return err
+		}
+		// Admins who applied while they were students keep their role
+		return tx.Model(&models.User{}).
+			Where("id = ? AND role = ?", application.UserID, models.RoleStudent).
+			Update("role", models.RoleInstructor).Error
+	})
+	if !ic.respondReviewError(c, err) {
+		return
+	}
+	if application.User.IsStudent() {
+		application.User.Role = models.RoleInstructor
+	}
+
+	if err := ic.tokens.RevokeAccessTokensForUser(c.Request.Context(), application.UserID); err != nil {
+		log.Printf("Failed to revoke access tokens of user %s after approving their application: %v", application.UserID, err)
+	}
+	ic.notifyApplicant(c, application)
+	setAuditEntry(c, "instructor_application.approve", "instructor_application", application.ID.String(),
+		map[string]interface{}{"user_id": application.UserID, "comment": req.Comment})
+
+	c.JSON(http.StatusOK, application)
+}
+
+// RejectApplication rejects an application (admin only). The comment tells the applicant why.
+func (ic *InstructorApplicationController) RejectApplication(c *gin.Context) {
+	var req struct {
+		Comment string `json:"comment" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A comment is required when rejecting an application"})
+		return
+	}
+
+	application, ok := ic.findApplication(c)
+	if !ok {
+		return
+	}
+
+	adminID, _ := c.Get("userID")
+	application.Reject(adminID.(uuid.UUID), req.Comment, time.Now())
+	if !ic.respondReviewError(c, ic.saveReview(ic.db, application)) {
+		return
+	}
+
+	ic.notifyApplicant(c, application)
+	setAuditEntry(c, "instructor_application.reject", "instructor_application", application.ID.String(),
+		map[string]interface{}{"user_id": application.UserID, "comment": req.Comment})
+
+	c.JSON(http.StatusOK, application)
+}
+
+// findApplication loads the application of the :id parameter with its applicant, writing an error
+// response if that fails
+func (ic *InstructorApplicationController) findApplication(c *gin.Context) (*models.InstructorApplication, bool) {
+	applicationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid application ID"})
+		return nil, false
+	}
+
+	var application models.InstructorApplication
+	if err := ic.db.Preload("User").First(&application, "id = ?", applicationID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Application not found"})
+		return nil, false
+	}
+	return &application, true
+}
+
+// saveReview stores the outcome of a review, unless the application was reviewed in the meantime
+func (ic *InstructorApplicationController) saveReview(tx *gorm.DB, application *models.InstructorApplication) error {
+	result := tx.Model(&models.InstructorApplication{}).
+		Where("id = ? AND status = ?", application.ID, models.ApplicationStatusPending).
+		Updates(map[string]interface{}{
+			"status":         application.Status,
+			"reviewer_id":    application.ReviewerID,
+			"review_comment": application.ReviewComment,
+			"reviewed_at":    application.ReviewedAt,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errApplicationAlreadyReviewed
+	}
+	return nil
+}
+
+// respondReviewError writes the error response of a failed review. It returns true if there was no error.
+func (ic *InstructorApplicationController) respondReviewError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errApplicationAlreadyReviewed):
+		c.JSON(http.StatusConflict, gin.H{"error": "Application was already reviewed"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to review application"})
+	}
+	return false
+}
+
+// notifyApplicant mails the applicant the outcome of the review. A failure is only logged, the
+// outcome is also shown with their applications.
+func (ic *InstructorApplicationController) notifyApplicant(c *gin.Context, application *models.InstructorApplication) {
+	if ic.mailer == nil {
+		return
+	}
+
+	var outcome string
+	if application.Status == models.ApplicationStatusApproved {
+		outcome = "Congratulations, your application to become an instructor on LearnVibe was approved. " +
+			"Log in again to start creating courses."
+	} else {
+		outcome = "Unfortunately, your application to become an instructor on LearnVibe was not approved."
+	}
+	body := fmt.Sprintf("Hi %s,\n\n%s\n", application.User.Name, outcome)
+	if application.ReviewComment != "" {
+		body += fmt.Sprintf("\nThe reviewer's comment:\n\n%s\n", application.ReviewComment)
+	}
+
+	err := ic.mailer.Send(c.Request.Context(), services.Email{
+		To:      application.User.Email,
+		Subject: "Your LearnVibe instructor application",
+		Body:    body,
+	})
+	if err != nil {
+		log.Printf("Failed to notify user %s about their application: %v", application.UserID, err)
+	}
+}
+
+// applicationErrorMessage turns an application validation error into a message for the applicant
+func applicationErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, models.ErrMotivationTooShort):
+		return fmt.Sprintf("Motivation must be at least %d characters", models.MinMotivationLength)
+	case errors.Is(err, models.ErrMotivationTooLong):
+		return fmt.Sprintf("Motivation must be at most %d characters", models.MaxMotivationLength)
+	case errors.Is(err, models.ErrTooManyAttachments):
+		return fmt.Sprintf("At most %d attachments are allowed", models.MaxAttachments)
+	default:
+		return "Attachments need a name and an http(s) URL"
+	}
+}
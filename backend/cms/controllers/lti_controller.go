@@ -0,0 +1,340 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/config"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"gorm.io/gorm"
+)
+
+// LTIController lets LMS platforms launch courses with LTI 1.3 and lets admins register the platforms
+type LTIController struct {
+	db            *gorm.DB
+	config        *config.Config
+	lti           *services.LTIService
+	auth          *AuthController
+	messageBroker *services.MessageBroker
+}
+
+// NewLTIController creates a new LTI controller. Launches log users in through the auth controller.
+func NewLTIController(db *gorm.DB, cfg *config.Config, lti *services.LTIService, auth *AuthController,
+	messageBroker *services.MessageBroker) *LTIController {
+	return &LTIController{
+		db:            db,
+		config:        cfg,
+		lti:           lti,
+		auth:          auth,
+		messageBroker: messageBroker,
+	}
+}
+
+// ltiPlatformRequest is the payload for registering or updating a platform
+type ltiPlatformRequest struct {
+	Name           string     `json:"name" binding:"required,max=100"`
+	Issuer         string     `json:"issuer" binding:"required,url"`
+	ClientID       string     `json:"client_id" binding:"required"`
+	DeploymentIDs  []string   `json:"deployment_ids" binding:"required,min=1"`
+	AuthLoginURL   string     `json:"auth_login_url" binding:"required,url"`
+	AuthTokenURL   string     `json:"auth_token_url" binding:"omitempty,url"`
+	KeySetURL      string     `json:"key_set_url" binding:"required,url"`
+	OrganizationID *uuid.UUID `json:"organization_id"` // Defaults to the default organization
+}
+
+// Login is the tool's third-party initiated login: the platform sends the browser here to start a
+// launch, and we send it back to the platform to authenticate the user
+func (lc *LTIController) Login(c *gin.Context) {
+	login := services.LTILoginRequest{
+		Issuer:        ltiParam(c, "iss"),
+		LoginHint:     ltiParam(c, "login_hint"),
+		TargetLinkURI: ltiParam(c, "target_link_uri"),
+		MessageHint:   ltiParam(c, "lti_message_hint"),
+		ClientID:      ltiParam(c, "client_id"),
+		DeploymentID:  ltiParam(c, "lti_deployment_id"),
+	}
+	if login.Issuer == "" || login.LoginHint == "" || login.TargetLinkURI == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "iss, login_hint and target_link_uri are required"})
+		return
+	}
+
+	platform, err := lc.lti.FindPlatform(login.Issuer, login.ClientID)
+	if errors.Is(err, services.ErrUnknownLTIPlatform) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown LTI platform"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start the launch"})
+		return
+	}
+	if login.DeploymentID != "" && !platform.HasDeployment(login.DeploymentID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown deployment"})
+		return
+	}
+
+	state, err := services.GenerateOpaqueToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate random state"})
+		return
+	}
+	nonce, err := services.GenerateOpaqueToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate nonce"})
+		return
+	}
+
+	authURL, err := lc.lti.AuthRequestURL(platform, login, lc.config.LTIToolURL+"/lti/launch", state, nonce)
+	if err != nil {
+		log.Printf("Failed to start LTI launch: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start the launch"})
+		return
+	}
+
+	// The state store is shared with provider logins; the provider name keeps the two apart
+	err = lc.auth.states.Save(c.Request.Context(), state, services.OAuthState{
+		Provider: platform.IdentityProvider(),
+		Nonce:    nonce,
+	}, oauthStateTTL)
+	if err != nil {
+		log.Printf("Failed to save LTI state: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start the launch"})
+		return
+	}
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Launch receives the signed launch the platform posts after authenticating the user. It
+// provisions the user and their enrollment, logs them in and sends them to the course.
+func (lc *LTIController) Launch(c *gin.Context) {
+	// The platform reports a failed authentication to the launch URL
+	if platformError := c.PostForm("error"); platformError != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "The platform refused the launch", "platform_error": platformError})
+		return
+	}
+
+	// Verify state; it is single-use and bound to the platform the launch started at
+	state, err := lc.auth.states.Take(c.Request.Context(), c.PostForm("state"))
+	if errors.Is(err, services.ErrUnknownOAuthState) || err == nil && !strings.HasPrefix(state.Provider, "lti:") {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired state"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load LTI state: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to finish the launch"})
+		return
+	}
+	platformID, err := uuid.Parse(strings.TrimPrefix(state.Provider, "lti:"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired state"})
+		return
+	}
+	platform, err := lc.lti.GetPlatform(platformID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unknown LTI platform"})
+		return
+	}
+
+	launch, err := lc.lti.ValidateLaunch(c.Request.Context(), platform, c.PostForm("id_token"), state.Nonce)
+	if err != nil {
+		log.Printf("LTI launch from %s failed: %v", platform.Issuer, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify the launch"})
+		return
+	}
+
+	provisioned, err := lc.lti.Provision(launch)
+	switch {
+	case errors.Is(err, services.ErrLTIEmailMissing):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The platform didn't share your email address, ask its administrator to enable it"})
+		return
+	case errors.Is(err, services.ErrAccountExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "A LearnVibe account with your email address already exists and isn't linked to this platform"})
+		return
+	case errors.Is(err, services.ErrLTICourseNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "The course of this link doesn't exist"})
+		return
+	case err != nil:
+		log.Printf("Failed to provision LTI launch from %s: %v", platform.Issuer, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to finish the launch"})
+		return
+	}
+	if provisioned.Enrolled {
+		publishEnrollmentAccess(lc.messageBroker, *provisioned.Enrollment)
+	}
+
+	lc.auth.finishBrowserLogin(c, provisioned.User, "lti", lc.config.AppBaseURL+"/courses/"+strconv.Itoa(provisioned.Course.ID))
+}
+
+// ListPlatforms lists the registered platforms (admin only)
+func (lc *LTIController) ListPlatforms(c *gin.Context) {
+	var platforms []models.LTIPlatform
+	if err := lc.db.Order("created_at ASC").Find(&platforms).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch LTI platforms"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"platforms": platforms})
+}
+
+// CreatePlatform registers a platform that may launch the organization's courses (admin only)
+func (lc *LTIController) CreatePlatform(c *gin.Context) {
+	var req ltiPlatformRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var platform models.LTIPlatform
+	if !lc.applyPlatformRequest(c, &platform, req) {
+		return
+	}
+
+	var existing int64
+	lc.db.Model(&models.LTIPlatform{}).Where("issuer = ? AND client_id = ?", platform.Issuer, platform.ClientID).Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "This platform and client ID are already registered"})
+		return
+	}
+
+	if err := lc.db.Create(&platform).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register LTI platform"})
+		return
+	}
+	setAuditEntry(c, "lti_platform.create", "lti_platform", platform.ID.String(), map[string]interface{}{
+		"issuer":          platform.Issuer,
+		"client_id":       platform.ClientID,
+		"organization_id": platform.OrganizationID,
+	})
+
+	c.JSON(http.StatusCreated, platform)
+}
+
+// UpdatePlatform changes a platform's registration, e.g. its URLs or deployments (admin only)
+func (lc *LTIController) UpdatePlatform(c *gin.Context) {
+	platform, ok := lc.loadPlatform(c)
+	if !ok {
+		return
+	}
+
+	var req ltiPlatformRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	before := platformAuditValues(*platform)
+	if !lc.applyPlatformRequest(c, platform, req) {
+		return
+	}
+
+	var existing int64
+	lc.db.Model(&models.LTIPlatform{}).Where("issuer = ? AND client_id = ? AND id <> ?", platform.Issuer, platform.ClientID, platform.ID).
+		Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "This platform and client ID are already registered"})
+		return
+	}
+
+	if err := lc.db.Save(platform).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update LTI platform"})
+		return
+	}
+	setAuditChange(c, "lti_platform.update", "lti_platform", platform.ID.String(), before, platformAuditValues(*platform))
+
+	c.JSON(http.StatusOK, platform)
+}
+
+// DeletePlatform removes a platform's registration and its resource links, so it can't launch
+// courses anymore. Users it provisioned keep their accounts. (admin only)
+func (lc *LTIController) DeletePlatform(c *gin.Context) {
+	platform, ok := lc.loadPlatform(c)
+	if !ok {
+		return
+	}
+
+	err := lc.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("platform_id = ?", platform.ID).Delete(&models.LTIResourceLink{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(platform).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete LTI platform"})
+		return
+	}
+	setAuditChange(c, "lti_platform.delete", "lti_platform", platform.ID.String(), platformAuditValues(*platform), nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "LTI platform deleted"})
+}
+
+// loadPlatform loads the platform of the :id path parameter, writing the error response if that fails
+func (lc *LTIController) loadPlatform(c *gin.Context) (*models.LTIPlatform, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid platform ID"})
+		return nil, false
+	}
+
+	platform, err := lc.lti.GetPlatform(id)
+	if errors.Is(err, services.ErrUnknownLTIPlatform) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "LTI platform not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch LTI platform"})
+		return nil, false
+	}
+	return platform, true
+}
+
+// applyPlatformRequest copies a registration request to a platform, checking the organization
+// exists. It writes the error response and returns false if it doesn't.
+func (lc *LTIController) applyPlatformRequest(c *gin.Context, platform *models.LTIPlatform, req ltiPlatformRequest) bool {
+	organizationID := claims.DefaultOrganizationID
+	if req.OrganizationID != nil {
+		organizationID = *req.OrganizationID
+	}
+	var organization models.Organization
+	if err := lc.db.First(&organization, "id = ?", organizationID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization not found"})
+		return false
+	}
+
+	platform.Name = strings.TrimSpace(req.Name)
+	platform.Issuer = strings.TrimSpace(req.Issuer)
+	platform.ClientID = strings.TrimSpace(req.ClientID)
+	platform.DeploymentIDs = req.DeploymentIDs
+	platform.AuthLoginURL = req.AuthLoginURL
+	platform.AuthTokenURL = req.AuthTokenURL
+	platform.KeySetURL = req.KeySetURL
+	platform.OrganizationID = organization.ID
+	return true
+}
+
+// platformAuditValues are the values of a registration recorded in the audit log
+func platformAuditValues(platform models.LTIPlatform) map[string]interface{} {
+	return map[string]interface{}{
+		"name":            platform.Name,
+		"issuer":          platform.Issuer,
+		"client_id":       platform.ClientID,
+		"deployment_ids":  platform.DeploymentIDs,
+		"auth_login_url":  platform.AuthLoginURL,
+		"auth_token_url":  platform.AuthTokenURL,
+		"key_set_url":     platform.KeySetURL,
+		"organization_id": platform.OrganizationID,
+	}
+}
+
+// ltiParam reads a login initiation parameter, which platforms send as a form or in the query
+func ltiParam(c *gin.Context, key string) string {
+	if value, ok := c.GetPostForm(key); ok {
+		return value
+	}
+	return c.Query(key)
+}
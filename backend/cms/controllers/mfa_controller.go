@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"gorm.io/gorm"
+)
+
+// MFAController lets users manage their two-factor authentication and admins set the MFA policy
+type MFAController struct {
+	db  *gorm.DB
+	mfa *services.MFAService
+}
+
+// NewMFAController creates a new MFA controller
+func NewMFAController(db *gorm.DB, mfa *services.MFAService) *MFAController {
+	return &MFAController{db: db, mfa: mfa}
+}
+
+// mfaCodeRequest is the body of the endpoints that need a TOTP or recovery code
+type mfaCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// GetStatus returns whether the current user has MFA enabled and whether their role requires it
+func (mc *MFAController) GetStatus(c *gin.Context) {
+	user, ok := mc.currentUser(c)
+	if !ok {
+		return
+	}
+
+	required, err := mc.mfa.IsRequired(user.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load MFA policy"})
+		return
+	}
+
+	var recoveryCodesLeft int64
+	mc.db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).Count(&recoveryCodesLeft)
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":             user.MFAEnabled,
+		"required":            required,
+		"recovery_codes_left": recoveryCodesLeft,
+	})
+}
+
+// Setup generates a TOTP secret for the current user. The provisioning URI is meant to be shown as a QR code.
+func (mc *MFAController) Setup(c *gin.Context) {
+	user, ok := mc.currentUser(c)
+	if !ok {
+		return
+	}
+
+	secret, uri, err := mc.mfa.BeginSetup(user)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":           secret,
+		"provisioning_uri": uri,
+	})
+}
+
+// Enable turns MFA on with a code from the authenticator app and returns the recovery codes
+func (mc *MFAController) Enable(c *gin.Context) {
+	var req mfaCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Code is required"})
+		return
+	}
+	user, ok := mc.currentUser(c)
+	if !ok {
+		return
+	}
+
+	codes, err := mc.mfa.Enable(user, req.Code)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled. Store the recovery codes somewhere safe, they are only shown once",
+		"recovery_codes": codes,
+	})
+}
+
+// Disable turns MFA off, unless the policy requires it for the user's role
+func (mc *MFAController) Disable(c *gin.Context) {
+	var req mfaCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Code is required"})
+		return
+	}
+	user, ok := mc.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if err := mc.mfa.Disable(user, req.Code); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces the current user's recovery codes
+func (mc *MFAController) RegenerateRecoveryCodes(c *gin.Context) {
+	var req mfaCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Code is required"})
+		return
+	}
+	user, ok := mc.currentUser(c)
+	if !ok {
+		return
+	}
+
+	codes, err := mc.mfa.RegenerateRecoveryCodes(user, req.Code)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// GetPolicies lists for every role whether MFA is mandatory (admin only)
+func (mc *MFAController) GetPolicies(c *gin.Context) {
+	policies, err := mc.mfa.Policies()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load MFA policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, policies)
+}
+
+// SetPolicy makes MFA mandatory or optional for a role (admin only). Users of the role without
+// MFA have to set it up at their next login.
+func (mc *MFAController) SetPolicy(c *gin.Context) {
+	role := models.Role(c.Param("role"))
+	if !models.IsValidRole(role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+
+	var req struct {
+		Required *bool `json:"required" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	adminID, _ := c.Get("userID")
+	policy, err := mc.mfa.SetPolicy(role, *req.Required, adminID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update MFA policy"})
+		return
+	}
+	setAuditEntry(c, "mfa_policy.update", "role", string(role), map[string]interface{}{"required": *req.Required})
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// currentUser loads the authenticated user, writing an error response if that fails
+func (mc *MFAController) currentUser(c *gin.Context) (*models.User, bool) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return nil, false
+	}
+
+	var user models.User
+	if err := mc.db.First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	return &user, true
+}
+
+// respondMFAError maps MFA service errors to responses
+func respondMFAError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidMFACode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+	case errors.Is(err, services.ErrInvalidMFAChallenge):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token, please log in again"})
+	case errors.Is(err, services.ErrMFAAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+	case errors.Is(err, services.ErrMFANotEnabled):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+	case errors.Is(err, services.ErrMFASetupNotStarted):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start the setup first"})
+	case errors.Is(err, services.ErrMFARequiredByPolicy):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is mandatory for your role"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Two-factor authentication failed"})
+	}
+}
@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"gorm.io/gorm"
+)
+
+// OrganizationController handles the organizations and their management by org admins
+type OrganizationController struct {
+	db     *gorm.DB
+	tokens *services.TokenService
+}
+
+// NewOrganizationController creates a new organization controller
+func NewOrganizationController(db *gorm.DB, tokens *services.TokenService) *OrganizationController {
+	return &OrganizationController{
+		db:     db,
+		tokens: tokens,
+	}
+}
+
+// GetOrganization returns the organization of the current user
+func (oc *OrganizationController) GetOrganization(c *gin.Context) {
+	var organization models.Organization
+	if err := oc.db.First(&organization, "id = ?", currentSubject(c).OrganizationID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, organization)
+}
+
+// ListUsers lists the users of the org admin's organization with pagination
+func (oc *OrganizationController) ListUsers(c *gin.Context) {
+	page, pageSize := pageParams(c.DefaultQuery("page", "1"), c.DefaultQuery("pageSize", "10"))
+
+	query := models.ForOrganization(oc.db, currentSubject(c).OrganizationID).Model(&models.User{})
+	if role := c.Query("role"); role != "" {
+		if !models.IsValidRole(models.Role(role)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+			return
+		}
+		query = query.Where("role = ?", role)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	var users []models.User
+	if err := query.Order("created_at DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":     users,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// ChangeRole changes the role of a user of the org admin's organization. Org admins can't make
+// platform admins, nor change the role of one.
+func (oc *OrganizationController) ChangeRole(c *gin.Context) {
+	var req struct {
+		Role models.Role `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || !isOrganizationRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be student, instructor or org_admin"})
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	// Users of other organizations don't exist as far as the org admin is concerned
+	subject := currentSubject(c)
+	var user models.User
+	if err := models.ForOrganization(oc.db, subject.OrganizationID).First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.ID == subject.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't change your own role"})
+		return
+	}
+	if user.IsAdmin() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only platform admins can change the role of a platform admin"})
+		return
+	}
+	if user.Role == req.Role {
+		c.JSON(http.StatusOK, user)
+		return
+	}
+
+	previous := user.Role
+	if err := oc.db.Model(&user).Update("role", req.Role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change role"})
+		return
+	}
+	user.Role = req.Role
+	if err := oc.tokens.RevokeAccessTokensForUser(c.Request.Context(), user.ID); err != nil {
+		log.Printf("Failed to revoke access tokens of user %s after role change: %v", user.ID, err)
+	}
+	c.Set(services.AuditContextKey, services.AuditEntry{
+		Action:     "organization.change_role",
+		TargetType: "user",
+		TargetID:   user.ID.String(),
+		Details:    map[string]interface{}{"organization": subject.OrganizationID},
+		Before:     map[string]interface{}{"role": previous},
+		After:      map[string]interface{}{"role": req.Role},
+	})
+
+	c.JSON(http.StatusOK, user)
+}
+
+// SetRegistration opens or closes the org admin's organization to self-registration
+func (oc *OrganizationController) SetRegistration(c *gin.Context) {
+	var req struct {
+		Open *bool `json:"open" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "open is required"})
+		return
+	}
+
+	subject := currentSubject(c)
+	var organization models.Organization
+	if err := oc.db.First(&organization, "id = ?", subject.OrganizationID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return
+	}
+	if organization.ID == claims.DefaultOrganizationID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The default organization is always open"})
+		return
+	}
+
+	previous := organization.OpenRegistration
+	if err := oc.db.Model(&organization).Update("open_registration", *req.Open).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update organization"})
+		return
+	}
+	organization.OpenRegistration = *req.Open
+	c.Set(services.AuditContextKey, services.AuditEntry{
+		Action:     "organization.set_registration",
+		TargetType: "organization",
+		TargetID:   organization.ID.String(),
+		Before:     map[string]interface{}{"open_registration": previous},
+		After:      map[string]interface{}{"open_registration": organization.OpenRegistration},
+	})
+
+	c.JSON(http.StatusOK, organization)
+}
+
+// ListOrganizations lists every organization on the platform (platform admins)
+func (oc *OrganizationController) ListOrganizations(c *gin.Context) {
+	var organizations []models.Organization
+	if err := oc.db.Order("name").Find(&organizations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch organizations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, organizations)
+}
+
+// CreateOrganization creates an organization (platform admins). Its first org admin is appointed by
+// changing the role of a user who registered with the organization's slug, which needs
+// open_registration until the org admin closes it.
+func (oc *OrganizationController) CreateOrganization(c *gin.Context) {
+	var req struct {
+		Name             string `json:"name" binding:"required,max=100"`
+		Slug             string `json:"slug" binding:"required"`
+		OpenRegistration bool   `json:"open_registration"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Slug = strings.ToLower(strings.TrimSpace(req.Slug))
+	if !models.IsValidOrganizationSlug(req.Slug) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Slug must be 3 to 50 lowercase letters, digits and dashes"})
+		return
+	}
+
+	var existing models.Organization
+	if err := oc.db.Where("slug = ?", req.Slug).First(&existing).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "An organization with this slug already exists"})
+		return
+	}
+
+	organization := models.Organization{Name: strings.TrimSpace(req.Name), Slug: req.Slug, OpenRegistration: req.OpenRegistration}
+	if err := oc.db.Create(&organization).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create organization"})
+		return
+	}
+	setAuditEntry(c, "organization.create", "organization", organization.ID.String(), map[string]interface{}{
+		"slug":              organization.Slug,
+		"open_registration": organization.OpenRegistration,
+	})
+
+	c.JSON(http.StatusCreated, organization)
+}
+
+// isOrganizationRole checks if org admins may give out the role
+func isOrganizationRole(role models.Role) bool {
+	return role == models.RoleStudent || role == models.RoleInstructor || role == models.RoleOrgAdmin
+}
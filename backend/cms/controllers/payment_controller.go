@@ -0,0 +1,619 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/policy"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PaymentController handles checkout, payment webhooks, refunds and coupons
+type PaymentController struct {
+	db              *gorm.DB
+	messageBroker   *services.MessageBroker
+	providers       map[string]services.PaymentProvider
+	defaultProvider string
+}
+
+// NewPaymentController creates a new payment controller.
+// The first provider is used when a checkout doesn't ask for a specific one.
+func NewPaymentController(db *gorm.DB, messageBroker *services.MessageBroker, providers ...services.PaymentProvider) *PaymentController {
+	pc := &PaymentController{
+		db:            db,
+		messageBroker: messageBroker,
+		providers:     make(map[string]services.PaymentProvider),
+	}
+	for _, provider := range providers {
+		if pc.defaultProvider == "" {
+			pc.defaultProvider = provider.Name()
+		}
+		pc.providers[provider.Name()] = provider
+	}
+	return pc
+}
+
+// Checkout creates an order for a paid course and starts the payment with the provider
+func (pc *PaymentController) Checkout(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	courseID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid course ID"})
+		return
+	}
+
+	var req struct {
+		CohortID   *uuid.UUID `json:"cohort_id"`
+		CouponCode string     `json:"coupon_code"`
+		Provider   string     `json:"provider"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	providerName := req.Provider
+	if providerName == "" {
+		providerName = pc.defaultProvider
+	}
+	provider, ok := pc.providers[providerName]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown payment provider"})
+		return
+	}
+
+	var course models.Course
+	if err := tenantDB(c, pc.db).First(&course, courseID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Course not found"})
+		return
+	}
+	if !course.IsPaid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This course is free, enroll directly"})
+		return
+	}
+
+	// Don't sell a course twice
+	var existing models.Enrollment
+	err = pc.db.Where("user_id = ? AND course_id = ? AND status IN ?", userID, courseID,
+		[]models.EnrollmentStatus{models.EnrollmentStatusActive, models.EnrollmentStatusCompleted}).
+		First(&existing).Error
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Already enrolled in this course", "enrollment": existing})
+		return
+	}
+
+	cohortID, status, errMsg := resolveEnrollmentCohort(pc.db, courseID, req.CohortID)
+	if errMsg != "" {
+		c.JSON(status, gin.H{"error": errMsg})
+		return
+	}
+
+	order := models.Order{
+		UserID:      userID.(uuid.UUID),
+		CourseID:    course.ID,
+		CohortID:    cohortID,
+		AmountCents: course.PriceCents,
+		Currency:    course.Currency,
+		Status:      models.OrderStatusPending,
+		Provider:    provider.Name(),
+	}
+
+	// Apply the coupon if one was given
+	if req.CouponCode != "" {
+		var coupon models.Coupon
+		if err := pc.db.Where("code = ?", models.NormalizeCouponCode(req.CouponCode)).First(&coupon).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid coupon code"})
+			return
+		}
+		if err := coupon.Validate(course, time.Now()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		order.CouponID = &coupon.ID
+		order.DiscountCents = coupon.Discount(order.AmountCents)
+	}
+	order.TotalCents = order.AmountCents - order.DiscountCents
+
+	open, err := pc.createOrder(&order)
+	if errors.Is(err, errOrderOpen) {
+		c.JSON(http.StatusConflict, gin.H{"error": "An order for this course is already open", "order": open})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+		return
+	}
+
+	// A 100% discount doesn't need the provider at all
+	if order.TotalCents == 0 {
+		if err := pc.fulfillOrder(&order); err != nil {
+			// No webhook will come for this order, so it mustn't stay pending
+			pc.failOrder(&order)
+			if errors.Is(err, models.ErrCouponUsedUp) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if errors.Is(err, errCohortFull) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Cohort is full"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete order"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"order": order})
+		return
+	}
+
+	var user models.User
+	pc.db.First(&user, "id = ?", order.UserID)
+
+	session, err := provider.CreateCheckout(c.Request.Context(), services.CheckoutRequest{
+		OrderID:     order.ID,
+		Description: course.Title,
+		AmountCents: order.TotalCents,
+		Currency:    order.Currency,
+		CustomerID:  user.ID,
+		Email:       user.Email,
+	})
+	if err != nil {
+		pc.db.Model(&order).Update("status", models.OrderStatusFailed)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to start payment"})
+		return
+	}
+
+	order.ProviderRef = session.ProviderRef
+	order.CheckoutURL = session.URL
+	if err := pc.db.Save(&order).Error; err != nil {
+		// The checkout URL is lost, the order can't be paid
+		pc.failOrder(&order)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save order"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"order": order, "checkout_url": session.URL})
+}
+
+// HandleWebhook processes payment confirmations sent by a provider
+func (pc *PaymentController) HandleWebhook(c *gin.Context) {
+	provider, ok := pc.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown payment provider"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read webhook"})
+		return
+	}
+
+	event, err := provider.ParseWebhook(body, c.Request.Header)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidWebhookSignature) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook payload"})
+		return
+	}
+
+	var order models.Order
+	err = pc.db.Where("provider = ? AND provider_ref = ?", provider.Name(), event.ProviderRef).First(&order).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	// Providers retry webhooks, so anything but a pending order has already been handled
+	if order.Status != models.OrderStatusPending {
+		c.JSON(http.StatusOK, gin.H{"message": "Already processed", "order": order})
+		return
+	}
+
+	switch event.Type {
+	case services.PaymentEventSucceeded:
+		if event.AmountCents != order.TotalCents || !strings.EqualFold(event.Currency, order.Currency) {
+			log.Printf("Payment amount mismatch for order %s: got %d %s, expected %d %s",
+				order.ID, event.AmountCents, event.Currency, order.TotalCents, order.Currency)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Payment amount doesn't match the order"})
+			return
+		}
+		err := pc.fulfillOrder(&order)
+		if errors.Is(err, errOrderProcessed) {
+			c.JSON(http.StatusOK, gin.H{"message": "Already processed", "order": order})
+			return
+		}
+		if isUnfulfillable(err) {
+			// Other orders took the coupon's last uses or the cohort's last seats since checkout,
+			// so the payment is given back
+			log.Printf("Order %s can't be fulfilled (%v), refunding", order.ID, err)
+			if err := provider.Refund(c.Request.Context(), order.ProviderRef, order.TotalCents, order.Currency); err != nil {
+				log.Printf("Failed to refund order %s: %v", order.ID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refund order"})
+				return
+			}
+			pc.failOrder(&order)
+			break
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete order"})
+			return
+		}
+	case services.PaymentEventFailed:
+		if err := pc.failOrder(&order); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+			return
+		}
+	default:
+		// Ignore event types we don't care about, but acknowledge them
+	}
+
+	c.JSON(http.StatusOK, gin.H{"order": order})
+}
+
+// RefundOrder refunds a paid order and drops the enrollment it created (admin only)
+func (pc *PaymentController) RefundOrder(c *gin.Context) {
+	orderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var order models.Order
+	if err := pc.db.First(&order, orderID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if order.Status != models.OrderStatusPaid {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only paid orders can be refunded"})
+		return
+	}
+
+	// Claim the order before asking the provider, so two admins can't refund it twice
+	claim := pc.db.Model(&models.Order{}).
+		Where("id = ? AND status = ?", order.ID, models.OrderStatusPaid).
+		Update("status", models.OrderStatusRefunding)
+	if claim.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refund order"})
+		return
+	}
+	if claim.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only paid orders can be refunded"})
+		return
+	}
+
+	// Orders fully covered by a coupon never went through a provider
+	if order.TotalCents > 0 {
+		provider, ok := pc.providers[order.Provider]
+		if !ok {
+			pc.releaseRefundClaim(order)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Payment provider is no longer configured"})
+			return
+		}
+		if err := provider.Refund(c.Request.Context(), order.ProviderRef, order.TotalCents, order.Currency); err != nil {
+			pc.releaseRefundClaim(order)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Provider refused the refund"})
+			return
+		}
+	}
+
+	var enrollment models.Enrollment
+	err = pc.db.Transaction(func(tx *gorm.DB) error {
+		order.MarkAsRefunded()
+		if err := tx.Save(&order).Error; err != nil {
+			return err
+		}
+		if order.EnrollmentID == nil {
+			return nil
+		}
+		if err := tx.First(&enrollment, *order.EnrollmentID).Error; err != nil {
+			return err
+		}
+		enrollment.MarkAsDropped()
+		return tx.Save(&enrollment).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Refund was issued but the order could not be updated"})
+		return
+	}
+	if order.EnrollmentID != nil {
+		publishEnrollmentAccess(pc.messageBroker, enrollment)
+	}
+	setAuditEntry(c, "order.refund", "order", order.ID.String(), map[string]interface{}{
+		"amount_cents": order.TotalCents,
+		"currency":     order.Currency,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Order refunded", "order": order})
+}
+
+// releaseRefundClaim puts an order back to paid when its refund didn't go through
+func (pc *PaymentController) releaseRefundClaim(order models.Order) {
+	err := pc.db.Model(&models.Order{}).
+		Where("id = ? AND status = ?", order.ID, models.OrderStatusRefunding).
+		Update("status", models.OrderStatusPaid).Error
+	if err != nil {
+		log.Printf("Failed to put order %s back to paid after a failed refund: %v", order.ID, err)
+	}
+}
+
+// GetUserOrders lists the orders of the current user
+func (pc *PaymentController) GetUserOrders(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var orders []models.Order
+	if err := pc.db.Where("user_id = ?", userID).Preload("Course").Order("created_at DESC").Find(&orders).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, orders)
+}
+
+// GetOrder gets an order of the current user (admins can see any order)
+func (pc *PaymentController) GetOrder(c *gin.Context) {
+	orderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var order models.Order
+	if err := pc.db.Preload("Course").First(&order, orderID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	if !authorize(c, policy.OrderRead, policy.OwnedBy(order.UserID).InOrganization(order.Course.OrganizationID), "You don't have permission to view this order") {
+		return
+	}
+
+	c.JSON(http.StatusOK, order)
+}
+
+// CreateCoupon creates a discount code (admin only)
+func (pc *PaymentController) CreateCoupon(c *gin.Context) {
+	var req struct {
+		Code      string              `json:"code" binding:"required"`
+		Type      models.DiscountType `json:"type" binding:"required,oneof=percent fixed"`
+		Value     int64               `json:"value" binding:"required,min=1"`
+		Currency  string              `json:"currency"`
+		CourseID  *int                `json:"course_id"`
+		MaxUses   int                 `json:"max_uses" binding:"min=0"`
+		ExpiresAt *time.Time          `json:"expires_at"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Type == models.DiscountTypePercent && req.Value > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Percentage discounts can't exceed 100"})
+		return
+	}
+	if req.Type == models.DiscountTypeFixed && len(req.Currency) != 3 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Fixed discounts need a 3-letter currency"})
+		return
+	}
+
+	coupon := models.Coupon{
+		Code:      req.Code,
+		Type:      req.Type,
+		Value:     req.Value,
+		Currency:  strings.ToUpper(req.Currency),
+		CourseID:  req.CourseID,
+		MaxUses:   req.MaxUses,
+		ExpiresAt: req.ExpiresAt,
+		Active:    true,
+	}
+
+	var existing models.Coupon
+	if err := pc.db.Where("code = ?", models.NormalizeCouponCode(req.Code)).First(&existing).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Coupon code already exists"})
+		return
+	}
+
+	if err := pc.db.Create(&coupon).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create coupon"})
+		return
+	}
+	setAuditEntry(c, "coupon.create", "coupon", coupon.ID.String(), map[string]interface{}{"code": coupon.Code})
+
+	c.JSON(http.StatusCreated, coupon)
+}
+
+// GetCoupons lists all coupons (admin only)
+func (pc *PaymentController) GetCoupons(c *gin.Context) {
+	var coupons []models.Coupon
+	if err := pc.db.Order("created_at DESC").Find(&coupons).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch coupons"})
+		return
+	}
+
+	c.JSON(http.StatusOK, coupons)
+}
+
+// DeactivateCoupon stops a coupon from being used (admin only)
+func (pc *PaymentController) DeactivateCoupon(c *gin.Context) {
+	couponID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid coupon ID"})
+		return
+	}
+
+	result := pc.db.Model(&models.Coupon{}).Where("id = ?", couponID).Update("active", false)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deactivate coupon"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found"})
+		return
+	}
+	setAuditEntry(c, "coupon.deactivate", "coupon", couponID.String(), nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Coupon deactivated"})
+}
+
+// errOrderProcessed is returned when another request already moved the order out of pending
+var errOrderProcessed = errors.New("order was already processed")
+
+// errOrderOpen is returned when the student already has an open order for the course
+var errOrderOpen = errors.New("an order for this course is already open")
+
+// createOrder saves a new order unless the student has an open one for the course, which is
+// returned with errOrderOpen: a second order would enroll the student again once both are paid.
+// Paid orders whose enrollment expired or was dropped aren't open, the course can be bought again.
+func (pc *PaymentController) createOrder(order *models.Order) (*models.Order, error) {
+	var open models.Order
+	err := pc.db.Transaction(func(tx *gorm.DB) error {
+		// Lock the student, so concurrent checkouts see each other's orders
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.User{}, "id = ?", order.UserID).Error; err != nil {
+			return err
+		}
+
+		err := tx.Where("user_id = ? AND course_id = ?", order.UserID, order.CourseID).
+			Where("status = ? OR (status = ? AND enrollment_id IN (?))", models.OrderStatusPending, models.OrderStatusPaid,
+				tx.Model(&models.Enrollment{}).Select("id").Where("status IN ?",
+					[]models.EnrollmentStatus{models.EnrollmentStatusActive, models.EnrollmentStatusCompleted})).
+			First(&open).Error
+		if err == nil {
+			return errOrderOpen
+		}
+		if err != gorm.ErrRecordNotFound {
+			return err
+		}
+		return tx.Create(order).Error
+	})
+	if err != nil {
+		return &open, err
+	}
+	return nil, nil
+}
+
+// errCohortFull is returned when the order's cohort filled up between checkout and payment
+var errCohortFull = errors.New("cohort is full")
+
+// fulfillOrder marks the order as paid, counts the coupon use and enrolls the student. Only a pending
+// order is fulfilled, once, and only while its coupon has uses left and its cohort has a seat;
+// otherwise nothing changes and errOrderProcessed, models.ErrCouponUsedUp or errCohortFull is
+// returned.
+func (pc *PaymentController) fulfillOrder(order *models.Order) error {
+	var course models.Course
+	var enrollment models.Enrollment
+	paid := *order
+
+	err := pc.db.Transaction(func(tx *gorm.DB) error {
+		// Claim the order, so concurrent webhooks can't both fulfill it
+		paid.MarkAsPaid()
+		claim := tx.Model(&models.Order{}).
+			Where("id = ? AND status = ?", order.ID, models.OrderStatusPending).
+			Updates(map[string]interface{}{"status": paid.Status, "paid_at": paid.PaidAt})
+		if claim.Error != nil {
+			return claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			return errOrderProcessed
+		}
+
+		if err := tx.First(&course, order.CourseID).Error; err != nil {
+			return err
+		}
+
+		// Count the use only while the coupon has uses left, checkout couldn't reserve one
+		if order.CouponID != nil {
+			used := tx.Model(&models.Coupon{}).
+				Where("id = ? AND (max_uses = 0 OR used_count < max_uses)", *order.CouponID).
+				Update("used_count", gorm.Expr("used_count + 1"))
+			if used.Error != nil {
+				return used.Error
+			}
+			if used.RowsAffected == 0 {
+				return models.ErrCouponUsedUp
+			}
+		}
+
+		// Checkout saw a free seat, but other orders may have taken it since; the lock makes
+		// concurrent fulfillments count one after the other
+		if order.CohortID != nil {
+			var cohort models.Cohort
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&cohort, "id = ?", *order.CohortID).Error; err != nil {
+				return err
+			}
+			if !cohort.HasCapacity(countCohortEnrollments(tx, cohort.ID)) {
+				return errCohortFull
+			}
+		}
+
+		// Reuse a previous (dropped or expired) enrollment, the user/course pair is unique
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND course_id = ?", order.UserID, order.CourseID).
+			First(&enrollment).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+
+		enrollment.UserID = order.UserID
+		enrollment.CourseID = order.CourseID
+		enrollment.CohortID = order.CohortID
+		enrollment.Status = models.EnrollmentStatusActive
+		enrollment.EnrolledAt = time.Now()
+		enrollment.StartAccessWindow(course)
+		if err := tx.Save(&enrollment).Error; err != nil {
+			return err
+		}
+
+		paid.EnrollmentID = &enrollment.ID
+		return tx.Model(&models.Order{}).Where("id = ?", order.ID).Update("enrollment_id", enrollment.ID).Error
+	})
+	if err != nil {
+		if !errors.Is(err, errOrderProcessed) && !isUnfulfillable(err) {
+			log.Printf("Failed to fulfill order %s: %v", order.ID, err)
+		}
+		return err
+	}
+
+	*order = paid
+	publishEnrollmentAccess(pc.messageBroker, enrollment)
+	return nil
+}
+
+// isUnfulfillable says whether fulfillOrder refused an order because its coupon or cohort ran out
+// since checkout; such an order fails and its payment is given back
+func isUnfulfillable(err error) bool {
+	return errors.Is(err, models.ErrCouponUsedUp) || errors.Is(err, errCohortFull)
+}
+
+// failOrder marks a pending order as failed
+func (pc *PaymentController) failOrder(order *models.Order) error {
+	result := pc.db.Model(&models.Order{}).
+		Where("id = ? AND status = ?", order.ID, models.OrderStatusPending).
+		Update("status", models.OrderStatusFailed)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		order.Status = models.OrderStatusFailed
+	}
+	return nil
+}
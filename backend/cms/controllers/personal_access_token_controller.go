@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"gorm.io/gorm"
+)
+
+// defaultTokenLifetimeDays is how long a personal access token lives when no lifetime is given
+const defaultTokenLifetimeDays = 90
+
+// PersonalAccessTokenController manages personal access tokens and answers introspection requests
+// from the other services
+type PersonalAccessTokenController struct {
+	db                  *gorm.DB
+	pats                *services.PersonalAccessTokenService
+	introspectionSecret string
+}
+
+// NewPersonalAccessTokenController creates a new personal access token controller. An empty
+// introspectionSecret disables introspection.
+func NewPersonalAccessTokenController(db *gorm.DB, pats *services.PersonalAccessTokenService, introspectionSecret string) *PersonalAccessTokenController {
+	return &PersonalAccessTokenController{
+		db:                  db,
+		pats:                pats,
+		introspectionSecret: introspectionSecret,
+	}
+}
+
+// ListTokens lists the current user's personal access tokens
+func (tc *PersonalAccessTokenController) ListTokens(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	tokens, err := tc.pats.List(userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens, "available_scopes": claims.Scopes})
+}
+
+// CreateToken creates a personal access token. The token is only returned this once.
+func (tc *PersonalAccessTokenController) CreateToken(c *gin.Context) {
+	var req struct {
+		Name          string   `json:"name" binding:"required,max=100"`
+		Scopes        []string `json:"scopes" binding:"required,min=1"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A name and at least one scope are required"})
+		return
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultTokenLifetimeDays
+	}
+	if req.ExpiresInDays < 1 || req.ExpiresInDays > 365 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_days must be between 1 and 365"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	var user models.User
+	if err := tc.db.First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	token, rawToken, err := tc.pats.Create(user, strings.TrimSpace(req.Name), req.Scopes, time.Duration(req.ExpiresInDays)*24*time.Hour)
+	switch {
+	case errors.Is(err, services.ErrInvalidScope), errors.Is(err, services.ErrScopeNotAllowed):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrPersonalAccessTokenLimit):
+		c.JSON(http.StatusConflict, gin.H{"error": "You have too many personal access tokens, revoke some first"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":                 rawToken,
+		"personal_access_token": token,
+		"message":               "Copy the token now, it won't be shown again",
+	})
+}
+
+// RevokeToken revokes one of the current user's personal access tokens
+func (tc *PersonalAccessTokenController) RevokeToken(c *gin.Context) {
+	tokenID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+	userID, _ := c.Get("userID")
+
+	token, err := tc.pats.Revoke(userID.(uuid.UUID), tokenID)
+	if errors.Is(err, services.ErrInvalidPersonalAccessToken) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+// Introspect tells another service whether a personal access token is active, who it belongs to
+// and its scopes (RFC 7662). The caller authenticates with the introspection secret as bearer token.
+func (tc *PersonalAccessTokenController) Introspect(c *gin.Context) {
+	if !authenticateService(c, tc.introspectionSecret) {
+		return
+	}
+
+	// The caller's address isn't the client's, so the last used IP is left alone
+	token, user, err := tc.pats.Authenticate(c.Request.Context(), c.PostForm("token"), "")
+	if err != nil {
+		c.JSON(http.StatusOK, claims.Introspection{Active: false})
+		return
+	}
+
+	c.JSON(http.StatusOK, claims.Introspection{
+		Active:        true,
+		Subject:       user.ID.String(),
+		Role:          string(user.Role),
+		EmailVerified: user.EmailVerified,
+		Organization:  user.OrganizationID.String(),
+		Scope:         strings.Join(token.Scopes, " "),
+		TokenID:       token.ID.String(),
+		ExpiresAt:     token.ExpiresAt.Unix(),
+	})
+}
@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"gorm.io/gorm"
+)
+
+// PrivacyController lets users export their personal data and delete their account
+type PrivacyController struct {
+	db      *gorm.DB
+	privacy *services.PrivacyService
+	auth    *AuthController
+}
+
+// NewPrivacyController creates a new privacy controller. Deletions are confirmed with the password
+// checks of the auth controller.
+func NewPrivacyController(db *gorm.DB, privacy *services.PrivacyService, auth *AuthController) *PrivacyController {
+	return &PrivacyController{
+		db:      db,
+		privacy: privacy,
+		auth:    auth,
+	}
+}
+
+// RequestExport starts an export of the current user's personal data. The archive is assembled by
+// content-delivery; the user follows its progress with ListExports.
+func (pc *PrivacyController) RequestExport(c *gin.Context) {
+	user, ok := pc.currentUser(c)
+	if !ok {
+		return
+	}
+
+	export, err := pc.privacy.RequestExport(user)
+	if errors.Is(err, services.ErrExportInProgress) {
+		c.JSON(http.StatusConflict, gin.H{"error": "An export of your data is already being prepared"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to start data export of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start data export"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, exportResponse(*export))
+}
+
+// ListExports lists the current user's data exports with the download links of the ready ones
+func (pc *PrivacyController) ListExports(c *gin.Context) {
+	userID := currentSubject(c).ID
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	exports, err := pc.privacy.ListExports(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data exports"})
+		return
+	}
+	list := make([]gin.H, 0, len(exports))
+	for _, export := range exports {
+		list = append(list, exportResponse(export))
+	}
+	c.JSON(http.StatusOK, gin.H{"exports": list})
+}
+
+// exportResponse describes a data export, with the content-delivery download path once it's ready
+func exportResponse(export models.DataExport) gin.H {
+	response := gin.H{
+		"id":         export.ID,
+		"status":     export.Status,
+		"created_at": export.CreatedAt,
+	}
+	switch export.Status {
+	case models.DataExportReady:
+		response["size_bytes"] = export.SizeBytes
+		response["ready_at"] = export.ReadyAt
+		response["expires_at"] = export.ExpiresAt
+		response["download_url"] = "/api/exports/" + export.ID.String() + "/download"
+	case models.DataExportFailed:
+		response["error"] = export.Error
+	}
+	return response
+}
+
+// DeleteAccount erases the current user's personal data in every service. Users confirm with their
+// password, or with their email address if they only log in with a provider. The response is the
+// deletion receipt, which stays readable at GetDeletion.
+func (pc *PrivacyController) DeleteAccount(c *gin.Context) {
+	var req struct {
+		Password string `json:"password"`
+		Email    string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	user, ok := pc.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.HasPassword() {
+		if req.Password == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Confirm the deletion with your password"})
+			return
+		}
+		if pc.auth.refuseGuardedAttempt(c, user.Email) {
+			return
+		}
+		if !user.VerifyPassword(req.Password) {
+			pc.auth.countPasswordFailure(c, user.Email, &user)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Password is incorrect"})
+			return
+		}
+		pc.auth.releaseGuardedAttempt(c, user.Email)
+	} else if !strings.EqualFold(strings.TrimSpace(req.Email), user.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Confirm the deletion with your email address"})
+		return
+	}
+
+	deletion, err := pc.privacy.DeleteAccount(c.Request.Context(), user)
+	if errors.Is(err, services.ErrAdminErasure) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Platform admins can't delete their own account, ask another admin to remove your admin role first"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete account of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+
+	// The details hold no personal data, the audit log keeps them after the account is gone
+	recordAuditEntry(c, pc.auth.audit, services.AuditEntry{
+		ActorID:    user.ID,
+		Action:     "account.delete",
+		TargetType: "user",
+		TargetID:   user.ID.String(),
+		Details:    map[string]interface{}{"deletion_id": deletion.ID},
+	})
+	pc.auth.clearTokenCookies(c)
+
+	c.JSON(http.StatusAccepted, deletion)
+}
+
+// GetDeletion shows the receipt of an account deletion. It holds no personal data and is public,
+// as its former owner can't log in anymore; the random ID is known only to them.
+func (pc *PrivacyController) GetDeletion(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deletion ID"})
+		return
+	}
+
+	deletion, err := pc.privacy.GetDeletion(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deletion not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve deletion"})
+		return
+	}
+	c.JSON(http.StatusOK, deletion)
+}
+
+// currentUser loads the authenticated user, writing the error response if there's none
+func (pc *PrivacyController) currentUser(c *gin.Context) (models.User, bool) {
+	var user models.User
+	userID := currentSubject(c).ID
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return user, false
+	}
+	if err := pc.db.First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return user, false
+	}
+	return user, true
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"gorm.io/gorm"
+)
+
+// ProfileController lets users edit their profile and shows instructors' profiles to other users
+type ProfileController struct {
+	db       *gorm.DB
+	profiles *services.ProfileService
+}
+
+// NewProfileController creates a new profile controller
+func NewProfileController(db *gorm.DB, profiles *services.ProfileService) *ProfileController {
+	if profiles == nil {
+		profiles = services.NewProfileService(db)
+	}
+	return &ProfileController{
+		db:       db,
+		profiles: profiles,
+	}
+}
+
+// UpdateProfile changes the current user's name and profile. Only the fields in the request are
+// changed, including single notification preferences.
+func (pc *ProfileController) UpdateProfile(c *gin.Context) {
+	userID := currentSubject(c).ID
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	var req struct {
+		Name                    *string         `json:"name"`
+		Bio                     *string         `json:"bio"`
+		Timezone                *string         `json:"timezone"`
+		Locale                  *string         `json:"locale"`
+		NotificationPreferences json.RawMessage `json:"notification_preferences"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	update := services.ProfileUpdate{
+		Name:     req.Name,
+		Bio:      req.Bio,
+		Timezone: req.Timezone,
+		Locale:   req.Locale,
+	}
+
+	// Preferences left out of the request keep their current value
+	if len(req.NotificationPreferences) > 0 {
+		current, err := pc.profiles.Get(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve profile"})
+			return
+		}
+		preferences := current.NotificationPreferences
+		if err := json.Unmarshal(req.NotificationPreferences, &preferences); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification preferences"})
+			return
+		}
+		update.NotificationPreferences = &preferences
+	}
+
+	user, profile, err := pc.profiles.Update(userID, update)
+	var profileErr *services.ProfileError
+	switch {
+	case errors.As(err, &profileErr):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "The profile is invalid",
+			"problems": profileErr.Problems,
+		})
+		return
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, currentUserResponse(user, profile))
+}
+
+// GetInstructor shows an instructor's public profile with the courses they teach. Instructors of
+// other organizations and deactivated instructors aren't found.
+func (pc *ProfileController) GetInstructor(c *gin.Context) {
+	instructorID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid instructor ID"})
+		return
+	}
+
+	db := tenantDB(c, pc.db)
+	var instructor models.User
+	if err := db.First(&instructor, "id = ?", instructorID).Error; err != nil || !instructor.IsInstructor() || !instructor.IsActive() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Instructor not found"})
+		return
+	}
+	profile, err := pc.profiles.Get(instructor.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve profile"})
+		return
+	}
+
+	var courses []models.Course
+	if err := db.Where("creator_id = ?", instructor.ID).Order("created_at DESC").Find(&courses).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve courses"})
+		return
+	}
+	courseList := make([]gin.H, 0, len(courses))
+	for _, course := range courses {
+		courseList = append(courseList, gin.H{
+			"id":                   course.ID,
+			"title":                course.Title,
+			"description":          course.Description,
+			"price_cents":          course.PriceCents,
+			"currency":             course.Currency,
+			"access_duration_days": course.AccessDurationDays,
+			"created_at":           course.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":          instructor.ID,
+		"name":        instructor.Name,
+		"bio":         profile.Bio,
+		"avatar_urls": profile.AvatarURLs,
+		"courses":     courseList,
+	})
+}
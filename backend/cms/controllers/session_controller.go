@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"gorm.io/gorm"
+)
+
+// SessionController lists the devices a user is logged in on and signs them out remotely
+type SessionController struct {
+	db     *gorm.DB
+	tokens *services.TokenService
+}
+
+// NewSessionController creates a new session controller
+func NewSessionController(db *gorm.DB, tokens *services.TokenService) *SessionController {
+	return &SessionController{
+		db:     db,
+		tokens: tokens,
+	}
+}
+
+// sessionResponse is a session as shown to its user, flagged if the request was made from it
+type sessionResponse struct {
+	models.Session
+	Current bool `json:"current"`
+}
+
+// ListSessions lists the current user's active sessions
+func (sc *SessionController) ListSessions(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	sessions, err := sc.tokens.ListSessions(userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	current := currentSessionID(c)
+	response := make([]sessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, sessionResponse{Session: session, Current: session.ID == current})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": response})
+}
+
+// RevokeSession signs the current user out of one of their sessions
+func (sc *SessionController) RevokeSession(c *gin.Context) {
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+	userID, _ := c.Get("userID")
+
+	err = sc.tokens.RevokeSession(c.Request.Context(), userID.(uuid.UUID), sessionID)
+	if errors.Is(err, services.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session signed out"})
+}
+
+// RevokeSessions signs the current user out of all their sessions, or of all but the current one
+// with ?keep_current=true
+func (sc *SessionController) RevokeSessions(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	except := uuid.Nil
+	if c.Query("keep_current") == "true" {
+		except = currentSessionID(c)
+	}
+
+	revoked, err := sc.tokens.RevokeSessions(c.Request.Context(), userID.(uuid.UUID), except)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Sessions signed out", "revoked": revoked})
+}
+
+// ListUserSessions lists the active sessions of any user (admin only)
+func (sc *SessionController) ListUserSessions(c *gin.Context) {
+	user, ok := sc.findUser(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := sc.tokens.ListSessions(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// RevokeUserSession signs any user out of one of their sessions (admin only)
+func (sc *SessionController) RevokeUserSession(c *gin.Context) {
+	user, ok := sc.findUser(c)
+	if !ok {
+		return
+	}
+	sessionID, err := uuid.Parse(c.Param("sessionId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	err = sc.tokens.RevokeSession(c.Request.Context(), user.ID, sessionID)
+	if errors.Is(err, services.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out session"})
+		return
+	}
+	setAuditEntry(c, "user.revoke_session", "user", user.ID.String(), map[string]interface{}{
+		"session_id": sessionID.String(),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session signed out"})
+}
+
+// findUser loads the user of the :id parameter, answering 400 or 404 if there's none
+func (sc *SessionController) findUser(c *gin.Context) (models.User, bool) {
+	var user models.User
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return user, false
+	}
+	if err := sc.db.First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return user, false
+	}
+	return user, true
+}
+
+// currentSessionID returns the session the request's access token was issued for, or uuid.Nil
+func currentSessionID(c *gin.Context) uuid.UUID {
+	sessionID, _ := c.Get("sessionID")
+	id, _ := sessionID.(string)
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil
+	}
+	return parsed
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"log"
+	"time"
 
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/config"
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/controllers"
@@ -9,8 +11,10 @@ import (
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/routes"
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 )
 
 func main() {
@@ -54,8 +58,183 @@ func main() {
 
 	// Initialize controllers with required services
 	courseController := controllers.NewCourseController(db)
-	authController := controllers.NewAuthController(db, cfg)
-	enrollmentController := controllers.NewEnrollmentController(db)
+	// Connect to Redis for the token revocation list shared with the gateway and content-delivery
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisURL,
+		Password: cfg.RedisPass,
+	})
+	// The client is kept when Redis isn't up yet: revoking tokens fails until it is, instead of
+	// only being seen by this process
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		logger.Warning("Failed to connect to Redis, token revocations will fail until it is reachable", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	defer rdb.Close()
+	// The gateway rejects tokens while Redis is down, lookup failures here let the token through
+	revocations := claims.NewRevocationList(rdb, time.Duration(cfg.RevocationCacheTTL)*time.Second, false)
+
+	// Load the access token signing keys and pick up rotated keys without a restart
+	keys, err := services.LoadKeySet(cfg.JWTKeysDir, cfg.JWTActiveKeyID)
+	if err != nil {
+		logger.Fatal("Failed to load JWT signing keys", err, nil)
+	}
+	logger.Info("Loaded JWT signing keys", map[string]interface{}{
+		"active_kid": keys.ActiveKeyID(),
+	})
+	go func() {
+		ticker := time.NewTicker(time.Duration(cfg.JWTKeysReload) * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := keys.Reload(); err != nil {
+				logger.Error("Failed to reload JWT signing keys", err, nil)
+			}
+		}
+	}()
+
+	tokenService := services.NewTokenService(
+		db,
+		revocations,
+		keys,
+		cfg.JWTIssuer,
+		cfg.JWTAudience,
+		time.Duration(cfg.AccessTokenTTL)*time.Minute,
+		time.Duration(cfg.RefreshTokenTTL)*24*time.Hour,
+	)
+	// Mailer for verification and password reset emails
+	var mailer services.Mailer
+	switch cfg.MailDriver {
+	case "smtp":
+		mailer = services.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	default:
+		if cfg.MailDriver != "outbox" {
+			logger.Warning("Unknown mail driver, writing emails to the outbox", map[string]interface{}{
+				"driver": cfg.MailDriver,
+			})
+		}
+		mailer = services.NewOutboxMailer(cfg.MailOutboxDir, cfg.MailFrom)
+	}
+	// Password policy, screening new passwords against a local copy of breached passwords
+	var breachedPasswords *services.BreachedPasswords
+	if cfg.BreachedPasswordsFile != "" {
+		breachedPasswords, err = services.LoadBreachedPasswords(cfg.BreachedPasswordsFile)
+		if err != nil {
+			logger.Fatal("Failed to load breached passwords", err, nil)
+		}
+		logger.Info("Loaded breached passwords", map[string]interface{}{
+			"count": breachedPasswords.Len(),
+		})
+	} else {
+		logger.Warning("BREACHED_PASSWORDS_FILE is not set, passwords aren't screened against known breaches", nil)
+	}
+	passwordPolicy := services.NewPasswordPolicy(services.PasswordPolicyConfig{
+		MinLength:           cfg.PasswordMinLength,
+		MinCharacterClasses: cfg.PasswordMinCharacterClasses,
+	}, breachedPasswords)
+	accountService := services.NewAccountService(
+		db,
+		mailer,
+		tokenService,
+		passwordPolicy,
+		cfg.AppBaseURL,
+		time.Duration(cfg.EmailVerificationTTL)*time.Hour,
+		time.Duration(cfg.PasswordResetTTL)*time.Minute,
+	)
+	mfaService := services.NewMFAService(db, cfg.MFAIssuer, time.Duration(cfg.MFAChallengeTTL)*time.Minute)
+	loginGuard := services.NewLoginGuard(rdb, logger, services.LoginGuardConfig{
+		MaxAccountFailures: cfg.LoginMaxAccountFailures,
+		MaxIPFailures:      cfg.LoginMaxIPFailures,
+		FailureWindow:      time.Duration(cfg.LoginFailureWindow) * time.Minute,
+		LockoutDuration:    time.Duration(cfg.LoginLockoutDuration) * time.Minute,
+		BaseDelay:          time.Duration(cfg.LoginBaseDelay) * time.Second,
+		MaxDelay:           time.Duration(cfg.LoginMaxDelay) * time.Second,
+	})
+	// OpenID Connect login providers, discovered on first use
+	var oidcProviders []*services.OIDCProvider
+	for _, provider := range cfg.OIDCProviders {
+		oidcProviders = append(oidcProviders, services.NewOIDCProvider(services.OIDCProviderConfig{
+			Name:         provider.Name,
+			DisplayName:  provider.DisplayName,
+			Issuer:       provider.Issuer,
+			ClientID:     provider.ClientID,
+			ClientSecret: provider.ClientSecret,
+			RedirectURL:  provider.RedirectURL,
+			Scopes:       provider.Scopes,
+		}, nil))
+	}
+	// Pending provider logins are kept in Redis so the callback can reach any instance
+	oauthStates := services.NewRedisStateStore(rdb)
+	auditService := services.NewAuditService(db)
+	// Record the audit events of the other services, e.g. content deleted in content-delivery
+	if messageBroker != nil {
+		if err := messageBroker.ConsumeMessages("cms.audit", "audit.*", auditService.HandleEvent); err != nil {
+			log.Printf("Warning: Failed to consume audit events: %v", err)
+		}
+	}
+	authController := controllers.NewAuthController(db, cfg, tokenService, accountService, passwordPolicy, mfaService, loginGuard,
+		services.NewOIDCRegistry(oidcProviders...), services.NewIdentityService(db), oauthStates, auditService)
+	mfaController := controllers.NewMFAController(db, mfaService)
+	pats := services.NewPersonalAccessTokenService(db)
+	tokenController := controllers.NewPersonalAccessTokenController(db, pats, cfg.IntrospectionSecret)
+	if cfg.IntrospectionSecret == "" {
+		logger.Warning("INTROSPECTION_SECRET is not set, personal access tokens only work on the CMS itself", nil)
+	}
+	sessionController := controllers.NewSessionController(db, tokenService)
+	adminController := controllers.NewAdminController(db, tokenService, accountService, auditService)
+	organizationController := controllers.NewOrganizationController(db, tokenService)
+	applicationController := controllers.NewInstructorApplicationController(db, tokenService, mailer)
+	enrollmentController := controllers.NewEnrollmentController(db, messageBroker, cfg.IntrospectionSecret)
+	// Profiles, with the avatars content-delivery reports after resizing uploads
+	profileService := services.NewProfileService(db)
+	if messageBroker != nil {
+		if err := messageBroker.ConsumeMessages("cms.avatars", "avatar.*", profileService.HandleAvatarEvent); err != nil {
+			log.Printf("Warning: Failed to consume avatar events: %v", err)
+		}
+	}
+	profileController := controllers.NewProfileController(db, profileService)
+	// Personal data exports and account deletions, completed by content-delivery
+	privacyService := services.NewPrivacyService(db, messageBroker, tokenService, mailer, cfg.AppBaseURL)
+	if messageBroker != nil {
+		if err := messageBroker.ConsumeMessages("cms.privacy", "privacy.result.*", privacyService.HandleEvent); err != nil {
+			log.Printf("Warning: Failed to consume privacy events: %v", err)
+		}
+	}
+	privacyController := controllers.NewPrivacyController(db, privacyService, authController)
+	ltiService := services.NewLTIService(db, keys, nil)
+	ltiController := controllers.NewLTIController(db, cfg, ltiService, authController, messageBroker)
+	cohortController := controllers.NewCohortController(db, ltiService)
+
+	// Payment providers, only the fake provider ships for now. The configuration is checked on load:
+	// a provider always has a webhook secret, and the fake one is explicitly enabled.
+	var paymentProviders []services.PaymentProvider
+	switch cfg.PaymentProvider {
+	case "":
+		logger.Warning("No payment provider configured, paid courses can't be bought", nil)
+	case "fake":
+		paymentProviders = append(paymentProviders, services.NewFakePaymentProvider(cfg.PaymentWebhookSecret, cfg.PaymentBaseURL))
+	default:
+		logger.Warning("Unknown payment provider, paid courses can't be bought", map[string]interface{}{
+			"provider": cfg.PaymentProvider,
+		})
+	}
+	paymentController := controllers.NewPaymentController(db, messageBroker, paymentProviders...)
+
+	// Start the background job that expires enrollments and emails expiry reminders
+	expiryCtx, stopExpiryJob := context.WithCancel(context.Background())
+	defer stopExpiryJob()
+	expiryJob := services.NewEnrollmentExpiryJob(
+		db,
+		messageBroker,
+		mailer,
+		time.Duration(cfg.EnrollmentExpiryInterval)*time.Minute,
+		time.Duration(cfg.AccessReminderDays)*24*time.Hour,
+	)
+	expiryJob.Start(expiryCtx)
+
+	// Account erasure requests aren't confirmed by RabbitMQ, ask again until content-delivery reports back
+	if messageBroker != nil {
+		services.NewErasureRetryJob(privacyService, time.Duration(cfg.ErasureRetryInterval)*time.Minute).Start(expiryCtx)
+	}
 
 	// Set up a health check handler that also monitors RabbitMQ and OpenSearch
 	healthController := controllers.NewHealthController(db, messageBroker, logger)
@@ -64,12 +243,13 @@ func main() {
 	router := gin.Default()
 
 	// Setup middleware
+	router.Use(middleware.RequestID())
 	router.Use(middleware.CORSMiddleware())
 	// Add a request logging middleware
 	router.Use(middleware.RequestLoggerMiddleware(logger))
 
 	// Setup routes
-	routes.SetupRoutes(router, courseController, authController, enrollmentController, healthController, cfg)
+	routes.SetupRoutes(router, courseController, authController, mfaController, tokenController, sessionController, adminController, organizationController, applicationController, profileController, privacyController, enrollmentController, cohortController, paymentController, ltiController, healthController, tokenService.Verifier(), revocations, pats, auditService, cfg)
 
 	// Start the server
 	logger.Info("Server starting", map[string]interface{}{
@@ -1,18 +1,19 @@
 package middleware
 
 import (
-	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/google/uuid"
-	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/policy"
 )
 
-// AuthMiddleware validates JWT tokens
-func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
+// AuthMiddleware validates JWT tokens against the signing keys and rejects tokens on the revocation list.
+// Personal access tokens are accepted too, on the routes their scopes allow.
+func AuthMiddleware(verifier claims.Verifier, revocations *claims.RevocationList,
+	pats *services.PersonalAccessTokenService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -29,41 +30,102 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 			return
 		}
 
-		tokenString := parts[1]
-		claims := jwt.MapClaims{}
-
-		// Parse and validate the token
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(jwtSecret), nil
-		})
+		if claims.IsPersonalAccessToken(parts[1]) {
+			authenticatePersonalAccessToken(c, pats, parts[1])
+			return
+		}
 
-		if err != nil || !token.Valid {
+		// Parse and validate the token (signature, issuer, audience and claim version)
+		accessClaims, err := verifier.Parse(parts[1])
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
 			return
 		}
 
-		// Extract user ID and role from claims
-		userID, err := uuid.Parse(claims["sub"].(string))
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token: user ID not found"})
+		// Check if the token was revoked (logout, role change, admin action)
+		if revocations != nil && revocations.IsRevoked(c.Request.Context(), accessClaims.ID, accessClaims.SessionID, accessClaims.Subject, accessClaims.IssuedAtTime()) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
 			c.Abort()
 			return
 		}
 
+		// Impersonation tokens die with the admin's tokens, and can't do everything the user can
+		if accessClaims.IsImpersonation() {
+			if revocations != nil && revocations.IsRevoked(c.Request.Context(), "", "", accessClaims.Actor.Subject, accessClaims.IssuedAtTime()) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+				c.Abort()
+				return
+			}
+			// Set before the check, so refused requests are recorded in the audit log too
+			c.Set("impersonation", accessClaims)
+			if !accessClaims.AllowsRequest(c.Request.Method, c.Request.URL.Path) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Impersonation tokens can't be used for this request"})
+				c.Abort()
+				return
+			}
+		}
+
 		// Set the user ID and role in the context
-		c.Set("userID", userID)
-		c.Set("userRole", claims["role"])
+		c.Set("userID", accessClaims.UserID())
+		c.Set("userRole", accessClaims.Role)
+		c.Set("emailVerified", accessClaims.EmailVerified)
+		c.Set("organizationID", accessClaims.OrgID())
+		c.Set("sessionID", accessClaims.SessionID)
 		c.Next()
 	}
 }
 
-// RoleMiddleware checks if the user has the required role
-func RoleMiddleware(roles ...models.Role) gin.HandlerFunc {
+// authenticatePersonalAccessToken checks a personal access token and the scope the route needs
+func authenticatePersonalAccessToken(c *gin.Context, pats *services.PersonalAccessTokenService, rawToken string) {
+	if pats == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Personal access tokens are not accepted here"})
+		c.Abort()
+		return
+	}
+
+	token, user, err := pats.Authenticate(c.Request.Context(), rawToken, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid, expired or revoked token"})
+		c.Abort()
+		return
+	}
+
+	scope, ok := claims.RequiredScope(c.Request.Method, c.Request.URL.Path)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Personal access tokens can't be used on this endpoint"})
+		c.Abort()
+		return
+	}
+	if !claims.HasScope(token.Scopes, scope) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Token is missing the " + scope + " scope", "required_scope": scope})
+		c.Abort()
+		return
+	}
+
+	c.Set("userID", user.ID)
+	c.Set("userRole", string(user.Role))
+	c.Set("emailVerified", user.EmailVerified)
+	c.Set("organizationID", user.OrganizationID)
+	c.Set("personalAccessTokenID", token.ID)
+	c.Next()
+}
+
+// RequireVerifiedEmail blocks users who haven't verified their email address yet
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if verified, _ := c.Get("emailVerified"); verified != true {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Please verify your email address first"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequirePermission turns away users whose role can never perform the action. Permissions limited
+// to the user's own resources are checked again by the handler once the resource is loaded.
+func RequirePermission(action policy.Action) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userRole, exists := c.Get("userRole")
 		if !exists {
@@ -72,33 +134,13 @@ func RoleMiddleware(roles ...models.Role) gin.HandlerFunc {
 			return
 		}
 
-		roleStr, ok := userRole.(string)
-		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid role format"})
+		role, _ := userRole.(string)
+		if !policy.Grants(role, action) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied: insufficient privileges"})
 			c.Abort()
 			return
 		}
 
-		// Check if the user has one of the required roles
-		userRoleEnum := models.Role(roleStr)
-		for _, role := range roles {
-			if userRoleEnum == role {
-				c.Next()
-				return
-			}
-		}
-
-		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied: insufficient privileges"})
-		c.Abort()
+		c.Next()
 	}
 }
-
-// AdminOnly middleware ensures only admin users can access the route
-func AdminOnly() gin.HandlerFunc {
-	return RoleMiddleware(models.RoleAdmin)
-}
-
-// InstructorOrAdmin middleware ensures only instructors or admin users can access the route
-func InstructorOrAdmin() gin.HandlerFunc {
-	return RoleMiddleware(models.RoleInstructor, models.RoleAdmin)
-}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -11,36 +12,61 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/policy"
 	"github.com/stretchr/testify/assert"
 )
 
+// newTestKeySet creates a key set with a freshly generated signing key
+func newTestKeySet(t *testing.T) *services.KeySet {
+	key, err := services.GenerateSigningKey("test-key", claims.AlgorithmRS256)
+	if err != nil {
+		t.Fatalf("Failed to generate signing key: %v", err)
+	}
+	return services.NewKeySet(key)
+}
+
+// newTestTokenService creates a token service issuing tokens the way the CMS does
+func newTestTokenService(keys *services.KeySet, issuer, audience string) *services.TokenService {
+	return services.NewTokenService(nil, nil, keys, issuer, audience, time.Hour, 24*time.Hour)
+}
+
 func TestAuthMiddleware(t *testing.T) {
 	// Setup
 	gin.SetMode(gin.TestMode)
 
-	// Test JWT secret
-	jwtSecret := "test-secret"
+	// Test signing key and token issuer
+	keys := newTestKeySet(t)
+	tokens := newTestTokenService(keys, claims.DefaultIssuer, claims.DefaultAudience)
+	testUser := models.User{ID: uuid.New(), Name: "Test User", Email: "test@example.com", Role: models.RoleStudent}
+	testUserID := testUser.ID
 
 	// Generate a valid token for testing
-	testUserID := uuid.New()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub":   testUserID.String(),
-		"name":  "Test User",
-		"email": "test@example.com",
-		"role":  string(models.RoleStudent),
-		"exp":   time.Now().Add(time.Hour).Unix(),
-	})
-	validTokenString, _ := token.SignedString([]byte(jwtSecret))
+	validTokenString, _, _ := tokens.IssueAccessToken(testUser)
 
 	// Generate an expired token
-	expiredToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub":   testUserID.String(),
-		"name":  "Test User",
-		"email": "test@example.com",
-		"role":  string(models.RoleStudent),
-		"exp":   time.Now().Add(-time.Hour).Unix(), // Expired
+	expiredTokenString, _ := keys.Sign(claims.NewAccessClaims(testUserID, "Test User", "test@example.com",
+		string(models.RoleStudent), claims.DefaultIssuer, claims.DefaultAudience, -time.Hour))
+
+	// Generate tokens for another audience and from another issuer
+	otherAudienceToken, _, _ := newTestTokenService(keys, claims.DefaultIssuer, "another-api").IssueAccessToken(testUser)
+	otherIssuerToken, _, _ := newTestTokenService(keys, "another-issuer", claims.DefaultAudience).IssueAccessToken(testUser)
+
+	// Generate a token with the old claim layout (no version, user ID in user_id)
+	legacyTokenString, _ := keys.Sign(jwt.MapClaims{
+		"user_id": testUserID.String(),
+		"role":    string(models.RoleStudent),
+		"iss":     claims.DefaultIssuer,
+		"aud":     claims.DefaultAudience,
+		"exp":     time.Now().Add(time.Hour).Unix(),
 	})
-	expiredTokenString, _ := expiredToken.SignedString([]byte(jwtSecret))
+
+	// Generate a token signed with the old shared HMAC secret
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims.NewAccessClaims(testUserID, "", "",
+		string(models.RoleAdmin), claims.DefaultIssuer, claims.DefaultAudience, time.Hour))
+	hmacToken.Header["kid"] = keys.ActiveKeyID()
+	hmacTokenString, _ := hmacToken.SignedString([]byte("your-secret-key"))
 
 	tests := []struct {
 		name       string
@@ -72,6 +98,30 @@ func TestAuthMiddleware(t *testing.T) {
 			wantStatus: http.StatusUnauthorized,
 			wantUserID: false,
 		},
+		{
+			name:       "Other Audience",
+			header:     "Bearer " + otherAudienceToken,
+			wantStatus: http.StatusUnauthorized,
+			wantUserID: false,
+		},
+		{
+			name:       "Other Issuer",
+			header:     "Bearer " + otherIssuerToken,
+			wantStatus: http.StatusUnauthorized,
+			wantUserID: false,
+		},
+		{
+			name:       "Legacy Claims",
+			header:     "Bearer " + legacyTokenString,
+			wantStatus: http.StatusUnauthorized,
+			wantUserID: false,
+		},
+		{
+			name:       "HMAC Token",
+			header:     "Bearer " + hmacTokenString,
+			wantStatus: http.StatusUnauthorized,
+			wantUserID: false,
+		},
 		{
 			name:       "Missing Bearer Prefix",
 			header:     validTokenString,
@@ -84,7 +134,7 @@ func TestAuthMiddleware(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// Create a test router and protected route
 			router := gin.New()
-			router.GET("/protected", AuthMiddleware(jwtSecret), func(c *gin.Context) {
+			router.GET("/protected", AuthMiddleware(tokens.Verifier(), nil, nil), func(c *gin.Context) {
 				userID, exists := c.Get("userID")
 				if !exists {
 					c.JSON(http.StatusInternalServerError, gin.H{"error": "UserID not found"})
@@ -117,7 +167,130 @@ func TestAuthMiddleware(t *testing.T) {
 	}
 }
 
-func TestRoleMiddleware(t *testing.T) {
+func TestAuthMiddlewareRevocation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keys := newTestKeySet(t)
+	tokens := newTestTokenService(keys, claims.DefaultIssuer, claims.DefaultAudience)
+	revocations := claims.NewRevocationList(nil, time.Minute, false)
+
+	newSessionToken := func(userID uuid.UUID, jti, sessionID string, issuedAt time.Time) string {
+		accessClaims := claims.NewAccessClaims(userID, "", "", string(models.RoleStudent),
+			claims.DefaultIssuer, claims.DefaultAudience, time.Hour)
+		accessClaims.ID = jti
+		accessClaims.SessionID = sessionID
+		accessClaims.IssuedAt = jwt.NewNumericDate(issuedAt)
+		tokenString, _ := keys.Sign(accessClaims)
+		return tokenString
+	}
+	newToken := func(userID uuid.UUID, jti string, issuedAt time.Time) string {
+		return newSessionToken(userID, jti, "", issuedAt)
+	}
+
+	revokedUser := uuid.New()
+	otherUser := uuid.New()
+	oldToken := newToken(revokedUser, uuid.NewString(), time.Now().Add(-time.Minute))
+	revokedToken := newToken(otherUser, "revoked-jti", time.Now())
+	validToken := newToken(otherUser, uuid.NewString(), time.Now())
+	signedOutSessionToken := newSessionToken(otherUser, uuid.NewString(), "signed-out-session", time.Now())
+	otherSessionToken := newSessionToken(otherUser, uuid.NewString(), "other-session", time.Now())
+
+	assert.NoError(t, revocations.RevokeToken(context.Background(), "revoked-jti", time.Now().Add(time.Hour)))
+	assert.NoError(t, revocations.RevokeUser(context.Background(), revokedUser.String(), time.Hour))
+	assert.NoError(t, revocations.RevokeSession(context.Background(), "signed-out-session", time.Hour))
+	newTokenAfterRevoke := newToken(revokedUser, uuid.NewString(), time.Now().Add(2*time.Second))
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{"Token of revoked user", oldToken, http.StatusUnauthorized},
+		{"Revoked token", revokedToken, http.StatusUnauthorized},
+		{"Token issued after user revocation", newTokenAfterRevoke, http.StatusOK},
+		{"Other token of same user", validToken, http.StatusOK},
+		{"Token of signed out session", signedOutSessionToken, http.StatusUnauthorized},
+		{"Token of other session", otherSessionToken, http.StatusOK},
+	}
+
+	router := gin.New()
+	router.GET("/protected", AuthMiddleware(tokens.Verifier(), revocations, nil), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
+
+func TestAuthMiddlewareImpersonation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keys := newTestKeySet(t)
+	tokens := newTestTokenService(keys, claims.DefaultIssuer, claims.DefaultAudience)
+	revocations := claims.NewRevocationList(nil, time.Minute, false)
+
+	admin := models.User{ID: uuid.New(), Email: "admin@example.com", Role: models.RoleAdmin}
+	revokedAdmin := models.User{ID: uuid.New(), Email: "revoked-admin@example.com", Role: models.RoleAdmin}
+	student := models.User{ID: uuid.New(), Email: "student@example.com", Role: models.RoleStudent}
+	readOnlyToken, _, _ := tokens.IssueImpersonationToken(admin, student, true, time.Hour)
+	writableToken, _, _ := tokens.IssueImpersonationToken(admin, student, false, time.Hour)
+	revokedAdminToken, _, _ := tokens.IssueImpersonationToken(revokedAdmin, student, true, time.Hour)
+	assert.NoError(t, revocations.RevokeUser(context.Background(), revokedAdmin.ID.String(), time.Hour))
+
+	recorder := &fakeAuditRecorder{}
+	router := gin.New()
+	router.Use(AuditImpersonation(recorder))
+	auth := AuthMiddleware(tokens.Verifier(), revocations, nil)
+	handler := func(c *gin.Context) {
+		assert.Equal(t, student.ID, c.MustGet("userID"))
+		c.Status(http.StatusOK)
+	}
+	router.GET("/api/enrollments", auth, handler)
+	router.PUT("/api/enrollments/:id/progress", auth, handler)
+	router.PUT("/auth/password", auth, handler)
+
+	tests := []struct {
+		name       string
+		token      string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{"Read-only token reads", readOnlyToken, http.MethodGet, "/api/enrollments", http.StatusOK},
+		{"Read-only token writes", readOnlyToken, http.MethodPut, "/api/enrollments/1/progress", http.StatusForbidden},
+		{"Writable token writes", writableToken, http.MethodPut, "/api/enrollments/1/progress", http.StatusOK},
+		{"Writable token changes the password", writableToken, http.MethodPut, "/auth/password", http.StatusForbidden},
+		{"Token of revoked admin", revokedAdminToken, http.MethodGet, "/api/enrollments", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+
+	// Every request that got past the token checks is recorded under the admin, refused ones too
+	if assert.Len(t, recorder.entries, 4) {
+		for _, entry := range recorder.entries {
+			assert.Equal(t, admin.ID, entry.ActorID)
+			assert.Equal(t, "impersonation.request", entry.Action)
+			assert.Equal(t, student.ID.String(), entry.TargetID)
+		}
+		assert.Equal(t, "/api/enrollments/1/progress", recorder.entries[1].Details["path"])
+		assert.Equal(t, http.StatusForbidden, recorder.entries[1].Details["status"])
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
 	// Setup
 	gin.SetMode(gin.TestMode)
 
@@ -130,37 +303,37 @@ func TestRoleMiddleware(t *testing.T) {
 		{
 			name:       "Admin accessing admin route",
 			role:       models.RoleAdmin,
-			middleware: AdminOnly(),
+			middleware: RequirePermission(policy.AdminAccess),
 			wantStatus: http.StatusOK,
 		},
 		{
 			name:       "Instructor accessing admin route",
 			role:       models.RoleInstructor,
-			middleware: AdminOnly(),
+			middleware: RequirePermission(policy.AdminAccess),
 			wantStatus: http.StatusForbidden,
 		},
 		{
 			name:       "Student accessing admin route",
 			role:       models.RoleStudent,
-			middleware: AdminOnly(),
+			middleware: RequirePermission(policy.AdminAccess),
 			wantStatus: http.StatusForbidden,
 		},
 		{
 			name:       "Admin accessing instructor route",
 			role:       models.RoleAdmin,
-			middleware: InstructorOrAdmin(),
+			middleware: RequirePermission(policy.CourseCreate),
 			wantStatus: http.StatusOK,
 		},
 		{
 			name:       "Instructor accessing instructor route",
 			role:       models.RoleInstructor,
-			middleware: InstructorOrAdmin(),
+			middleware: RequirePermission(policy.CourseCreate),
 			wantStatus: http.StatusOK,
 		},
 		{
 			name:       "Student accessing instructor route",
 			role:       models.RoleStudent,
-			middleware: InstructorOrAdmin(),
+			middleware: RequirePermission(policy.CourseCreate),
 			wantStatus: http.StatusForbidden,
 		},
 	}
@@ -196,3 +369,42 @@ func TestRoleMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+
+	keys := newTestKeySet(t)
+	tokens := newTestTokenService(keys, claims.DefaultIssuer, claims.DefaultAudience)
+
+	unverified := models.User{ID: uuid.New(), Email: "new@example.com", Role: models.RoleStudent}
+	verified := models.User{ID: uuid.New(), Email: "verified@example.com", Role: models.RoleStudent}
+	verified.MarkEmailVerified(time.Now())
+
+	unverifiedToken, _, _ := tokens.IssueAccessToken(unverified)
+	verifiedToken, _, _ := tokens.IssueAccessToken(verified)
+
+	router := gin.New()
+	router.POST("/enroll", AuthMiddleware(tokens.Verifier(), nil, nil), RequireVerifiedEmail(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "success"})
+	})
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{"Verified email", verifiedToken, http.StatusOK},
+		{"Unverified email", unverifiedToken, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/enroll", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
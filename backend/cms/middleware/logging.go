@@ -38,6 +38,7 @@ func RequestLoggerMiddleware(logger *services.LoggingService) gin.HandlerFunc {
 			"latency_ms": latencyTime.Milliseconds(),
 			"client_ip":  clientIP,
 			"user_agent": userAgent,
+			"request_id": c.GetString("requestID"),
 		}
 
 		// Add user ID if available
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var seen string
+	router := gin.New()
+	router.Use(RequestID())
+	router.GET("/", func(c *gin.Context) {
+		seen = c.GetString("requestID")
+		c.Status(http.StatusOK)
+	})
+
+	send := func(requestID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if requestID != "" {
+			req.Header.Set(RequestIDHeader, requestID)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The caller's ID is kept
+	w := send("gateway-7f3a")
+	assert.Equal(t, "gateway-7f3a", seen)
+	assert.Equal(t, "gateway-7f3a", w.Header().Get(RequestIDHeader))
+
+	// Missing, overlong or unprintable IDs are replaced
+	for _, requestID := range []string{"", strings.Repeat("a", 65), "line\nbreak", "spa ce"} {
+		w = send(requestID)
+		_, err := uuid.Parse(seen)
+		assert.NoError(t, err, "%q", requestID)
+		assert.Equal(t, seen, w.Header().Get(RequestIDHeader))
+	}
+}
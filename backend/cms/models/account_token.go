@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AccountTokenPurpose says what an account token may be used for
+type AccountTokenPurpose string
+
+const (
+	PurposeEmailVerification AccountTokenPurpose = "email_verification"
+	PurposePasswordReset     AccountTokenPurpose = "password_reset"
+	PurposeAccountUnlock     AccountTokenPurpose = "account_unlock"
+)
+
+// AccountToken is a single-use, expiring token mailed to a user to verify their email address,
+// reset their password or unlock their account. Only the SHA-256 hash of the token is stored.
+type AccountToken struct {
+	ID        uuid.UUID           `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID    uuid.UUID           `gorm:"type:uuid;index" json:"user_id"`
+	Purpose   AccountTokenPurpose `gorm:"type:varchar(30);index" json:"purpose"`
+	TokenHash string              `gorm:"size:64;uniqueIndex" json:"-"`
+	ExpiresAt time.Time           `json:"expires_at"`
+	UsedAt    *time.Time          `json:"used_at,omitempty"`
+	CreatedAt time.Time           `json:"created_at"`
+}
+
+// BeforeCreate hook to set UUID before account token creation
+func (t *AccountToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+// IsUsable checks if the token can still be redeemed at the given time
+func (t *AccountToken) IsUsable(at time.Time) bool {
+	return t.UsedAt == nil && at.Before(t.ExpiresAt)
+}
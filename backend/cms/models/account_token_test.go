@@ -0,0 +1,31 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAccountTokenIsUsable tests that account tokens are single-use and expire
+func TestAccountTokenIsUsable(t *testing.T) {
+	now := time.Now()
+
+	token := AccountToken{Purpose: PurposePasswordReset, ExpiresAt: now.Add(time.Hour)}
+	assert.True(t, token.IsUsable(now))
+	assert.False(t, token.IsUsable(now.Add(2*time.Hour)))
+
+	token.UsedAt = &now
+	assert.False(t, token.IsUsable(now))
+}
+
+// TestMarkEmailVerified tests that verifying an email records when it happened
+func TestMarkEmailVerified(t *testing.T) {
+	user := User{Email: "test@example.com"}
+	assert.False(t, user.EmailVerified)
+
+	now := time.Now()
+	user.MarkEmailVerified(now)
+	assert.True(t, user.EmailVerified)
+	assert.Equal(t, now, *user.EmailVerifiedAt)
+}
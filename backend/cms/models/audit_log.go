@@ -0,0 +1,164 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Audit chain errors, returned when an entry doesn't follow the one before it
+var (
+	ErrAuditEntryMissing  = errors.New("audit log entry is missing")
+	ErrAuditChainBroken   = errors.New("audit log entry doesn't link to the entry before it")
+	ErrAuditEntryModified = errors.New("audit log entry was modified")
+)
+
+// auditChainLock is the Postgres advisory lock that serializes appends to the audit chain
+const auditChainLock = 7208391
+
+// AuditLog records a security relevant action, e.g. a login, a role change or a deleted course.
+// Entries form a hash chain: each one's hash covers its contents and the previous entry's hash, so
+// changing or deleting an entry breaks the chain from there on.
+type AuditLog struct {
+	ID         uuid.UUID              `gorm:"type:uuid;primaryKey" json:"id"`
+	Sequence   int64                  `gorm:"not null;default:0;index" json:"sequence"` // Position in the chain, starting at 1
+	ActorID    uuid.UUID              `gorm:"type:uuid;index" json:"actor_id"`
+	Action     string                 `gorm:"size:100;index" json:"action"`         // e.g. "user.deactivate"
+	TargetType string                 `gorm:"size:50" json:"target_type,omitempty"` // e.g. "user"
+	TargetID   string                 `gorm:"size:100;index" json:"target_id,omitempty"`
+	Details    map[string]interface{} `gorm:"type:jsonb;serializer:json" json:"details,omitempty"`
+	Before     map[string]interface{} `gorm:"type:jsonb;serializer:json" json:"before,omitempty"` // Values the action changed or deleted
+	After      map[string]interface{} `gorm:"type:jsonb;serializer:json" json:"after,omitempty"`  // Values the action set
+	IPAddress  string                 `gorm:"size:45" json:"ip_address"`
+	RequestID  string                 `gorm:"size:64;index" json:"request_id,omitempty"`
+	PrevHash   string                 `gorm:"size:64" json:"prev_hash"`
+	Hash       string                 `gorm:"size:64" json:"hash"`
+	CreatedAt  time.Time              `gorm:"index" json:"created_at"`
+}
+
+// BeforeCreate hook to set UUID before audit log creation
+func (a *AuditLog) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
+
+// Link appends the entry to the chain after prev, which is nil for the first entry, and seals it
+// with its hash. CreatedAt must be set to what the database will store, i.e. in microseconds.
+func (a *AuditLog) Link(prev *AuditLog) {
+	a.Sequence, a.PrevHash = 1, ""
+	if prev != nil {
+		a.Sequence, a.PrevHash = prev.Sequence+1, prev.Hash
+	}
+	a.Hash = a.ComputeHash()
+}
+
+// Follows checks that the entry comes right after prev in the chain (prev is nil for the first
+// entry) and wasn't changed since it was recorded
+func (a *AuditLog) Follows(prev *AuditLog) error {
+	wantSequence, wantPrevHash := int64(1), ""
+	if prev != nil {
+		wantSequence, wantPrevHash = prev.Sequence+1, prev.Hash
+	}
+
+	switch {
+	case a.Sequence != wantSequence:
+		return fmt.Errorf("%w: expected entry %d, found %d", ErrAuditEntryMissing, wantSequence, a.Sequence)
+	case a.PrevHash != wantPrevHash:
+		return fmt.Errorf("%w: entry %d", ErrAuditChainBroken, a.Sequence)
+	case a.Hash != a.ComputeHash():
+		return fmt.Errorf("%w: entry %d", ErrAuditEntryModified, a.Sequence)
+	}
+	return nil
+}
+
+// ComputeHash returns the hex SHA-256 of the entry's contents and the previous entry's hash
+func (a *AuditLog) ComputeHash() string {
+	content, _ := json.Marshal([]interface{}{
+		a.Sequence,
+		a.PrevHash,
+		a.ID,
+		a.ActorID,
+		a.Action,
+		a.TargetType,
+		a.TargetID,
+		canonicalJSON(a.Details),
+		canonicalJSON(a.Before),
+		canonicalJSON(a.After),
+		a.IPAddress,
+		a.RequestID,
+		a.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalJSON returns values the way they read back from the database, so that e.g. a struct in
+// the details hashes the same as the map it's stored as
+func canonicalJSON(values map[string]interface{}) interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil
+	}
+	var canonical interface{}
+	if err := json.Unmarshal(data, &canonical); err != nil {
+		return nil
+	}
+	return canonical
+}
+
+// LockAuditChain makes other transactions wait with appending to the audit chain until tx ends
+func LockAuditChain(tx *gorm.DB) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(?)", auditChainLock).Error
+}
+
+// ChainAuditLogs seals the audit log entries recorded before the log was hash chained, oldest
+// first. It only runs while the chain is empty: entries slipped in later stay outside the chain,
+// where verification finds them.
+func ChainAuditLogs(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := LockAuditChain(tx); err != nil {
+			return err
+		}
+
+		var chained int64
+		if err := tx.Model(&AuditLog{}).Where("hash <> ''").Count(&chained).Error; err != nil {
+			return err
+		}
+		if chained > 0 {
+			return nil
+		}
+
+		var unchained []AuditLog
+		if err := tx.Order("created_at, id").Find(&unchained).Error; err != nil {
+			return err
+		}
+
+		var prev *AuditLog
+		for i := range unchained {
+			entry := &unchained[i]
+			entry.Link(prev)
+			err := tx.Model(entry).Updates(map[string]interface{}{
+				"sequence":  entry.Sequence,
+				"prev_hash": entry.PrevHash,
+				"hash":      entry.Hash,
+			}).Error
+			if err != nil {
+				return err
+			}
+			prev = entry
+		}
+		return nil
+	})
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// auditChain builds a chain of n linked entries
+func auditChain(n int) []AuditLog {
+	entries := make([]AuditLog, n)
+	for i := range entries {
+		entries[i] = AuditLog{
+			ID:        uuid.New(),
+			ActorID:   uuid.New(),
+			Action:    "user.change_role",
+			TargetID:  uuid.NewString(),
+			Before:    map[string]interface{}{"role": "student"},
+			After:     map[string]interface{}{"role": "instructor"},
+			IPAddress: "203.0.113.7",
+			CreatedAt: time.Now().Add(time.Duration(i) * time.Second).Truncate(time.Microsecond),
+		}
+		if i == 0 {
+			entries[i].Link(nil)
+		} else {
+			entries[i].Link(&entries[i-1])
+		}
+	}
+	return entries
+}
+
+// verifyAuditChain checks every entry of a chain, returning the first error
+func verifyAuditChain(entries []AuditLog) error {
+	var prev *AuditLog
+	for i := range entries {
+		if err := entries[i].Follows(prev); err != nil {
+			return err
+		}
+		prev = &entries[i]
+	}
+	return nil
+}
+
+func TestAuditChainLinks(t *testing.T) {
+	entries := auditChain(3)
+
+	assert.Equal(t, int64(1), entries[0].Sequence)
+	assert.Empty(t, entries[0].PrevHash)
+	assert.Equal(t, int64(3), entries[2].Sequence)
+	assert.Equal(t, entries[1].Hash, entries[2].PrevHash)
+	assert.Len(t, entries[2].Hash, 64)
+	assert.NoError(t, verifyAuditChain(entries))
+}
+
+func TestAuditChainDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(entries []AuditLog) []AuditLog
+		want   error
+	}{
+		{"changed value", func(entries []AuditLog) []AuditLog {
+			entries[1].After["role"] = "admin"
+			return entries
+		}, ErrAuditEntryModified},
+		{"changed actor", func(entries []AuditLog) []AuditLog {
+			entries[0].ActorID = uuid.New()
+			return entries
+		}, ErrAuditEntryModified},
+		{"changed time", func(entries []AuditLog) []AuditLog {
+			entries[2].CreatedAt = entries[2].CreatedAt.Add(-time.Hour)
+			return entries
+		}, ErrAuditEntryModified},
+		{"deleted entry", func(entries []AuditLog) []AuditLog {
+			return append(entries[:1], entries[2:]...)
+		}, ErrAuditEntryMissing},
+		{"rehashed entry", func(entries []AuditLog) []AuditLog {
+			// Recomputing the hash of a changed entry breaks the link of the next one
+			entries[1].After["role"] = "admin"
+			entries[1].Hash = entries[1].ComputeHash()
+			return entries
+		}, ErrAuditChainBroken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, verifyAuditChain(tt.tamper(auditChain(3))), tt.want)
+		})
+	}
+}
+
+func TestAuditHashMatchesStoredValues(t *testing.T) {
+	// Details read back from the database as generic maps, in UTC
+	type refund struct {
+		Amount   int    `json:"amount"`
+		Currency string `json:"currency"`
+	}
+	entry := AuditLog{
+		ID:        uuid.New(),
+		Action:    "order.refund",
+		Details:   map[string]interface{}{"refund": refund{Amount: 1999, Currency: "USD"}, "empty": nil},
+		CreatedAt: time.Now().Truncate(time.Microsecond),
+	}
+	entry.Link(nil)
+
+	stored := entry
+	stored.Details = map[string]interface{}{
+		"empty":  nil,
+		"refund": map[string]interface{}{"currency": "USD", "amount": float64(1999)},
+	}
+	stored.Before = map[string]interface{}{}
+	stored.CreatedAt = entry.CreatedAt.UTC()
+	require.NoError(t, stored.Follows(nil))
+}
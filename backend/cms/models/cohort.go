@@ -0,0 +1,78 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Cohort represents one run (section) of a course with its own dates and capacity
+type Cohort struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	CourseID    int       `gorm:"index" json:"course_id"`
+	Course      Course    `gorm:"foreignKey:CourseID" json:"-"`
+	Name        string    `json:"name"`
+	StartDate   time.Time `json:"start_date"`
+	EndDate     time.Time `json:"end_date"`
+	Capacity    int       `gorm:"default:0" json:"capacity"` // 0 means unlimited
+	Schedule    string    `json:"schedule"`                  // Free-form meeting schedule, e.g. "Mon/Wed 18:00-19:30"
+	Instructors []User    `gorm:"many2many:cohort_instructors;" json:"instructors,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// BeforeCreate hook to set UUID before cohort creation
+func (c *Cohort) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+// HasCapacity checks if another student can join given the current number of enrollments
+func (c *Cohort) HasCapacity(enrolled int64) bool {
+	return c.Capacity <= 0 || enrolled < int64(c.Capacity)
+}
+
+// IsOpen checks if the cohort still accepts enrollments at the given time
+func (c *Cohort) IsOpen(at time.Time) bool {
+	return c.EndDate.IsZero() || at.Before(c.EndDate)
+}
+
+// HasInstructor checks if the user is one of the cohort's instructors
+func (c *Cohort) HasInstructor(userID uuid.UUID) bool {
+	for _, instructor := range c.Instructors {
+		if instructor.ID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// InstructorIDs returns the IDs of the cohort's instructors
+func (c *Cohort) InstructorIDs() []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(c.Instructors))
+	for _, instructor := range c.Instructors {
+		ids = append(ids, instructor.ID)
+	}
+	return ids
+}
+
+// CohortDueDate holds the due date of a course content item for a specific cohort
+type CohortDueDate struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	CohortID  uuid.UUID `gorm:"type:uuid;index:idx_cohort_due_date_content,unique:true" json:"cohort_id"`
+	ContentID uuid.UUID `gorm:"type:uuid;index:idx_cohort_due_date_content,unique:true" json:"content_id"`
+	DueAt     time.Time `json:"due_at"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// BeforeCreate hook to set UUID before due date creation
+func (d *CohortDueDate) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCohortCapacity tests the capacity check of a cohort
+func TestCohortCapacity(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		enrolled int64
+		want     bool
+	}{
+		{name: "Unlimited cohort", capacity: 0, enrolled: 500, want: true},
+		{name: "Seats left", capacity: 30, enrolled: 29, want: true},
+		{name: "Cohort full", capacity: 30, enrolled: 30, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cohort := Cohort{Capacity: tt.capacity}
+			assert.Equal(t, tt.want, cohort.HasCapacity(tt.enrolled))
+		})
+	}
+}
+
+// TestCohortIsOpen tests that enrollment closes once the cohort has ended
+func TestCohortIsOpen(t *testing.T) {
+	now := time.Now()
+	cohort := Cohort{StartDate: now.AddDate(0, -1, 0), EndDate: now.AddDate(0, 1, 0)}
+
+	assert.True(t, cohort.IsOpen(now))
+	assert.False(t, cohort.IsOpen(now.AddDate(0, 2, 0)))
+}
+
+// TestEnrollmentTransferKeepsProgress tests that moving cohorts doesn't reset progress
+func TestEnrollmentTransferKeepsProgress(t *testing.T) {
+	grade := float32(87)
+	enrollment := Enrollment{Progress: 60, Grade: &grade}
+	target := uuid.New()
+
+	enrollment.TransferToCohort(target)
+
+	assert.Equal(t, target, *enrollment.CohortID)
+	assert.Equal(t, float32(60), enrollment.Progress)
+	assert.Equal(t, float32(87), *enrollment.Grade)
+}
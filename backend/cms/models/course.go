@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
 	"gorm.io/gorm"
 )
 
@@ -19,22 +20,34 @@ const (
 
 // Course represents a course in the system
 type Course struct {
-	ID          int             `gorm:"primaryKey" json:"id"`
-	Title       string          `json:"title"`
-	Description string          `json:"description"`
-	CreatorID   uuid.UUID       `gorm:"type:uuid" json:"creator_id"`
-	Creator     User            `gorm:"foreignKey:CreatorID" json:"creator,omitempty"`
-	Contents    []CourseContent `json:"contents,omitempty"`
-	CreatedAt   time.Time       `json:"created_at"`
-	UpdatedAt   time.Time       `json:"updated_at"`
+	ID                 int             `gorm:"primaryKey" json:"id"`
+	Title              string          `json:"title"`
+	Description        string          `json:"description"`
+	CreatorID          uuid.UUID       `gorm:"type:uuid" json:"creator_id"`
+	Creator            User            `gorm:"foreignKey:CreatorID" json:"creator,omitempty"`
+	OrganizationID     uuid.UUID       `gorm:"type:uuid;index" json:"organization_id"`
+	Contents           []CourseContent `json:"contents,omitempty"`
+	AccessDurationDays *int            `json:"access_duration_days,omitempty"` // Days of access after enrolling, nil means no limit
+	PriceCents         int64           `gorm:"default:0" json:"price_cents"`   // 0 means the course is free
+	Currency           string          `gorm:"type:varchar(3);default:'USD'" json:"currency"`
+	CreatedAt          time.Time       `json:"created_at"`
+	UpdatedAt          time.Time       `json:"updated_at"`
 }
 
 // BeforeCreate hook to set UUID before course creation
 func (c *Course) BeforeCreate(tx *gorm.DB) error {
 	// ID is auto-incremented
+	if c.OrganizationID == uuid.Nil {
+		c.OrganizationID = claims.DefaultOrganizationID
+	}
 	return nil
 }
 
+// IsPaid checks if students have to buy the course before enrolling
+func (c *Course) IsPaid() bool {
+	return c.PriceCents > 0
+}
+
 // CourseContent represents content attached to a course
 type CourseContent struct {
 	ID          uuid.UUID   `gorm:"type:uuid;primaryKey" json:"id"`
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
 	"github.com/joho/godotenv"
 	"github.com/sony/gobreaker"
 	"gorm.io/driver/postgres"
@@ -72,6 +73,10 @@ func InitDB() (*gorm.DB, error) {
 		return nil, fmt.Errorf("database connection failed: %v", err)
 	}
 
+	if err := RegisterTenantCallbacks(db); err != nil {
+		return nil, fmt.Errorf("error registering organization callbacks: %v", err)
+	}
+
 	return db, nil
 }
 
@@ -81,16 +86,56 @@ func MigrateDB(db *gorm.DB) {
 	log.Println("Starting database migration...")
 
 	// First migrate models that don't depend on others
+	log.Println("Migrating Organization model...")
+	if err := db.AutoMigrate(&Organization{}); err != nil {
+		log.Fatal("Error migrating Organization model:", err)
+	}
+	if err := EnsureDefaultOrganization(db); err != nil {
+		log.Fatal("Error creating the default organization:", err)
+	}
+
 	log.Println("Migrating User model...")
+	// Accounts created before email verification existed are trusted as they are
+	grandfatherUsers := db.Migrator().HasTable(&User{}) && !db.Migrator().HasColumn(&User{}, "EmailVerified")
+	// google_id was never filled in; logins through providers are linked with UserIdentity now
+	if db.Migrator().HasColumn(&User{}, "google_id") {
+		log.Println("Dropping users.google_id...")
+		if err := db.Migrator().DropColumn(&User{}, "google_id"); err != nil {
+			log.Fatal("Error dropping users.google_id:", err)
+		}
+	}
 	if err := db.AutoMigrate(&User{}); err != nil {
 		log.Fatal("Error migrating User model:", err)
 	}
+	if grandfatherUsers {
+		log.Println("Marking existing users as verified...")
+		if err := db.Exec("UPDATE users SET email_verified = true, email_verified_at = created_at").Error; err != nil {
+			log.Fatal("Error marking existing users as verified:", err)
+		}
+	}
+
+	log.Println("Migrating UserIdentity model...")
+	if err := db.AutoMigrate(&UserIdentity{}); err != nil {
+		log.Fatal("Error migrating UserIdentity model:", err)
+	}
+
+	log.Println("Migrating UserProfile model...")
+	if err := db.AutoMigrate(&UserProfile{}); err != nil {
+		log.Fatal("Error migrating UserProfile model:", err)
+	}
 
 	log.Println("Migrating Course model...")
 	if err := db.AutoMigrate(&Course{}); err != nil {
 		log.Fatal("Error migrating Course model:", err)
 	}
 
+	// Users and courses created before organizations existed belong to the default one
+	for _, table := range []string{"users", "courses"} {
+		if err := db.Exec("UPDATE "+table+" SET organization_id = ? WHERE organization_id IS NULL", claims.DefaultOrganizationID).Error; err != nil {
+			log.Fatal("Error assigning "+table+" to the default organization:", err)
+		}
+	}
+
 	// Then migrate models that depend on the first ones
 	// First drop the course_contents table if it exists to avoid constraint issues
 	log.Println("Dropping CourseContent table if exists...")
@@ -118,10 +163,80 @@ func MigrateDB(db *gorm.DB) {
 	// Create index on course_id
 	db.Exec("CREATE INDEX IF NOT EXISTS idx_course_contents_course_id ON course_contents(course_id)")
 
+	log.Println("Migrating Cohort models...")
+	if err := db.AutoMigrate(&Cohort{}, &CohortDueDate{}); err != nil {
+		log.Fatal("Error migrating Cohort models:", err)
+	}
+
+	// Enrollment.CourseID used to be a UUID although courses are keyed by an integer,
+	// so no enrollment could ever reference a course. Convert the column in place
+	// because Postgres can't cast uuid to bigint on its own.
+	var courseIDType string
+	db.Raw("SELECT data_type FROM information_schema.columns WHERE table_name = 'enrollments' AND column_name = 'course_id'").Scan(&courseIDType)
+	if courseIDType == "uuid" {
+		log.Println("Converting enrollments.course_id from uuid to bigint...")
+		if err := db.Exec("ALTER TABLE enrollments ALTER COLUMN course_id TYPE bigint USING NULL").Error; err != nil {
+			log.Fatal("Error converting enrollments.course_id:", err)
+		}
+	}
+
 	log.Println("Migrating Enrollment model...")
 	if err := db.AutoMigrate(&Enrollment{}); err != nil {
 		log.Fatal("Error migrating Enrollment model:", err)
 	}
 
+	log.Println("Migrating payment models...")
+	if err := db.AutoMigrate(&Coupon{}, &Order{}); err != nil {
+		log.Fatal("Error migrating payment models:", err)
+	}
+
+	log.Println("Migrating RefreshToken model...")
+	if err := db.AutoMigrate(&RefreshToken{}); err != nil {
+		log.Fatal("Error migrating RefreshToken model:", err)
+	}
+
+	log.Println("Migrating Session model...")
+	if err := db.AutoMigrate(&Session{}); err != nil {
+		log.Fatal("Error migrating Session model:", err)
+	}
+
+	log.Println("Migrating PersonalAccessToken model...")
+	if err := db.AutoMigrate(&PersonalAccessToken{}); err != nil {
+		log.Fatal("Error migrating PersonalAccessToken model:", err)
+	}
+
+	log.Println("Migrating AccountToken model...")
+	if err := db.AutoMigrate(&AccountToken{}); err != nil {
+		log.Fatal("Error migrating AccountToken model:", err)
+	}
+
+	log.Println("Migrating MFA models...")
+	if err := db.AutoMigrate(&MFAChallenge{}, &RecoveryCode{}, &MFAPolicy{}); err != nil {
+		log.Fatal("Error migrating MFA models:", err)
+	}
+
+	log.Println("Migrating LTI models...")
+	if err := db.AutoMigrate(&LTIPlatform{}, &LTIResourceLink{}); err != nil {
+		log.Fatal("Error migrating LTI models:", err)
+	}
+
+	log.Println("Migrating InstructorApplication model...")
+	if err := db.AutoMigrate(&InstructorApplication{}); err != nil {
+		log.Fatal("Error migrating InstructorApplication model:", err)
+	}
+
+	log.Println("Migrating AuditLog model...")
+	if err := db.AutoMigrate(&AuditLog{}); err != nil {
+		log.Fatal("Error migrating AuditLog model:", err)
+	}
+	if err := ChainAuditLogs(db); err != nil {
+		log.Fatal("Error chaining existing audit log entries:", err)
+	}
+
+	log.Println("Migrating DataExport and AccountDeletion models...")
+	if err := db.AutoMigrate(&DataExport{}, &AccountDeletion{}); err != nil {
+		log.Fatal("Error migrating privacy models:", err)
+	}
+
 	log.Println("Database migration completed successfully!")
 }
@@ -14,22 +14,28 @@ const (
 	EnrollmentStatusActive    EnrollmentStatus = "active"
 	EnrollmentStatusCompleted EnrollmentStatus = "completed"
 	EnrollmentStatusDropped   EnrollmentStatus = "dropped"
+	EnrollmentStatusExpired   EnrollmentStatus = "expired"
 )
 
 // Enrollment represents a student's enrollment in a course
 type Enrollment struct {
-	ID           uuid.UUID        `gorm:"type:uuid;primaryKey" json:"id"`
-	UserID       uuid.UUID        `gorm:"type:uuid;index:idx_enrollment_user_course,unique:true" json:"user_id"`
-	User         User             `gorm:"foreignKey:UserID" json:"user,omitempty"`
-	CourseID     uuid.UUID        `gorm:"type:uuid;index:idx_enrollment_user_course,unique:true" json:"course_id"`
-	Course       Course           `gorm:"foreignKey:CourseID" json:"course,omitempty"`
-	Status       EnrollmentStatus `gorm:"type:varchar(20);default:'active'" json:"status"`
-	EnrolledAt   time.Time        `json:"enrolled_at"`
-	CompletedAt  *time.Time       `json:"completed_at,omitempty"`
-	LastAccessAt *time.Time       `json:"last_access_at,omitempty"`
-	Progress     float32          `gorm:"default:0" json:"progress"`
-	CreatedAt    time.Time        `json:"created_at"`
-	UpdatedAt    time.Time        `json:"updated_at"`
+	ID             uuid.UUID        `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID         uuid.UUID        `gorm:"type:uuid;index:idx_enrollment_user_course,unique:true" json:"user_id"`
+	User           User             `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	CourseID       int              `gorm:"index:idx_enrollment_user_course,unique:true" json:"course_id"`
+	Course         Course           `gorm:"foreignKey:CourseID" json:"course,omitempty"`
+	CohortID       *uuid.UUID       `gorm:"type:uuid;index" json:"cohort_id,omitempty"`
+	Cohort         *Cohort          `gorm:"foreignKey:CohortID" json:"cohort,omitempty"`
+	Status         EnrollmentStatus `gorm:"type:varchar(20);default:'active'" json:"status"`
+	EnrolledAt     time.Time        `json:"enrolled_at"`
+	CompletedAt    *time.Time       `json:"completed_at,omitempty"`
+	LastAccessAt   *time.Time       `json:"last_access_at,omitempty"`
+	ExpiresAt      *time.Time       `gorm:"index" json:"expires_at,omitempty"`
+	ReminderSentAt *time.Time       `json:"reminder_sent_at,omitempty"`
+	Progress       float32          `gorm:"default:0" json:"progress"`
+	Grade          *float32         `json:"grade,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+	UpdatedAt      time.Time        `json:"updated_at"`
 }
 
 // BeforeCreate hook to set UUID and enrollment time before creation
@@ -63,3 +69,51 @@ func (e *Enrollment) RecordAccess() {
 	now := time.Now()
 	e.LastAccessAt = &now
 }
+
+// TransferToCohort moves the enrollment to another cohort, keeping progress and grade
+func (e *Enrollment) TransferToCohort(cohortID uuid.UUID) {
+	e.CohortID = &cohortID
+}
+
+// StartAccessWindow sets the expiry date from the course access duration, if the course has one
+func (e *Enrollment) StartAccessWindow(course Course) {
+	e.ExpiresAt = nil
+	e.ReminderSentAt = nil
+	if course.AccessDurationDays != nil && *course.AccessDurationDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, *course.AccessDurationDays)
+		e.ExpiresAt = &expiresAt
+	}
+}
+
+// Reactivate re-enrolls a student who dropped the course. The access window keeps running from the
+// first enrollment, so dropping and enrolling again doesn't restart it.
+func (e *Enrollment) Reactivate(course Course, cohortID *uuid.UUID) {
+	e.Status = EnrollmentStatusActive
+	e.EnrolledAt = time.Now()
+	e.CohortID = cohortID
+	if e.ExpiresAt == nil {
+		e.StartAccessWindow(course)
+	}
+}
+
+// IsExpired checks if the student's access has run out
+func (e *Enrollment) IsExpired(at time.Time) bool {
+	if e.Status == EnrollmentStatusExpired {
+		return true
+	}
+	return e.ExpiresAt != nil && !at.Before(*e.ExpiresAt)
+}
+
+// MarkAsExpired marks the enrollment as expired
+func (e *Enrollment) MarkAsExpired() {
+	e.Status = EnrollmentStatusExpired
+}
+
+// ExtendAccess moves the expiry date and reactivates an expired enrollment
+func (e *Enrollment) ExtendAccess(until time.Time) {
+	e.ExpiresAt = &until
+	e.ReminderSentAt = nil
+	if e.Status == EnrollmentStatusExpired {
+		e.Status = EnrollmentStatusActive
+	}
+}
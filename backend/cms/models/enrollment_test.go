@@ -0,0 +1,58 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEnrollmentAccessWindow tests that the course access duration sets the expiry date
+func TestEnrollmentAccessWindow(t *testing.T) {
+	days := 90
+	limited := Course{AccessDurationDays: &days}
+	unlimited := Course{}
+
+	enrollment := Enrollment{Status: EnrollmentStatusActive}
+	enrollment.StartAccessWindow(limited)
+	assert.NotNil(t, enrollment.ExpiresAt)
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, 90), *enrollment.ExpiresAt, time.Minute)
+	assert.False(t, enrollment.IsExpired(time.Now()))
+	assert.True(t, enrollment.IsExpired(time.Now().AddDate(0, 0, 91)))
+
+	enrollment.StartAccessWindow(unlimited)
+	assert.Nil(t, enrollment.ExpiresAt)
+	assert.False(t, enrollment.IsExpired(time.Now().AddDate(10, 0, 0)))
+}
+
+// TestEnrollmentExtendAccess tests that extending access reactivates an expired enrollment
+func TestEnrollmentExtendAccess(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	enrollment := Enrollment{Status: EnrollmentStatusActive, ExpiresAt: &past, ReminderSentAt: &past}
+	enrollment.MarkAsExpired()
+	assert.True(t, enrollment.IsExpired(time.Now()))
+
+	enrollment.ExtendAccess(time.Now().AddDate(0, 0, 30))
+	assert.Equal(t, EnrollmentStatusActive, enrollment.Status)
+	assert.Nil(t, enrollment.ReminderSentAt)
+	assert.False(t, enrollment.IsExpired(time.Now()))
+}
+
+// TestEnrollmentReactivate tests that dropping and enrolling again keeps the original access window
+func TestEnrollmentReactivate(t *testing.T) {
+	days := 90
+	course := Course{AccessDurationDays: &days}
+	expiresAt := time.Now().AddDate(0, 0, 10)
+	enrollment := Enrollment{Status: EnrollmentStatusActive, ExpiresAt: &expiresAt}
+
+	enrollment.MarkAsDropped()
+	enrollment.Reactivate(course, nil)
+	assert.Equal(t, EnrollmentStatusActive, enrollment.Status)
+	assert.Equal(t, expiresAt, *enrollment.ExpiresAt)
+
+	// Enrollments from before the course had an access duration start their window now
+	enrollment = Enrollment{Status: EnrollmentStatusDropped}
+	enrollment.Reactivate(course, nil)
+	assert.NotNil(t, enrollment.ExpiresAt)
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, 90), *enrollment.ExpiresAt, time.Minute)
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ApplicationStatus represents the review status of an instructor application
+type ApplicationStatus string
+
+const (
+	ApplicationStatusPending  ApplicationStatus = "pending"
+	ApplicationStatusApproved ApplicationStatus = "approved"
+	ApplicationStatusRejected ApplicationStatus = "rejected"
+)
+
+// Limits of an instructor application
+const (
+	MinMotivationLength = 50
+	MaxMotivationLength = 5000
+	MaxAttachments      = 5
+)
+
+// Instructor application errors returned by InstructorApplication.Validate
+var (
+	ErrMotivationTooShort = errors.New("motivation is too short")
+	ErrMotivationTooLong  = errors.New("motivation is too long")
+	ErrTooManyAttachments = errors.New("too many attachments")
+	ErrInvalidAttachment  = errors.New("attachments need a name and an http(s) URL")
+)
+
+// ApplicationAttachment links a supporting document, e.g. a CV or a sample lesson
+type ApplicationAttachment struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// InstructorApplication is a student's request to become an instructor, reviewed by an admin
+type InstructorApplication struct {
+	ID            uuid.UUID               `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID        uuid.UUID               `gorm:"type:uuid;index" json:"user_id"`
+	User          User                    `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Motivation    string                  `gorm:"type:text" json:"motivation"`
+	Attachments   []ApplicationAttachment `gorm:"type:jsonb;serializer:json" json:"attachments"`
+	Status        ApplicationStatus       `gorm:"type:varchar(20);default:'pending';index" json:"status"`
+	ReviewerID    *uuid.UUID              `gorm:"type:uuid" json:"reviewer_id,omitempty"`
+	ReviewComment string                  `gorm:"type:text" json:"review_comment,omitempty"`
+	ReviewedAt    *time.Time              `json:"reviewed_at,omitempty"`
+	CreatedAt     time.Time               `json:"created_at"`
+	UpdatedAt     time.Time               `json:"updated_at"`
+}
+
+// BeforeCreate hook to set UUID before instructor application creation
+func (a *InstructorApplication) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
+
+// Validate checks the motivation and attachments of a new application
+func (a *InstructorApplication) Validate() error {
+	switch length := len([]rune(a.Motivation)); {
+	case length < MinMotivationLength:
+		return ErrMotivationTooShort
+	case length > MaxMotivationLength:
+		return ErrMotivationTooLong
+	}
+
+	if len(a.Attachments) > MaxAttachments {
+		return ErrTooManyAttachments
+	}
+	for _, attachment := range a.Attachments {
+		parsed, err := url.Parse(attachment.URL)
+		if attachment.Name == "" || err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return ErrInvalidAttachment
+		}
+	}
+	return nil
+}
+
+// IsPending checks if the application still waits for a review
+func (a *InstructorApplication) IsPending() bool {
+	return a.Status == ApplicationStatusPending
+}
+
+// Approve marks the application as approved by an admin
+func (a *InstructorApplication) Approve(reviewerID uuid.UUID, comment string, at time.Time) {
+	a.review(ApplicationStatusApproved, reviewerID, comment, at)
+}
+
+// Reject marks the application as rejected by an admin
+func (a *InstructorApplication) Reject(reviewerID uuid.UUID, comment string, at time.Time) {
+	a.review(ApplicationStatusRejected, reviewerID, comment, at)
+}
+
+// review records the outcome of a review
+func (a *InstructorApplication) review(status ApplicationStatus, reviewerID uuid.UUID, comment string, at time.Time) {
+	a.Status = status
+	a.ReviewerID = &reviewerID
+	a.ReviewComment = comment
+	a.ReviewedAt = &at
+}
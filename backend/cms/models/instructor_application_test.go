@@ -0,0 +1,57 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestInstructorApplicationValidate tests the motivation and attachment rules
+func TestInstructorApplicationValidate(t *testing.T) {
+	motivation := strings.Repeat("I have taught Go for years. ", 3)
+	cv := ApplicationAttachment{Name: "CV", URL: "https://example.com/cv.pdf"}
+
+	tests := []struct {
+		name        string
+		application InstructorApplication
+		err         error
+	}{
+		{"valid", InstructorApplication{Motivation: motivation}, nil},
+		{"with attachments", InstructorApplication{Motivation: motivation, Attachments: []ApplicationAttachment{cv}}, nil},
+		{"short motivation", InstructorApplication{Motivation: "Let me teach"}, ErrMotivationTooShort},
+		{"long motivation", InstructorApplication{Motivation: strings.Repeat("a", MaxMotivationLength+1)}, ErrMotivationTooLong},
+		{"too many attachments", InstructorApplication{Motivation: motivation, Attachments: []ApplicationAttachment{cv, cv, cv, cv, cv, cv}}, ErrTooManyAttachments},
+		{"attachment without name", InstructorApplication{Motivation: motivation, Attachments: []ApplicationAttachment{{URL: cv.URL}}}, ErrInvalidAttachment},
+		{"attachment with other scheme", InstructorApplication{Motivation: motivation, Attachments: []ApplicationAttachment{{Name: "CV", URL: "javascript:alert(1)"}}}, ErrInvalidAttachment},
+		{"attachment without host", InstructorApplication{Motivation: motivation, Attachments: []ApplicationAttachment{{Name: "CV", URL: "/cv.pdf"}}}, ErrInvalidAttachment},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.err, tt.application.Validate())
+		})
+	}
+}
+
+// TestInstructorApplicationReview tests the approve and reject transitions
+func TestInstructorApplicationReview(t *testing.T) {
+	reviewerID := uuid.New()
+	now := time.Now()
+
+	approved := InstructorApplication{Status: ApplicationStatusPending}
+	assert.True(t, approved.IsPending())
+	approved.Approve(reviewerID, "Welcome aboard", now)
+	assert.False(t, approved.IsPending())
+	assert.Equal(t, ApplicationStatusApproved, approved.Status)
+	assert.Equal(t, reviewerID, *approved.ReviewerID)
+	assert.Equal(t, "Welcome aboard", approved.ReviewComment)
+	assert.Equal(t, now, *approved.ReviewedAt)
+
+	rejected := InstructorApplication{Status: ApplicationStatusPending}
+	rejected.Reject(reviewerID, "Please add a sample lesson", now)
+	assert.Equal(t, ApplicationStatusRejected, rejected.Status)
+	assert.False(t, rejected.IsPending())
+}
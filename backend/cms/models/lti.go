@@ -0,0 +1,99 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"gorm.io/gorm"
+)
+
+// LTI role URIs of the platform's course membership. Platforms send the short form too.
+const (
+	LTIRoleInstructor    = "http://purl.imsglobal.org/vocab/lis/v2/membership#Instructor"
+	LTIRoleAdministrator = "http://purl.imsglobal.org/vocab/lis/v2/membership#Administrator"
+
+	ltiInstructorSubRole = "http://purl.imsglobal.org/vocab/lis/v2/membership/Instructor#"
+)
+
+// LTIPlatform is an LMS (Moodle, Canvas, ...) registered to launch LearnVibe courses with LTI 1.3.
+// The issuer and client ID identify the registration; users launched from it are provisioned into
+// its organization and can only reach that organization's courses.
+type LTIPlatform struct {
+	ID             uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	Name           string    `gorm:"size:100;not null" json:"name"`
+	Issuer         string    `gorm:"size:255;not null;uniqueIndex:idx_lti_platforms_issuer_client" json:"issuer"`
+	ClientID       string    `gorm:"size:255;not null;uniqueIndex:idx_lti_platforms_issuer_client" json:"client_id"` // Our client ID at the platform
+	DeploymentIDs  []string  `gorm:"type:jsonb;serializer:json" json:"deployment_ids"`                               // Deployments of the tool allowed to launch
+	AuthLoginURL   string    `gorm:"size:500;not null" json:"auth_login_url"`                                        // The platform's OIDC authorization endpoint
+	AuthTokenURL   string    `gorm:"size:500" json:"auth_token_url,omitempty"`                                       // The platform's OAuth2 token endpoint, needed to send grades
+	KeySetURL      string    `gorm:"size:500;not null" json:"key_set_url"`                                           // The platform's JWKS, which signs launches
+	OrganizationID uuid.UUID `gorm:"type:uuid;index" json:"organization_id"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// BeforeCreate hook to set UUID before platform creation
+func (p *LTIPlatform) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	if p.OrganizationID == uuid.Nil {
+		p.OrganizationID = claims.DefaultOrganizationID
+	}
+	return nil
+}
+
+// HasDeployment checks if a deployment of the tool on the platform is registered
+func (p *LTIPlatform) HasDeployment(deploymentID string) bool {
+	for _, id := range p.DeploymentIDs {
+		if id == deploymentID {
+			return true
+		}
+	}
+	return false
+}
+
+// IdentityProvider is the provider name of the identities of users launched from the platform
+func (p *LTIPlatform) IdentityProvider() string {
+	return "lti:" + p.ID.String()
+}
+
+// LTIResourceLink is a link to a course placed in a course of the platform. It remembers the
+// course the link launches and the platform's line item grades are sent to.
+type LTIResourceLink struct {
+	ID             uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	PlatformID     uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_lti_resource_links_platform_link" json:"platform_id"`
+	ResourceLinkID string    `gorm:"size:255;not null;uniqueIndex:idx_lti_resource_links_platform_link" json:"resource_link_id"`
+	CourseID       int       `gorm:"index" json:"course_id"`
+	ContextID      string    `gorm:"size:255" json:"context_id,omitempty"` // The platform's course
+	Title          string    `json:"title,omitempty"`
+	LineItemURL    string    `gorm:"size:500" json:"line_item_url,omitempty"` // Empty when the platform doesn't take grades for the link
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// BeforeCreate hook to set UUID before resource link creation
+func (l *LTIResourceLink) BeforeCreate(tx *gorm.DB) error {
+	if l.ID == uuid.Nil {
+		l.ID = uuid.New()
+	}
+	return nil
+}
+
+// RoleForLTIRoles maps the roles of a launch to the role a new user gets: instructors and
+// administrators of the platform course teach, everyone else learns
+func RoleForLTIRoles(roles []string) Role {
+	for _, role := range roles {
+		switch role {
+		case LTIRoleInstructor, LTIRoleAdministrator, "Instructor", "Administrator":
+			return RoleInstructor
+		}
+		// Sub-roles, e.g. teaching assistants, are .../membership/Instructor#TeachingAssistant
+		if strings.HasPrefix(role, ltiInstructorSubRole) {
+			return RoleInstructor
+		}
+	}
+	return RoleStudent
+}
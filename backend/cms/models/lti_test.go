@@ -0,0 +1,37 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleForLTIRoles(t *testing.T) {
+	tests := []struct {
+		name  string
+		roles []string
+		want  Role
+	}{
+		{"learner", []string{"http://purl.imsglobal.org/vocab/lis/v2/membership#Learner"}, RoleStudent},
+		{"no roles", nil, RoleStudent},
+		{"instructor", []string{"http://purl.imsglobal.org/vocab/lis/v2/institution/person#Faculty", LTIRoleInstructor}, RoleInstructor},
+		{"course administrator", []string{LTIRoleAdministrator}, RoleInstructor},
+		{"short form", []string{"Instructor"}, RoleInstructor},
+		{"teaching assistant", []string{"http://purl.imsglobal.org/vocab/lis/v2/membership/Instructor#TeachingAssistant"}, RoleInstructor},
+		// Institution roles say nothing about the course being launched
+		{"institution administrator", []string{"http://purl.imsglobal.org/vocab/lis/v2/institution/person#Administrator"}, RoleStudent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, RoleForLTIRoles(tt.roles))
+		})
+	}
+}
+
+func TestLTIPlatformHasDeployment(t *testing.T) {
+	platform := LTIPlatform{DeploymentIDs: []string{"1", "7"}}
+
+	assert.True(t, platform.HasDeployment("7"))
+	assert.False(t, platform.HasDeployment("2"))
+	assert.False(t, platform.HasDeployment(""))
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// MFAChallengePurpose says what the holder of an MFA challenge still has to do
+type MFAChallengePurpose string
+
+const (
+	// MFAChallengeVerify means the user has to enter a TOTP or recovery code
+	MFAChallengeVerify MFAChallengePurpose = "verify"
+	// MFAChallengeEnroll means MFA is mandatory for the user's role and they have to set it up first
+	MFAChallengeEnroll MFAChallengePurpose = "enroll"
+)
+
+// MFAChallenge is the short-lived token handed out after a correct password when a second factor
+// is needed. It can be exchanged for the access and refresh tokens once. Only its hash is stored.
+type MFAChallenge struct {
+	ID        uuid.UUID           `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID    uuid.UUID           `gorm:"type:uuid;index" json:"user_id"`
+	Purpose   MFAChallengePurpose `gorm:"type:varchar(10)" json:"purpose"`
+	TokenHash string              `gorm:"size:64;uniqueIndex" json:"-"`
+	ExpiresAt time.Time           `json:"expires_at"`
+	Attempts  int                 `gorm:"not null;default:0" json:"attempts"` // Wrong codes entered so far
+	UsedAt    *time.Time          `json:"used_at,omitempty"`
+	CreatedAt time.Time           `json:"created_at"`
+}
+
+// BeforeCreate hook to set UUID before challenge creation
+func (c *MFAChallenge) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+// IsUsable checks if the challenge can still be answered at the given time
+func (c *MFAChallenge) IsUsable(at time.Time, maxAttempts int) bool {
+	return c.UsedAt == nil && at.Before(c.ExpiresAt) && c.Attempts < maxAttempts
+}
+
+// RecoveryCode is a one-time code that replaces a TOTP code when the user lost their authenticator.
+// Only its hash is stored.
+type RecoveryCode struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;index" json:"user_id"`
+	CodeHash  string     `gorm:"size:64;index" json:"-"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// BeforeCreate hook to set UUID before recovery code creation
+func (r *RecoveryCode) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// MFAPolicy says whether users with a role must use two-factor authentication
+type MFAPolicy struct {
+	Role        Role       `gorm:"type:varchar(20);primaryKey" json:"role"`
+	Required    bool       `gorm:"not null;default:false" json:"required"`
+	UpdatedByID *uuid.UUID `gorm:"type:uuid" json:"updated_by_id,omitempty"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
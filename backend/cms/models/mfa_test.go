@@ -0,0 +1,31 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMFAChallengeIsUsable tests that challenges expire, are single-use and lock after too many wrong codes
+func TestMFAChallengeIsUsable(t *testing.T) {
+	now := time.Now()
+
+	challenge := MFAChallenge{Purpose: MFAChallengeVerify, ExpiresAt: now.Add(5 * time.Minute)}
+	assert.True(t, challenge.IsUsable(now, 5))
+	assert.False(t, challenge.IsUsable(now.Add(10*time.Minute), 5))
+
+	challenge.Attempts = 5
+	assert.False(t, challenge.IsUsable(now, 5))
+
+	used := MFAChallenge{ExpiresAt: now.Add(5 * time.Minute), UsedAt: &now}
+	assert.False(t, used.IsUsable(now, 5))
+}
+
+// TestIsValidRole tests role validation
+func TestIsValidRole(t *testing.T) {
+	assert.True(t, IsValidRole(RoleStudent))
+	assert.True(t, IsValidRole(RoleInstructor))
+	assert.True(t, IsValidRole(RoleAdmin))
+	assert.False(t, IsValidRole(Role("superuser")))
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OrderStatus represents the status of a course order
+type OrderStatus string
+
+const (
+	OrderStatusPending  OrderStatus = "pending"
+	OrderStatusPaid     OrderStatus = "paid"
+	OrderStatusFailed   OrderStatus = "failed"
+	OrderStatusRefunded OrderStatus = "refunded"
+	// OrderStatusRefunding is held while the provider is asked for the refund, so it's only asked once
+	OrderStatusRefunding OrderStatus = "refunding"
+)
+
+// Order represents a purchase of a paid course
+type Order struct {
+	ID            uuid.UUID   `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID        uuid.UUID   `gorm:"type:uuid;index" json:"user_id"`
+	User          User        `gorm:"foreignKey:UserID" json:"-"`
+	CourseID      int         `gorm:"index" json:"course_id"`
+	Course        Course      `gorm:"foreignKey:CourseID" json:"course,omitempty"`
+	CohortID      *uuid.UUID  `gorm:"type:uuid" json:"cohort_id,omitempty"`
+	CouponID      *uuid.UUID  `gorm:"type:uuid" json:"coupon_id,omitempty"`
+	EnrollmentID  *uuid.UUID  `gorm:"type:uuid" json:"enrollment_id,omitempty"`
+	AmountCents   int64       `json:"amount_cents"`   // Course price at checkout time
+	DiscountCents int64       `json:"discount_cents"` // Coupon discount
+	TotalCents    int64       `json:"total_cents"`    // Amount actually charged
+	Currency      string      `gorm:"type:varchar(3)" json:"currency"`
+	Status        OrderStatus `gorm:"type:varchar(20);default:'pending';index" json:"status"`
+	Provider      string      `gorm:"type:varchar(50);index:idx_order_provider_ref" json:"provider"`
+	ProviderRef   string      `gorm:"index:idx_order_provider_ref" json:"provider_ref,omitempty"`
+	CheckoutURL   string      `json:"checkout_url,omitempty"`
+	PaidAt        *time.Time  `json:"paid_at,omitempty"`
+	RefundedAt    *time.Time  `json:"refunded_at,omitempty"`
+	CreatedAt     time.Time   `json:"created_at"`
+	UpdatedAt     time.Time   `json:"updated_at"`
+}
+
+// BeforeCreate hook to set UUID before order creation
+func (o *Order) BeforeCreate(tx *gorm.DB) error {
+	if o.ID == uuid.Nil {
+		o.ID = uuid.New()
+	}
+	return nil
+}
+
+// MarkAsPaid marks the order as paid
+func (o *Order) MarkAsPaid() {
+	now := time.Now()
+	o.Status = OrderStatusPaid
+	o.PaidAt = &now
+}
+
+// MarkAsRefunded marks the order as refunded
+func (o *Order) MarkAsRefunded() {
+	now := time.Now()
+	o.Status = OrderStatusRefunded
+	o.RefundedAt = &now
+}
+
+// DiscountType represents how a coupon reduces the price
+type DiscountType string
+
+const (
+	DiscountTypePercent DiscountType = "percent"
+	DiscountTypeFixed   DiscountType = "fixed"
+)
+
+// Coupon errors returned by Coupon.Validate
+var (
+	ErrCouponInactive      = errors.New("coupon is not active")
+	ErrCouponExpired       = errors.New("coupon has expired")
+	ErrCouponUsedUp        = errors.New("coupon usage limit reached")
+	ErrCouponWrongCourse   = errors.New("coupon is not valid for this course")
+	ErrCouponWrongCurrency = errors.New("coupon currency doesn't match the course currency")
+)
+
+// Coupon represents a discount code for paid courses
+type Coupon struct {
+	ID        uuid.UUID    `gorm:"type:uuid;primaryKey" json:"id"`
+	Code      string       `gorm:"uniqueIndex" json:"code"`
+	Type      DiscountType `gorm:"type:varchar(10)" json:"type"`
+	Value     int64        `json:"value"`                                     // Percentage (1-100) or amount in cents
+	Currency  string       `gorm:"type:varchar(3)" json:"currency,omitempty"` // Only used by fixed discounts
+	CourseID  *int         `json:"course_id,omitempty"`                       // Restricts the coupon to one course
+	MaxUses   int          `gorm:"default:0" json:"max_uses"`                 // 0 means unlimited
+	UsedCount int          `gorm:"default:0" json:"used_count"`
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"`
+	Active    bool         `gorm:"default:true" json:"active"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// BeforeCreate hook to set UUID and normalize the code before coupon creation
+func (c *Coupon) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	c.Code = NormalizeCouponCode(c.Code)
+	return nil
+}
+
+// NormalizeCouponCode makes coupon codes case-insensitive
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Validate checks if the coupon can be applied to the course at the given time
+func (c *Coupon) Validate(course Course, at time.Time) error {
+	if !c.Active {
+		return ErrCouponInactive
+	}
+	if c.ExpiresAt != nil && !at.Before(*c.ExpiresAt) {
+		return ErrCouponExpired
+	}
+	if c.MaxUses > 0 && c.UsedCount >= c.MaxUses {
+		return ErrCouponUsedUp
+	}
+	if c.CourseID != nil && *c.CourseID != course.ID {
+		return ErrCouponWrongCourse
+	}
+	if c.Type == DiscountTypeFixed && !strings.EqualFold(c.Currency, course.Currency) {
+		return ErrCouponWrongCurrency
+	}
+	return nil
+}
+
+// Discount returns the discount in cents for the given amount, never more than the amount itself
+func (c *Coupon) Discount(amountCents int64) int64 {
+	var discount int64
+	switch c.Type {
+	case DiscountTypePercent:
+		discount = amountCents * c.Value / 100
+	case DiscountTypeFixed:
+		discount = c.Value
+	}
+	if discount > amountCents {
+		return amountCents
+	}
+	if discount < 0 {
+		return 0
+	}
+	return discount
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCouponValidate tests the coupon rules for a course
+func TestCouponValidate(t *testing.T) {
+	course := Course{ID: 1, PriceCents: 5000, Currency: "USD"}
+	otherCourse := 2
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name   string
+		coupon Coupon
+		err    error
+	}{
+		{"valid", Coupon{Type: DiscountTypePercent, Value: 20, Active: true}, nil},
+		{"inactive", Coupon{Type: DiscountTypePercent, Value: 20}, ErrCouponInactive},
+		{"expired", Coupon{Type: DiscountTypePercent, Value: 20, Active: true, ExpiresAt: &past}, ErrCouponExpired},
+		{"used up", Coupon{Type: DiscountTypePercent, Value: 20, Active: true, MaxUses: 3, UsedCount: 3}, ErrCouponUsedUp},
+		{"other course", Coupon{Type: DiscountTypePercent, Value: 20, Active: true, CourseID: &otherCourse}, ErrCouponWrongCourse},
+		{"other currency", Coupon{Type: DiscountTypeFixed, Value: 500, Currency: "EUR", Active: true}, ErrCouponWrongCurrency},
+		{"same currency", Coupon{Type: DiscountTypeFixed, Value: 500, Currency: "usd", Active: true}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.err, tt.coupon.Validate(course, time.Now()))
+		})
+	}
+}
+
+// TestCouponDiscount tests that discounts never exceed the price
+func TestCouponDiscount(t *testing.T) {
+	percent := Coupon{Type: DiscountTypePercent, Value: 25}
+	assert.Equal(t, int64(1250), percent.Discount(5000))
+
+	fixed := Coupon{Type: DiscountTypeFixed, Value: 1000}
+	assert.Equal(t, int64(1000), fixed.Discount(5000))
+	assert.Equal(t, int64(800), fixed.Discount(800))
+}
+
+// TestOrderStatusChanges tests the paid and refunded transitions
+func TestOrderStatusChanges(t *testing.T) {
+	order := Order{Status: OrderStatusPending}
+	order.MarkAsPaid()
+	assert.Equal(t, OrderStatusPaid, order.Status)
+	assert.NotNil(t, order.PaidAt)
+
+	order.MarkAsRefunded()
+	assert.Equal(t, OrderStatusRefunded, order.Status)
+	assert.NotNil(t, order.RefundedAt)
+}
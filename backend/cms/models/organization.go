@@ -0,0 +1,52 @@
+package models
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"gorm.io/gorm"
+)
+
+// DefaultOrganizationSlug is the slug of the organization single-school deployments run in
+const DefaultOrganizationSlug = "default"
+
+var organizationSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,48}[a-z0-9]$`)
+
+// Organization is a school hosted on the platform. Users, courses and content belong to exactly one
+// organization and only ever see their own organization's data.
+type Organization struct {
+	ID   uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	Name string    `gorm:"size:100;not null" json:"name"`
+	Slug string    `gorm:"size:50;uniqueIndex" json:"slug"` // Picked at registration to join the organization
+	// OpenRegistration lets anyone register into the organization with its slug. The default
+	// organization is always open.
+	OpenRegistration bool      `gorm:"not null;default:false" json:"open_registration"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// BeforeCreate hook to set UUID before organization creation
+func (o *Organization) BeforeCreate(tx *gorm.DB) error {
+	if o.ID == uuid.Nil {
+		o.ID = uuid.New()
+	}
+	return nil
+}
+
+// AcceptsRegistrations says whether users may register into the organization by themselves
+func (o *Organization) AcceptsRegistrations() bool {
+	return o.OpenRegistration || o.ID == claims.DefaultOrganizationID
+}
+
+// IsValidOrganizationSlug checks if a slug is 3 to 50 lowercase letters, digits and dashes
+func IsValidOrganizationSlug(slug string) bool {
+	return organizationSlugPattern.MatchString(slug)
+}
+
+// EnsureDefaultOrganization creates the default organization if it doesn't exist yet
+func EnsureDefaultOrganization(db *gorm.DB) error {
+	organization := Organization{ID: claims.DefaultOrganizationID, Name: "LearnVibe", Slug: DefaultOrganizationSlug}
+	return db.Where(Organization{ID: claims.DefaultOrganizationID}).FirstOrCreate(&organization).Error
+}
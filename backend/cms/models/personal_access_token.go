@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PersonalAccessToken is a long-lived, named credential for scripts and automation. It only works
+// on the routes its scopes allow, and only the SHA-256 hash of the token is stored.
+type PersonalAccessToken struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID     uuid.UUID  `gorm:"type:uuid;index" json:"user_id"`
+	Name       string     `gorm:"size:100;not null" json:"name"`
+	TokenHash  string     `gorm:"size:64;uniqueIndex" json:"-"`
+	Hint       string     `gorm:"size:20" json:"hint"` // Start of the token, so the user can tell their tokens apart
+	Scopes     []string   `gorm:"type:jsonb;serializer:json" json:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `gorm:"size:45" json:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// BeforeCreate hook to set UUID before token creation
+func (t *PersonalAccessToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+// IsActive checks if the token can be used at the given time
+func (t *PersonalAccessToken) IsActive(at time.Time) bool {
+	return t.RevokedAt == nil && at.Before(t.ExpiresAt)
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPersonalAccessTokenIsActive tests that expired and revoked tokens stop working
+func TestPersonalAccessTokenIsActive(t *testing.T) {
+	now := time.Now()
+
+	token := PersonalAccessToken{ExpiresAt: now.Add(time.Hour)}
+	assert.True(t, token.IsActive(now))
+	assert.False(t, token.IsActive(now.Add(2*time.Hour)))
+
+	token.RevokedAt = &now
+	assert.False(t, token.IsActive(now))
+}
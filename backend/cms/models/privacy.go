@@ -0,0 +1,105 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// DataExportStatus represents how far a data export has come
+type DataExportStatus string
+
+const (
+	DataExportPending DataExportStatus = "pending" // The services are collecting the data
+	DataExportReady   DataExportStatus = "ready"   // The archive can be downloaded from content-delivery
+	DataExportFailed  DataExportStatus = "failed"
+)
+
+// DataExport is a user's request for a copy of their personal data. The CMS collects its part and
+// hands it to content-delivery, which adds its own part and stores the archive for download.
+type DataExport struct {
+	ID        uuid.UUID        `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID    uuid.UUID        `gorm:"type:uuid;index" json:"-"`
+	Status    DataExportStatus `gorm:"type:varchar(20);default:'pending'" json:"status"`
+	SizeBytes int64            `json:"size_bytes,omitempty"` // Size of the archive once ready
+	Error     string           `json:"error,omitempty"`
+	ReadyAt   *time.Time       `json:"ready_at,omitempty"`
+	ExpiresAt *time.Time       `json:"expires_at,omitempty"` // The archive is deleted after this
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// BeforeCreate hook to set UUID before data export creation
+func (e *DataExport) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
+
+// Services that hold personal data and take part in erasing an account
+const (
+	ErasureServiceCMS             = "cms"
+	ErasureServiceContentDelivery = "content-delivery"
+)
+
+// ErasureServices lists every service an account deletion has to complete in
+var ErasureServices = []string{ErasureServiceCMS, ErasureServiceContentDelivery}
+
+// AccountDeletionStatus represents how far the erasure of an account has come
+type AccountDeletionStatus string
+
+const (
+	AccountDeletionInProgress AccountDeletionStatus = "in_progress"
+	AccountDeletionCompleted  AccountDeletionStatus = "completed"
+)
+
+// ErasureStep is what one service did to erase an account's personal data
+type ErasureStep struct {
+	CompletedAt *time.Time     `json:"completed_at,omitempty"`
+	Erased      map[string]int `json:"erased,omitempty"` // Number of records deleted, by kind
+	Kept        map[string]int `json:"kept,omitempty"`   // Number of records kept without personal data, by kind
+}
+
+// AccountDeletion is the receipt of a deleted account. It tells which service erased what, and
+// holds no personal data, so it stays available to the former user after the account is gone.
+type AccountDeletion struct {
+	ID                 uuid.UUID              `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID             uuid.UUID              `gorm:"type:uuid;index" json:"-"` // The anonymized user row keeps its ID
+	Status             AccountDeletionStatus  `gorm:"type:varchar(20);default:'in_progress'" json:"status"`
+	Steps              map[string]ErasureStep `gorm:"type:jsonb;serializer:json" json:"steps"` // By service, see ErasureServices
+	RequestedAt        time.Time              `json:"requested_at"`
+	CompletedAt        *time.Time             `json:"completed_at,omitempty"`
+	RequestedErasureAt *time.Time             `gorm:"index" json:"-"` // When the other services were last asked to erase the account
+}
+
+// BeforeCreate hook to set UUID before account deletion creation
+func (d *AccountDeletion) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
+
+// CompleteStep records a service's erasure step, and completes the deletion once every service
+// has completed its step
+func (d *AccountDeletion) CompleteStep(service string, step ErasureStep) {
+	if d.Steps == nil {
+		d.Steps = map[string]ErasureStep{}
+	}
+	d.Steps[service] = step
+
+	var completedAt time.Time
+	for _, name := range ErasureServices {
+		stepCompletedAt := d.Steps[name].CompletedAt
+		if stepCompletedAt == nil {
+			return
+		}
+		if stepCompletedAt.After(completedAt) {
+			completedAt = *stepCompletedAt
+		}
+	}
+	d.Status = AccountDeletionCompleted
+	d.CompletedAt = &completedAt
+}
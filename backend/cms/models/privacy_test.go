@@ -0,0 +1,29 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAccountDeletionCompleteStep tests that a deletion is only complete once every service erased
+// its part, and completes when the last one did
+func TestAccountDeletionCompleteStep(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Minute)
+
+	deletion := AccountDeletion{Status: AccountDeletionInProgress, RequestedAt: now}
+	deletion.CompleteStep(ErasureServiceCMS, ErasureStep{CompletedAt: &now, Erased: map[string]int{"sessions": 2}})
+	assert.Equal(t, AccountDeletionInProgress, deletion.Status)
+	assert.Nil(t, deletion.CompletedAt)
+
+	// A step without a completion time doesn't count
+	deletion.CompleteStep(ErasureServiceContentDelivery, ErasureStep{})
+	assert.Equal(t, AccountDeletionInProgress, deletion.Status)
+
+	deletion.CompleteStep(ErasureServiceContentDelivery, ErasureStep{CompletedAt: &later, Erased: map[string]int{"avatars": 1}})
+	assert.Equal(t, AccountDeletionCompleted, deletion.Status)
+	assert.Equal(t, later, *deletion.CompletedAt)
+	assert.Equal(t, 2, deletion.Steps[ErasureServiceCMS].Erased["sessions"])
+}
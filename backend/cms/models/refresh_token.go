@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RefreshToken is an opaque, single-use token that can be exchanged for a new access token.
+// Only the SHA-256 hash of the token is stored. Every rotation creates a new token in the same
+// family, so reusing an already rotated token can revoke everything issued after it.
+type RefreshToken struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID       uuid.UUID  `gorm:"type:uuid;index" json:"user_id"`
+	FamilyID     uuid.UUID  `gorm:"type:uuid;index" json:"family_id"`
+	TokenHash    string     `gorm:"size:64;uniqueIndex" json:"-"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	UsedAt       *time.Time `json:"used_at,omitempty"`    // Set when the token is rotated
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"` // Set on logout or reuse detection
+	ReplacedByID *uuid.UUID `gorm:"type:uuid" json:"replaced_by_id,omitempty"`
+	UserAgent    string     `json:"user_agent"`
+	IPAddress    string     `gorm:"size:45" json:"ip_address"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// BeforeCreate hook to set UUID before refresh token creation
+func (t *RefreshToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+// IsActive checks if the token can still be exchanged at the given time
+func (t *RefreshToken) IsActive(at time.Time) bool {
+	return t.UsedAt == nil && t.RevokedAt == nil && at.Before(t.ExpiresAt)
+}
+
+// WasRotated checks if the token was already exchanged or revoked, presenting it again means it leaked
+func (t *RefreshToken) WasRotated() bool {
+	return t.UsedAt != nil || t.RevokedAt != nil
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRefreshTokenState tests when a refresh token can still be exchanged
+func TestRefreshTokenState(t *testing.T) {
+	now := time.Now()
+
+	token := RefreshToken{ExpiresAt: now.Add(time.Hour)}
+	assert.True(t, token.IsActive(now))
+	assert.False(t, token.WasRotated())
+	assert.False(t, token.IsActive(now.Add(2*time.Hour)))
+
+	token.UsedAt = &now
+	assert.False(t, token.IsActive(now))
+	assert.True(t, token.WasRotated())
+
+	revoked := RefreshToken{ExpiresAt: now.Add(time.Hour), RevokedAt: &now}
+	assert.False(t, revoked.IsActive(now))
+	assert.True(t, revoked.WasRotated())
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is one login of a user on a device. Its ID is the family ID of the refresh tokens issued
+// for the login and the sid claim of its access tokens, so signing out of a session revokes both.
+type Session struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID     uuid.UUID  `gorm:"type:uuid;index" json:"user_id"`
+	Device     string     `gorm:"size:100" json:"device"` // Browser and operating system guessed from the user agent
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `gorm:"size:45" json:"ip_address"` // Of the last refresh
+	LastSeenAt time.Time  `json:"last_seen_at"`              // Updated on every refresh
+	ExpiresAt  time.Time  `json:"expires_at"`                // When the latest refresh token expires
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`      // Set on logout or when the session is signed out
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// IsActive checks if the session can still be refreshed at the given time
+func (s *Session) IsActive(at time.Time) bool {
+	return s.RevokedAt == nil && at.Before(s.ExpiresAt)
+}
+
+// Browsers and operating systems recognized in user agents, most specific first since e.g. every
+// Chromium based browser also claims to be Chrome and Safari
+var (
+	userAgentBrowsers = []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	}
+	userAgentSystems = []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// DeviceName describes the device of a user agent for people, e.g. "Firefox on Windows"
+func DeviceName(userAgent string) string {
+	browser, system := "", ""
+	for _, b := range userAgentBrowsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, s := range userAgentSystems {
+		if strings.Contains(userAgent, s.token) {
+			system = s.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	default:
+		return "Unknown device"
+	}
+}
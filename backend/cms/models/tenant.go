@@ -0,0 +1,105 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// organizationKey is the GORM setting holding the organization a DB is limited to
+const organizationKey = "learnvibe:organization_id"
+
+// ErrWrongOrganization is returned when a row of another organization is created through a DB
+// limited to an organization
+var ErrWrongOrganization = errors.New("record belongs to another organization")
+
+// ForOrganization returns a DB limited to one organization: queries, updates and deletes of models
+// with an OrganizationID field only see that organization's rows, and created rows are assigned to
+// it. Other models and raw SQL aren't affected.
+func ForOrganization(db *gorm.DB, organizationID uuid.UUID) *gorm.DB {
+	return db.Set(organizationKey, organizationID).Session(&gorm.Session{})
+}
+
+// OrganizationOf returns the organization a DB is limited to, if any
+func OrganizationOf(db *gorm.DB) (uuid.UUID, bool) {
+	value, ok := db.Get(organizationKey)
+	if !ok {
+		return uuid.Nil, false
+	}
+	organizationID, ok := value.(uuid.UUID)
+	return organizationID, ok
+}
+
+// RegisterTenantCallbacks makes the DBs returned by ForOrganization enforce their organization.
+// It has to be called once on every new connection.
+func RegisterTenantCallbacks(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Query().Before("gorm:query").Register("learnvibe:organization_query", scopeToOrganization); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("gorm:row").Register("learnvibe:organization_row", scopeToOrganization); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("learnvibe:organization_update", scopeToOrganization); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("gorm:delete").Register("learnvibe:organization_delete", scopeToOrganization); err != nil {
+		return err
+	}
+	// Before the model's own BeforeCreate hook, which falls back to the default organization
+	return callbacks.Create().Before("gorm:before_create").Register("learnvibe:organization_create", assignOrganization)
+}
+
+// organizationField returns the OrganizationID field of the statement's model if the DB is limited
+// to an organization
+func organizationField(db *gorm.DB) (*schema.Field, uuid.UUID, bool) {
+	organizationID, ok := OrganizationOf(db)
+	if !ok || db.Statement.Schema == nil {
+		return nil, uuid.Nil, false
+	}
+	field := db.Statement.Schema.LookUpField("OrganizationID")
+	return field, organizationID, field != nil
+}
+
+// scopeToOrganization adds the organization condition to a query, update or delete
+func scopeToOrganization(db *gorm.DB) {
+	field, organizationID, ok := organizationField(db)
+	if !ok {
+		return
+	}
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: organizationID},
+	}})
+}
+
+// assignOrganization assigns created rows to the organization, refusing rows of another one
+func assignOrganization(db *gorm.DB) {
+	field, organizationID, ok := organizationField(db)
+	if !ok {
+		return
+	}
+
+	assign := func(value reflect.Value) {
+		current, zero := field.ValueOf(db.Statement.Context, value)
+		if !zero && current != organizationID {
+			db.AddError(ErrWrongOrganization)
+			return
+		}
+		if err := field.Set(db.Statement.Context, value, organizationID); err != nil {
+			db.AddError(err)
+		}
+	}
+
+	switch value := db.Statement.ReflectValue; value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			assign(reflect.Indirect(value.Index(i)))
+		}
+	case reflect.Struct:
+		assign(value)
+	}
+}
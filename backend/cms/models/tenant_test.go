@@ -0,0 +1,83 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// dryRunDB returns a DB that builds statements without running them
+func dryRunDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost dbname=learnvibe_test"}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	require.NoError(t, err)
+	require.NoError(t, RegisterTenantCallbacks(db))
+	return db
+}
+
+func TestForOrganizationScopesQueries(t *testing.T) {
+	db := dryRunDB(t)
+	organizationID := uuid.New()
+	scoped := ForOrganization(db, organizationID)
+
+	tests := []struct {
+		name string
+		run  func(tx *gorm.DB) *gorm.DB
+	}{
+		{"find", func(tx *gorm.DB) *gorm.DB { return tx.Find(&[]Course{}) }},
+		{"first by id", func(tx *gorm.DB) *gorm.DB { return tx.First(&Course{}, 42) }},
+		{"count", func(tx *gorm.DB) *gorm.DB {
+			var count int64
+			return tx.Model(&User{}).Count(&count)
+		}},
+		{"update", func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&User{ID: uuid.New()}).Update("role", RoleInstructor)
+		}},
+		{"delete", func(tx *gorm.DB) *gorm.DB { return tx.Delete(&Course{ID: 42}) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt := tt.run(scoped).Statement
+			assert.Contains(t, stmt.SQL.String(), "organization_id")
+			assert.Contains(t, stmt.Vars, organizationID)
+
+			// The same statement without an organization isn't limited
+			stmt = tt.run(db).Statement
+			assert.NotContains(t, stmt.SQL.String(), "organization_id")
+		})
+	}
+}
+
+func TestForOrganizationDoesNotAffectOtherModels(t *testing.T) {
+	stmt := ForOrganization(dryRunDB(t), uuid.New()).Find(&[]Enrollment{}).Statement
+	assert.NotContains(t, stmt.SQL.String(), "organization_id")
+}
+
+func TestForOrganizationAssignsCreatedRows(t *testing.T) {
+	db := dryRunDB(t)
+	organizationID := uuid.New()
+
+	course := Course{Title: "Go"}
+	require.NoError(t, ForOrganization(db, organizationID).Create(&course).Error)
+	assert.Equal(t, organizationID, course.OrganizationID)
+
+	users := []User{{Email: "a@example.com"}, {Email: "b@example.com"}}
+	require.NoError(t, ForOrganization(db, organizationID).Create(&users).Error)
+	for _, user := range users {
+		assert.Equal(t, organizationID, user.OrganizationID)
+	}
+
+	// Rows can't be smuggled into another organization
+	other := Course{Title: "Rust", OrganizationID: uuid.New()}
+	assert.ErrorIs(t, ForOrganization(db, organizationID).Create(&other).Error, ErrWrongOrganization)
+
+	// Without an organization, rows fall back to the default one
+	unscoped := Course{Title: "Python"}
+	require.NoError(t, db.Create(&unscoped).Error)
+	assert.Equal(t, claims.DefaultOrganizationID, unscoped.OrganizationID)
+}
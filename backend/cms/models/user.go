@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
@@ -14,19 +15,27 @@ type Role string
 const (
 	RoleStudent    Role = "student"
 	RoleInstructor Role = "instructor"
+	RoleOrgAdmin   Role = "org_admin" // Manages one organization, unlike admins who manage the platform
 	RoleAdmin      Role = "admin"
 )
 
 // User represents a user in the system
 type User struct {
-	ID        uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
-	Email     string    `gorm:"uniqueIndex" json:"email"`
-	Name      string    `json:"name"`
-	GoogleID  string    `gorm:"uniqueIndex" json:"google_id,omitempty"`
-	Password  string    `gorm:"size:255"` // Hashed password
-	Role      Role      `gorm:"type:varchar(20);default:'student'" json:"role"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID                    uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	Email                 string     `gorm:"uniqueIndex" json:"email"`
+	Name                  string     `json:"name"`
+	Password              string     `gorm:"size:255" json:"-"` // Hashed password
+	Role                  Role       `gorm:"type:varchar(20);default:'student'" json:"role"`
+	OrganizationID        uuid.UUID  `gorm:"type:uuid;index" json:"organization_id"`
+	EmailVerified         bool       `gorm:"not null;default:false" json:"email_verified"` // Unverified accounts can't enroll, buy or teach
+	EmailVerifiedAt       *time.Time `json:"email_verified_at,omitempty"`
+	MFAEnabled            bool       `gorm:"not null;default:false" json:"mfa_enabled"`
+	MFASecret             string     `gorm:"size:64" json:"-"`                                      // Base32 TOTP secret, set during setup before MFA is enabled
+	MFALastStep           int64      `gorm:"not null;default:0" json:"-"`                           // Last accepted TOTP time step, so codes can't be replayed
+	DeactivatedAt         *time.Time `json:"deactivated_at,omitempty"`                              // Deactivated accounts can't log in or use their tokens
+	PasswordResetRequired bool       `gorm:"not null;default:false" json:"password_reset_required"` // Set by admins, the user has to reset their password before logging in again
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
 }
 
 // BeforeCreate hook to set UUID before user creation
@@ -34,6 +43,9 @@ func (u *User) BeforeCreate(tx *gorm.DB) error {
 	if u.ID == uuid.Nil {
 		u.ID = uuid.New()
 	}
+	if u.OrganizationID == uuid.Nil {
+		u.OrganizationID = claims.DefaultOrganizationID
+	}
 	return nil
 }
 
@@ -49,7 +61,7 @@ func (u *User) IsAdmin() bool {
 
 // IsInstructor checks if the user is an instructor
 func (u *User) IsInstructor() bool {
-	return u.Role == RoleInstructor || u.Role == RoleAdmin
+	return u.Role == RoleInstructor || u.Role == RoleOrgAdmin || u.Role == RoleAdmin
 }
 
 // IsStudent checks if the user is a student
@@ -57,6 +69,22 @@ func (u *User) IsStudent() bool {
 	return u.Role == RoleStudent
 }
 
+// IsActive checks if the account wasn't deactivated by an admin
+func (u *User) IsActive() bool {
+	return u.DeactivatedAt == nil
+}
+
+// IsValidRole checks if the role is one of the known roles
+func IsValidRole(role Role) bool {
+	return role == RoleStudent || role == RoleInstructor || role == RoleOrgAdmin || role == RoleAdmin
+}
+
+// MarkEmailVerified records that the user proved they own their email address
+func (u *User) MarkEmailVerified(at time.Time) {
+	u.EmailVerified = true
+	u.EmailVerifiedAt = &at
+}
+
 // SetPassword hashes and sets the user's password
 func (u *User) SetPassword(password string) error {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -67,6 +95,12 @@ func (u *User) SetPassword(password string) error {
 	return nil
 }
 
+// HasPassword checks if the user can log in with a password. Accounts created through a login
+// provider have none until they reset it.
+func (u *User) HasPassword() bool {
+	return u.Password != ""
+}
+
 // VerifyPassword checks if the provided password matches the stored hash
 func (u *User) VerifyPassword(password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// UserIdentity links an account at a login provider to a user. The provider and its subject (the
+// provider's stable user ID) identify the account; the email is only kept to show the user which
+// account is linked, since it can change at the provider.
+type UserIdentity struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID      uuid.UUID  `gorm:"type:uuid;index" json:"user_id"`
+	Provider    string     `gorm:"size:50;not null;uniqueIndex:idx_user_identities_provider_subject" json:"provider"`
+	Subject     string     `gorm:"size:255;not null;uniqueIndex:idx_user_identities_provider_subject" json:"-"`
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// BeforeCreate hook to set UUID before identity creation
+func (i *UserIdentity) BeforeCreate(tx *gorm.DB) error {
+	if i.ID == uuid.Nil {
+		i.ID = uuid.New()
+	}
+	return nil
+}
+
+// CanUnlinkIdentity checks if a user with the given number of linked identities can unlink one of
+// them and still log in, with their password or another identity
+func CanUnlinkIdentity(user User, identities int) bool {
+	if user.HasPassword() {
+		return identities >= 1
+	}
+	return identities >= 2
+}
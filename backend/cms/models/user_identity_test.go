@@ -0,0 +1,34 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCanUnlinkIdentity verifies that users always keep a way to log in
+func TestCanUnlinkIdentity(t *testing.T) {
+	withPassword := User{Email: "password@example.com"}
+	assert.NoError(t, withPassword.SetPassword("securepassword123"))
+	assert.True(t, withPassword.HasPassword())
+
+	socialOnly := User{Email: "social@example.com"}
+	assert.False(t, socialOnly.HasPassword())
+
+	tests := []struct {
+		name       string
+		user       User
+		identities int
+		want       bool
+	}{
+		{"password and one identity", withPassword, 1, true},
+		{"password and no identity", withPassword, 0, false},
+		{"only identity", socialOnly, 1, false},
+		{"two identities", socialOnly, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, CanUnlinkIdentity(tt.user, tt.identities))
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"regexp"
+	"time"
+	_ "time/tzdata" // Timezones are validated without relying on the system's zoneinfo
+
+	"github.com/google/uuid"
+)
+
+// Profile field limits
+const (
+	MaxBioLength = 2000
+	// DefaultTimezone and DefaultLocale apply until the user chooses their own
+	DefaultTimezone = "UTC"
+	DefaultLocale   = "en"
+)
+
+// NotificationPreferences says which optional emails a user wants. Security emails (verification,
+// password resets, lockouts) are always sent.
+type NotificationPreferences struct {
+	CourseAnnouncements bool `json:"course_announcements"` // News from the instructors of enrolled courses
+	ExpiryReminders     bool `json:"expiry_reminders"`     // Course access running out soon
+	ProductUpdates      bool `json:"product_updates"`      // New LearnVibe features
+}
+
+// DefaultNotificationPreferences are the preferences of users who haven't changed them
+func DefaultNotificationPreferences() NotificationPreferences {
+	return NotificationPreferences{CourseAnnouncements: true, ExpiryReminders: true}
+}
+
+// UserProfile holds what users tell about themselves and how they want LearnVibe to behave for them.
+// Users without a row have the default profile. The avatar is uploaded to content-delivery, which
+// reports the URLs of its sizes back.
+type UserProfile struct {
+	UserID                  uuid.UUID               `gorm:"type:uuid;primaryKey" json:"-"`
+	Bio                     string                  `gorm:"size:2000" json:"bio"`
+	Timezone                string                  `gorm:"size:64;not null" json:"timezone"` // IANA name, e.g. Europe/Berlin
+	Locale                  string                  `gorm:"size:35;not null" json:"locale"`   // BCP 47 tag, e.g. en-US
+	NotificationPreferences NotificationPreferences `gorm:"type:jsonb;serializer:json" json:"notification_preferences"`
+	AvatarID                *uuid.UUID              `gorm:"type:uuid" json:"avatar_id,omitempty"`
+	AvatarURLs              map[string]string       `gorm:"type:jsonb;serializer:json" json:"avatar_urls,omitempty"` // By size: small, medium and large
+	AvatarUpdatedAt         *time.Time              `json:"-"`                                                       // When content-delivery processed the avatar, to ignore stale updates
+	CreatedAt               time.Time               `json:"created_at"`
+	UpdatedAt               time.Time               `json:"updated_at"`
+}
+
+// DefaultUserProfile returns the profile of a user who hasn't set one up
+func DefaultUserProfile(userID uuid.UUID) UserProfile {
+	return UserProfile{
+		UserID:                  userID,
+		Timezone:                DefaultTimezone,
+		Locale:                  DefaultLocale,
+		NotificationPreferences: DefaultNotificationPreferences(),
+	}
+}
+
+// localePattern matches BCP 47 language tags of a language with an optional script and region,
+// e.g. en, pt-BR, zh-Hant-TW or es-419
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z][a-z]{3})?(-([A-Z]{2}|[0-9]{3}))?$`)
+
+// IsValidLocale checks if the locale is a language tag like en or pt-BR
+func IsValidLocale(locale string) bool {
+	return localePattern.MatchString(locale)
+}
+
+// IsValidTimezone checks if the timezone is an IANA timezone name like Europe/Berlin
+func IsValidTimezone(timezone string) bool {
+	if timezone == "" || timezone == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(timezone)
+	return err == nil
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsValidLocale(t *testing.T) {
+	for _, locale := range []string{"en", "de", "pt-BR", "zh-Hant-TW", "es-419", "fil"} {
+		assert.True(t, IsValidLocale(locale), locale)
+	}
+	for _, locale := range []string{"", "EN", "en_US", "en-us", "english", "en-US-x-private", "de-"} {
+		assert.False(t, IsValidLocale(locale), locale)
+	}
+}
+
+func TestIsValidTimezone(t *testing.T) {
+	for _, timezone := range []string{"UTC", "Europe/Berlin", "America/Argentina/Buenos_Aires", "Asia/Kolkata"} {
+		assert.True(t, IsValidTimezone(timezone), timezone)
+	}
+	for _, timezone := range []string{"", "Local", "Mars/Olympus_Mons", "../../etc/passwd"} {
+		assert.False(t, IsValidTimezone(timezone), timezone)
+	}
+}
+
+func TestDefaultUserProfile(t *testing.T) {
+	userID := uuid.New()
+	profile := DefaultUserProfile(userID)
+
+	assert.Equal(t, userID, profile.UserID)
+	assert.Equal(t, "UTC", profile.Timezone)
+	assert.Equal(t, "en", profile.Locale)
+	assert.True(t, profile.NotificationPreferences.ExpiryReminders)
+	assert.False(t, profile.NotificationPreferences.ProductUpdates)
+	assert.Nil(t, profile.AvatarID)
+}
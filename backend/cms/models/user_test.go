@@ -1,7 +1,9 @@
 package models
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -107,3 +109,24 @@ func TestBeforeCreate(t *testing.T) {
 
 // Simple mock for gorm.DB
 type mockDB struct{}
+
+// TestUserIsActive tests that deactivated users are recognized
+func TestUserIsActive(t *testing.T) {
+	user := User{Role: RoleStudent}
+	assert.True(t, user.IsActive())
+
+	now := time.Now()
+	user.DeactivatedAt = &now
+	assert.False(t, user.IsActive())
+}
+
+// TestUserPasswordNotSerialized makes sure the password hash never ends up in API responses
+func TestUserPasswordNotSerialized(t *testing.T) {
+	user := User{ID: uuid.New(), Email: "test@example.com"}
+	assert.NoError(t, user.SetPassword("securepassword123"))
+
+	body, err := json.Marshal(user)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(body), user.Password)
+	assert.NotContains(t, string(body), "Password")
+}
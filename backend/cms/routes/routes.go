@@ -5,12 +5,26 @@ import (
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/config"
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/controllers"
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/middleware"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/policy"
 )
 
 // SetupRoutes configures all the routes for the application
 func SetupRoutes(router *gin.Engine, courseController *controllers.CourseController,
-	authController *controllers.AuthController, enrollmentController *controllers.EnrollmentController,
-	healthController *controllers.HealthController, cfg *config.Config) {
+	authController *controllers.AuthController, mfaController *controllers.MFAController, tokenController *controllers.PersonalAccessTokenController,
+	sessionController *controllers.SessionController,
+	adminController *controllers.AdminController, organizationController *controllers.OrganizationController,
+	applicationController *controllers.InstructorApplicationController, profileController *controllers.ProfileController,
+	privacyController *controllers.PrivacyController,
+	enrollmentController *controllers.EnrollmentController, cohortController *controllers.CohortController,
+	paymentController *controllers.PaymentController, ltiController *controllers.LTIController,
+	healthController *controllers.HealthController, verifier claims.Verifier,
+	revocations *claims.RevocationList, pats *services.PersonalAccessTokenService, audit *services.AuditService,
+	cfg *config.Config) {
+	// Every request an admin makes while impersonating a user is recorded under the admin
+	router.Use(middleware.AuditImpersonation(audit))
+
 	// Auth routes
 	authRoutes := router.Group("/auth")
 	{
@@ -18,17 +32,103 @@ func SetupRoutes(router *gin.Engine, courseController *controllers.CourseControl
 		authRoutes.GET("/google", authController.GoogleLogin)
 		authRoutes.GET("/google/callback", authController.GoogleCallback)
 
+		// OpenID Connect providers (Keycloak, Azure AD, ...)
+		authRoutes.GET("/providers", authController.GetProviders)
+		authRoutes.GET("/:provider/login", authController.ProviderLogin)
+		authRoutes.GET("/:provider/callback", authController.ProviderCallback)
+
 		// Standard authentication routes
 		authRoutes.POST("/login", authController.Login)
+		authRoutes.POST("/login/mfa", authController.VerifyMFA)
+		authRoutes.POST("/login/mfa/setup", authController.BeginMFAEnrollment)
+		authRoutes.POST("/login/mfa/enable", authController.CompleteMFAEnrollment)
 		authRoutes.POST("/register", authController.Register)
+		authRoutes.POST("/refresh", authController.Refresh)
+		authRoutes.POST("/logout", authController.Logout)
+
+		// Email verification and password reset
+		authRoutes.GET("/verify-email", authController.VerifyEmail)
+		authRoutes.POST("/verify-email", authController.VerifyEmail)
+		authRoutes.POST("/verify-email/resend", middleware.AuthMiddleware(verifier, revocations, pats), authController.ResendVerification)
+		authRoutes.POST("/forgot-password", authController.ForgotPassword)
+		authRoutes.POST("/reset-password", authController.ResetPassword)
+		authRoutes.GET("/password-policy", authController.GetPasswordPolicy)
+		authRoutes.PUT("/password", middleware.AuthMiddleware(verifier, revocations, pats), authController.ChangePassword)
+		authRoutes.GET("/unlock", authController.UnlockAccount)
+		authRoutes.POST("/unlock", authController.UnlockAccount)
 
 		// Current user route (protected)
-		authRoutes.GET("/me", middleware.AuthMiddleware(cfg.JWTSecret), authController.GetCurrentUser)
+		authRoutes.GET("/me", middleware.AuthMiddleware(verifier, revocations, pats), authController.GetCurrentUser)
+		authRoutes.PUT("/me", middleware.AuthMiddleware(verifier, revocations, pats), profileController.UpdateProfile)
+
+		// Personal data export and account deletion (protected); the deletion receipt is public, as
+		// its owner can't log in anymore
+		authRoutes.POST("/me/export", middleware.AuthMiddleware(verifier, revocations, pats), privacyController.RequestExport)
+		authRoutes.GET("/me/exports", middleware.AuthMiddleware(verifier, revocations, pats), privacyController.ListExports)
+		authRoutes.DELETE("/me", middleware.AuthMiddleware(verifier, revocations, pats), privacyController.DeleteAccount)
+		authRoutes.GET("/deletions/:id", privacyController.GetDeletion)
+
+		// Two-factor authentication management (protected)
+		mfaRoutes := authRoutes.Group("/mfa")
+		mfaRoutes.Use(middleware.AuthMiddleware(verifier, revocations, pats))
+		{
+			mfaRoutes.GET("", mfaController.GetStatus)
+			mfaRoutes.POST("/setup", mfaController.Setup)
+			mfaRoutes.POST("/enable", mfaController.Enable)
+			mfaRoutes.POST("/disable", mfaController.Disable)
+			mfaRoutes.POST("/recovery-codes", mfaController.RegenerateRecoveryCodes)
+		}
+
+		// Personal access tokens (protected); a personal access token can't manage tokens itself
+		tokenRoutes := authRoutes.Group("/tokens")
+		tokenRoutes.Use(middleware.AuthMiddleware(verifier, revocations, pats))
+		{
+			tokenRoutes.GET("", tokenController.ListTokens)
+			tokenRoutes.POST("", tokenController.CreateToken)
+			tokenRoutes.DELETE("/:id", tokenController.RevokeToken)
+		}
+
+		// Active sessions (protected); signing one out rejects its tokens in every service
+		sessionRoutes := authRoutes.Group("/sessions")
+		sessionRoutes.Use(middleware.AuthMiddleware(verifier, revocations, pats))
+		{
+			sessionRoutes.GET("", sessionController.ListSessions)
+			sessionRoutes.DELETE("", sessionController.RevokeSessions)
+			sessionRoutes.DELETE("/:id", sessionController.RevokeSession)
+		}
+
+		// Other services check personal access tokens here, authenticated with the introspection secret
+		authRoutes.POST("/introspect", tokenController.Introspect)
+		// Content-delivery asks about access windows it missed the enrollment events of, with the same secret
+		authRoutes.GET("/enrollment-access", enrollmentController.GetEnrollmentAccess)
+
+		// Linked login provider accounts (protected); linking needs a verified email
+		identityRoutes := authRoutes.Group("/identities")
+		identityRoutes.Use(middleware.AuthMiddleware(verifier, revocations, pats))
+		{
+			identityRoutes.GET("", authController.GetIdentities)
+			identityRoutes.POST("/:provider", middleware.RequireVerifiedEmail(), authController.LinkIdentity)
+			identityRoutes.DELETE("/:id", authController.UnlinkIdentity)
+		}
+	}
+
+	// Public keys for verifying access tokens
+	router.GET("/.well-known/jwks.json", authController.JWKS)
+
+	// Payment provider webhooks (public, authenticated by the provider's signature)
+	router.POST("/payments/:provider/webhook", paymentController.HandleWebhook)
+
+	// LTI 1.3 launches from registered platforms (public, authenticated by the platform's signature)
+	ltiRoutes := router.Group("/lti")
+	{
+		ltiRoutes.GET("/login", ltiController.Login)
+		ltiRoutes.POST("/login", ltiController.Login)
+		ltiRoutes.POST("/launch", ltiController.Launch)
 	}
 
 	// API routes (protected)
 	api := router.Group("/api")
-	api.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+	api.Use(middleware.AuthMiddleware(verifier, revocations, pats))
 	{
 		// Course routes
 		courses := api.Group("/courses")
@@ -37,21 +137,36 @@ func SetupRoutes(router *gin.Engine, courseController *controllers.CourseControl
 			courses.GET("", courseController.GetCourses)
 			courses.GET("/:id", courseController.GetCourse)
 
-			// Enrollment routes
-			courses.POST("/:id/enroll", enrollmentController.EnrollInCourse)
+			// Enrollment routes (verified email required)
+			courses.POST("/:id/enroll", middleware.RequireVerifiedEmail(), enrollmentController.EnrollInCourse)
+			courses.POST("/:id/checkout", middleware.RequireVerifiedEmail(), paymentController.Checkout)
 
-			// Routes restricted to instructors and admins
+			// Cohort routes
+			courses.GET("/:id/cohorts", cohortController.GetCourseCohorts)
+			courses.GET("/:id/cohorts/:cohortId", cohortController.GetCohort)
+			courses.GET("/:id/cohorts/:cohortId/due-dates", cohortController.GetCohortDueDates)
+
+			// Routes restricted to instructors and admins; the handlers check they own the course
 			instructorRoutes := courses.Group("")
-			instructorRoutes.Use(middleware.InstructorOrAdmin())
+			instructorRoutes.Use(middleware.RequireVerifiedEmail())
 			{
-				instructorRoutes.POST("", courseController.CreateCourse)
-				instructorRoutes.PUT("/:id", courseController.UpdateCourse)
-				instructorRoutes.DELETE("/:id", courseController.DeleteCourse)
-				instructorRoutes.POST("/:id/contents", courseController.AddCourseContent)
-				instructorRoutes.DELETE("/:id/contents/:contentId", courseController.DeleteCourseContent)
+				instructorRoutes.POST("", middleware.RequirePermission(policy.CourseCreate), courseController.CreateCourse)
+				instructorRoutes.PUT("/:id", middleware.RequirePermission(policy.CourseUpdate), courseController.UpdateCourse)
+				instructorRoutes.DELETE("/:id", middleware.RequirePermission(policy.CourseDelete), middleware.AuditActions(audit), courseController.DeleteCourse)
+				instructorRoutes.POST("/:id/contents", middleware.RequirePermission(policy.CourseUpdate), courseController.AddCourseContent)
+				instructorRoutes.DELETE("/:id/contents/:contentId", middleware.RequirePermission(policy.CourseUpdate), middleware.AuditActions(audit),
+					courseController.DeleteCourseContent)
 
 				// View enrollments for a course (instructors/admins only)
-				instructorRoutes.GET("/:id/enrollments", enrollmentController.GetCourseEnrollments)
+				instructorRoutes.GET("/:id/enrollments", middleware.RequirePermission(policy.CourseReadEnrollments), enrollmentController.GetCourseEnrollments)
+				instructorRoutes.POST("/:id/enrollments/:enrollmentId/transfer", middleware.RequirePermission(policy.CohortManage), cohortController.TransferEnrollment)
+
+				// Cohort management (instructors/admins only)
+				instructorRoutes.POST("/:id/cohorts", middleware.RequirePermission(policy.CohortManage), cohortController.CreateCohort)
+				instructorRoutes.PUT("/:id/cohorts/:cohortId", middleware.RequirePermission(policy.CohortManage), cohortController.UpdateCohort)
+				instructorRoutes.DELETE("/:id/cohorts/:cohortId", middleware.RequirePermission(policy.CohortManage), cohortController.DeleteCohort)
+				instructorRoutes.PUT("/:id/cohorts/:cohortId/due-dates/:contentId", middleware.RequirePermission(policy.CohortTeach), cohortController.SetCohortDueDate)
+				instructorRoutes.GET("/:id/cohorts/:cohortId/gradebook", middleware.RequirePermission(policy.CohortTeach), cohortController.GetCohortGradebook)
 			}
 		}
 
@@ -63,13 +178,93 @@ func SetupRoutes(router *gin.Engine, courseController *controllers.CourseControl
 			enrollments.GET("/:id", enrollmentController.GetEnrollmentDetails)
 			enrollments.PUT("/:id/progress", enrollmentController.UpdateEnrollmentProgress)
 			enrollments.PUT("/:id/drop", enrollmentController.DropEnrollment)
+			enrollments.PUT("/:id/grade", middleware.RequirePermission(policy.CohortTeach), middleware.RequireVerifiedEmail(), middleware.AuditActions(audit),
+				cohortController.SetEnrollmentGrade)
+		}
+
+		// Instructor applications (verified email required to apply)
+		applications := api.Group("/instructor-applications")
+		{
+			applications.POST("", middleware.RequireVerifiedEmail(), applicationController.Apply)
+			applications.GET("/mine", applicationController.GetMyApplications)
 		}
 
-		// Admin-only routes
+		// Instructor profiles with the courses they teach
+		api.GET("/instructors/:id", profileController.GetInstructor)
+
+		// Order routes
+		orders := api.Group("/orders")
+		{
+			orders.GET("", paymentController.GetUserOrders)
+			orders.GET("/:id", paymentController.GetOrder)
+		}
+
+		// The user's organization; org admins manage its users, and every change is recorded in the audit log
+		organization := api.Group("/organization")
+		{
+			organization.GET("", organizationController.GetOrganization)
+			organization.GET("/users", middleware.RequirePermission(policy.OrganizationManage), organizationController.ListUsers)
+			organization.PUT("/users/:id/role", middleware.RequirePermission(policy.OrganizationManage), middleware.AuditActions(audit),
+				organizationController.ChangeRole)
+			organization.PUT("/registration", middleware.RequirePermission(policy.OrganizationManage), middleware.AuditActions(audit),
+				organizationController.SetRegistration)
+		}
+
+		// Admin-only routes, every change is recorded in the audit log
 		admin := api.Group("/admin")
-		admin.Use(middleware.AdminOnly())
+		admin.Use(middleware.RequirePermission(policy.AdminAccess), middleware.AuditActions(audit))
 		{
-			// TODO: Add admin-specific routes here if needed
+			// User management
+			admin.GET("/users", adminController.ListUsers)
+			admin.GET("/users/:id", adminController.GetUser)
+			admin.GET("/users/:id/enrollments", adminController.GetUserEnrollments)
+			admin.GET("/users/:id/courses", adminController.GetUserCourses)
+			admin.PUT("/users/:id/role", adminController.ChangeRole)
+			admin.POST("/users/:id/deactivate", adminController.DeactivateUser)
+			admin.POST("/users/:id/reactivate", adminController.ReactivateUser)
+			admin.POST("/users/:id/force-password-reset", adminController.ForcePasswordReset)
+			admin.POST("/users/:id/impersonate", adminController.Impersonate)
+
+			// Instructor application review
+			admin.GET("/instructor-applications", applicationController.ListApplications)
+			admin.GET("/instructor-applications/:id", applicationController.GetApplication)
+			admin.POST("/instructor-applications/:id/approve", applicationController.ApproveApplication)
+			admin.POST("/instructor-applications/:id/reject", applicationController.RejectApplication)
+
+			// Audit log
+			admin.GET("/audit-logs", adminController.GetAuditLogs)
+			admin.GET("/audit-logs/verify", adminController.VerifyAuditLogs)
+
+			// Enrollment access management
+			admin.PUT("/enrollments/:id/access", enrollmentController.ExtendEnrollmentAccess)
+
+			// Token revocation
+			admin.POST("/users/:id/revoke-tokens", authController.RevokeUserTokens)
+			admin.GET("/users/:id/sessions", sessionController.ListUserSessions)
+			admin.DELETE("/users/:id/sessions/:sessionId", sessionController.RevokeUserSession)
+
+			// Login lockouts
+			admin.POST("/users/:id/unlock", authController.UnlockUser)
+
+			// Two-factor authentication policy
+			admin.GET("/mfa-policy", mfaController.GetPolicies)
+			admin.PUT("/mfa-policy/:role", mfaController.SetPolicy)
+
+			// Organizations
+			admin.GET("/organizations", organizationController.ListOrganizations)
+			admin.POST("/organizations", organizationController.CreateOrganization)
+
+			// LTI platforms that launch the organizations' courses
+			admin.GET("/lti/platforms", ltiController.ListPlatforms)
+			admin.POST("/lti/platforms", ltiController.CreatePlatform)
+			admin.PUT("/lti/platforms/:id", ltiController.UpdatePlatform)
+			admin.DELETE("/lti/platforms/:id", ltiController.DeletePlatform)
+
+			// Orders and coupons
+			admin.POST("/orders/:id/refund", paymentController.RefundOrder)
+			admin.GET("/coupons", paymentController.GetCoupons)
+			admin.POST("/coupons", paymentController.CreateCoupon)
+			admin.DELETE("/coupons/:id", paymentController.DeactivateCoupon)
 		}
 	}
 
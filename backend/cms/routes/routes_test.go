@@ -0,0 +1,269 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/config"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/controllers"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// access is who a route lets through to its handler
+type access int
+
+const (
+	public     access = iota // Everyone
+	users                    // Any logged in user
+	verified                 // Logged in users with a verified email
+	instructor               // Instructors, org admins and admins with a verified email
+	orgAdmin                 // Org admins and admins
+	admin                    // Admins
+)
+
+// routeMatrix lists every route with who may reach its handler. Ownership of the course,
+// enrollment or order is checked by the handlers and isn't part of the matrix.
+var routeMatrix = map[string]access{
+	"GET /auth/google":                                            public,
+	"GET /auth/google/callback":                                   public,
+	"GET /auth/providers":                                         public,
+	"GET /auth/:provider/login":                                   public,
+	"GET /auth/:provider/callback":                                public,
+	"POST /auth/login":                                            public,
+	"POST /auth/login/mfa":                                        public,
+	"POST /auth/login/mfa/setup":                                  public,
+	"POST /auth/login/mfa/enable":                                 public,
+	"POST /auth/register":                                         public,
+	"POST /auth/refresh":                                          public,
+	"POST /auth/logout":                                           public,
+	"GET /auth/verify-email":                                      public,
+	"POST /auth/verify-email":                                     public,
+	"POST /auth/verify-email/resend":                              users,
+	"POST /auth/forgot-password":                                  public,
+	"POST /auth/reset-password":                                   public,
+	"GET /auth/password-policy":                                   public,
+	"PUT /auth/password":                                          users,
+	"GET /auth/unlock":                                            public,
+	"POST /auth/unlock":                                           public,
+	"GET /auth/me":                                                users,
+	"PUT /auth/me":                                                users,
+	"DELETE /auth/me":                                             users,
+	"POST /auth/me/export":                                        users,
+	"GET /auth/me/exports":                                        users,
+	"GET /auth/deletions/:id":                                     public,
+	"GET /auth/mfa":                                               users,
+	"POST /auth/mfa/setup":                                        users,
+	"POST /auth/mfa/enable":                                       users,
+	"POST /auth/mfa/disable":                                      users,
+	"POST /auth/mfa/recovery-codes":                               users,
+	"GET /auth/tokens":                                            users,
+	"POST /auth/tokens":                                           users,
+	"DELETE /auth/tokens/:id":                                     users,
+	"GET /auth/sessions":                                          users,
+	"DELETE /auth/sessions":                                       users,
+	"DELETE /auth/sessions/:id":                                   users,
+	"POST /auth/introspect":                                       public,
+	"GET /auth/enrollment-access":                                 public,
+	"GET /auth/identities":                                        users,
+	"POST /auth/identities/:provider":                             verified,
+	"DELETE /auth/identities/:id":                                 users,
+	"GET /.well-known/jwks.json":                                  public,
+	"POST /payments/:provider/webhook":                            public,
+	"GET /lti/login":                                              public,
+	"POST /lti/login":                                             public,
+	"POST /lti/launch":                                            public,
+	"GET /api/courses":                                            users,
+	"GET /api/courses/:id":                                        users,
+	"POST /api/courses/:id/enroll":                                verified,
+	"POST /api/courses/:id/checkout":                              verified,
+	"GET /api/courses/:id/cohorts":                                users,
+	"GET /api/courses/:id/cohorts/:cohortId":                      users,
+	"GET /api/courses/:id/cohorts/:cohortId/due-dates":            users,
+	"POST /api/courses":                                           instructor,
+	"PUT /api/courses/:id":                                        instructor,
+	"DELETE /api/courses/:id":                                     instructor,
+	"POST /api/courses/:id/contents":                              instructor,
+	"DELETE /api/courses/:id/contents/:contentId":                 instructor,
+	"GET /api/courses/:id/enrollments":                            instructor,
+	"POST /api/courses/:id/enrollments/:enrollmentId/transfer":    instructor,
+	"POST /api/courses/:id/cohorts":                               instructor,
+	"PUT /api/courses/:id/cohorts/:cohortId":                      instructor,
+	"DELETE /api/courses/:id/cohorts/:cohortId":                   instructor,
+	"PUT /api/courses/:id/cohorts/:cohortId/due-dates/:contentId": instructor,
+	"GET /api/courses/:id/cohorts/:cohortId/gradebook":            instructor,
+	"GET /api/enrollments":                                        users,
+	"GET /api/enrollments/:id":                                    users,
+	"PUT /api/enrollments/:id/progress":                           users,
+	"PUT /api/enrollments/:id/drop":                               users,
+	"PUT /api/enrollments/:id/grade":                              instructor,
+	"POST /api/instructor-applications":                           verified,
+	"GET /api/instructor-applications/mine":                       users,
+	"GET /api/instructors/:id":                                    users,
+	"GET /api/orders":                                             users,
+	"GET /api/orders/:id":                                         users,
+	"GET /api/organization":                                       users,
+	"GET /api/organization/users":                                 orgAdmin,
+	"PUT /api/organization/users/:id/role":                        orgAdmin,
+	"PUT /api/organization/registration":                          orgAdmin,
+	"GET /api/admin/users":                                        admin,
+	"GET /api/admin/users/:id":                                    admin,
+	"GET /api/admin/users/:id/enrollments":                        admin,
+	"GET /api/admin/users/:id/courses":                            admin,
+	"PUT /api/admin/users/:id/role":                               admin,
+	"POST /api/admin/users/:id/deactivate":                        admin,
+	"POST /api/admin/users/:id/reactivate":                        admin,
+	"POST /api/admin/users/:id/force-password-reset":              admin,
+	"POST /api/admin/users/:id/impersonate":                       admin,
+	"GET /api/admin/instructor-applications":                      admin,
+	"GET /api/admin/instructor-applications/:id":                  admin,
+	"POST /api/admin/instructor-applications/:id/approve":         admin,
+	"POST /api/admin/instructor-applications/:id/reject":          admin,
+	"GET /api/admin/audit-logs/verify":                            admin,
+	"GET /api/admin/audit-logs":                                   admin,
+	"PUT /api/admin/enrollments/:id/access":                       admin,
+	"POST /api/admin/users/:id/revoke-tokens":                     admin,
+	"GET /api/admin/users/:id/sessions":                           admin,
+	"DELETE /api/admin/users/:id/sessions/:sessionId":             admin,
+	"POST /api/admin/users/:id/unlock":                            admin,
+	"GET /api/admin/mfa-policy":                                   admin,
+	"PUT /api/admin/mfa-policy/:role":                             admin,
+	"GET /api/admin/organizations":                                admin,
+	"POST /api/admin/organizations":                               admin,
+	"GET /api/admin/lti/platforms":                                admin,
+	"POST /api/admin/lti/platforms":                               admin,
+	"PUT /api/admin/lti/platforms/:id":                            admin,
+	"DELETE /api/admin/lti/platforms/:id":                         admin,
+	"POST /api/admin/orders/:id/refund":                           admin,
+	"GET /api/admin/coupons":                                      admin,
+	"POST /api/admin/coupons":                                     admin,
+	"DELETE /api/admin/coupons/:id":                               admin,
+	"GET /health":                                                 public,
+}
+
+// testCaller is someone calling the routes
+type testCaller struct {
+	name     string
+	role     models.Role // Empty for anonymous callers
+	verified bool
+}
+
+// allowed checks if the access level lets the caller through
+func (tc testCaller) allowed(level access) bool {
+	switch level {
+	case public:
+		return true
+	case users:
+		return tc.role != ""
+	case verified:
+		return tc.role != "" && tc.verified
+	case instructor:
+		return (tc.role == models.RoleInstructor || tc.role == models.RoleOrgAdmin || tc.role == models.RoleAdmin) && tc.verified
+	case orgAdmin:
+		return tc.role == models.RoleOrgAdmin || tc.role == models.RoleAdmin
+	default:
+		return tc.role == models.RoleAdmin
+	}
+}
+
+var pathParam = regexp.MustCompile(`:[A-Za-z]+`)
+
+// TestRouteAuthorizationMatrix calls every route as every kind of user and checks the auth,
+// email verification and permission middlewares let exactly the right users through to the handler
+func TestRouteAuthorizationMatrix(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	key, err := services.GenerateSigningKey("test-key", claims.AlgorithmRS256)
+	require.NoError(t, err)
+	tokens := services.NewTokenService(nil, nil, services.NewKeySet(key), "", "", time.Hour, 24*time.Hour)
+
+	// The controllers have no database, so a handler that's reached panics or fails. Whether it was
+	// reached is all that matters: the middlewares abort the requests they refuse.
+	var reached bool
+	var matchedRoute string
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		defer func() {
+			recover()
+			reached = !c.IsAborted()
+			matchedRoute = c.Request.Method + " " + c.FullPath()
+		}()
+		c.Next()
+	})
+	SetupRoutes(router, &controllers.CourseController{}, &controllers.AuthController{}, &controllers.MFAController{},
+		&controllers.PersonalAccessTokenController{}, &controllers.SessionController{}, &controllers.AdminController{}, &controllers.OrganizationController{},
+		&controllers.InstructorApplicationController{}, &controllers.ProfileController{}, &controllers.PrivacyController{},
+		&controllers.EnrollmentController{}, &controllers.CohortController{}, &controllers.PaymentController{},
+		&controllers.LTIController{}, controllers.NewTestHealthController(), tokens.Verifier(), nil, nil, services.NewAuditService(nil), &config.Config{})
+
+	// Every route is in the matrix, and the matrix has no stale routes
+	registered := map[string]bool{}
+	for _, route := range router.Routes() {
+		key := route.Method + " " + route.Path
+		registered[key] = true
+		assert.Contains(t, routeMatrix, key, "route isn't in the authorization matrix")
+	}
+	for key := range routeMatrix {
+		assert.True(t, registered[key], "%s is in the matrix but not registered", key)
+	}
+
+	callers := []testCaller{
+		{name: "anonymous"},
+		{name: "unverified student", role: models.RoleStudent},
+		{name: "student", role: models.RoleStudent, verified: true},
+		{name: "unverified instructor", role: models.RoleInstructor},
+		{name: "instructor", role: models.RoleInstructor, verified: true},
+		{name: "org admin", role: models.RoleOrgAdmin, verified: true},
+		{name: "admin", role: models.RoleAdmin, verified: true},
+	}
+	for _, caller := range callers {
+		token := ""
+		if caller.role != "" {
+			user := models.User{ID: uuid.New(), Name: caller.name, Email: "caller@example.com", Role: caller.role,
+				EmailVerified: caller.verified}
+			token, _, err = tokens.IssueAccessToken(user)
+			require.NoError(t, err)
+		}
+
+		for _, route := range router.Routes() {
+			key := route.Method + " " + route.Path
+			t.Run(caller.name+" "+key, func(t *testing.T) {
+				req := httptest.NewRequest(route.Method, pathParam.ReplaceAllString(route.Path, "1"), nil)
+				if token != "" {
+					req.Header.Set("Authorization", "Bearer "+token)
+				}
+				reached = false
+				router.ServeHTTP(httptest.NewRecorder(), req)
+
+				assert.Equal(t, key, matchedRoute)
+				assert.Equal(t, caller.allowed(routeMatrix[key]), reached)
+			})
+		}
+	}
+
+	// Unknown routes don't reach anything
+	req := httptest.NewRequest(http.MethodGet, "/api/unknown", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// TestReservedProviderNames tests that no OIDC provider can be named like another /auth route
+func TestReservedProviderNames(t *testing.T) {
+	authRoute := regexp.MustCompile(`^[A-Z]+ /auth/([^/]+)`)
+	for route := range routeMatrix {
+		match := authRoute.FindStringSubmatch(route)
+		if match == nil || match[1] == ":provider" {
+			continue
+		}
+		assert.True(t, config.IsReservedProviderName(match[1]), "%s isn't a reserved provider name", match[1])
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// breached-passwords turns a list of passwords, one per line on stdin (e.g. a list of the most
+// common passwords), into a BREACHED_PASSWORDS_FILE on stdout. The Pwned Passwords downloads can be
+// used as they are.
+func main() {
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		password := strings.TrimRight(scanner.Text(), "\r")
+		if password == "" {
+			continue
+		}
+		hash := sha1.Sum([]byte(password))
+		seen[strings.ToUpper(hex.EncodeToString(hash[:]))] = true
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Failed to read passwords: %v", err)
+	}
+
+	hashes := make([]string, 0, len(seen))
+	for hash := range seen {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for _, hash := range hashes {
+		fmt.Fprintln(out, hash)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services/ltitest"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+)
+
+// lti-platform runs a simulated LMS for trying out LTI launches locally. It prints the registration
+// to post to /api/admin/lti/platforms; every visit to the -listen address then launches the course
+// as the configured user, and the scores the CMS sends back are logged.
+func main() {
+	toolURL := flag.String("tool", "http://localhost:8080", "public URL of the CMS")
+	listen := flag.String("listen", "localhost:9090", "address to start launches at")
+	courseID := flag.String("course", "1", "ID of the course to launch")
+	email := flag.String("email", "lti.learner@example.com", "email of the launched user")
+	instructor := flag.Bool("instructor", false, "launch as an instructor of the LMS course")
+	flag.Parse()
+	tool := strings.TrimRight(*toolURL, "/")
+
+	platform := ltitest.NewPlatform("learnvibe-local", "local-deployment")
+	defer platform.Close()
+	platform.ToolKeyfunc = toolKeyfunc(tool + "/.well-known/jwks.json")
+
+	registration, _ := json.MarshalIndent(map[string]interface{}{
+		"name":           "Local LMS",
+		"issuer":         platform.Issuer(),
+		"client_id":      platform.ClientID,
+		"deployment_ids": []string{platform.DeploymentID},
+		"auth_login_url": platform.AuthLoginURL(),
+		"auth_token_url": platform.AuthTokenURL(),
+		"key_set_url":    platform.KeySetURL(),
+	}, "", "  ")
+	fmt.Printf("Register the platform with POST %s/api/admin/lti/platforms:\n%s\n\n", tool, registration)
+
+	launch := ltitest.Launch{
+		User:           ltitest.User{Subject: "local-" + *email, Email: *email, Name: *email},
+		ResourceLinkID: "local-link-" + *courseID,
+		ContextID:      "local-course",
+		Custom:         map[string]string{"course_id": *courseID},
+		Grades:         true,
+	}
+	if *instructor {
+		launch.Roles = []string{ltitest.RoleInstructor}
+	}
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, platform.LoginInitiationURL(tool+"/lti/login", tool+"/lti/launch", launch), http.StatusFound)
+	})
+	go logScores(platform)
+
+	fmt.Printf("Open http://%s to launch course %s as %s\n", *listen, *courseID, *email)
+	if err := http.ListenAndServe(*listen, nil); err != nil {
+		log.Printf("Failed to listen on %s: %v", *listen, err)
+		os.Exit(1)
+	}
+}
+
+// toolKeyfunc verifies the CMS's client assertions with the keys of its JWKS
+func toolKeyfunc(jwksURL string) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		resp, err := http.Get(jwksURL)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		var set claims.JSONWebKeySet
+		if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+			return nil, err
+		}
+		for _, key := range set.Keys {
+			if key.KeyID == token.Header["kid"] {
+				return key.PublicKey()
+			}
+		}
+		return nil, fmt.Errorf("unknown tool key %v", token.Header["kid"])
+	}
+}
+
+// logScores prints the scores the CMS sends as they arrive
+func logScores(platform *ltitest.Platform) {
+	seen := 0
+	for range time.Tick(time.Second) {
+		scores := platform.Scores()
+		for _, score := range scores[seen:] {
+			log.Printf("Score for %s: %.1f/%.0f (%s)", score.UserID, score.ScoreGiven, score.ScoreMaximum, score.GradingProgress)
+		}
+		seen = len(scores)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+)
+
+// verify-audit checks the hash chain of the audit log and exits with status 1 if it was tampered
+// with. Pass the head hash printed by an earlier run with -head to also notice removed newest entries.
+func main() {
+	expectedHead := flag.String("head", "", "head hash of an earlier run, which must still be in the audit log")
+	flag.Parse()
+
+	db, err := models.InitDB()
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	verification, err := services.NewAuditService(db).Verify(*expectedHead)
+	if err != nil {
+		log.Fatalf("Failed to verify the audit log: %v", err)
+	}
+
+	if !verification.Valid {
+		fmt.Printf("Audit log was tampered with after %d intact entries: %s\n", verification.Entries, verification.Error)
+		os.Exit(1)
+	}
+	fmt.Printf("Audit log is intact: %d entries\n", verification.Entries)
+	fmt.Printf("Head hash: %s\n", verification.HeadHash)
+}
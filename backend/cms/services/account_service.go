@@ -0,0 +1,337 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"gorm.io/gorm"
+)
+
+// Account token errors
+var (
+	ErrInvalidAccountToken  = errors.New("invalid or expired token")
+	ErrEmailAlreadyVerified = errors.New("email is already verified")
+	ErrResendTooSoon        = errors.New("a verification email was sent recently")
+)
+
+// Password change errors
+var (
+	ErrWrongPassword     = errors.New("current password is wrong")
+	ErrNoPassword        = errors.New("account has no password")
+	ErrPasswordUnchanged = errors.New("new password is the current password")
+)
+
+// verificationResendCooldown is how long a user has to wait before asking for another verification email
+const verificationResendCooldown = time.Minute
+
+// AccountService handles email verification, password resets and changes, and unlocking locked
+// accounts. Verification, resets and unlocks send the user a single-use, expiring link through the
+// mailer; only the token hash is stored. New passwords have to pass the password policy.
+type AccountService struct {
+	db              *gorm.DB
+	mailer          Mailer
+	tokens          *TokenService
+	passwords       *PasswordPolicy
+	baseURL         string
+	verificationTTL time.Duration
+	resetTTL        time.Duration
+}
+
+// NewAccountService creates a new account service. baseURL is where the links in the emails point to.
+// New passwords are checked against the default password policy if passwords is nil.
+func NewAccountService(db *gorm.DB, mailer Mailer, tokens *TokenService, passwords *PasswordPolicy, baseURL string,
+	verificationTTL, resetTTL time.Duration) *AccountService {
+	if passwords == nil {
+		passwords = NewPasswordPolicy(PasswordPolicyConfig{}, nil)
+	}
+	return &AccountService{
+		db:              db,
+		mailer:          mailer,
+		tokens:          tokens,
+		passwords:       passwords,
+		baseURL:         strings.TrimRight(baseURL, "/"),
+		verificationTTL: verificationTTL,
+		resetTTL:        resetTTL,
+	}
+}
+
+// SendEmailVerification mails the user a link to verify their email address
+func (s *AccountService) SendEmailVerification(ctx context.Context, user models.User) error {
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+
+	var recent int64
+	s.db.Model(&models.AccountToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL AND created_at > ?",
+			user.ID, models.PurposeEmailVerification, time.Now().Add(-verificationResendCooldown)).
+		Count(&recent)
+	if recent > 0 {
+		return ErrResendTooSoon
+	}
+
+	rawToken, err := s.issueToken(user, models.PurposeEmailVerification, s.verificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := s.baseURL + "/auth/verify-email?token=" + url.QueryEscape(rawToken)
+	return s.mailer.Send(ctx, Email{
+		To:      user.Email,
+		Subject: "Verify your LearnVibe email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s\n\n"+
+			"The link expires in %s. If you didn't create a LearnVibe account, you can ignore this email.\n",
+			user.Name, link, formatTTL(s.verificationTTL)),
+	})
+}
+
+// VerifyEmail redeems an email verification token and marks the user's email as verified
+func (s *AccountService) VerifyEmail(rawToken string) (*models.User, error) {
+	var user models.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		token, err := s.consumeToken(tx, rawToken, models.PurposeEmailVerification)
+		if err != nil {
+			return err
+		}
+		if err := tx.First(&user, "id = ?", token.UserID).Error; err != nil {
+			return ErrInvalidAccountToken
+		}
+
+		user.MarkEmailVerified(time.Now())
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"email_verified":    user.EmailVerified,
+			"email_verified_at": user.EmailVerifiedAt,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// RequestPasswordReset mails a password reset link if an account with the email exists. Unknown
+// addresses are silently ignored so the endpoint can't be used to find out who has an account.
+func (s *AccountService) RequestPasswordReset(ctx context.Context, email string) error {
+	var user models.User
+	if err := s.db.Where("email = ?", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	return s.sendPasswordReset(ctx, user, "Someone asked to reset the password of your LearnVibe account.",
+		"If you didn't ask for this, you can ignore this email.")
+}
+
+// RequirePasswordReset makes a user choose a new password before they can log in again (an admin
+// action, e.g. after a suspected compromise). The user is logged out everywhere and mailed a reset link.
+func (s *AccountService) RequirePasswordReset(ctx context.Context, user models.User) error {
+	if err := s.db.Model(&user).Update("password_reset_required", true).Error; err != nil {
+		return err
+	}
+	if err := s.tokens.RevokeAllForUser(ctx, user.ID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	return s.sendPasswordReset(ctx, user, "An administrator asked you to choose a new password for your LearnVibe account.",
+		"You won't be able to log in until you have reset your password.")
+}
+
+// sendPasswordReset mails the user a password reset link
+func (s *AccountService) sendPasswordReset(ctx context.Context, user models.User, reason, footer string) error {
+	rawToken, err := s.issueToken(user, models.PurposePasswordReset, s.resetTTL)
+	if err != nil {
+		return err
+	}
+
+	link := s.baseURL + "/reset-password?token=" + url.QueryEscape(rawToken)
+	return s.mailer.Send(ctx, Email{
+		To:      user.Email,
+		Subject: "Reset your LearnVibe password",
+		Body: fmt.Sprintf("Hi %s,\n\n%s To choose a new password, open the link below:\n\n%s\n\n"+
+			"The link expires in %s. %s\n",
+			user.Name, reason, link, formatTTL(s.resetTTL), footer),
+	})
+}
+
+// ResetPassword redeems a password reset token, sets the new password and logs the user out everywhere.
+// A new password the policy rejects leaves the token usable, with a *PasswordPolicyError.
+func (s *AccountService) ResetPassword(ctx context.Context, rawToken, newPassword string) (*models.User, error) {
+	var user models.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		token, err := s.consumeToken(tx, rawToken, models.PurposePasswordReset)
+		if err != nil {
+			return err
+		}
+		if err := tx.First(&user, "id = ?", token.UserID).Error; err != nil {
+			return ErrInvalidAccountToken
+		}
+		if err := s.passwords.Check(newPassword, user.Email, user.Name); err != nil {
+			return err
+		}
+
+		if err := user.SetPassword(newPassword); err != nil {
+			return fmt.Errorf("failed to hash password: %w", err)
+		}
+		updates := map[string]interface{}{"password": user.Password, "password_reset_required": false}
+		// The reset link reached the user's inbox, which proves they own the address
+		if !user.EmailVerified {
+			user.MarkEmailVerified(time.Now())
+			updates["email_verified"] = user.EmailVerified
+			updates["email_verified_at"] = user.EmailVerifiedAt
+		}
+		return tx.Model(&user).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Whoever knew the old password must not stay logged in
+	if err := s.tokens.RevokeAllForUser(ctx, user.ID); err != nil {
+		return nil, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return &user, nil
+}
+
+// ChangePassword sets a new password for a user who knows their current one, and signs out all their
+// sessions except keepSession (the one the change was made from). It returns the number of sessions
+// signed out.
+func (s *AccountService) ChangePassword(ctx context.Context, userID uuid.UUID, currentPassword, newPassword string,
+	keepSession uuid.UUID) (int, error) {
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return 0, err
+	}
+	if !user.HasPassword() {
+		return 0, ErrNoPassword
+	}
+	if !user.VerifyPassword(currentPassword) {
+		return 0, ErrWrongPassword
+	}
+	if currentPassword == newPassword {
+		return 0, ErrPasswordUnchanged
+	}
+	if err := s.passwords.Check(newPassword, user.Email, user.Name); err != nil {
+		return 0, err
+	}
+
+	if err := user.SetPassword(newPassword); err != nil {
+		return 0, fmt.Errorf("failed to hash password: %w", err)
+	}
+	if err := s.db.Model(&user).Update("password", user.Password).Error; err != nil {
+		return 0, err
+	}
+
+	// Whoever knew the old password must not stay logged in elsewhere
+	revoked, err := s.tokens.RevokeSessions(ctx, user.ID, keepSession)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return revoked, nil
+}
+
+// SendUnlockEmail mails the owner of a locked account a link that lifts the lockout.
+// The link is valid as long as a password reset link.
+func (s *AccountService) SendUnlockEmail(ctx context.Context, user models.User) error {
+	rawToken, err := s.issueToken(user, models.PurposeAccountUnlock, s.resetTTL)
+	if err != nil {
+		return err
+	}
+
+	link := s.baseURL + "/auth/unlock?token=" + url.QueryEscape(rawToken)
+	return s.mailer.Send(ctx, Email{
+		To:      user.Email,
+		Subject: "Your LearnVibe account was locked",
+		Body: fmt.Sprintf("Hi %s,\n\nThere were too many failed login attempts on your LearnVibe account, so it was "+
+			"locked for a while. If that was you, open the link below to unlock it right away:\n\n%s\n\n"+
+			"If it wasn't you, someone may be guessing your password. Consider resetting it.\n",
+			user.Name, link),
+	})
+}
+
+// RedeemUnlockToken redeems an account unlock token and returns the account to unlock
+func (s *AccountService) RedeemUnlockToken(rawToken string) (*models.User, error) {
+	var user models.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		token, err := s.consumeToken(tx, rawToken, models.PurposeAccountUnlock)
+		if err != nil {
+			return err
+		}
+		if err := tx.First(&user, "id = ?", token.UserID).Error; err != nil {
+			return ErrInvalidAccountToken
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// issueToken creates a new token for the purpose, invalidating the user's older unused ones
+func (s *AccountService) issueToken(user models.User, purpose models.AccountTokenPurpose, ttl time.Duration) (string, error) {
+	rawToken, err := GenerateOpaqueToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND purpose = ? AND used_at IS NULL", user.ID, purpose).
+			Delete(&models.AccountToken{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&models.AccountToken{
+			UserID:    user.ID,
+			Purpose:   purpose,
+			TokenHash: HashToken(rawToken),
+			ExpiresAt: time.Now().Add(ttl),
+		}).Error
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to store account token: %w", err)
+	}
+	return rawToken, nil
+}
+
+// consumeToken marks a token as used. Only one request can redeem a token.
+func (s *AccountService) consumeToken(tx *gorm.DB, rawToken string, purpose models.AccountTokenPurpose) (*models.AccountToken, error) {
+	var token models.AccountToken
+	if err := tx.Where("token_hash = ? AND purpose = ?", HashToken(rawToken), purpose).First(&token).Error; err != nil {
+		return nil, ErrInvalidAccountToken
+	}
+	if !token.IsUsable(time.Now()) {
+		return nil, ErrInvalidAccountToken
+	}
+
+	result := tx.Model(&models.AccountToken{}).
+		Where("id = ? AND used_at IS NULL", token.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidAccountToken
+	}
+	return &token, nil
+}
+
+// formatTTL renders a link lifetime for an email, e.g. "48 hours" or "30 minutes"
+func formatTTL(ttl time.Duration) string {
+	if ttl >= time.Hour && ttl%time.Hour == 0 {
+		hours := int(ttl / time.Hour)
+		if hours == 1 {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", hours)
+	}
+	return fmt.Sprintf("%d minutes", int(ttl/time.Minute))
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"gorm.io/gorm"
+)
+
+// AuditContextKey is the gin context key handlers put an AuditEntry under to describe what they
+// did. The audit middleware fills in the actor, IP and request ID and records it once the request succeeded.
+const AuditContextKey = "auditEntry"
+
+// EventAuditRecorded is the routing key other services publish their audit events under
+const EventAuditRecorded = "audit.recorded"
+
+// auditVerifyBatchSize is how many entries Verify loads at a time
+const auditVerifyBatchSize = 500
+
+// AuditEntry describes a security relevant action to record
+type AuditEntry struct {
+	ActorID    uuid.UUID              `json:"actor_id"`
+	Action     string                 `json:"action"`
+	TargetType string                 `json:"target_type"`
+	TargetID   string                 `json:"target_id"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+	Before     map[string]interface{} `json:"before,omitempty"`
+	After      map[string]interface{} `json:"after,omitempty"`
+	IPAddress  string                 `json:"ip_address"`
+	RequestID  string                 `json:"request_id,omitempty"`
+}
+
+// AuditFilter narrows down the audit log, empty fields match everything
+type AuditFilter struct {
+	ActorID    *uuid.UUID
+	Action     string
+	TargetType string
+	TargetID   string
+	RequestID  string
+	From       *time.Time
+	To         *time.Time
+}
+
+// AuditVerification is the result of checking the audit chain
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Entries  int64  `json:"entries"`             // Entries checked
+	HeadHash string `json:"head_hash,omitempty"` // Hash of the newest entry, keep a copy elsewhere to also notice the newest entries being removed
+	BrokenAt int64  `json:"broken_at,omitempty"` // Sequence of the first entry that doesn't fit the chain
+	Error    string `json:"error,omitempty"`
+}
+
+// AuditService stores the hash chained audit log, lists it and verifies the chain
+type AuditService struct {
+	db *gorm.DB
+}
+
+// NewAuditService creates a new audit service
+func NewAuditService(db *gorm.DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+// Record appends an entry to the audit log, chained to the newest entry
+func (s *AuditService) Record(entry AuditEntry) (*models.AuditLog, error) {
+	record := models.AuditLog{
+		ID:         uuid.New(),
+		ActorID:    entry.ActorID,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		Details:    entry.Details,
+		Before:     entry.Before,
+		After:      entry.After,
+		IPAddress:  entry.IPAddress,
+		RequestID:  entry.RequestID,
+		// Postgres keeps microseconds, and the hash must match what reads back
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := models.LockAuditChain(tx); err != nil {
+			return err
+		}
+
+		var head models.AuditLog
+		result := tx.Order("sequence DESC").Limit(1).Find(&head)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			record.Link(&head)
+		} else {
+			record.Link(nil)
+		}
+		return tx.Create(&record).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record audit log: %w", err)
+	}
+	return &record, nil
+}
+
+// HandleEvent records an audit event published by another service
+func (s *AuditService) HandleEvent(payload MessagePayload) error {
+	// Data arrives as a generic map, so round-trip it into the entry type
+	data, err := json.Marshal(payload.Data)
+	if err != nil {
+		return fmt.Errorf("failed to read audit event: %v", err)
+	}
+
+	var entry AuditEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return fmt.Errorf("failed to read audit event: %v", err)
+	}
+	if entry.Action == "" {
+		return fmt.Errorf("audit event without an action")
+	}
+
+	_, err = s.Record(entry)
+	return err
+}
+
+// Verify walks the whole audit chain, oldest first, and reports the first entry that was changed,
+// removed or doesn't link to the one before it. Removing the newest entries leaves a valid chain,
+// so expectedHead, the head hash of an earlier verification, must still be in it unless it's empty.
+func (s *AuditService) Verify(expectedHead string) (*AuditVerification, error) {
+	verification := &AuditVerification{Valid: true}
+
+	var prev *models.AuditLog
+	for {
+		after := int64(0)
+		if prev != nil {
+			after = prev.Sequence
+		}
+
+		var batch []models.AuditLog
+		err := s.db.Where("sequence > ?", after).Order("sequence").Limit(auditVerifyBatchSize).Find(&batch).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %w", err)
+		}
+
+		for i := range batch {
+			entry := &batch[i]
+			if err := entry.Follows(prev); err != nil {
+				verification.Valid = false
+				verification.BrokenAt = entry.Sequence
+				verification.Error = err.Error()
+				return verification, nil
+			}
+			verification.Entries++
+			verification.HeadHash = entry.Hash
+			prev = entry
+		}
+		if len(batch) < auditVerifyBatchSize {
+			break
+		}
+	}
+
+	// Entries that aren't part of the chain at all were slipped in around it
+	var unchained int64
+	if err := s.db.Model(&models.AuditLog{}).Where("sequence <= 0").Count(&unchained).Error; err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	if unchained > 0 {
+		verification.Valid = false
+		verification.Error = fmt.Sprintf("%d audit log entries aren't part of the chain", unchained)
+		return verification, nil
+	}
+
+	if expectedHead != "" {
+		var found int64
+		if err := s.db.Model(&models.AuditLog{}).Where("hash = ?", expectedHead).Count(&found).Error; err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %w", err)
+		}
+		if found == 0 {
+			verification.Valid = false
+			verification.Error = "the entry with hash " + expectedHead + " was removed"
+		}
+	}
+	return verification, nil
+}
+
+// List returns a page of the audit log, newest first, and the number of matching entries
+func (s *AuditService) List(filter AuditFilter, offset, limit int) ([]models.AuditLog, int64, error) {
+	query := s.db.Model(&models.AuditLog{})
+	if filter.ActorID != nil {
+		query = query.Where("actor_id = ?", *filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.RequestID != "" {
+		query = query.Where("request_id = ?", filter.RequestID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var logs []models.AuditLog
+	if err := query.Order("sequence DESC").Offset(offset).Limit(limit).Find(&logs).Error; err != nil {
+		return nil, 0, err
+	}
+	return logs, total, nil
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// BreachedPasswords is a local copy of passwords known from data breaches, kept as SHA-1 hashes in
+// the format of the Pwned Passwords downloads: one upper case hash per line, optionally followed by
+// ":<count>". That is the k-anonymity range files of the Pwned Passwords API with the 5 digit prefix
+// put back in front of each suffix, so passwords are screened without sending anything anywhere.
+type BreachedPasswords struct {
+	hashes [][sha1.Size]byte // Sorted
+}
+
+// LoadBreachedPasswords reads a breached password file
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadBreachedPasswords(file)
+}
+
+// ReadBreachedPasswords reads breached password hashes. Empty lines and lines starting with # are skipped.
+func ReadBreachedPasswords(r io.Reader) (*BreachedPasswords, error) {
+	var hashes [][sha1.Size]byte
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if i := strings.IndexByte(text, ':'); i >= 0 {
+			text = text[:i]
+		}
+
+		var hash [sha1.Size]byte
+		if len(text) != 2*sha1.Size {
+			return nil, fmt.Errorf("line %d: expected a SHA-1 hash of %d hex digits", line, 2*sha1.Size)
+		}
+		if _, err := hex.Decode(hash[:], []byte(text)); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		hashes = append(hashes, hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// The downloads are sorted already, but hand-made lists may not be
+	sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+	return &BreachedPasswords{hashes: hashes}, nil
+}
+
+// Contains checks if the password appeared in a breach
+func (b *BreachedPasswords) Contains(password string) bool {
+	hash := sha1.Sum([]byte(password))
+	i := sort.Search(len(b.hashes), func(i int) bool { return bytes.Compare(b.hashes[i][:], hash[:]) >= 0 })
+	return i < len(b.hashes) && b.hashes[i] == hash
+}
+
+// Len returns the number of breached passwords
+func (b *BreachedPasswords) Len() int {
+	return len(b.hashes)
+}
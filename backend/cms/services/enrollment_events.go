@@ -0,0 +1,40 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Routing keys for enrollment events published on the message broker
+const (
+	EventEnrollmentAccessUpdated = "enrollment.access_updated"
+	EventEnrollmentExpired       = "enrollment.expired"
+)
+
+// EnrollmentAccessEvent describes the access window of an enrollment.
+// Content-delivery keeps a copy of it to decide who may download course content.
+type EnrollmentAccessEvent struct {
+	EnrollmentID uuid.UUID  `json:"enrollment_id"`
+	UserID       uuid.UUID  `json:"user_id"`
+	CourseID     int        `json:"course_id"`
+	Status       string     `json:"status"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+}
+
+// PublishEvent publishes an event if the broker is available.
+// Failures are logged and not returned, so callers never fail a request on them.
+func PublishEvent(mb *MessageBroker, routingKey string, event interface{}) {
+	if mb == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := mb.PublishMessage(ctx, routingKey, event); err != nil {
+		log.Printf("Failed to publish %s event: %v", routingKey, err)
+	}
+}
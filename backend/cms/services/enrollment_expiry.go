@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"gorm.io/gorm"
+)
+
+// EnrollmentExpiryJob periodically expires enrollments whose access has run out
+// and emails reminders to students whose access is about to run out
+type EnrollmentExpiryJob struct {
+	db             *gorm.DB
+	messageBroker  *MessageBroker
+	mailer         Mailer
+	interval       time.Duration
+	reminderWindow time.Duration
+}
+
+// NewEnrollmentExpiryJob creates a new enrollment expiry job. Without a mailer no reminders are sent.
+func NewEnrollmentExpiryJob(db *gorm.DB, messageBroker *MessageBroker, mailer Mailer, interval, reminderWindow time.Duration) *EnrollmentExpiryJob {
+	return &EnrollmentExpiryJob{
+		db:             db,
+		messageBroker:  messageBroker,
+		mailer:         mailer,
+		interval:       interval,
+		reminderWindow: reminderWindow,
+	}
+}
+
+// Start runs the job every interval until the context is cancelled
+func (j *EnrollmentExpiryJob) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		for {
+			j.RunOnce(time.Now())
+
+			select {
+			case <-ctx.Done():
+				log.Println("Enrollment expiry job stopped")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RunOnce expires overdue enrollments and sends due reminders
+func (j *EnrollmentExpiryJob) RunOnce(now time.Time) {
+	if err := j.expireEnrollments(now); err != nil {
+		log.Printf("Failed to expire enrollments: %v", err)
+	}
+	if err := j.sendReminders(now); err != nil {
+		log.Printf("Failed to send expiry reminders: %v", err)
+	}
+}
+
+// expireEnrollments moves active enrollments past their expiry date to the expired status
+func (j *EnrollmentExpiryJob) expireEnrollments(now time.Time) error {
+	var enrollments []models.Enrollment
+	err := j.db.Where("status = ? AND expires_at IS NOT NULL AND expires_at <= ?", models.EnrollmentStatusActive, now).
+		Find(&enrollments).Error
+	if err != nil {
+		return err
+	}
+
+	for _, enrollment := range enrollments {
+		// Guard on the status so a concurrent extension isn't overwritten
+		result := j.db.Model(&models.Enrollment{}).
+			Where("id = ? AND status = ?", enrollment.ID, models.EnrollmentStatusActive).
+			Update("status", models.EnrollmentStatusExpired)
+		if result.Error != nil {
+			log.Printf("Failed to expire enrollment %s: %v", enrollment.ID, result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		enrollment.MarkAsExpired()
+		PublishEvent(j.messageBroker, EventEnrollmentExpired, EnrollmentAccessEvent{
+			EnrollmentID: enrollment.ID,
+			UserID:       enrollment.UserID,
+			CourseID:     enrollment.CourseID,
+			Status:       string(enrollment.Status),
+			ExpiresAt:    enrollment.ExpiresAt,
+		})
+	}
+
+	if len(enrollments) > 0 {
+		log.Printf("Expired %d enrollments", len(enrollments))
+	}
+	return nil
+}
+
+// sendReminders emails students once when their access expires within the reminder window, with
+// the expiry date in their timezone, unless they turned expiry reminders off. A reminder that
+// couldn't be sent is tried again on the next run.
+func (j *EnrollmentExpiryJob) sendReminders(now time.Time) error {
+	if j.mailer == nil {
+		return nil
+	}
+
+	var enrollments []models.Enrollment
+	err := j.db.Preload("User").Preload("Course").
+		Where("status = ? AND reminder_sent_at IS NULL AND expires_at > ? AND expires_at <= ?",
+			models.EnrollmentStatusActive, now, now.Add(j.reminderWindow)).
+		Find(&enrollments).Error
+	if err != nil {
+		return err
+	}
+
+	profiles := NewProfileService(j.db)
+	for _, enrollment := range enrollments {
+		// Students who turned reminders off are skipped, but marked so they aren't checked again
+		profile, err := profiles.Get(enrollment.UserID)
+		if err != nil {
+			log.Printf("Failed to load the profile of user %s for an expiry reminder: %v", enrollment.UserID, err)
+			continue
+		}
+		if profile.NotificationPreferences.ExpiryReminders {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			err := j.mailer.Send(ctx, expiryReminderEmail(enrollment, profile))
+			cancel()
+			if err != nil {
+				log.Printf("Failed to send the expiry reminder of enrollment %s: %v", enrollment.ID, err)
+				continue
+			}
+		}
+
+		if err := j.db.Model(&enrollment).Update("reminder_sent_at", now).Error; err != nil {
+			log.Printf("Failed to record expiry reminder for enrollment %s: %v", enrollment.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// expiryReminderEmail tells a student when their access to a course runs out, in their timezone
+func expiryReminderEmail(enrollment models.Enrollment, profile models.UserProfile) Email {
+	location, err := time.LoadLocation(profile.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	expiresAt := enrollment.ExpiresAt.In(location)
+
+	return Email{
+		To:      enrollment.User.Email,
+		Subject: fmt.Sprintf("Your access to %s ends soon", enrollment.Course.Title),
+		Body: fmt.Sprintf("Hi %s,\n\nYour access to %q ends on %s (%s). Make sure to finish what you "+
+			"want to before then.\n\nYou can turn these reminders off in your notification settings.\n",
+			enrollment.User.Name, enrollment.Course.Title, expiresAt.Format("Monday, January 2, 2006 at 15:04"), location),
+	}
+}
@@ -0,0 +1,28 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExpiryReminderEmail tests that the reminder shows the expiry date in the student's timezone
+func TestExpiryReminderEmail(t *testing.T) {
+	expiresAt := time.Date(2026, 3, 1, 2, 30, 0, 0, time.UTC)
+	enrollment := models.Enrollment{
+		ExpiresAt: &expiresAt,
+		User:      models.User{Email: "rosa@example.com", Name: "Rosa"},
+		Course:    models.Course{Title: "Watercolor Basics"},
+	}
+
+	email := expiryReminderEmail(enrollment, models.UserProfile{Timezone: "America/Mexico_City"})
+	assert.Equal(t, "rosa@example.com", email.To)
+	assert.Contains(t, email.Subject, "Watercolor Basics")
+	assert.Contains(t, email.Body, "Saturday, February 28, 2026 at 20:30 (America/Mexico_City)")
+
+	// An unknown timezone falls back to UTC
+	email = expiryReminderEmail(enrollment, models.UserProfile{Timezone: "Nowhere/Special"})
+	assert.Contains(t, email.Body, "Sunday, March 1, 2026 at 02:30 (UTC)")
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// ErasureRetryJob periodically asks content-delivery again to erase deleted accounts it hasn't
+// reported on, see PrivacyService.RetryErasures
+type ErasureRetryJob struct {
+	privacy  *PrivacyService
+	interval time.Duration
+}
+
+// NewErasureRetryJob creates a new erasure retry job
+func NewErasureRetryJob(privacy *PrivacyService, interval time.Duration) *ErasureRetryJob {
+	return &ErasureRetryJob{
+		privacy:  privacy,
+		interval: interval,
+	}
+}
+
+// Start runs the job every interval until the context is cancelled
+func (j *ErasureRetryJob) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		for {
+			j.RunOnce(time.Now())
+
+			select {
+			case <-ctx.Done():
+				log.Println("Erasure retry job stopped")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RunOnce requests the overdue erasures again
+func (j *ErasureRetryJob) RunOnce(now time.Time) {
+	retried, err := j.privacy.RetryErasures(now)
+	if err != nil {
+		log.Printf("Failed to retry account erasures: %v", err)
+	}
+	if retried > 0 {
+		log.Printf("Asked content-delivery again to erase %d deleted accounts", retried)
+	}
+}
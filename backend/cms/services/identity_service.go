@@ -0,0 +1,201 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Identity errors
+var (
+	ErrAccountExists           = errors.New("an account with this email already exists")
+	ErrProviderEmailUnverified = errors.New("the provider hasn't verified the email address")
+	ErrIdentityLinkedElsewhere = errors.New("the identity is linked to another account")
+	ErrProviderAlreadyLinked   = errors.New("an identity of this provider is already linked")
+	ErrIdentityNotFound        = errors.New("identity not found")
+	ErrLastLoginMethod         = errors.New("the identity is the last way to log in")
+)
+
+// IdentityService links accounts at login providers to users. A provider login only ever logs
+// into the user its identity is linked to: matching on the email alone would let anyone with an
+// account for the same address at some provider take over a password account.
+type IdentityService struct {
+	db *gorm.DB
+}
+
+// NewIdentityService creates a new identity service
+func NewIdentityService(db *gorm.DB) *IdentityService {
+	return &IdentityService{db: db}
+}
+
+// Resolve finds the user a provider login belongs to, creating an account for new emails. An
+// existing account with the same email isn't logged into, its owner has to link the provider first.
+func (s *IdentityService) Resolve(identity *OIDCIdentity) (*models.User, error) {
+	var user models.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var linked models.UserIdentity
+		err := tx.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&linked).Error
+		if err == nil {
+			if err := tx.First(&user, "id = ?", linked.UserID).Error; err != nil {
+				return err
+			}
+			return tx.Model(&linked).Updates(map[string]interface{}{
+				"email":         identity.Email,
+				"last_login_at": time.Now(),
+			}).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		err = tx.Where("LOWER(email) = LOWER(?)", identity.Email).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return s.createUser(tx, &user, identity)
+		}
+		if err != nil {
+			return err
+		}
+		if !s.isLegacyGoogleAccount(tx, user, identity) {
+			return ErrAccountExists
+		}
+		return createIdentity(tx, user.ID, identity)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Link links a provider identity to a user who is logged in. The provider has to vouch for the
+// email of the identity, and a user can link one identity per provider.
+func (s *IdentityService) Link(userID uuid.UUID, identity *OIDCIdentity) (*models.UserIdentity, error) {
+	if !identity.EmailVerified {
+		return nil, ErrProviderEmailUnverified
+	}
+
+	var linked models.UserIdentity
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&linked).Error
+		if err == nil {
+			if linked.UserID != userID {
+				return ErrIdentityLinkedElsewhere
+			}
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		var count int64
+		if err := tx.Model(&models.UserIdentity{}).Where("user_id = ? AND provider = ?", userID, identity.Provider).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrProviderAlreadyLinked
+		}
+
+		linked = models.UserIdentity{
+			UserID:   userID,
+			Provider: identity.Provider,
+			Subject:  identity.Subject,
+			Email:    identity.Email,
+		}
+		return tx.Create(&linked).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &linked, nil
+}
+
+// List returns the identities linked to a user
+func (s *IdentityService) List(userID uuid.UUID) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	if err := s.db.Where("user_id = ?", userID).Order("created_at ASC").Find(&identities).Error; err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// Unlink removes an identity from a user, unless it is the last way they can log in
+func (s *IdentityService) Unlink(userID, identityID uuid.UUID) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Locking the user keeps two unlinks from removing the last two identities at once
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", userID).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("id = ? AND user_id = ?", identityID, userID).First(&identity).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrIdentityNotFound
+			}
+			return err
+		}
+
+		var count int64
+		if err := tx.Model(&models.UserIdentity{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if !models.CanUnlinkIdentity(user, int(count)) {
+			return ErrLastLoginMethod
+		}
+		return tx.Delete(&identity).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// createUser creates an account for a first provider login and links the identity to it
+func (s *IdentityService) createUser(tx *gorm.DB, user *models.User, identity *OIDCIdentity) error {
+	name := identity.Name
+	if name == "" {
+		name = identity.Email
+	}
+	*user = models.User{
+		ID:    uuid.New(),
+		Email: identity.Email,
+		Name:  name,
+		Role:  models.RoleStudent, // Default role for new users
+	}
+	// The provider already verified the address
+	if identity.EmailVerified {
+		user.MarkEmailVerified(time.Now())
+	}
+
+	if err := tx.Create(user).Error; err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	return createIdentity(tx, user.ID, identity)
+}
+
+// isLegacyGoogleAccount checks if the user was created by a Google login before identities were
+// linked. Those accounts have no password and no identities, only a Google login can get into them.
+func (s *IdentityService) isLegacyGoogleAccount(tx *gorm.DB, user models.User, identity *OIDCIdentity) bool {
+	if identity.Provider != "google" || !identity.EmailVerified || user.HasPassword() {
+		return false
+	}
+	var count int64
+	tx.Model(&models.UserIdentity{}).Where("user_id = ?", user.ID).Count(&count)
+	return count == 0
+}
+
+// createIdentity links an identity to a user
+func createIdentity(tx *gorm.DB, userID uuid.UUID, identity *OIDCIdentity) error {
+	now := time.Now()
+	return tx.Create(&models.UserIdentity{
+		UserID:      userID,
+		Provider:    identity.Provider,
+		Subject:     identity.Subject,
+		Email:       identity.Email,
+		LastLoginAt: &now,
+	}).Error
+}
@@ -0,0 +1,242 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+)
+
+// SigningKey is a private key used to sign access tokens, identified by its kid
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+}
+
+// KeySet holds the keys the CMS signs access tokens with. Every key is published in the JWKS so
+// tokens signed with a previous key stay valid while keys are being rotated; only the active key signs.
+type KeySet struct {
+	dir      string
+	activeID string
+
+	mu     sync.RWMutex
+	keys   map[string]*SigningKey
+	active *SigningKey
+}
+
+// NewKeySet creates a key set from keys that are already loaded. The active key signs new tokens.
+func NewKeySet(active *SigningKey, others ...*SigningKey) *KeySet {
+	ks := &KeySet{keys: map[string]*SigningKey{active.ID: active}, active: active, activeID: active.ID}
+	for _, key := range others {
+		ks.keys[key.ID] = key
+	}
+	return ks
+}
+
+// LoadKeySet loads every <kid>.pem private key (RSA or Ed25519) from dir.
+// activeID selects the signing key; when empty the last kid in alphabetical order is used,
+// so keys named after their creation date rotate automatically.
+// Without any key file an ephemeral key is generated, which is only suitable for development.
+func LoadKeySet(dir, activeID string) (*KeySet, error) {
+	ks := &KeySet{dir: dir, activeID: activeID}
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+
+	if ks.active == nil {
+		log.Printf("WARNING: No JWT signing keys found in %q, using an ephemeral key for development only!", dir)
+		key, err := GenerateSigningKey("dev-"+randomKeyID(), claims.AlgorithmRS256)
+		if err != nil {
+			return nil, err
+		}
+		ks.keys = map[string]*SigningKey{key.ID: key}
+		ks.active = key
+	}
+
+	return ks, nil
+}
+
+// Reload reads the key directory again, picking up added and removed keys without a restart
+func (ks *KeySet) Reload() error {
+	if ks.dir == "" {
+		return nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(ks.dir, "*.pem"))
+	if err != nil {
+		return fmt.Errorf("failed to list signing keys: %v", err)
+	}
+	if len(files) == 0 {
+		// Keep whatever we have rather than locking everyone out
+		return nil
+	}
+	sort.Strings(files)
+
+	keys := make(map[string]*SigningKey, len(files))
+	var active *SigningKey
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read signing key %s: %v", file, err)
+		}
+		key, err := ParseSigningKey(strings.TrimSuffix(filepath.Base(file), ".pem"), data)
+		if err != nil {
+			return err
+		}
+		keys[key.ID] = key
+		if ks.activeID == "" || key.ID == ks.activeID {
+			active = key
+		}
+	}
+	if active == nil {
+		return fmt.Errorf("active signing key %q not found in %s", ks.activeID, ks.dir)
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+	ks.active = active
+	return nil
+}
+
+// Sign signs the claims with the active key and sets the kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	ks.mu.RLock()
+	key := ks.active
+	ks.mu.RUnlock()
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// Keyfunc returns the public key matching the token's kid, for use with jwt.Parse
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	ks.mu.RLock()
+	key, ok := ks.keys[kid]
+	ks.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Method.Alg())
+	}
+	return key.Private.Public(), nil
+}
+
+// ActiveKeyID returns the kid of the key new tokens are signed with
+func (ks *KeySet) ActiveKeyID() string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.active.ID
+}
+
+// JWKS returns the public keys of the set
+func (ks *KeySet) JWKS() claims.JSONWebKeySet {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	ids := make([]string, 0, len(ks.keys))
+	for id := range ks.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	set := claims.JSONWebKeySet{Keys: make([]claims.JSONWebKey, 0, len(ids))}
+	for _, id := range ids {
+		set.Keys = append(set.Keys, ks.keys[id].PublicJWK())
+	}
+	return set
+}
+
+// PublicJWK returns the public part of the key as a JWK
+func (k *SigningKey) PublicJWK() claims.JSONWebKey {
+	jwk := claims.JSONWebKey{KeyID: k.ID, Use: "sig", Algorithm: k.Algorithm}
+	switch pub := k.Private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+	return jwk
+}
+
+// ParseSigningKey parses a PEM encoded RSA (PKCS#1 or PKCS#8) or Ed25519 (PKCS#8) private key
+func ParseSigningKey(id string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", id)
+	}
+
+	var parsed interface{}
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %v", id, err)
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("signing key %s is too short, use at least 2048 bits", id)
+		}
+		return &SigningKey{ID: id, Algorithm: claims.AlgorithmRS256, Private: key}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: id, Algorithm: claims.AlgorithmEdDSA, Private: key}, nil
+	default:
+		return nil, errors.New("signing key " + id + " must be an RSA or Ed25519 key")
+	}
+}
+
+// GenerateSigningKey creates a new random signing key
+func GenerateSigningKey(id, algorithm string) (*SigningKey, error) {
+	switch algorithm {
+	case claims.AlgorithmRS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate RSA key: %v", err)
+		}
+		return &SigningKey{ID: id, Algorithm: algorithm, Private: key}, nil
+	case claims.AlgorithmEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate Ed25519 key: %v", err)
+		}
+		return &SigningKey{ID: id, Algorithm: algorithm, Private: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+}
+
+// randomKeyID returns a short random key ID
+func randomKeyID() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKey stores a signing key as <kid>.pem in dir
+func writeKey(t *testing.T, dir string, key *SigningKey) {
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	require.NoError(t, err)
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, key.ID+".pem"), data, 0600))
+}
+
+// TestKeySetSignAndVerify tests both supported algorithms and the published JWKS
+func TestKeySetSignAndVerify(t *testing.T) {
+	for _, algorithm := range []string{claims.AlgorithmRS256, claims.AlgorithmEdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			key, err := GenerateSigningKey("key-1", algorithm)
+			require.NoError(t, err)
+			keys := NewKeySet(key)
+
+			signed, err := keys.Sign(jwt.MapClaims{"sub": "user", "exp": time.Now().Add(time.Minute).Unix()})
+			require.NoError(t, err)
+
+			token, err := jwt.Parse(signed, keys.Keyfunc)
+			require.NoError(t, err)
+			assert.True(t, token.Valid)
+			assert.Equal(t, algorithm, token.Method.Alg())
+
+			jwks := keys.JWKS()
+			require.Len(t, jwks.Keys, 1)
+			assert.Equal(t, "key-1", jwks.Keys[0].KeyID)
+			assert.Equal(t, algorithm, jwks.Keys[0].Algorithm)
+		})
+	}
+}
+
+// TestKeySetRejectsForeignTokens tests that HMAC tokens and unknown keys are refused
+func TestKeySetRejectsForeignTokens(t *testing.T) {
+	key, err := GenerateSigningKey("key-1", claims.AlgorithmRS256)
+	require.NoError(t, err)
+	keys := NewKeySet(key)
+
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "user"})
+	hmacToken.Header["kid"] = "key-1"
+	signed, _ := hmacToken.SignedString([]byte("guessed-secret"))
+	_, err = jwt.Parse(signed, keys.Keyfunc)
+	assert.Error(t, err)
+
+	other, err := GenerateSigningKey("other", claims.AlgorithmRS256)
+	require.NoError(t, err)
+	signed, err = NewKeySet(other).Sign(jwt.MapClaims{"sub": "user"})
+	require.NoError(t, err)
+	_, err = jwt.Parse(signed, keys.Keyfunc)
+	assert.Error(t, err)
+}
+
+// TestKeySetRotation tests that adding a key to the directory switches signing without breaking old tokens
+func TestKeySetRotation(t *testing.T) {
+	dir := t.TempDir()
+	oldKey, err := GenerateSigningKey("2026-01-01", claims.AlgorithmRS256)
+	require.NoError(t, err)
+	writeKey(t, dir, oldKey)
+
+	keys, err := LoadKeySet(dir, "")
+	require.NoError(t, err)
+	assert.Equal(t, "2026-01-01", keys.ActiveKeyID())
+
+	oldToken, err := keys.Sign(jwt.MapClaims{"sub": "user"})
+	require.NoError(t, err)
+
+	newKey, err := GenerateSigningKey("2026-04-01", claims.AlgorithmEdDSA)
+	require.NoError(t, err)
+	writeKey(t, dir, newKey)
+	require.NoError(t, keys.Reload())
+
+	assert.Equal(t, "2026-04-01", keys.ActiveKeyID())
+	assert.Len(t, keys.JWKS().Keys, 2)
+
+	_, err = jwt.Parse(oldToken, keys.Keyfunc)
+	assert.NoError(t, err)
+
+	// Retiring the old key invalidates its tokens
+	require.NoError(t, os.Remove(filepath.Join(dir, "2026-01-01.pem")))
+	require.NoError(t, keys.Reload())
+	_, err = jwt.Parse(oldToken, keys.Keyfunc)
+	assert.Error(t, err)
+}
+
+// TestLoadKeySetPinnedKey tests that an explicitly configured active key wins over newer ones
+func TestLoadKeySetPinnedKey(t *testing.T) {
+	dir := t.TempDir()
+	for _, id := range []string{"a", "b"} {
+		key, err := GenerateSigningKey(id, claims.AlgorithmEdDSA)
+		require.NoError(t, err)
+		writeKey(t, dir, key)
+	}
+
+	keys, err := LoadKeySet(dir, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "a", keys.ActiveKeyID())
+
+	_, err = LoadKeySet(dir, "missing")
+	assert.Error(t, err)
+}
@@ -229,6 +229,16 @@ func (ls *LoggingService) Error(message string, err error, metadata map[string]i
 	ls.Log(LogLevelError, message, metadata)
 }
 
+// SecurityEvent logs a security relevant event (lockouts, unlocks, ...) so it can be filtered on in OpenSearch
+func (ls *LoggingService) SecurityEvent(event string, metadata map[string]interface{}) {
+	if metadata == nil {
+		metadata = make(map[string]interface{})
+	}
+	metadata["category"] = "security"
+	metadata["event"] = event
+	ls.Log(LogLevelWarning, "Security event: "+event, metadata)
+}
+
 // Fatal logs a fatal message and exits the application
 func (ls *LoggingService) Fatal(message string, err error, metadata map[string]interface{}) {
 	if metadata == nil {
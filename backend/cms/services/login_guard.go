@@ -0,0 +1,424 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis key prefixes of the login guard
+const (
+	loginFailPrefix = "login:fail:" // Failed attempts in the current window
+	loginLockPrefix = "login:lock:" // Temporary lockout
+	loginWaitPrefix = "login:wait:" // Progressive delay before the next attempt
+)
+
+// LoginGuardConfig holds the brute-force protection limits
+type LoginGuardConfig struct {
+	MaxAccountFailures int           // Failed attempts on one account before it is locked
+	MaxIPFailures      int           // Failed attempts from one IP (on any account) before it is locked
+	FailureWindow      time.Duration // How long failed attempts are remembered
+	LockoutDuration    time.Duration
+	BaseDelay          time.Duration // Wait after the second failure, doubled with every further failure
+	MaxDelay           time.Duration
+}
+
+// LoginStatus says whether a login attempt may go ahead
+type LoginStatus struct {
+	Locked     bool          // The account or IP is locked out
+	RetryAfter time.Duration // How long until the next attempt is allowed, zero if it is allowed now
+}
+
+// Allowed checks if the attempt may go ahead
+func (s LoginStatus) Allowed() bool {
+	return s.RetryAfter <= 0
+}
+
+// localGuardEntry is an in-memory counter or marker, used when Redis isn't available
+type localGuardEntry struct {
+	value     int64
+	expiresAt time.Time
+}
+
+// LoginGuard protects password logins against brute force. Attempts are counted per account and per
+// IP in Redis, so every CMS instance sees them. Each failure makes the client wait longer before the
+// next attempt, and too many failures lock the account or IP for a while. An attempt is counted
+// before the password is checked and forgotten again when it succeeds, so parallel attempts can't
+// all get in before the first failure is counted.
+type LoginGuard struct {
+	redis  *redis.Client
+	logger *LoggingService
+	config LoginGuardConfig
+
+	mu    sync.Mutex
+	local map[string]localGuardEntry
+}
+
+// NewLoginGuard creates a new login guard. With a nil Redis client the counters are kept in memory,
+// which only protects a single instance.
+func NewLoginGuard(rdb *redis.Client, logger *LoggingService, config LoginGuardConfig) *LoginGuard {
+	return &LoginGuard{
+		redis:  rdb,
+		logger: logger,
+		config: config,
+		local:  make(map[string]localGuardEntry),
+	}
+}
+
+// Check says whether a login attempt for the email from the IP may go ahead
+func (g *LoginGuard) Check(ctx context.Context, email, ip string) (LoginStatus, error) {
+	account, address := accountKey(email), ipKey(ip)
+
+	for _, key := range []string{loginLockPrefix + account, loginLockPrefix + address} {
+		ttl, err := g.ttl(ctx, key)
+		if err != nil {
+			return LoginStatus{}, err
+		}
+		if ttl > 0 {
+			return LoginStatus{Locked: true, RetryAfter: ttl}, nil
+		}
+	}
+
+	ttl, err := g.ttl(ctx, loginWaitPrefix+account)
+	if err != nil {
+		return LoginStatus{}, err
+	}
+	return LoginStatus{RetryAfter: ttl}, nil
+}
+
+// Attempt counts a login attempt for the email from the IP before the password is checked, and says
+// whether it may go ahead. Attempts that go ahead have to end with RecordFailure or RecordSuccess.
+func (g *LoginGuard) Attempt(ctx context.Context, email, ip string) (LoginStatus, error) {
+	status, err := g.Check(ctx, email, ip)
+	if err != nil || !status.Allowed() {
+		return status, err
+	}
+
+	account, address := accountKey(email), ipKey(ip)
+	attempts, err := g.incr(ctx, loginFailPrefix+account, g.config.FailureWindow)
+	if err != nil {
+		return LoginStatus{}, err
+	}
+	ipAttempts, err := g.incr(ctx, loginFailPrefix+address, g.config.FailureWindow)
+	if err != nil {
+		return LoginStatus{}, err
+	}
+
+	// Attempts past the limits were started alongside the one that locked, or after a lockout
+	// expired within the failure window; they lock again
+	if ipAttempts > int64(g.config.MaxIPFailures) {
+		if err := g.set(ctx, loginLockPrefix+address, g.config.LockoutDuration); err != nil {
+			return LoginStatus{}, err
+		}
+		return LoginStatus{Locked: true, RetryAfter: g.config.LockoutDuration}, nil
+	}
+	if attempts > int64(g.config.MaxAccountFailures) {
+		if err := g.set(ctx, loginLockPrefix+account, g.config.LockoutDuration); err != nil {
+			return LoginStatus{}, err
+		}
+		return LoginStatus{Locked: true, RetryAfter: g.config.LockoutDuration}, nil
+	}
+
+	// The wait before the next attempt starts now; taking it only succeeds once the previous one
+	// is over, which is what makes the delay hold for parallel attempts
+	if delay := g.Delay(int(attempts)); delay > 0 {
+		taken, err := g.setNX(ctx, loginWaitPrefix+account, delay)
+		if err != nil {
+			return LoginStatus{}, err
+		}
+		if !taken {
+			if err := g.decr(ctx, loginFailPrefix+account, loginFailPrefix+address); err != nil {
+				return LoginStatus{}, err
+			}
+			wait, err := g.ttl(ctx, loginWaitPrefix+account)
+			if err != nil {
+				return LoginStatus{}, err
+			}
+			return LoginStatus{RetryAfter: wait}, nil
+		}
+	}
+	return LoginStatus{}, nil
+}
+
+// RecordFailure records that an attempt counted by Attempt failed, and locks the account or IP when
+// it reached the limit. It returns true when this failure locked the account, so the caller can
+// send the owner an unlock email.
+func (g *LoginGuard) RecordFailure(ctx context.Context, email, ip string) (bool, error) {
+	account, address := accountKey(email), ipKey(ip)
+
+	accountFailures, err := g.get(ctx, loginFailPrefix+account)
+	if err != nil {
+		return false, err
+	}
+	ipFailures, err := g.get(ctx, loginFailPrefix+address)
+	if err != nil {
+		return false, err
+	}
+
+	if ipFailures >= int64(g.config.MaxIPFailures) {
+		if err := g.set(ctx, loginLockPrefix+address, g.config.LockoutDuration); err != nil {
+			return false, err
+		}
+		g.securityEvent("login_ip_locked", map[string]interface{}{
+			"ip_address": ip,
+			"failures":   ipFailures,
+			"duration":   g.config.LockoutDuration.String(),
+		})
+	}
+
+	if accountFailures >= int64(g.config.MaxAccountFailures) {
+		if err := g.set(ctx, loginLockPrefix+account, g.config.LockoutDuration); err != nil {
+			return false, err
+		}
+		g.securityEvent("login_account_locked", map[string]interface{}{
+			"email":      email,
+			"ip_address": ip,
+			"failures":   accountFailures,
+			"duration":   g.config.LockoutDuration.String(),
+		})
+		return accountFailures == int64(g.config.MaxAccountFailures), nil
+	}
+	return false, nil
+}
+
+// RecordSuccess forgets the failed attempts on an account after a successful login, and takes the
+// attempt back from the IP's count
+func (g *LoginGuard) RecordSuccess(ctx context.Context, email, ip string) error {
+	account := accountKey(email)
+	if err := g.del(ctx, loginFailPrefix+account, loginWaitPrefix+account); err != nil {
+		return err
+	}
+	return g.ReleaseIP(ctx, ip)
+}
+
+// ReleaseIP takes an attempt that didn't fail back from the IP's count. The account's count is
+// kept, e.g. after a correct password of a login that still needs an MFA code.
+func (g *LoginGuard) ReleaseIP(ctx context.Context, ip string) error {
+	return g.decr(ctx, loginFailPrefix+ipKey(ip))
+}
+
+// Unlock lifts the lockout of an account and resets its failed attempts. via says who unlocked
+// it (e.g. "email" or "admin") for the security log.
+func (g *LoginGuard) Unlock(ctx context.Context, email, via string) error {
+	account := accountKey(email)
+	if err := g.del(ctx, loginLockPrefix+account, loginFailPrefix+account, loginWaitPrefix+account); err != nil {
+		return err
+	}
+	g.securityEvent("login_account_unlocked", map[string]interface{}{
+		"email": email,
+		"via":   via,
+	})
+	return nil
+}
+
+// Delay returns how long a client has to wait after its nth failed attempt. The first failure is
+// free, a typo shouldn't slow anyone down.
+func (g *LoginGuard) Delay(failures int) time.Duration {
+	if failures <= 1 {
+		return 0
+	}
+	delay := g.config.BaseDelay
+	for i := 2; i < failures && delay < g.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > g.config.MaxDelay {
+		delay = g.config.MaxDelay
+	}
+	return delay
+}
+
+// securityEvent reports an event to the central logging service
+func (g *LoginGuard) securityEvent(event string, metadata map[string]interface{}) {
+	if g.logger != nil {
+		g.logger.SecurityEvent(event, metadata)
+	}
+}
+
+// incr increments a counter that expires ttl after its first increment
+func (g *LoginGuard) incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	if g.redis == nil {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		entry, ok := g.local[key]
+		if !ok || time.Now().After(entry.expiresAt) {
+			entry = localGuardEntry{expiresAt: time.Now().Add(ttl)}
+		}
+		entry.value++
+		g.local[key] = entry
+		return entry.value, nil
+	}
+
+	count, err := g.redis.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count login failure: %v", err)
+	}
+	if count == 1 {
+		if err := g.redis.Expire(ctx, key, ttl).Err(); err != nil {
+			return 0, fmt.Errorf("failed to count login failure: %v", err)
+		}
+	}
+	return count, nil
+}
+
+// decr takes one back from counters that still exist
+func (g *LoginGuard) decr(ctx context.Context, keys ...string) error {
+	if g.redis == nil {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		for _, key := range keys {
+			if entry, ok := g.local[key]; ok && entry.value > 0 {
+				entry.value--
+				g.local[key] = entry
+			}
+		}
+		return nil
+	}
+
+	for _, key := range keys {
+		// A counter that expired in the meantime must not come back without an expiry
+		if err := decrScript.Run(ctx, g.redis, []string{key}).Err(); err != nil && err != redis.Nil {
+			return fmt.Errorf("failed to update login guard: %v", err)
+		}
+	}
+	return nil
+}
+
+// decrScript decrements a counter only if it exists and is above zero
+var decrScript = redis.NewScript(`
+if tonumber(redis.call("GET", KEYS[1]) or "0") > 0 then
+	return redis.call("DECR", KEYS[1])
+end
+return 0`)
+
+// get returns the value of a counter, zero if it doesn't exist
+func (g *LoginGuard) get(ctx context.Context, key string) (int64, error) {
+	if g.redis == nil {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		entry, ok := g.local[key]
+		if !ok || time.Now().After(entry.expiresAt) {
+			return 0, nil
+		}
+		return entry.value, nil
+	}
+
+	count, err := g.redis.Get(ctx, key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to check login guard: %v", err)
+	}
+	return count, nil
+}
+
+// setNX sets a marker that expires after ttl unless it is already set, and says whether it was set
+func (g *LoginGuard) setNX(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if g.redis == nil {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if entry, ok := g.local[key]; ok && time.Now().Before(entry.expiresAt) {
+			return false, nil
+		}
+		g.local[key] = localGuardEntry{value: 1, expiresAt: time.Now().Add(ttl)}
+		g.sweep()
+		return true, nil
+	}
+
+	set, err := g.redis.SetNX(ctx, key, 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to update login guard: %v", err)
+	}
+	return set, nil
+}
+
+// set sets a marker that expires after ttl
+func (g *LoginGuard) set(ctx context.Context, key string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	if g.redis == nil {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.local[key] = localGuardEntry{value: 1, expiresAt: time.Now().Add(ttl)}
+		g.sweep()
+		return nil
+	}
+
+	if err := g.redis.Set(ctx, key, 1, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to update login guard: %v", err)
+	}
+	return nil
+}
+
+// ttl returns how long a key still lives, zero if it doesn't exist
+func (g *LoginGuard) ttl(ctx context.Context, key string) (time.Duration, error) {
+	if g.redis == nil {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		entry, ok := g.local[key]
+		if !ok {
+			return 0, nil
+		}
+		remaining := time.Until(entry.expiresAt)
+		if remaining <= 0 {
+			delete(g.local, key)
+			return 0, nil
+		}
+		return remaining, nil
+	}
+
+	ttl, err := g.redis.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to check login guard: %v", err)
+	}
+	// PTTL returns negative values for missing keys
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// del removes keys
+func (g *LoginGuard) del(ctx context.Context, keys ...string) error {
+	if g.redis == nil {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		for _, key := range keys {
+			delete(g.local, key)
+		}
+		return nil
+	}
+
+	if err := g.redis.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("failed to reset login guard: %v", err)
+	}
+	return nil
+}
+
+// sweep drops expired in-memory entries now and then so the map doesn't grow forever
+func (g *LoginGuard) sweep() {
+	if len(g.local) <= 10000 {
+		return
+	}
+	now := time.Now()
+	for key, entry := range g.local {
+		if now.After(entry.expiresAt) {
+			delete(g.local, key)
+		}
+	}
+}
+
+// accountKey is the key suffix of an account; emails are compared case-insensitively
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// ipKey is the key suffix of a client IP
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestLoginGuard creates a login guard with in-memory counters
+func newTestLoginGuard() *LoginGuard {
+	return NewLoginGuard(nil, nil, LoginGuardConfig{
+		MaxAccountFailures: 3,
+		MaxIPFailures:      5,
+		FailureWindow:      time.Minute,
+		LockoutDuration:    time.Minute,
+		BaseDelay:          time.Second,
+		MaxDelay:           4 * time.Second,
+	})
+}
+
+// TestLoginGuardDelay tests that the wait grows with every failure and is capped
+func TestLoginGuardDelay(t *testing.T) {
+	guard := newTestLoginGuard()
+
+	assert.Equal(t, time.Duration(0), guard.Delay(0))
+	assert.Equal(t, time.Duration(0), guard.Delay(1))
+	assert.Equal(t, time.Second, guard.Delay(2))
+	assert.Equal(t, 2*time.Second, guard.Delay(3))
+	assert.Equal(t, 4*time.Second, guard.Delay(4))
+	assert.Equal(t, 4*time.Second, guard.Delay(10))
+}
+
+// failedAttempt makes an attempt that fails, and says whether the failure locked the account
+func failedAttempt(t *testing.T, guard *LoginGuard, email, ip string) bool {
+	ctx := context.Background()
+	status, err := guard.Attempt(ctx, email, ip)
+	assert.NoError(t, err)
+	assert.True(t, status.Allowed())
+	locked, err := guard.RecordFailure(ctx, email, ip)
+	assert.NoError(t, err)
+	return locked
+}
+
+// waitOver ends the progressive delay of an account, as if the client waited
+func waitOver(guard *LoginGuard, email string) {
+	guard.del(context.Background(), loginWaitPrefix+accountKey(email))
+}
+
+// TestLoginGuardAccountLockout tests that too many failures lock the account until it is unlocked
+func TestLoginGuardAccountLockout(t *testing.T) {
+	ctx := context.Background()
+	guard := newTestLoginGuard()
+
+	status, err := guard.Check(ctx, "student@example.com", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.True(t, status.Allowed())
+
+	// The first failure is free, the second one makes the client wait
+	assert.False(t, failedAttempt(t, guard, "student@example.com", "10.0.0.1"))
+	status, _ = guard.Check(ctx, "student@example.com", "10.0.0.1")
+	assert.True(t, status.Allowed())
+
+	assert.False(t, failedAttempt(t, guard, "Student@Example.com", "10.0.0.2"))
+	status, _ = guard.Attempt(ctx, "student@example.com", "10.0.0.1")
+	assert.False(t, status.Allowed())
+	assert.False(t, status.Locked)
+	assert.InDelta(t, time.Second, status.RetryAfter, float64(100*time.Millisecond))
+
+	// The third failure locks the account, from every IP
+	waitOver(guard, "student@example.com")
+	assert.True(t, failedAttempt(t, guard, "student@example.com", "10.0.0.1"))
+	status, _ = guard.Attempt(ctx, "student@example.com", "10.0.0.9")
+	assert.True(t, status.Locked)
+	assert.False(t, status.Allowed())
+
+	// An attempt after the lockout expired within the window locks again, without a failure that
+	// would ask for another unlock email
+	guard.del(ctx, loginLockPrefix+accountKey("student@example.com"))
+	waitOver(guard, "student@example.com")
+	status, _ = guard.Attempt(ctx, "student@example.com", "10.0.0.9")
+	assert.True(t, status.Locked)
+
+	// Other accounts aren't affected
+	status, _ = guard.Check(ctx, "other@example.com", "10.0.0.9")
+	assert.True(t, status.Allowed())
+
+	assert.NoError(t, guard.Unlock(ctx, "student@example.com", "admin"))
+	status, _ = guard.Check(ctx, "student@example.com", "10.0.0.9")
+	assert.True(t, status.Allowed())
+}
+
+// TestLoginGuardParallelAttempts tests that attempts started before any of them failed are counted,
+// so they can't get past the delay and the lockout
+func TestLoginGuardParallelAttempts(t *testing.T) {
+	ctx := context.Background()
+	guard := newTestLoginGuard()
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		status, err := guard.Attempt(ctx, "student@example.com", "10.0.0.1")
+		assert.NoError(t, err)
+		if status.Allowed() {
+			allowed++
+		}
+	}
+	// The first attempt is free and the second one starts the wait
+	assert.Equal(t, 2, allowed)
+
+	// Once the wait is over, attempts beyond the limit lock the account
+	waitOver(guard, "student@example.com")
+	status, _ := guard.Attempt(ctx, "student@example.com", "10.0.0.1")
+	assert.True(t, status.Allowed())
+	waitOver(guard, "student@example.com")
+	status, _ = guard.Attempt(ctx, "student@example.com", "10.0.0.1")
+	assert.True(t, status.Locked)
+}
+
+// TestLoginGuardIPLockout tests that an IP trying many accounts gets locked, and locked again when
+// it goes on after the lockout
+func TestLoginGuardIPLockout(t *testing.T) {
+	ctx := context.Background()
+	guard := newTestLoginGuard()
+
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com", "d@example.com", "e@example.com"} {
+		failedAttempt(t, guard, email, "10.0.0.1")
+	}
+
+	status, err := guard.Attempt(ctx, "f@example.com", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.True(t, status.Locked)
+
+	status, _ = guard.Check(ctx, "f@example.com", "10.0.0.2")
+	assert.True(t, status.Allowed())
+
+	// The lockout ends before the failures are forgotten, the next attempt locks the IP again
+	guard.del(ctx, loginLockPrefix+ipKey("10.0.0.1"))
+	status, _ = guard.Attempt(ctx, "g@example.com", "10.0.0.1")
+	assert.True(t, status.Locked)
+	status, _ = guard.Check(ctx, "h@example.com", "10.0.0.1")
+	assert.True(t, status.Locked)
+}
+
+// TestLoginGuardSuccessResets tests that a successful login forgets earlier failures
+func TestLoginGuardSuccessResets(t *testing.T) {
+	ctx := context.Background()
+	guard := newTestLoginGuard()
+
+	failedAttempt(t, guard, "student@example.com", "10.0.0.1")
+	failedAttempt(t, guard, "student@example.com", "10.0.0.1")
+	waitOver(guard, "student@example.com")
+	status, _ := guard.Attempt(ctx, "student@example.com", "10.0.0.1")
+	assert.True(t, status.Allowed())
+	assert.NoError(t, guard.RecordSuccess(ctx, "student@example.com", "10.0.0.1"))
+
+	status, _ = guard.Check(ctx, "student@example.com", "10.0.0.1")
+	assert.True(t, status.Allowed())
+
+	assert.False(t, failedAttempt(t, guard, "student@example.com", "10.0.0.1"))
+	status, _ = guard.Check(ctx, "student@example.com", "10.0.0.1")
+	assert.True(t, status.Allowed())
+}
+
+// TestLoginGuardReleaseIP tests that releasing the IP keeps the account's attempts counted
+func TestLoginGuardReleaseIP(t *testing.T) {
+	ctx := context.Background()
+	guard := newTestLoginGuard()
+
+	status, _ := guard.Attempt(ctx, "student@example.com", "10.0.0.1")
+	assert.True(t, status.Allowed())
+	assert.NoError(t, guard.ReleaseIP(ctx, "10.0.0.1"))
+
+	ipFailures, _ := guard.get(ctx, loginFailPrefix+ipKey("10.0.0.1"))
+	assert.Equal(t, int64(0), ipFailures)
+	accountFailures, _ := guard.get(ctx, loginFailPrefix+accountKey("student@example.com"))
+	assert.Equal(t, int64(1), accountFailures)
+}
@@ -0,0 +1,573 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"gorm.io/gorm"
+)
+
+// LTI errors
+var (
+	ErrUnknownLTIPlatform = errors.New("unknown LTI platform")
+	ErrInvalidLTILaunch   = errors.New("invalid LTI launch")
+	ErrLTIEmailMissing    = errors.New("the platform didn't share the user's email address")
+	ErrLTICourseNotFound  = errors.New("the launched course doesn't exist in the platform's organization")
+)
+
+// LTI message and scope values
+const (
+	ltiVersion             = "1.3.0"
+	ltiResourceLinkRequest = "LtiResourceLinkRequest"
+	LTIScopeScore          = "https://purl.imsglobal.org/spec/lti-ags/scope/score"
+)
+
+// ltiAssertionType is the client assertion type of the tool's token requests
+const ltiAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// LTILoginRequest is the third-party login initiation a platform starts a launch with
+type LTILoginRequest struct {
+	Issuer        string
+	LoginHint     string
+	TargetLinkURI string
+	MessageHint   string // lti_message_hint, passed back to the platform as it is
+	ClientID      string // Optional, needed when the tool is registered more than once at the platform
+	DeploymentID  string // Optional
+}
+
+// LTILaunch is a validated resource link launch
+type LTILaunch struct {
+	Platform          *models.LTIPlatform
+	Subject           string
+	Email             string
+	Name              string
+	Roles             []string
+	DeploymentID      string
+	ResourceLinkID    string
+	ResourceLinkTitle string
+	ContextID         string
+	TargetLinkURI     string
+	Custom            map[string]string
+	LineItemURL       string // Set when the platform takes scores for the link
+}
+
+// LTIProvisioning is what a launch set up: the user, the course the link launches and, for
+// learners, their enrollment
+type LTIProvisioning struct {
+	User       models.User
+	Course     models.Course
+	Enrollment *models.Enrollment // Nil for instructors
+	Enrolled   bool               // The launch created or reactivated the enrollment
+}
+
+// LTIScore is a score sent to a platform's line item with the Assignment and Grade Services
+type LTIScore struct {
+	UserID           string  `json:"userId"`
+	ScoreGiven       float64 `json:"scoreGiven"`
+	ScoreMaximum     float64 `json:"scoreMaximum"`
+	Comment          string  `json:"comment,omitempty"`
+	ActivityProgress string  `json:"activityProgress"`
+	GradingProgress  string  `json:"gradingProgress"`
+	Timestamp        string  `json:"timestamp"`
+}
+
+// ltiLaunchClaims are the claims of a launch's ID token we read
+type ltiLaunchClaims struct {
+	jwt.RegisteredClaims
+	Nonce           string   `json:"nonce"`
+	AuthorizedParty string   `json:"azp"`
+	Email           string   `json:"email"`
+	Name            string   `json:"name"`
+	GivenName       string   `json:"given_name"`
+	FamilyName      string   `json:"family_name"`
+	MessageType     string   `json:"https://purl.imsglobal.org/spec/lti/claim/message_type"`
+	Version         string   `json:"https://purl.imsglobal.org/spec/lti/claim/version"`
+	DeploymentID    string   `json:"https://purl.imsglobal.org/spec/lti/claim/deployment_id"`
+	TargetLinkURI   string   `json:"https://purl.imsglobal.org/spec/lti/claim/target_link_uri"`
+	Roles           []string `json:"https://purl.imsglobal.org/spec/lti/claim/roles"`
+	ResourceLink    struct {
+		ID    string `json:"id"`
+		Title string `json:"title"`
+	} `json:"https://purl.imsglobal.org/spec/lti/claim/resource_link"`
+	Context struct {
+		ID string `json:"id"`
+	} `json:"https://purl.imsglobal.org/spec/lti/claim/context"`
+	Custom      map[string]interface{} `json:"https://purl.imsglobal.org/spec/lti/claim/custom"`
+	AGSEndpoint struct {
+		Scope    []string `json:"scope"`
+		LineItem string   `json:"lineitem"`
+	} `json:"https://purl.imsglobal.org/spec/lti-ags/claim/endpoint"`
+}
+
+// ltiAccessToken is a cached access token of a platform
+type ltiAccessToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// LTIService lets registered LMS platforms launch courses with LTI 1.3 and sends grades back to
+// them with the Assignment and Grade Services. Launches are ID tokens signed by the platform; the
+// tool's own requests are signed with the access token keys, which platforms read from our JWKS.
+type LTIService struct {
+	db         *gorm.DB
+	keys       *KeySet
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	keySets map[string]*oidcKeySet    // Platform signing keys by JWKS URL
+	tokens  map[string]ltiAccessToken // Platform access tokens by platform and scope
+}
+
+// NewLTIService creates a new LTI service. A nil HTTP client means a default one with a timeout.
+func NewLTIService(db *gorm.DB, keys *KeySet, httpClient *http.Client) *LTIService {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &LTIService{
+		db:         db,
+		keys:       keys,
+		httpClient: httpClient,
+		keySets:    make(map[string]*oidcKeySet),
+		tokens:     make(map[string]ltiAccessToken),
+	}
+}
+
+// FindPlatform finds the registration of a platform by its issuer. Without a client ID the
+// issuer must have a single registration.
+func (s *LTIService) FindPlatform(issuer, clientID string) (*models.LTIPlatform, error) {
+	query := s.db.Where("issuer = ?", issuer)
+	if clientID != "" {
+		query = query.Where("client_id = ?", clientID)
+	}
+	var platforms []models.LTIPlatform
+	if err := query.Limit(2).Find(&platforms).Error; err != nil {
+		return nil, err
+	}
+	if len(platforms) != 1 {
+		return nil, ErrUnknownLTIPlatform
+	}
+	return &platforms[0], nil
+}
+
+// GetPlatform returns a platform registration
+func (s *LTIService) GetPlatform(id uuid.UUID) (*models.LTIPlatform, error) {
+	var platform models.LTIPlatform
+	if err := s.db.First(&platform, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUnknownLTIPlatform
+		}
+		return nil, err
+	}
+	return &platform, nil
+}
+
+// AuthRequestURL returns the platform URL that authenticates the user for a launch. The platform
+// posts the signed launch to redirectURI; state and nonce have to be kept until then.
+func (s *LTIService) AuthRequestURL(platform *models.LTIPlatform, login LTILoginRequest, redirectURI, state, nonce string) (string, error) {
+	authURL, err := url.Parse(platform.AuthLoginURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid login URL of LTI platform %s: %v", platform.ID, err)
+	}
+	query := authURL.Query()
+	query.Set("scope", "openid")
+	query.Set("response_type", "id_token")
+	query.Set("response_mode", "form_post")
+	query.Set("prompt", "none")
+	query.Set("client_id", platform.ClientID)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("login_hint", login.LoginHint)
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	if login.MessageHint != "" {
+		query.Set("lti_message_hint", login.MessageHint)
+	}
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+// ValidateLaunch checks a launch's ID token: its signature by the platform, issuer, audience,
+// expiry and nonce, and that it launches a resource link of a registered deployment
+func (s *LTIService) ValidateLaunch(ctx context.Context, platform *models.LTIPlatform, rawIDToken, nonce string) (*LTILaunch, error) {
+	var claims ltiLaunchClaims
+	_, err := jwt.ParseWithClaims(rawIDToken, &claims, s.keySet(platform.KeySetURL).Keyfunc,
+		jwt.WithValidMethods(idTokenAlgorithms),
+		jwt.WithIssuer(platform.Issuer),
+		jwt.WithAudience(platform.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLTILaunch, err)
+	}
+
+	switch {
+	case nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidLTILaunch)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != platform.ClientID:
+		return nil, fmt.Errorf("%w: issued to another party", ErrInvalidLTILaunch)
+	case claims.Subject == "":
+		// Anonymous launches can't be given an account
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidLTILaunch)
+	case claims.MessageType != ltiResourceLinkRequest:
+		return nil, fmt.Errorf("%w: unsupported message type %q", ErrInvalidLTILaunch, claims.MessageType)
+	case claims.Version != ltiVersion:
+		return nil, fmt.Errorf("%w: unsupported LTI version %q", ErrInvalidLTILaunch, claims.Version)
+	case !platform.HasDeployment(claims.DeploymentID):
+		return nil, fmt.Errorf("%w: unknown deployment %q", ErrInvalidLTILaunch, claims.DeploymentID)
+	case claims.ResourceLink.ID == "":
+		return nil, fmt.Errorf("%w: missing resource link", ErrInvalidLTILaunch)
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = strings.TrimSpace(claims.GivenName + " " + claims.FamilyName)
+	}
+	launch := &LTILaunch{
+		Platform:          platform,
+		Subject:           claims.Subject,
+		Email:             strings.ToLower(strings.TrimSpace(claims.Email)),
+		Name:              name,
+		Roles:             claims.Roles,
+		DeploymentID:      claims.DeploymentID,
+		ResourceLinkID:    claims.ResourceLink.ID,
+		ResourceLinkTitle: claims.ResourceLink.Title,
+		ContextID:         claims.Context.ID,
+		TargetLinkURI:     claims.TargetLinkURI,
+		Custom:            make(map[string]string, len(claims.Custom)),
+	}
+	for key, value := range claims.Custom {
+		launch.Custom[key] = fmt.Sprint(value)
+	}
+	// The tool can only send scores when the platform granted the score scope for the link
+	if claims.AGSEndpoint.LineItem != "" && contains(claims.AGSEndpoint.Scope, LTIScopeScore) {
+		launch.LineItemURL = claims.AGSEndpoint.LineItem
+	}
+	return launch, nil
+}
+
+// Provision sets up what a launch needs: the user of the platform account, created in the
+// platform's organization on their first launch, the resource link and, for learners, an active
+// enrollment in the course. The course comes from the link's course_id custom parameter, or from an
+// earlier launch of the link.
+func (s *LTIService) Provision(launch *LTILaunch) (*LTIProvisioning, error) {
+	var result LTIProvisioning
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		link, err := s.saveResourceLink(tx, launch)
+		if err != nil {
+			return err
+		}
+		if err := models.ForOrganization(tx, launch.Platform.OrganizationID).First(&result.Course, link.CourseID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrLTICourseNotFound
+			}
+			return err
+		}
+
+		if err := s.resolveUser(tx, launch, &result.User); err != nil {
+			return err
+		}
+		if models.RoleForLTIRoles(launch.Roles) != models.RoleStudent {
+			return nil
+		}
+		return enrollLearner(tx, &result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// saveResourceLink creates or updates the resource link of a launch
+func (s *LTIService) saveResourceLink(tx *gorm.DB, launch *LTILaunch) (*models.LTIResourceLink, error) {
+	var link models.LTIResourceLink
+	err := tx.Where("platform_id = ? AND resource_link_id = ?", launch.Platform.ID, launch.ResourceLinkID).First(&link).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		link = models.LTIResourceLink{PlatformID: launch.Platform.ID, ResourceLinkID: launch.ResourceLinkID}
+	}
+
+	if courseID, ok := launch.Custom["course_id"]; ok {
+		id, err := strconv.Atoi(courseID)
+		if err != nil {
+			return nil, ErrLTICourseNotFound
+		}
+		link.CourseID = id
+	}
+	if link.CourseID == 0 {
+		return nil, ErrLTICourseNotFound
+	}
+	link.ContextID = launch.ContextID
+	link.Title = launch.ResourceLinkTitle
+	if launch.LineItemURL != "" {
+		link.LineItemURL = launch.LineItemURL
+	}
+	if err := tx.Save(&link).Error; err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// resolveUser finds the user linked to the platform account, creating one on the first launch.
+// Like provider logins, an existing account with the same email isn't taken over.
+func (s *LTIService) resolveUser(tx *gorm.DB, launch *LTILaunch, user *models.User) error {
+	identity := &OIDCIdentity{
+		Provider: launch.Platform.IdentityProvider(),
+		Subject:  launch.Subject,
+		Email:    launch.Email,
+		Name:     launch.Name,
+	}
+
+	var linked models.UserIdentity
+	err := tx.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&linked).Error
+	if err == nil {
+		if err := tx.First(user, "id = ?", linked.UserID).Error; err != nil {
+			return err
+		}
+		return tx.Model(&linked).Updates(map[string]interface{}{
+			"email":         identity.Email,
+			"last_login_at": time.Now(),
+		}).Error
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if identity.Email == "" {
+		return ErrLTIEmailMissing
+	}
+	var existing int64
+	if err := tx.Model(&models.User{}).Where("LOWER(email) = LOWER(?)", identity.Email).Count(&existing).Error; err != nil {
+		return err
+	}
+	if existing > 0 {
+		return ErrAccountExists
+	}
+
+	name := identity.Name
+	if name == "" {
+		name = identity.Email
+	}
+	*user = models.User{
+		ID:             uuid.New(),
+		Email:          identity.Email,
+		Name:           name,
+		Role:           models.RoleForLTIRoles(launch.Roles),
+		OrganizationID: launch.Platform.OrganizationID,
+	}
+	// The platform is registered by an admin and vouches for its users' addresses
+	user.MarkEmailVerified(time.Now())
+	if err := tx.Create(user).Error; err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	return createIdentity(tx, user.ID, identity)
+}
+
+// enrollLearner makes sure the learner has an active enrollment in the course. The platform
+// decides who takes the course, so dropped and expired enrollments are started again.
+func enrollLearner(tx *gorm.DB, result *LTIProvisioning) error {
+	var enrollment models.Enrollment
+	err := tx.Where("user_id = ? AND course_id = ?", result.User.ID, result.Course.ID).First(&enrollment).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		enrollment = models.Enrollment{
+			UserID:   result.User.ID,
+			CourseID: result.Course.ID,
+			Status:   models.EnrollmentStatusActive,
+		}
+		enrollment.StartAccessWindow(result.Course)
+		if err := tx.Create(&enrollment).Error; err != nil {
+			return err
+		}
+		result.Enrolled = true
+	case err != nil:
+		return err
+	case enrollment.Status == models.EnrollmentStatusDropped || enrollment.IsExpired(time.Now()):
+		enrollment.Status = models.EnrollmentStatusActive
+		enrollment.EnrolledAt = time.Now()
+		enrollment.StartAccessWindow(result.Course)
+		if err := tx.Save(&enrollment).Error; err != nil {
+			return err
+		}
+		result.Enrolled = true
+	}
+	result.Enrollment = &enrollment
+	return nil
+}
+
+// SendGrade sends an enrollment's grade, out of 100, to the line items of the platforms the
+// student launched the course from. Students who didn't come from a platform are skipped.
+func (s *LTIService) SendGrade(ctx context.Context, enrollment models.Enrollment) error {
+	if enrollment.Grade == nil {
+		return nil
+	}
+
+	var links []models.LTIResourceLink
+	if err := s.db.Where("course_id = ? AND line_item_url <> ''", enrollment.CourseID).Find(&links).Error; err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, link := range links {
+		platform, err := s.GetPlatform(link.PlatformID)
+		if err != nil {
+			continue
+		}
+		var identity models.UserIdentity
+		err = s.db.Where("user_id = ? AND provider = ?", enrollment.UserID, platform.IdentityProvider()).First(&identity).Error
+		if err != nil {
+			continue
+		}
+
+		score := LTIScore{
+			UserID:           identity.Subject,
+			ScoreGiven:       float64(*enrollment.Grade),
+			ScoreMaximum:     100,
+			ActivityProgress: "Completed",
+			GradingProgress:  "FullyGraded",
+			Timestamp:        time.Now().UTC().Format(time.RFC3339Nano),
+		}
+		if err := s.PublishScore(ctx, platform, link.LineItemURL, score); err != nil {
+			errs = append(errs, fmt.Errorf("line item %s: %w", link.LineItemURL, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PublishScore posts a score to a line item of the platform
+func (s *LTIService) PublishScore(ctx context.Context, platform *models.LTIPlatform, lineItemURL string, score LTIScore) error {
+	scoresURL, err := url.Parse(lineItemURL)
+	if err != nil {
+		return fmt.Errorf("invalid line item URL: %v", err)
+	}
+	// The line item URL may have a query, the scores endpoint is below its path
+	scoresURL.Path = strings.TrimSuffix(scoresURL.Path, "/") + "/scores"
+
+	token, err := s.accessToken(ctx, platform, LTIScopeScore)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(score)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, scoresURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/vnd.ims.lis.v1.score+json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		// The platform may have revoked the token, get a new one next time
+		s.mu.Lock()
+		delete(s.tokens, platform.ID.String()+" "+LTIScopeScore)
+		s.mu.Unlock()
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("platform returned %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// accessToken returns an access token of the platform for a scope, requesting a new one with a
+// signed client assertion when there's none cached
+func (s *LTIService) accessToken(ctx context.Context, platform *models.LTIPlatform, scope string) (string, error) {
+	if platform.AuthTokenURL == "" {
+		return "", fmt.Errorf("LTI platform %s has no token URL", platform.ID)
+	}
+
+	cacheKey := platform.ID.String() + " " + scope
+	s.mu.Lock()
+	cached, ok := s.tokens[cacheKey]
+	s.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.token, nil
+	}
+
+	now := time.Now()
+	assertion, err := s.keys.Sign(jwt.RegisteredClaims{
+		Issuer:    platform.ClientID,
+		Subject:   platform.ClientID,
+		Audience:  jwt.ClaimStrings{platform.AuthTokenURL},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		ID:        uuid.NewString(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to sign client assertion: %v", err)
+	}
+
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_assertion_type": {ltiAssertionType},
+		"client_assertion":      {assertion},
+		"scope":                 {scope},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, platform.AuthTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request an access token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %d", resp.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil || token.AccessToken == "" {
+		return "", errors.New("token endpoint returned no access token")
+	}
+
+	// Renew a little early so a token doesn't expire on its way to the platform
+	lifetime := time.Duration(token.ExpiresIn)*time.Second - 30*time.Second
+	if lifetime > 0 {
+		s.mu.Lock()
+		s.tokens[cacheKey] = ltiAccessToken{token: token.AccessToken, expiresAt: now.Add(lifetime)}
+		s.mu.Unlock()
+	}
+	return token.AccessToken, nil
+}
+
+// keySet returns the cached signing keys of a platform's JWKS
+func (s *LTIService) keySet(url string) *oidcKeySet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys, ok := s.keySets[url]
+	if !ok {
+		keys = newOIDCKeySet(url, s.httpClient)
+		s.keySets[url] = keys
+	}
+	return keys
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services/ltitest"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestLTIPlatform starts a simulated platform, registers it and creates a service whose
+// client assertions the platform verifies
+func newTestLTIPlatform(t *testing.T) (*ltitest.Platform, *models.LTIPlatform, *LTIService) {
+	server := ltitest.NewPlatform("learnvibe-tool", "deployment-1")
+	t.Cleanup(server.Close)
+
+	key, err := GenerateSigningKey("tool-key", claims.AlgorithmRS256)
+	require.NoError(t, err)
+	keys := NewKeySet(key)
+	server.ToolKeyfunc = keys.Keyfunc
+
+	platform := &models.LTIPlatform{
+		ID:            uuid.New(),
+		Issuer:        server.Issuer(),
+		ClientID:      server.ClientID,
+		DeploymentIDs: []string{server.DeploymentID},
+		AuthLoginURL:  server.AuthLoginURL(),
+		AuthTokenURL:  server.AuthTokenURL(),
+		KeySetURL:     server.KeySetURL(),
+	}
+	return server, platform, NewLTIService(nil, keys, server.Client())
+}
+
+// testLaunch is a learner launching a course with grades
+var testLaunch = ltitest.Launch{
+	User:           ltitest.User{Subject: "moodle-user-7", Email: "Learner@Example.com", Name: "Lea Learner"},
+	ResourceLinkID: "link-1",
+	ContextID:      "course-101",
+	Custom:         map[string]string{"course_id": "42"},
+	Grades:         true,
+}
+
+func TestLTIAuthRequestURL(t *testing.T) {
+	_, platform, service := newTestLTIPlatform(t)
+
+	authURL, err := service.AuthRequestURL(platform, LTILoginRequest{LoginHint: "moodle-user-7", MessageHint: "hint-1"},
+		"http://localhost:8080/lti/launch", "state-123", "nonce-456")
+	require.NoError(t, err)
+	parsed, _ := url.Parse(authURL)
+	query := parsed.Query()
+	assert.Equal(t, platform.AuthLoginURL, parsed.Scheme+"://"+parsed.Host+parsed.Path)
+	assert.Equal(t, "openid", query.Get("scope"))
+	assert.Equal(t, "id_token", query.Get("response_type"))
+	assert.Equal(t, "form_post", query.Get("response_mode"))
+	assert.Equal(t, "none", query.Get("prompt"))
+	assert.Equal(t, "learnvibe-tool", query.Get("client_id"))
+	assert.Equal(t, "http://localhost:8080/lti/launch", query.Get("redirect_uri"))
+	assert.Equal(t, "moodle-user-7", query.Get("login_hint"))
+	assert.Equal(t, "hint-1", query.Get("lti_message_hint"))
+	assert.Equal(t, "state-123", query.Get("state"))
+	assert.Equal(t, "nonce-456", query.Get("nonce"))
+}
+
+func TestLTIValidateLaunch(t *testing.T) {
+	server, platform, service := newTestLTIPlatform(t)
+
+	launch, err := service.ValidateLaunch(context.Background(), platform, server.IDToken("nonce-1", testLaunch), "nonce-1")
+	require.NoError(t, err)
+	assert.Equal(t, "moodle-user-7", launch.Subject)
+	assert.Equal(t, "learner@example.com", launch.Email)
+	assert.Equal(t, "Lea Learner", launch.Name)
+	assert.Equal(t, []string{ltitest.RoleLearner}, launch.Roles)
+	assert.Equal(t, "link-1", launch.ResourceLinkID)
+	assert.Equal(t, "course-101", launch.ContextID)
+	assert.Equal(t, "42", launch.Custom["course_id"])
+	assert.Equal(t, server.LineItemURL("link-1"), launch.LineItemURL)
+}
+
+func TestLTIValidateLaunchRejectsInvalidLaunches(t *testing.T) {
+	server, platform, service := newTestLTIPlatform(t)
+	other := ltitest.NewPlatform("learnvibe-tool", "deployment-1")
+	defer other.Close()
+
+	tests := []struct {
+		name   string
+		token  func() string
+		nonce  string
+		modify func(claims jwt.MapClaims)
+	}{
+		{"wrong nonce", nil, "other-nonce", nil},
+		{"signed by another platform", func() string { return other.IDToken("nonce-1", testLaunch) }, "nonce-1", nil},
+		{"wrong issuer", nil, "nonce-1", func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" }},
+		{"other audience", nil, "nonce-1", func(claims jwt.MapClaims) { claims["aud"] = "another-tool" }},
+		{"issued to another party", nil, "nonce-1", func(claims jwt.MapClaims) {
+			claims["aud"] = []string{"learnvibe-tool", "another-tool"}
+			claims["azp"] = "another-tool"
+		}},
+		{"expired", nil, "nonce-1", func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"anonymous", nil, "nonce-1", func(claims jwt.MapClaims) { delete(claims, "sub") }},
+		{"unknown deployment", nil, "nonce-1", func(claims jwt.MapClaims) {
+			claims["https://purl.imsglobal.org/spec/lti/claim/deployment_id"] = "deployment-2"
+		}},
+		{"deep linking", nil, "nonce-1", func(claims jwt.MapClaims) {
+			claims["https://purl.imsglobal.org/spec/lti/claim/message_type"] = "LtiDeepLinkingRequest"
+		}},
+		{"LTI 1.1", nil, "nonce-1", func(claims jwt.MapClaims) {
+			claims["https://purl.imsglobal.org/spec/lti/claim/version"] = "1.1"
+		}},
+		{"no resource link", nil, "nonce-1", func(claims jwt.MapClaims) {
+			delete(claims, "https://purl.imsglobal.org/spec/lti/claim/resource_link")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.ModifyClaims = tt.modify
+			defer func() { server.ModifyClaims = nil }()
+
+			token := server.IDToken("nonce-1", testLaunch)
+			if tt.token != nil {
+				token = tt.token()
+			}
+			_, err := service.ValidateLaunch(context.Background(), platform, token, tt.nonce)
+			assert.ErrorIs(t, err, ErrInvalidLTILaunch)
+		})
+	}
+}
+
+func TestLTILaunchWithoutScoreScopeHasNoLineItem(t *testing.T) {
+	server, platform, service := newTestLTIPlatform(t)
+	server.ModifyClaims = func(claims jwt.MapClaims) {
+		claims["https://purl.imsglobal.org/spec/lti-ags/claim/endpoint"] = map[string]interface{}{
+			"scope":    []string{"https://purl.imsglobal.org/spec/lti-ags/scope/lineitem.readonly"},
+			"lineitem": server.LineItemURL("link-1"),
+		}
+	}
+
+	launch, err := service.ValidateLaunch(context.Background(), platform, server.IDToken("nonce-1", testLaunch), "nonce-1")
+	require.NoError(t, err)
+	assert.Empty(t, launch.LineItemURL)
+}
+
+func TestLTIPublishScore(t *testing.T) {
+	server, platform, service := newTestLTIPlatform(t)
+	ctx := context.Background()
+
+	for _, grade := range []float64{72.5, 88} {
+		err := service.PublishScore(ctx, platform, server.LineItemURL("link-1"), LTIScore{
+			UserID:           "moodle-user-7",
+			ScoreGiven:       grade,
+			ScoreMaximum:     100,
+			ActivityProgress: "Completed",
+			GradingProgress:  "FullyGraded",
+			Timestamp:        time.Now().UTC().Format(time.RFC3339Nano),
+		})
+		require.NoError(t, err)
+	}
+
+	scores := server.Scores()
+	require.Len(t, scores, 2)
+	assert.Equal(t, server.LineItemURL("link-1"), scores[0].LineItem)
+	assert.Equal(t, "moodle-user-7", scores[0].UserID)
+	assert.Equal(t, 72.5, scores[0].ScoreGiven)
+	assert.Equal(t, float64(100), scores[0].ScoreMaximum)
+	assert.Equal(t, "FullyGraded", scores[1].GradingProgress)
+	assert.Equal(t, float64(88), scores[1].ScoreGiven)
+}
+
+func TestLTIPublishScoreRejectedWithUnknownToolKey(t *testing.T) {
+	server, platform, service := newTestLTIPlatform(t)
+	// The platform only trusts keys it read from the tool's JWKS
+	stranger, err := GenerateSigningKey("stranger", claims.AlgorithmRS256)
+	require.NoError(t, err)
+	server.ToolKeyfunc = NewKeySet(stranger).Keyfunc
+
+	err = service.PublishScore(context.Background(), platform, server.LineItemURL("link-1"), LTIScore{UserID: "moodle-user-7"})
+	assert.Error(t, err)
+	assert.Empty(t, server.Scores())
+}
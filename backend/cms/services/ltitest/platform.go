@@ -0,0 +1,400 @@
+// Package ltitest provides a simulated LTI 1.3 platform for tests and local development. It starts
+// launches the way an LMS does, authenticates its user right away and posts the signed launch to the
+// tool, serves its JWKS, grants the tool access tokens for its signed client assertions and records
+// the scores the tool sends to its line items.
+package ltitest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// LTI role URIs
+const (
+	RoleLearner    = "http://purl.imsglobal.org/vocab/lis/v2/membership#Learner"
+	RoleInstructor = "http://purl.imsglobal.org/vocab/lis/v2/membership#Instructor"
+)
+
+// scoreScope is the Assignment and Grade Services scope for posting scores
+const scoreScope = "https://purl.imsglobal.org/spec/lti-ags/scope/score"
+
+// User is who the platform launches
+type User struct {
+	Subject string
+	Email   string
+	Name    string
+}
+
+// Launch describes a resource link being launched
+type Launch struct {
+	User           User
+	ResourceLinkID string
+	ContextID      string
+	Roles          []string          // Defaults to learner
+	Custom         map[string]string // Custom parameters of the link, e.g. course_id
+	Grades         bool              // The link has a line item the tool may post scores to
+}
+
+// Score is a score the tool posted to a line item
+type Score struct {
+	LineItem         string  `json:"-"`
+	UserID           string  `json:"userId"`
+	ScoreGiven       float64 `json:"scoreGiven"`
+	ScoreMaximum     float64 `json:"scoreMaximum"`
+	Comment          string  `json:"comment"`
+	ActivityProgress string  `json:"activityProgress"`
+	GradingProgress  string  `json:"gradingProgress"`
+	Timestamp        string  `json:"timestamp"`
+}
+
+// Platform is a simulated LTI platform
+type Platform struct {
+	*httptest.Server
+	ClientID     string
+	DeploymentID string
+
+	// ToolKeyfunc verifies the tool's client assertions. Without it their signature isn't checked.
+	ToolKeyfunc jwt.Keyfunc
+	// ModifyClaims, if set, can change the launch claims before they are signed
+	ModifyClaims func(claims jwt.MapClaims)
+
+	key   *rsa.PrivateKey
+	keyID string
+
+	mu       sync.Mutex
+	launches map[string]Launch // Pending launches by lti_message_hint
+	tokens   map[string]bool   // Issued access tokens
+	scores   []Score
+}
+
+// NewPlatform starts a simulated platform with the tool registered under a client ID and a deployment
+func NewPlatform(clientID, deploymentID string) *Platform {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic("ltitest: failed to generate key: " + err.Error())
+	}
+
+	p := &Platform{
+		ClientID:     clientID,
+		DeploymentID: deploymentID,
+		key:          key,
+		keyID:        "platform-key",
+		launches:     make(map[string]Launch),
+		tokens:       make(map[string]bool),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jwks", p.handleJWKS)
+	mux.HandleFunc("/auth", p.handleAuth)
+	mux.HandleFunc("/token", p.handleToken)
+	mux.HandleFunc("/lineitems/", p.handleScores)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// Issuer returns the issuer to register the platform with
+func (p *Platform) Issuer() string {
+	return p.URL
+}
+
+// AuthLoginURL returns the platform's OIDC authorization endpoint
+func (p *Platform) AuthLoginURL() string {
+	return p.URL + "/auth"
+}
+
+// AuthTokenURL returns the platform's OAuth2 token endpoint
+func (p *Platform) AuthTokenURL() string {
+	return p.URL + "/token"
+}
+
+// KeySetURL returns the platform's JWKS URL
+func (p *Platform) KeySetURL() string {
+	return p.URL + "/jwks"
+}
+
+// LineItemURL returns the line item of a resource link
+func (p *Platform) LineItemURL(resourceLinkID string) string {
+	return p.URL + "/lineitems/" + url.PathEscape(resourceLinkID)
+}
+
+// Scores returns the scores the tool posted so far
+func (p *Platform) Scores() []Score {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Score(nil), p.scores...)
+}
+
+// LoginInitiationURL returns the tool URL the platform sends the browser to for a launch, i.e. the
+// third-party initiated login with the launch remembered under the message hint
+func (p *Platform) LoginInitiationURL(toolLoginURL, targetLinkURI string, launch Launch) string {
+	hint := randomString()
+	p.mu.Lock()
+	p.launches[hint] = launch
+	p.mu.Unlock()
+
+	query := url.Values{
+		"iss":               {p.Issuer()},
+		"login_hint":        {launch.User.Subject},
+		"target_link_uri":   {targetLinkURI},
+		"lti_message_hint":  {hint},
+		"client_id":         {p.ClientID},
+		"lti_deployment_id": {p.DeploymentID},
+	}
+	return toolLoginURL + "?" + query.Encode()
+}
+
+// Launch runs a launch the way a browser would: it starts the login at the tool, follows the
+// redirect to the platform and posts the signed launch back to the tool. The tool's response to
+// the launch is returned without following its redirects.
+func (p *Platform) Launch(client *http.Client, toolLoginURL, targetLinkURI string, launch Launch) (*http.Response, error) {
+	noRedirects := *client
+	noRedirects.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	resp, err := noRedirects.Get(p.LoginInitiationURL(toolLoginURL, targetLinkURI, launch))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound && resp.StatusCode != http.StatusSeeOther && resp.StatusCode != http.StatusTemporaryRedirect {
+		return nil, fmt.Errorf("tool answered the login initiation with %d", resp.StatusCode)
+	}
+
+	resp, err = noRedirects.Get(resp.Header.Get("Location"))
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("platform refused the authentication request: %s", strings.TrimSpace(string(body)))
+	}
+
+	action, values, err := parseFormPost(string(body))
+	if err != nil {
+		return nil, err
+	}
+	return noRedirects.PostForm(action, values)
+}
+
+// IDToken signs the launch message of a resource link, as the authorization endpoint does
+func (p *Platform) IDToken(nonce string, launch Launch) string {
+	roles := launch.Roles
+	if len(roles) == 0 {
+		roles = []string{RoleLearner}
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   p.Issuer(),
+		"sub":   launch.User.Subject,
+		"aud":   p.ClientID,
+		"azp":   p.ClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": nonce,
+		"email": launch.User.Email,
+		"name":  launch.User.Name,
+		"https://purl.imsglobal.org/spec/lti/claim/message_type":  "LtiResourceLinkRequest",
+		"https://purl.imsglobal.org/spec/lti/claim/version":       "1.3.0",
+		"https://purl.imsglobal.org/spec/lti/claim/deployment_id": p.DeploymentID,
+		"https://purl.imsglobal.org/spec/lti/claim/roles":         roles,
+		"https://purl.imsglobal.org/spec/lti/claim/resource_link": map[string]string{"id": launch.ResourceLinkID, "title": "LearnVibe course"},
+		"https://purl.imsglobal.org/spec/lti/claim/context":       map[string]string{"id": launch.ContextID},
+		"https://purl.imsglobal.org/spec/lti/claim/custom":        launch.Custom,
+	}
+	if launch.Grades {
+		claims["https://purl.imsglobal.org/spec/lti-ags/claim/endpoint"] = map[string]interface{}{
+			"scope":    []string{scoreScope},
+			"lineitem": p.LineItemURL(launch.ResourceLinkID),
+		}
+	}
+	if p.ModifyClaims != nil {
+		p.ModifyClaims(claims)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.keyID
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		panic("ltitest: failed to sign launch: " + err.Error())
+	}
+	return signed
+}
+
+// handleJWKS serves the public signing key
+func (p *Platform) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": p.keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+// handleAuth authenticates the launch's user without asking and answers with a form that posts
+// the signed launch to the tool
+func (p *Platform) handleAuth(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("scope") != "openid" || query.Get("response_type") != "id_token" ||
+		query.Get("response_mode") != "form_post" || query.Get("prompt") != "none" {
+		http.Error(w, "invalid authentication request", http.StatusBadRequest)
+		return
+	}
+	if query.Get("client_id") != p.ClientID || query.Get("nonce") == "" || query.Get("redirect_uri") == "" {
+		http.Error(w, "invalid authentication request", http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	launch, ok := p.launches[query.Get("lti_message_hint")]
+	delete(p.launches, query.Get("lti_message_hint"))
+	p.mu.Unlock()
+	if !ok || query.Get("login_hint") != launch.User.Subject {
+		http.Error(w, "unknown launch", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html><body onload="document.forms[0].submit()">
+<form method="post" action="%s">
+<input type="hidden" name="id_token" value="%s">
+<input type="hidden" name="state" value="%s">
+</form>
+</body></html>
+`, html.EscapeString(query.Get("redirect_uri")), html.EscapeString(p.IDToken(query.Get("nonce"), launch)),
+		html.EscapeString(query.Get("state")))
+}
+
+// handleToken grants an access token for a client assertion signed by the tool
+func (p *Platform) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	if r.PostForm.Get("client_assertion_type") != "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithIssuer(p.ClientID),
+		jwt.WithSubject(p.ClientID),
+		jwt.WithAudience(p.AuthTokenURL()),
+		jwt.WithExpirationRequired(),
+	}
+	var err error
+	if p.ToolKeyfunc != nil {
+		_, err = jwt.Parse(r.PostForm.Get("client_assertion"), p.ToolKeyfunc, options...)
+	} else {
+		_, _, err = jwt.NewParser().ParseUnverified(r.PostForm.Get("client_assertion"), jwt.MapClaims{})
+	}
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client", "error_description": err.Error()})
+		return
+	}
+	if r.PostForm.Get("scope") != scoreScope {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_scope"})
+		return
+	}
+
+	token := randomString()
+	p.mu.Lock()
+	p.tokens[token] = true
+	p.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"scope":        scoreScope,
+	})
+}
+
+// handleScores records a score posted to a line item
+func (p *Platform) handleScores(w http.ResponseWriter, r *http.Request) {
+	lineItem := strings.TrimSuffix(r.URL.Path, "/scores")
+	if r.Method != http.MethodPost || lineItem == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+
+	p.mu.Lock()
+	authorized := p.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	p.mu.Unlock()
+	if !authorized {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/vnd.ims.lis.v1.score+json" {
+		writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "unsupported content type"})
+		return
+	}
+
+	var score Score
+	if err := json.NewDecoder(r.Body).Decode(&score); err != nil || score.UserID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid score"})
+		return
+	}
+	score.LineItem = p.URL + lineItem
+
+	p.mu.Lock()
+	p.scores = append(p.scores, score)
+	p.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var (
+	formActionPattern = regexp.MustCompile(`<form method="post" action="([^"]*)">`)
+	formInputPattern  = regexp.MustCompile(`<input type="hidden" name="([^"]*)" value="([^"]*)">`)
+)
+
+// parseFormPost reads the auto-submitting form of the authorization endpoint
+func parseFormPost(body string) (string, url.Values, error) {
+	action := formActionPattern.FindStringSubmatch(body)
+	if action == nil {
+		return "", nil, errors.New("platform didn't answer with a launch form")
+	}
+	values := url.Values{}
+	for _, input := range formInputPattern.FindAllStringSubmatch(body, -1) {
+		values.Set(html.UnescapeString(input[1]), html.UnescapeString(input[2]))
+	}
+	return html.UnescapeString(action[1]), values, nil
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// randomString returns a random URL-safe string
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("ltitest: failed to generate random string: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
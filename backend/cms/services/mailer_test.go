@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestOutboxMailer tests that emails are written to the outbox directory instead of being sent
+func TestOutboxMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	mailer := NewOutboxMailer(dir, "LearnVibe <no-reply@learnvibe.local>")
+
+	err := mailer.Send(context.Background(), Email{
+		To:      "student@example.com",
+		Subject: "Verify your LearnVibe email address",
+		Body:    "Hi,\n\nhttp://localhost:8000/auth/verify-email?token=abc\n",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mailer.Send(context.Background(), Email{To: "other@example.com", Subject: "Second"}))
+
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	var first string
+	for _, file := range files {
+		assert.True(t, strings.HasSuffix(file.Name(), ".eml"))
+		content, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		assert.NoError(t, err)
+		if strings.Contains(string(content), "To: student@example.com\r\n") {
+			first = string(content)
+		}
+	}
+	assert.Contains(t, first, "From: LearnVibe <no-reply@learnvibe.local>\r\n")
+	assert.Contains(t, first, "Subject: Verify your LearnVibe email address\r\n")
+	assert.Contains(t, first, "\r\n\r\nHi,\r\n\r\nhttp://localhost:8000/auth/verify-email?token=abc\r\n")
+}
+
+// TestFormatTTL tests how link lifetimes are written in emails
+func TestFormatTTL(t *testing.T) {
+	assert.Equal(t, "48 hours", formatTTL(48*time.Hour))
+	assert.Equal(t, "1 hour", formatTTL(time.Hour))
+	assert.Equal(t, "30 minutes", formatTTL(30*time.Minute))
+	assert.Equal(t, "90 minutes", formatTTL(90*time.Minute))
+}
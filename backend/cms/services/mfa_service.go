@@ -0,0 +1,390 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MFA errors
+var (
+	ErrInvalidMFAChallenge = errors.New("invalid or expired MFA challenge")
+	ErrInvalidMFACode      = errors.New("invalid MFA code")
+	ErrMFAAlreadyEnabled   = errors.New("MFA is already enabled")
+	ErrMFANotEnabled       = errors.New("MFA is not enabled")
+	ErrMFASetupNotStarted  = errors.New("MFA setup was not started")
+	ErrMFARequiredByPolicy = errors.New("MFA is mandatory for this role")
+)
+
+const (
+	// maxMFAAttempts is how many wrong codes a challenge survives
+	maxMFAAttempts = 5
+	// recoveryCodeCount is how many recovery codes a user gets
+	recoveryCodeCount = 10
+)
+
+// recoveryCodeAlphabet avoids characters that are easy to mix up
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// MFAService handles TOTP two-factor authentication: setup, recovery codes, login challenges and
+// the per-role policy that makes it mandatory
+type MFAService struct {
+	db           *gorm.DB
+	issuer       string
+	challengeTTL time.Duration
+}
+
+// NewMFAService creates a new MFA service. issuer is the account name shown in authenticator apps.
+func NewMFAService(db *gorm.DB, issuer string, challengeTTL time.Duration) *MFAService {
+	return &MFAService{db: db, issuer: issuer, challengeTTL: challengeTTL}
+}
+
+// ChallengePurpose says what a user with a correct password still has to do before getting tokens:
+// nothing (""), enter a code, or set up MFA because their role requires it
+func (s *MFAService) ChallengePurpose(user models.User) (models.MFAChallengePurpose, error) {
+	if user.MFAEnabled {
+		return models.MFAChallengeVerify, nil
+	}
+
+	required, err := s.IsRequired(user.Role)
+	if err != nil {
+		return "", err
+	}
+	if required {
+		return models.MFAChallengeEnroll, nil
+	}
+	return "", nil
+}
+
+// CreateChallenge issues a short-lived challenge token for the second login step
+func (s *MFAService) CreateChallenge(user models.User, purpose models.MFAChallengePurpose) (string, time.Time, error) {
+	rawToken, err := GenerateOpaqueToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	challenge := models.MFAChallenge{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: HashToken(rawToken),
+		ExpiresAt: time.Now().Add(s.challengeTTL),
+	}
+	if err := s.db.Create(&challenge).Error; err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to store MFA challenge: %w", err)
+	}
+	return rawToken, challenge.ExpiresAt, nil
+}
+
+// ChallengeUser returns the user of a challenge that is still open
+func (s *MFAService) ChallengeUser(rawToken string, purpose models.MFAChallengePurpose) (*models.User, error) {
+	_, user, err := s.openChallenge(rawToken, purpose)
+	return user, err
+}
+
+// UseChallenge marks an enroll challenge as used once the user finished setting up MFA
+func (s *MFAService) UseChallenge(rawToken string) error {
+	challenge, _, err := s.openChallenge(rawToken, models.MFAChallengeEnroll)
+	if err != nil {
+		return err
+	}
+	return s.useChallenge(challenge)
+}
+
+// CompleteChallenge checks the code entered for a verify challenge and uses the challenge up.
+// code may be a TOTP code or a recovery code.
+func (s *MFAService) CompleteChallenge(rawToken, code string) (*models.User, error) {
+	challenge, user, err := s.openChallenge(rawToken, models.MFAChallengeVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.VerifyCode(user, code); err != nil {
+		s.failAttempt(challenge)
+		return nil, err
+	}
+	if err := s.useChallenge(challenge); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// BeginSetup generates a new TOTP secret for the user and returns it with its provisioning URI.
+// MFA stays disabled until Enable confirms the user's authenticator produces valid codes.
+func (s *MFAService) BeginSetup(user *models.User) (string, string, error) {
+	if user.MFAEnabled {
+		return "", "", ErrMFAAlreadyEnabled
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.db.Model(user).Updates(map[string]interface{}{"mfa_secret": secret, "mfa_last_step": 0}).Error; err != nil {
+		return "", "", fmt.Errorf("failed to store MFA secret: %w", err)
+	}
+	user.MFASecret = secret
+	user.MFALastStep = 0
+
+	return secret, TOTPProvisioningURI(s.issuer, user.Email, secret), nil
+}
+
+// Enable turns MFA on once the user entered a valid code for the secret from BeginSetup, and
+// returns a fresh set of recovery codes
+func (s *MFAService) Enable(user *models.User, code string) ([]string, error) {
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.MFASecret == "" {
+		return nil, ErrMFASetupNotStarted
+	}
+	if err := s.verifyTOTP(user, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("mfa_enabled", true).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to enable MFA: %w", err)
+	}
+	user.MFAEnabled = true
+	return codes, nil
+}
+
+// Disable turns MFA off after checking a code, unless the user's role requires it
+func (s *MFAService) Disable(user *models.User, code string) error {
+	if !user.MFAEnabled {
+		return ErrMFANotEnabled
+	}
+	required, err := s.IsRequired(user.Role)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrMFARequiredByPolicy
+	}
+	if err := s.VerifyCode(user, code); err != nil {
+		return err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(user).Updates(map[string]interface{}{
+			"mfa_enabled":   false,
+			"mfa_secret":    "",
+			"mfa_last_step": 0,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to disable MFA: %w", err)
+	}
+	user.MFAEnabled = false
+	user.MFASecret = ""
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking a code
+func (s *MFAService) RegenerateRecoveryCodes(user *models.User, code string) ([]string, error) {
+	if !user.MFAEnabled {
+		return nil, ErrMFANotEnabled
+	}
+	if err := s.VerifyCode(user, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// VerifyCode checks a TOTP code or, failing that, redeems a recovery code
+func (s *MFAService) VerifyCode(user *models.User, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		return s.verifyTOTP(user, code)
+	}
+
+	result := s.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, HashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// IsRequired checks if the policy makes MFA mandatory for a role
+func (s *MFAService) IsRequired(role models.Role) (bool, error) {
+	var policy models.MFAPolicy
+	err := s.db.Where("role = ?", role).First(&policy).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return policy.Required, nil
+}
+
+// Policies returns the MFA policy of every role, defaulting to optional
+func (s *MFAService) Policies() ([]models.MFAPolicy, error) {
+	var stored []models.MFAPolicy
+	if err := s.db.Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	byRole := make(map[models.Role]models.MFAPolicy, len(stored))
+	for _, policy := range stored {
+		byRole[policy.Role] = policy
+	}
+
+	policies := make([]models.MFAPolicy, 0, 4)
+	for _, role := range []models.Role{models.RoleStudent, models.RoleInstructor, models.RoleOrgAdmin, models.RoleAdmin} {
+		policy, ok := byRole[role]
+		if !ok {
+			policy = models.MFAPolicy{Role: role}
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// SetPolicy makes MFA mandatory or optional for a role
+func (s *MFAService) SetPolicy(role models.Role, required bool, adminID uuid.UUID) (*models.MFAPolicy, error) {
+	policy := models.MFAPolicy{Role: role, Required: required, UpdatedByID: &adminID, UpdatedAt: time.Now()}
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "role"}},
+		DoUpdates: clause.AssignmentColumns([]string{"required", "updated_by_id", "updated_at"}),
+	}).Create(&policy).Error
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// verifyTOTP checks a TOTP code and records its time step, so the same code can't be used twice
+func (s *MFAService) verifyTOTP(user *models.User, code string) error {
+	step, ok := ValidateTOTP(user.MFASecret, code, time.Now())
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	result := s.db.Model(&models.User{}).
+		Where("id = ? AND mfa_last_step < ?", user.ID, step).
+		Update("mfa_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidMFACode
+	}
+	user.MFALastStep = step
+	return nil
+}
+
+// openChallenge looks up a challenge that can still be answered, along with its user
+func (s *MFAService) openChallenge(rawToken string, purpose models.MFAChallengePurpose) (*models.MFAChallenge, *models.User, error) {
+	var challenge models.MFAChallenge
+	if err := s.db.Where("token_hash = ? AND purpose = ?", HashToken(rawToken), purpose).First(&challenge).Error; err != nil {
+		return nil, nil, ErrInvalidMFAChallenge
+	}
+	if !challenge.IsUsable(time.Now(), maxMFAAttempts) {
+		return nil, nil, ErrInvalidMFAChallenge
+	}
+
+	var user models.User
+	if err := s.db.First(&user, "id = ?", challenge.UserID).Error; err != nil {
+		return nil, nil, ErrInvalidMFAChallenge
+	}
+	return &challenge, &user, nil
+}
+
+// useChallenge marks a challenge as used; only one request can use it
+func (s *MFAService) useChallenge(challenge *models.MFAChallenge) error {
+	result := s.db.Model(&models.MFAChallenge{}).
+		Where("id = ? AND used_at IS NULL", challenge.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidMFAChallenge
+	}
+	return nil
+}
+
+// failAttempt counts a wrong code against the challenge
+func (s *MFAService) failAttempt(challenge *models.MFAChallenge) {
+	s.db.Model(&models.MFAChallenge{}).
+		Where("id = ?", challenge.ID).
+		Update("attempts", gorm.Expr("attempts + 1"))
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and stores a new set
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := GenerateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		if err := tx.Create(&models.RecoveryCode{UserID: userID, CodeHash: HashToken(code)}).Error; err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// GenerateRecoveryCode returns a random recovery code like "k7m2p-x9qrt"
+func GenerateRecoveryCode() (string, error) {
+	alphabetSize := big.NewInt(int64(len(recoveryCodeAlphabet)))
+	code := make([]byte, 10)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		code[i] = recoveryCodeAlphabet[n.Int64()]
+	}
+	return string(code[:5]) + "-" + string(code[5:]), nil
+}
+
+// normalizeRecoveryCode makes recovery codes forgiving about case and missing dashes
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+	if len(code) == 10 && !strings.Contains(code, "-") {
+		code = code[:5] + "-" + code[5:]
+	}
+	return code
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// oauthStatePrefix is the Redis key prefix of pending provider logins
+const oauthStatePrefix = "oauth:state:"
+
+// ErrUnknownOAuthState is returned for a state that was never issued, already used or expired
+var ErrUnknownOAuthState = errors.New("unknown or expired OAuth state")
+
+// OAuthState is what a provider login remembers between the redirect to the provider and the callback
+type OAuthState struct {
+	Provider     string    `json:"provider"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"` // PKCE verifier of the authorization request
+	ReturnTo     string    `json:"return_to"`     // Where to send the user afterwards, checked with IsAllowedReturnTo
+	LinkUserID   uuid.UUID `json:"link_user_id"`  // Set when a logged in user links the provider instead of logging in
+}
+
+// StateStore keeps pending provider logins until their callback. A state can only be taken once.
+type StateStore interface {
+	Save(ctx context.Context, state string, login OAuthState, ttl time.Duration) error
+	Take(ctx context.Context, state string) (*OAuthState, error)
+}
+
+// RedisStateStore keeps pending provider logins in Redis, so the callback can reach any CMS instance
+type RedisStateStore struct {
+	redis *redis.Client
+}
+
+// NewRedisStateStore creates a new Redis state store
+func NewRedisStateStore(rdb *redis.Client) *RedisStateStore {
+	return &RedisStateStore{redis: rdb}
+}
+
+// Save stores a pending login under the hash of its state
+func (s *RedisStateStore) Save(ctx context.Context, state string, login OAuthState, ttl time.Duration) error {
+	data, err := json.Marshal(login)
+	if err != nil {
+		return err
+	}
+	if err := s.redis.Set(ctx, oauthStatePrefix+HashToken(state), data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to save OAuth state: %v", err)
+	}
+	return nil
+}
+
+// Take returns and removes a pending login in one step, so two callbacks can't both use it
+func (s *RedisStateStore) Take(ctx context.Context, state string) (*OAuthState, error) {
+	data, err := s.redis.GetDel(ctx, oauthStatePrefix+HashToken(state)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrUnknownOAuthState
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OAuth state: %v", err)
+	}
+
+	var login OAuthState
+	if err := json.Unmarshal(data, &login); err != nil {
+		return nil, fmt.Errorf("failed to decode OAuth state: %v", err)
+	}
+	return &login, nil
+}
+
+// memoryState is a pending login kept in memory
+type memoryState struct {
+	login     OAuthState
+	expiresAt time.Time
+}
+
+// MemoryStateStore keeps pending provider logins in memory. It only works with a single CMS
+// instance and loses the logins on a restart, so it is meant for development and tests.
+type MemoryStateStore struct {
+	mu     sync.Mutex
+	states map[string]memoryState
+}
+
+// NewMemoryStateStore creates a new in-memory state store
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{states: make(map[string]memoryState)}
+}
+
+// Save stores a pending login, dropping expired ones
+func (s *MemoryStateStore) Save(ctx context.Context, state string, login OAuthState, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, existing := range s.states {
+		if now.After(existing.expiresAt) {
+			delete(s.states, key)
+		}
+	}
+	s.states[HashToken(state)] = memoryState{login: login, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+// Take returns and removes a pending login
+func (s *MemoryStateStore) Take(ctx context.Context, state string) (*OAuthState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := HashToken(state)
+	existing, ok := s.states[key]
+	delete(s.states, key)
+	if !ok || time.Now().After(existing.expiresAt) {
+		return nil, ErrUnknownOAuthState
+	}
+	return &existing.login, nil
+}
+
+// IsAllowedReturnTo checks if a login may redirect to target afterwards. Paths on the CMS itself are
+// allowed, other URLs only when their origin (scheme, host and port) is in the allowlist. Anything
+// else could send a freshly logged in user to an attacker's site.
+func IsAllowedReturnTo(target string, allowedOrigins []string) bool {
+	// Browsers treat backslashes like slashes, so "/\evil.com" is protocol-relative too
+	if strings.ContainsAny(target, "\\\r\n\t") {
+		return false
+	}
+
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	if parsed.Scheme == "" && parsed.Host == "" {
+		// A path; "//evil.com" would have parsed with a host
+		return strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//")
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.User != nil {
+		return false
+	}
+
+	origin := parsed.Scheme + "://" + strings.ToLower(parsed.Host)
+	for _, allowed := range allowedOrigins {
+		allowedURL, err := url.Parse(strings.TrimSpace(allowed))
+		if err != nil || allowedURL.Host == "" {
+			continue
+		}
+		if origin == allowedURL.Scheme+"://"+strings.ToLower(allowedURL.Host) {
+			return true
+		}
+	}
+	return false
+}
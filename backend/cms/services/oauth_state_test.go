@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMemoryStateStore tests that a state can be taken once and expires
+func TestMemoryStateStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStateStore()
+
+	login := OAuthState{Provider: "google", Nonce: "nonce", CodeVerifier: "verifier", ReturnTo: "/dashboard"}
+	require.NoError(t, store.Save(ctx, "state", login, time.Minute))
+
+	taken, err := store.Take(ctx, "state")
+	require.NoError(t, err)
+	assert.Equal(t, login, *taken)
+
+	_, err = store.Take(ctx, "state")
+	assert.ErrorIs(t, err, ErrUnknownOAuthState)
+
+	_, err = store.Take(ctx, "never-issued")
+	assert.ErrorIs(t, err, ErrUnknownOAuthState)
+
+	require.NoError(t, store.Save(ctx, "expired", login, -time.Second))
+	_, err = store.Take(ctx, "expired")
+	assert.ErrorIs(t, err, ErrUnknownOAuthState)
+}
+
+// TestMemoryStateStoreConcurrentTake tests that only one of several concurrent callbacks gets a state
+func TestMemoryStateStoreConcurrentTake(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStateStore()
+	require.NoError(t, store.Save(ctx, "state", OAuthState{Provider: "google"}, time.Minute))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	taken := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.Take(ctx, "state"); err == nil {
+				mu.Lock()
+				taken++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, taken)
+}
+
+// TestIsAllowedReturnTo tests that logins only redirect to the CMS or allowed origins
+func TestIsAllowedReturnTo(t *testing.T) {
+	allowed := []string{"https://app.learnvibe.com", "http://localhost:8000"}
+
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"/", true},
+		{"/dashboard?tab=courses", true},
+		{"https://app.learnvibe.com/courses", true},
+		{"https://APP.learnvibe.com", true},
+		{"http://localhost:8000/settings", true},
+		{"http://app.learnvibe.com/courses", false}, // Wrong scheme
+		{"http://localhost:8001/", false},           // Wrong port
+		{"https://app.learnvibe.com.evil.com/", false},
+		{"https://evil.com/?next=https://app.learnvibe.com", false},
+		{"https://app.learnvibe.com@evil.com/", false},
+		{"//evil.com", false},
+		{"/\\evil.com", false},
+		{"javascript:alert(1)", false},
+		{"dashboard", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsAllowedReturnTo(tt.target, allowed))
+		})
+	}
+}
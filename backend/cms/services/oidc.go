@@ -0,0 +1,479 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// OIDC errors
+var (
+	ErrUnknownOIDCProvider = errors.New("unknown login provider")
+	ErrInvalidIDToken      = errors.New("invalid ID token")
+	ErrUnknownOIDCKey      = errors.New("unknown ID token signing key")
+)
+
+// idTokenAlgorithms are the ID token signing algorithms we accept. "none" and HMAC are never accepted.
+var idTokenAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// oidcDiscoveryTTL is how long a discovery document is cached
+const oidcDiscoveryTTL = time.Hour
+
+// OIDCProviderConfig configures an OpenID Connect login provider
+type OIDCProviderConfig struct {
+	Name         string // Used in the login URLs, e.g. "keycloak" for /auth/keycloak/login
+	DisplayName  string // Shown on the login page, e.g. "University login"
+	Issuer       string // The discovery document is read from <Issuer>/.well-known/openid-configuration
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string // Defaults to openid, email and profile
+}
+
+// OIDCDiscovery holds the fields of a discovery document we use
+type OIDCDiscovery struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	JWKSURI                       string   `json:"jwks_uri"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+}
+
+// OIDCIdentity is who the provider says logged in, taken from a validated ID token
+type OIDCIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// idTokenClaims are the ID token claims we read
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string          `json:"nonce"`
+	AuthorizedParty   string          `json:"azp"`
+	Email             string          `json:"email"`
+	EmailVerified     json.RawMessage `json:"email_verified"` // A boolean, but some providers send a string
+	Name              string          `json:"name"`
+	PreferredUsername string          `json:"preferred_username"`
+}
+
+// OIDCProvider logs users in with the authorization code flow of an OpenID Connect provider, using
+// PKCE and a nonce. The discovery document and signing keys are fetched lazily and cached.
+type OIDCProvider struct {
+	config     OIDCProviderConfig
+	httpClient *http.Client
+
+	mu           sync.Mutex
+	discovery    *OIDCDiscovery
+	discoveredAt time.Time
+	keys         *oidcKeySet
+}
+
+// NewOIDCProvider creates a new OIDC provider. A nil HTTP client means a default one with a timeout.
+func NewOIDCProvider(config OIDCProviderConfig, httpClient *http.Client) *OIDCProvider {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	if config.DisplayName == "" {
+		config.DisplayName = config.Name
+	}
+	config.Issuer = strings.TrimRight(config.Issuer, "/")
+	return &OIDCProvider{config: config, httpClient: httpClient}
+}
+
+// Name returns the name the provider is registered under
+func (p *OIDCProvider) Name() string {
+	return p.config.Name
+}
+
+// DisplayName returns the name to show on the login page
+func (p *OIDCProvider) DisplayName() string {
+	return p.config.DisplayName
+}
+
+// AuthCodeURL returns the URL to send the user to. The state, nonce and PKCE verifier have to be
+// kept until the callback.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	oauthConf, err := p.oauthConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	return oauthConf.AuthCodeURL(state,
+		oauth2.AccessTypeOnline,
+		oauth2.S256ChallengeOption(codeVerifier),
+		oauth2.SetAuthURLParam("nonce", nonce),
+	), nil
+}
+
+// Exchange redeems an authorization code and returns the identity from the validated ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*OIDCIdentity, error) {
+	oauthConf, err := p.oauthConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := oauthConf.Exchange(context.WithValue(ctx, oauth2.HTTPClient, p.httpClient), code,
+		oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, fmt.Errorf("%w: token response has no id_token", ErrInvalidIDToken)
+	}
+	return p.VerifyIDToken(ctx, rawIDToken, nonce)
+}
+
+// VerifyIDToken checks an ID token's signature, issuer, audience, expiry and nonce
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*OIDCIdentity, error) {
+	discovery, keys, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims idTokenClaims
+	_, err = jwt.ParseWithClaims(rawIDToken, &claims, keys.Keyfunc,
+		jwt.WithValidMethods(idTokenAlgorithms),
+		jwt.WithIssuer(discovery.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	// With several audiences the token must have been issued to us
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID {
+		return nil, fmt.Errorf("%w: issued to another party", ErrInvalidIDToken)
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = claims.PreferredUsername
+	}
+	return &OIDCIdentity{
+		Provider:      p.config.Name,
+		Subject:       claims.Subject,
+		Email:         strings.ToLower(strings.TrimSpace(claims.Email)),
+		EmailVerified: isTrue(claims.EmailVerified),
+		Name:          name,
+	}, nil
+}
+
+// oauthConfig returns the OAuth2 configuration built from the discovery document
+func (p *OIDCProvider) oauthConfig(ctx context.Context) (*oauth2.Config, error) {
+	discovery, _, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectURL,
+		Scopes:       p.config.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  discovery.AuthorizationEndpoint,
+			TokenURL: discovery.TokenEndpoint,
+		},
+	}, nil
+}
+
+// discover returns the cached discovery document, fetching it when it is missing or stale
+func (p *OIDCProvider) discover(ctx context.Context) (*OIDCDiscovery, *oidcKeySet, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil && time.Since(p.discoveredAt) < oidcDiscoveryTTL {
+		return p.discovery, p.keys, nil
+	}
+
+	discovery, err := p.fetchDiscovery(ctx)
+	if err != nil {
+		if p.discovery != nil {
+			// Keep using the old document if the provider is briefly unreachable
+			log.Printf("Warning: Failed to refresh OIDC discovery of %s: %v", p.config.Name, err)
+			return p.discovery, p.keys, nil
+		}
+		return nil, nil, err
+	}
+
+	if p.keys == nil || p.keys.url != discovery.JWKSURI {
+		p.keys = newOIDCKeySet(discovery.JWKSURI, p.httpClient)
+	}
+	p.discovery = discovery
+	p.discoveredAt = time.Now()
+	return p.discovery, p.keys, nil
+}
+
+// fetchDiscovery downloads and checks the discovery document
+func (p *OIDCProvider) fetchDiscovery(ctx context.Context) (*OIDCDiscovery, error) {
+	var discovery OIDCDiscovery
+	if err := getJSON(ctx, p.httpClient, p.config.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC discovery document of %s: %w", p.config.Name, err)
+	}
+
+	// The issuer must match exactly, otherwise another provider could be impersonated
+	if strings.TrimRight(discovery.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("OIDC discovery document of %s is for issuer %q", p.config.Name, discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery document of %s is incomplete", p.config.Name)
+	}
+	if len(discovery.CodeChallengeMethodsSupported) > 0 && !contains(discovery.CodeChallengeMethodsSupported, "S256") {
+		return nil, fmt.Errorf("OIDC provider %s doesn't support PKCE with S256", p.config.Name)
+	}
+	return &discovery, nil
+}
+
+// OIDCRegistry holds the configured login providers by name
+type OIDCRegistry struct {
+	providers map[string]*OIDCProvider
+}
+
+// NewOIDCRegistry creates a registry of the given providers
+func NewOIDCRegistry(providers ...*OIDCProvider) *OIDCRegistry {
+	registry := &OIDCRegistry{providers: make(map[string]*OIDCProvider, len(providers))}
+	for _, provider := range providers {
+		registry.providers[provider.Name()] = provider
+	}
+	return registry
+}
+
+// Get returns the provider registered under a name
+func (r *OIDCRegistry) Get(name string) (*OIDCProvider, error) {
+	if r == nil {
+		return nil, ErrUnknownOIDCProvider
+	}
+	provider, ok := r.providers[name]
+	if !ok {
+		return nil, ErrUnknownOIDCProvider
+	}
+	return provider, nil
+}
+
+// Providers returns all providers sorted by name
+func (r *OIDCRegistry) Providers() []*OIDCProvider {
+	if r == nil {
+		return nil
+	}
+	providers := make([]*OIDCProvider, 0, len(r.providers))
+	for _, provider := range r.providers {
+		providers = append(providers, provider)
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name() < providers[j].Name() })
+	return providers
+}
+
+// oidcKeySet fetches and caches a provider's signing keys. Keys are refetched when a token carries
+// an unknown kid (at most every minRefetch), which is how key rotation is picked up.
+type oidcKeySet struct {
+	url        string
+	httpClient *http.Client
+	minRefetch time.Duration
+
+	mu          sync.Mutex
+	keys        map[string]interface{}
+	lastAttempt time.Time
+}
+
+// newOIDCKeySet creates a key set for a JWKS URL
+func newOIDCKeySet(url string, httpClient *http.Client) *oidcKeySet {
+	return &oidcKeySet{url: url, httpClient: httpClient, minRefetch: 10 * time.Second}
+}
+
+// Keyfunc returns the public key matching the token's kid, for use with jwt.Parse
+func (ks *oidcKeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	key, ok := ks.find(kid)
+	if !ok && time.Since(ks.lastAttempt) >= ks.minRefetch {
+		ks.lastAttempt = time.Now()
+		if err := ks.refresh(); err != nil {
+			log.Printf("Warning: Failed to fetch OIDC keys from %s: %v", ks.url, err)
+		}
+		key, ok = ks.find(kid)
+	}
+	if !ok {
+		return nil, ErrUnknownOIDCKey
+	}
+
+	// The key type must fit the algorithm in the token header
+	switch key.(type) {
+	case *rsa.PublicKey:
+		ok = strings.HasPrefix(token.Method.Alg(), "RS") || strings.HasPrefix(token.Method.Alg(), "PS")
+	case *ecdsa.PublicKey:
+		ok = strings.HasPrefix(token.Method.Alg(), "ES")
+	case ed25519.PublicKey:
+		ok = token.Method.Alg() == "EdDSA"
+	}
+	if !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Method.Alg())
+	}
+	return key, nil
+}
+
+// find looks a key up by kid. Tokens without a kid are accepted if the provider has a single key.
+func (ks *oidcKeySet) find(kid string) (interface{}, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+// refresh downloads the JWKS
+func (ks *oidcKeySet) refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var set struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+			Curve   string `json:"crv"`
+			X       string `json:"x"`
+			Y       string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := getJSON(ctx, ks.httpClient, ks.url, &set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		// Providers also publish encryption keys, which must not verify signatures
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := parsePublicJWK(jwk.KeyType, jwk.N, jwk.E, jwk.Curve, jwk.X, jwk.Y)
+		if err != nil {
+			log.Printf("Warning: Skipping OIDC key %q from %s: %v", jwk.KeyID, ks.url, err)
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+	ks.keys = keys
+	return nil
+}
+
+// parsePublicJWK turns the fields of an RSA, EC or Ed25519 JWK into a public key
+func parsePublicJWK(keyType, n, e, curve, x, y string) (interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch keyType {
+	case "RSA":
+		modulus, err := decode(n)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %v", err)
+		}
+		exponent, err := decode(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %v", err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(new(big.Int).SetBytes(exponent).Int64())}, nil
+	case "EC":
+		var c elliptic.Curve
+		switch curve {
+		case "P-256":
+			c = elliptic.P256()
+		case "P-384":
+			c = elliptic.P384()
+		case "P-521":
+			c = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", curve)
+		}
+		xBytes, errX := decode(x)
+		yBytes, errY := decode(y)
+		if errX != nil || errY != nil {
+			return nil, errors.New("invalid EC point")
+		}
+		key := &ecdsa.PublicKey{Curve: c, X: new(big.Int).SetBytes(xBytes), Y: new(big.Int).SetBytes(yBytes)}
+		if !c.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return key, nil
+	case "OKP":
+		public, err := decode(x)
+		if curve != "Ed25519" || err != nil || len(public) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(public), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", keyType)
+	}
+}
+
+// getJSON fetches a JSON document
+func getJSON(ctx context.Context, client *http.Client, url string, dest interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(dest)
+}
+
+// isTrue reads a JSON boolean that may also be sent as the string "true"
+func isTrue(raw json.RawMessage) bool {
+	var b bool
+	if json.Unmarshal(raw, &b) == nil {
+		return b
+	}
+	var s string
+	return json.Unmarshal(raw, &s) == nil && strings.EqualFold(s, "true")
+}
+
+// contains checks if a string is in a slice
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services/oidctest"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+// newTestOIDCProvider starts a mock provider and a client for it
+func newTestOIDCProvider(t *testing.T) (*oidctest.Server, *OIDCProvider) {
+	server := oidctest.NewServer("learnvibe", "secret")
+	t.Cleanup(server.Close)
+
+	provider := NewOIDCProvider(OIDCProviderConfig{
+		Name:         "mock",
+		Issuer:       server.Issuer(),
+		ClientID:     "learnvibe",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/auth/mock/callback",
+	}, server.Client())
+	return server, provider
+}
+
+// authorize follows the authorization URL like a browser and returns the code and state from the callback
+func authorize(t *testing.T, authURL string) (string, string) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	if !assert.Equal(t, http.StatusFound, resp.StatusCode) {
+		t.FailNow()
+	}
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	assert.NoError(t, err)
+	return callback.Query().Get("code"), callback.Query().Get("state")
+}
+
+// TestOIDCLoginFlow tests the authorization code flow with PKCE and nonce against the mock provider
+func TestOIDCLoginFlow(t *testing.T) {
+	server, provider := newTestOIDCProvider(t)
+	ctx := context.Background()
+	verifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-123", "nonce-456", verifier)
+	assert.NoError(t, err)
+	parsed, _ := url.Parse(authURL)
+	assert.Equal(t, server.Issuer()+"/authorize", parsed.Scheme+"://"+parsed.Host+parsed.Path)
+	assert.Equal(t, "S256", parsed.Query().Get("code_challenge_method"))
+	assert.Equal(t, oauth2.S256ChallengeFromVerifier(verifier), parsed.Query().Get("code_challenge"))
+	assert.Equal(t, "nonce-456", parsed.Query().Get("nonce"))
+	assert.Equal(t, "openid email profile", parsed.Query().Get("scope"))
+
+	code, state := authorize(t, authURL)
+	assert.Equal(t, "state-123", state)
+
+	identity, err := provider.Exchange(ctx, code, verifier, "nonce-456")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "mock", identity.Provider)
+		assert.Equal(t, "mock-user", identity.Subject)
+		assert.Equal(t, "mock.user@example.com", identity.Email)
+		assert.True(t, identity.EmailVerified)
+		assert.Equal(t, "Mock User", identity.Name)
+	}
+
+	// Codes are single-use
+	_, err = provider.Exchange(ctx, code, verifier, "nonce-456")
+	assert.Error(t, err)
+}
+
+// TestOIDCExchangeRejections tests that a wrong PKCE verifier or nonce fails the login
+func TestOIDCExchangeRejections(t *testing.T) {
+	_, provider := newTestOIDCProvider(t)
+	ctx := context.Background()
+	verifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", verifier)
+	assert.NoError(t, err)
+	code, _ := authorize(t, authURL)
+	_, err = provider.Exchange(ctx, code, oauth2.GenerateVerifier(), "nonce")
+	assert.Error(t, err, "another PKCE verifier must be refused")
+
+	authURL, _ = provider.AuthCodeURL(ctx, "state", "nonce", verifier)
+	code, _ = authorize(t, authURL)
+	_, err = provider.Exchange(ctx, code, verifier, "another-nonce")
+	assert.True(t, errors.Is(err, ErrInvalidIDToken), "a replayed ID token must be refused")
+}
+
+// TestOIDCVerifyIDToken tests the ID token checks
+func TestOIDCVerifyIDToken(t *testing.T) {
+	server, provider := newTestOIDCProvider(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+		valid  bool
+	}{
+		{"valid", nil, true},
+		{"email_verified as string", func(c jwt.MapClaims) { c["email_verified"] = "true" }, true},
+		{"several audiences with azp", func(c jwt.MapClaims) {
+			c["aud"] = []string{"learnvibe", "other"}
+			c["azp"] = "learnvibe"
+		}, true},
+		{"other audience", func(c jwt.MapClaims) { c["aud"] = "other-client" }, false},
+		{"several audiences without azp", func(c jwt.MapClaims) { c["aud"] = []string{"learnvibe", "other"} }, false},
+		{"other issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, false},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, false},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }, false},
+		{"no subject", func(c jwt.MapClaims) { delete(c, "sub") }, false},
+		{"no nonce", func(c jwt.MapClaims) { delete(c, "nonce") }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.ModifyClaims = tt.modify
+			identity, err := provider.VerifyIDToken(ctx, server.IDToken("nonce"), "nonce")
+			if tt.valid {
+				assert.NoError(t, err)
+				assert.True(t, identity.EmailVerified)
+			} else {
+				assert.True(t, errors.Is(err, ErrInvalidIDToken), "got %v", err)
+			}
+		})
+	}
+}
+
+// TestOIDCKeyRotation tests that tokens signed with a new key are accepted once the JWKS has it
+func TestOIDCKeyRotation(t *testing.T) {
+	server, provider := newTestOIDCProvider(t)
+	ctx := context.Background()
+
+	_, err := provider.VerifyIDToken(ctx, server.IDToken("nonce"), "nonce")
+	assert.NoError(t, err)
+
+	server.RotateKey("rotated-key")
+	provider.keys.lastAttempt = time.Time{}
+	_, err = provider.VerifyIDToken(ctx, server.IDToken("nonce"), "nonce")
+	assert.NoError(t, err)
+}
+
+// TestOIDCDiscoveryIssuerMismatch tests that a discovery document for another issuer is refused
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	server := oidctest.NewServer("learnvibe", "secret")
+	defer server.Close()
+
+	provider := NewOIDCProvider(OIDCProviderConfig{
+		Name:     "mock",
+		Issuer:   server.Issuer() + "/realms/other",
+		ClientID: "learnvibe",
+	}, server.Client())
+	_, err := provider.AuthCodeURL(context.Background(), "state", "nonce", oauth2.GenerateVerifier())
+	assert.Error(t, err)
+}
+
+// TestOIDCRegistry tests looking providers up by name
+func TestOIDCRegistry(t *testing.T) {
+	registry := NewOIDCRegistry(
+		NewOIDCProvider(OIDCProviderConfig{Name: "keycloak", DisplayName: "University login"}, nil),
+		NewOIDCProvider(OIDCProviderConfig{Name: "azure"}, nil),
+	)
+
+	provider, err := registry.Get("keycloak")
+	assert.NoError(t, err)
+	assert.Equal(t, "University login", provider.DisplayName())
+
+	_, err = registry.Get("github")
+	assert.Equal(t, ErrUnknownOIDCProvider, err)
+
+	providers := registry.Providers()
+	assert.Len(t, providers, 2)
+	assert.Equal(t, "azure", providers[0].Name())
+	assert.Equal(t, "azure", providers[0].DisplayName())
+
+	var empty *OIDCRegistry
+	_, err = empty.Get("keycloak")
+	assert.Equal(t, ErrUnknownOIDCProvider, err)
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxPasswordBytes is the longest password bcrypt can hash
+const maxPasswordBytes = 72
+
+// minSimilarityLength is the shortest part of a name or email a password may not contain, so
+// short names don't rule out ordinary words
+const minSimilarityLength = 4
+
+// PasswordPolicyConfig holds the rules new passwords have to follow
+type PasswordPolicyConfig struct {
+	MinLength           int // in characters
+	MinCharacterClasses int // of lower case letters, upper case letters, digits and symbols
+}
+
+// PasswordPolicyError lists why a password was rejected, in words that can be shown to the user
+type PasswordPolicyError struct {
+	Problems []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password rejected: " + strings.Join(e.Problems, "; ")
+}
+
+// PasswordPolicy checks new passwords: their length, the kinds of characters they mix, that they
+// don't contain the user's name or email, and that they aren't known from a data breach
+type PasswordPolicy struct {
+	config   PasswordPolicyConfig
+	breached *BreachedPasswords
+}
+
+// NewPasswordPolicy creates a new password policy. Without breached passwords that check is skipped.
+func NewPasswordPolicy(config PasswordPolicyConfig, breached *BreachedPasswords) *PasswordPolicy {
+	if config.MinLength < 1 {
+		config.MinLength = 8
+	}
+	if config.MinCharacterClasses < 1 {
+		config.MinCharacterClasses = 1
+	}
+	if config.MinCharacterClasses > 4 {
+		config.MinCharacterClasses = 4
+	}
+	return &PasswordPolicy{config: config, breached: breached}
+}
+
+// Config returns the rules of the policy
+func (p *PasswordPolicy) Config() PasswordPolicyConfig {
+	return p.config
+}
+
+// Check checks a new password of the user with the email and name. It returns a
+// *PasswordPolicyError listing every rule the password breaks.
+func (p *PasswordPolicy) Check(password, email, name string) error {
+	var problems []string
+	if utf8.RuneCountInString(password) < p.config.MinLength {
+		problems = append(problems, fmt.Sprintf("Use at least %d characters", p.config.MinLength))
+	}
+	if len(password) > maxPasswordBytes {
+		problems = append(problems, fmt.Sprintf("Use at most %d characters", maxPasswordBytes))
+	}
+	if characterClasses(password) < p.config.MinCharacterClasses {
+		problems = append(problems, fmt.Sprintf("Mix at least %d of lower case letters, upper case letters, digits and symbols",
+			p.config.MinCharacterClasses))
+	}
+	if containsPersonalInfo(password, email, name) {
+		problems = append(problems, "Don't use your name or email address in your password")
+	}
+	if p.breached != nil && p.breached.Contains(password) {
+		problems = append(problems, "This password appeared in a data breach, choose another one")
+	}
+
+	if len(problems) > 0 {
+		return &PasswordPolicyError{Problems: problems}
+	}
+	return nil
+}
+
+// characterClasses counts the kinds of characters in the password
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	count := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			count++
+		}
+	}
+	return count
+}
+
+// containsPersonalInfo checks if the password contains the email's local part or a word of it or of
+// the name, ignoring case. The site's name counts too.
+func containsPersonalInfo(password, email, name string) bool {
+	password = strings.ToLower(password)
+	localPart := strings.ToLower(email)
+	if i := strings.LastIndexByte(localPart, '@'); i >= 0 {
+		localPart = localPart[:i]
+	}
+
+	isSeparator := func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }
+	words := []string{localPart, "learnvibe"}
+	words = append(words, strings.FieldsFunc(localPart, isSeparator)...)
+	words = append(words, strings.FieldsFunc(strings.ToLower(name), isSeparator)...)
+	for _, word := range words {
+		if utf8.RuneCountInString(word) >= minSimilarityLength && strings.Contains(password, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// breachedHash returns a password's line in a breached password file
+func breachedHash(password string) string {
+	hash := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(hash[:]))
+}
+
+func TestReadBreachedPasswords(t *testing.T) {
+	corpus := "# Pwned Passwords sample\n" +
+		breachedHash("Summer2024!") + ":4211\n" +
+		"\n" +
+		strings.ToLower(breachedHash("password123")) + "\n" +
+		breachedHash("Qwerty!2345") + ":12\n"
+
+	breached, err := ReadBreachedPasswords(strings.NewReader(corpus))
+	require.NoError(t, err)
+	assert.Equal(t, 3, breached.Len())
+	assert.True(t, breached.Contains("Summer2024!"))
+	assert.True(t, breached.Contains("password123"))
+	assert.True(t, breached.Contains("Qwerty!2345"))
+	assert.False(t, breached.Contains("summer2024!"))
+	assert.False(t, breached.Contains("Tr1cky-Walrus-Lantern"))
+}
+
+func TestReadBreachedPasswordsRejectsMalformedLines(t *testing.T) {
+	_, err := ReadBreachedPasswords(strings.NewReader(breachedHash("a") + "\nnot-a-hash:3\n"))
+	assert.ErrorContains(t, err, "line 2")
+
+	_, err = ReadBreachedPasswords(strings.NewReader(strings.Repeat("Z", 40) + "\n"))
+	assert.ErrorContains(t, err, "line 1")
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	breached, err := ReadBreachedPasswords(strings.NewReader(breachedHash("Summer2024!")))
+	require.NoError(t, err)
+	policy := NewPasswordPolicy(PasswordPolicyConfig{MinLength: 10, MinCharacterClasses: 3}, breached)
+
+	tests := []struct {
+		name     string
+		password string
+		problems []string
+	}{
+		{"good", "Tr1cky-Walrus-Lantern", nil},
+		{"unicode length", "Größenwahn1ü", nil},
+		{"too short", "Ab1-xyz", []string{"Use at least 10 characters"}},
+		{"too long", "Aa1-" + strings.Repeat("x", 69), []string{"Use at most 72 characters"}},
+		{"too few character classes", "lowercase-only", []string{
+			"Mix at least 3 of lower case letters, upper case letters, digits and symbols",
+		}},
+		{"email", "Mayaparker!99", []string{"Don't use your name or email address in your password"}},
+		{"name", "Tr1cky-Lindqvist", []string{"Don't use your name or email address in your password"}},
+		{"site name", "LearnVibe-2024", []string{"Don't use your name or email address in your password"}},
+		{"breached", "Summer2024!", []string{"This password appeared in a data breach, choose another one"}},
+		{"several problems", "parker", []string{
+			"Use at least 10 characters",
+			"Mix at least 3 of lower case letters, upper case letters, digits and symbols",
+			"Don't use your name or email address in your password",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.password, "maya.parker@example.com", "Maya Lindqvist")
+			if tt.problems == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var policyErr *PasswordPolicyError
+			require.ErrorAs(t, err, &policyErr)
+			assert.Equal(t, tt.problems, policyErr.Problems)
+		})
+	}
+}
+
+func TestPasswordPolicyIgnoresShortNames(t *testing.T) {
+	policy := NewPasswordPolicy(PasswordPolicyConfig{}, nil)
+
+	// "Al" and "Li" are too short to count, the defaults only ask for 8 characters
+	assert.NoError(t, policy.Check("totally-alright", "al@example.com", "Al Li"))
+	assert.Equal(t, PasswordPolicyConfig{MinLength: 8, MinCharacterClasses: 1}, policy.Config())
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFakePaymentProviderWebhook tests that signed webhooks are accepted and tampered ones rejected
+func TestFakePaymentProviderWebhook(t *testing.T) {
+	provider := NewFakePaymentProvider("test-secret", "http://localhost:8080")
+
+	session, err := provider.CreateCheckout(context.Background(), CheckoutRequest{
+		OrderID:     uuid.New(),
+		AmountCents: 4900,
+		Currency:    "USD",
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, session.URL, session.ProviderRef)
+
+	event := PaymentEvent{Type: PaymentEventSucceeded, ProviderRef: session.ProviderRef, AmountCents: 4900, Currency: "USD"}
+	body, header, err := provider.SignWebhook(event)
+	assert.NoError(t, err)
+
+	parsed, err := provider.ParseWebhook(body, header)
+	assert.NoError(t, err)
+	assert.Equal(t, event.ProviderRef, parsed.ProviderRef)
+	assert.Equal(t, event.AmountCents, parsed.AmountCents)
+
+	// Changing the body invalidates the signature
+	tampered := append([]byte{}, body...)
+	tampered[len(tampered)-2] = '9'
+	_, err = provider.ParseWebhook(tampered, header)
+	assert.ErrorIs(t, err, ErrInvalidWebhookSignature)
+
+	// A different secret can't sign webhooks
+	other := NewFakePaymentProvider("other-secret", "http://localhost:8080")
+	body, header, _ = other.SignWebhook(event)
+	_, err = provider.ParseWebhook(body, header)
+	assert.ErrorIs(t, err, ErrInvalidWebhookSignature)
+}
+
+// TestFakePaymentProviderRefund tests that refunds are recorded
+func TestFakePaymentProviderRefund(t *testing.T) {
+	provider := NewFakePaymentProvider("test-secret", "http://localhost:8080")
+	assert.NoError(t, provider.Refund(context.Background(), "fake_ref", 4900, "USD"))
+	assert.Equal(t, int64(4900), provider.Refunded("fake_ref"))
+}
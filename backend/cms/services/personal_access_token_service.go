@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/policy"
+	"gorm.io/gorm"
+)
+
+// Personal access token errors
+var (
+	ErrInvalidPersonalAccessToken = errors.New("invalid, expired or revoked personal access token")
+	ErrPersonalAccessTokenLimit   = errors.New("too many personal access tokens")
+	ErrInvalidScope               = errors.New("unknown scope")
+	ErrScopeNotAllowed            = errors.New("scope not allowed for the user's role")
+	ErrInvalidTokenLifetime       = errors.New("token lifetime must be between a day and a year")
+)
+
+const (
+	// maxPersonalAccessTokens is how many active tokens a user can have
+	maxPersonalAccessTokens = 50
+	// MaxPersonalAccessTokenTTL is the longest lifetime a token can be created with
+	MaxPersonalAccessTokenTTL = 365 * 24 * time.Hour
+	// lastUsedInterval is how often the last use of a token is written, so busy scripts don't
+	// write on every request
+	lastUsedInterval = time.Minute
+)
+
+// PersonalAccessTokenService creates and checks personal access tokens
+type PersonalAccessTokenService struct {
+	db *gorm.DB
+}
+
+// NewPersonalAccessTokenService creates a new personal access token service
+func NewPersonalAccessTokenService(db *gorm.DB) *PersonalAccessTokenService {
+	return &PersonalAccessTokenService{db: db}
+}
+
+// Create creates a token for a user and returns it with the raw token, which is only shown this once.
+// Every token expires, after at most MaxPersonalAccessTokenTTL.
+func (s *PersonalAccessTokenService) Create(user models.User, name string, scopes []string, ttl time.Duration) (*models.PersonalAccessToken, string, error) {
+	if ttl <= 0 || ttl > MaxPersonalAccessTokenTTL {
+		return nil, "", ErrInvalidTokenLifetime
+	}
+	for _, scope := range scopes {
+		if !claims.IsValidScope(scope) {
+			return nil, "", fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+		if (scope == claims.ScopeAdminRead || scope == claims.ScopeAdminWrite) && !policy.Grants(string(user.Role), policy.AdminAccess) {
+			return nil, "", fmt.Errorf("%w: %s", ErrScopeNotAllowed, scope)
+		}
+	}
+
+	var active int64
+	s.db.Model(&models.PersonalAccessToken{}).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", user.ID, time.Now()).
+		Count(&active)
+	if active >= maxPersonalAccessTokens {
+		return nil, "", ErrPersonalAccessTokenLimit
+	}
+
+	secret, err := GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	rawToken := claims.PersonalAccessTokenPrefix + secret
+
+	token := models.PersonalAccessToken{
+		UserID:    user.ID,
+		Name:      name,
+		TokenHash: HashToken(rawToken),
+		Hint:      rawToken[:len(claims.PersonalAccessTokenPrefix)+4],
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := s.db.Create(&token).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to store personal access token: %w", err)
+	}
+	return &token, rawToken, nil
+}
+
+// List returns the tokens of a user, newest first
+func (s *PersonalAccessTokenService) List(userID uuid.UUID) ([]models.PersonalAccessToken, error) {
+	var tokens []models.PersonalAccessToken
+	if err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Revoke revokes a token of a user. Services that cache introspection results accept it until their cache expires.
+func (s *PersonalAccessTokenService) Revoke(userID, tokenID uuid.UUID) (*models.PersonalAccessToken, error) {
+	var token models.PersonalAccessToken
+	if err := s.db.Where("id = ? AND user_id = ?", tokenID, userID).First(&token).Error; err != nil {
+		return nil, ErrInvalidPersonalAccessToken
+	}
+	if token.RevokedAt != nil {
+		return &token, nil
+	}
+
+	now := time.Now()
+	if err := s.db.Model(&token).Update("revoked_at", now).Error; err != nil {
+		return nil, err
+	}
+	token.RevokedAt = &now
+	return &token, nil
+}
+
+// Authenticate checks a raw token and returns it with its user. Tokens of deactivated users and
+// users who have to reset their password don't work. ip, if known, is recorded as where the token was last used.
+func (s *PersonalAccessTokenService) Authenticate(ctx context.Context, rawToken, ip string) (*models.PersonalAccessToken, *models.User, error) {
+	if !claims.IsPersonalAccessToken(rawToken) {
+		return nil, nil, ErrInvalidPersonalAccessToken
+	}
+
+	var token models.PersonalAccessToken
+	if err := s.db.WithContext(ctx).Where("token_hash = ?", HashToken(rawToken)).First(&token).Error; err != nil {
+		return nil, nil, ErrInvalidPersonalAccessToken
+	}
+	now := time.Now()
+	if !token.IsActive(now) {
+		return nil, nil, ErrInvalidPersonalAccessToken
+	}
+
+	var user models.User
+	if err := s.db.WithContext(ctx).First(&user, "id = ?", token.UserID).Error; err != nil {
+		return nil, nil, ErrInvalidPersonalAccessToken
+	}
+	if !user.IsActive() || user.PasswordResetRequired {
+		return nil, nil, ErrInvalidPersonalAccessToken
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > lastUsedInterval || ip != "" && ip != token.LastUsedIP {
+		updates := map[string]interface{}{"last_used_at": now}
+		if ip != "" {
+			updates["last_used_ip"] = ip
+		}
+		if err := s.db.WithContext(ctx).Model(&token).Updates(updates).Error; err != nil {
+			log.Printf("Failed to record use of personal access token %s: %v", token.ID, err)
+		}
+	}
+	return &token, &user, nil
+}
+
+// revokePersonalAccessTokens revokes every active token of a user
+func revokePersonalAccessTokens(db *gorm.DB, userID uuid.UUID) error {
+	return db.Model(&models.PersonalAccessToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
@@ -0,0 +1,476 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Routing keys of the privacy events. The CMS asks content-delivery to export or erase a user's
+// data with the request events and learns the outcome from the result events.
+const (
+	EventPrivacyExportRequested  = "privacy.request.export"
+	EventPrivacyErasureRequested = "privacy.request.erasure"
+	EventPrivacyExportReady      = "privacy.result.export_ready"
+	EventPrivacyExportFailed     = "privacy.result.export_failed"
+	EventPrivacyErasureCompleted = "privacy.result.erasure_completed"
+)
+
+// exportTimeout is how long a data export may stay pending before the user can request another one
+const exportTimeout = 24 * time.Hour
+
+// erasureRetryInterval is how long content-delivery has to report an erasure before it's asked again
+const erasureRetryInterval = 15 * time.Minute
+
+var (
+	// ErrExportInProgress is returned when the user already has a pending data export
+	ErrExportInProgress = errors.New("a data export is already in progress")
+	// ErrAdminErasure is returned when a platform admin tries to delete their own account
+	ErrAdminErasure = errors.New("platform admins can't delete their own account")
+)
+
+// DataExportRequest asks content-delivery to build a user's data export archive. Records holds the
+// CMS's part, one list or object per kind of record.
+type DataExportRequest struct {
+	ExportID       uuid.UUID              `json:"export_id"`
+	UserID         uuid.UUID              `json:"user_id"`
+	OrganizationID uuid.UUID              `json:"organization_id"`
+	Records        map[string]interface{} `json:"records"`
+}
+
+// DataExportResult is content-delivery's answer to a DataExportRequest
+type DataExportResult struct {
+	ExportID  uuid.UUID  `json:"export_id"`
+	SizeBytes int64      `json:"size_bytes,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// ErasureRequest asks content-delivery to erase a user's personal data
+type ErasureRequest struct {
+	DeletionID     uuid.UUID `json:"deletion_id"`
+	UserID         uuid.UUID `json:"user_id"`
+	OrganizationID uuid.UUID `json:"organization_id"`
+}
+
+// ErasureResult reports what a service erased for an ErasureRequest
+type ErasureResult struct {
+	DeletionID  uuid.UUID      `json:"deletion_id"`
+	Service     string         `json:"service"`
+	CompletedAt time.Time      `json:"completed_at"`
+	Erased      map[string]int `json:"erased,omitempty"`
+	Kept        map[string]int `json:"kept,omitempty"`
+}
+
+// PrivacyService exports and erases users' personal data, together with content-delivery
+type PrivacyService struct {
+	db      *gorm.DB
+	broker  *MessageBroker
+	tokens  *TokenService
+	mailer  Mailer
+	baseURL string
+}
+
+// NewPrivacyService creates a new privacy service. The receipt email of a deleted account links to
+// baseURL; without a mailer it isn't sent.
+func NewPrivacyService(db *gorm.DB, broker *MessageBroker, tokens *TokenService, mailer Mailer, baseURL string) *PrivacyService {
+	return &PrivacyService{
+		db:      db,
+		broker:  broker,
+		tokens:  tokens,
+		mailer:  mailer,
+		baseURL: baseURL,
+	}
+}
+
+// RequestExport collects the CMS's part of a user's data and asks content-delivery to add its part
+// and build the archive. A user can have one pending export at a time.
+func (s *PrivacyService) RequestExport(user models.User) (*models.DataExport, error) {
+	var pending int64
+	if err := s.db.Model(&models.DataExport{}).
+		Where("user_id = ? AND status = ? AND created_at > ?", user.ID, models.DataExportPending, time.Now().Add(-exportTimeout)).
+		Count(&pending).Error; err != nil {
+		return nil, err
+	}
+	if pending > 0 {
+		return nil, ErrExportInProgress
+	}
+
+	records, err := s.collectRecords(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect personal data: %v", err)
+	}
+
+	export := models.DataExport{UserID: user.ID, Status: models.DataExportPending}
+	if err := s.db.Create(&export).Error; err != nil {
+		return nil, err
+	}
+	PublishEvent(s.broker, EventPrivacyExportRequested, DataExportRequest{
+		ExportID:       export.ID,
+		UserID:         user.ID,
+		OrganizationID: user.OrganizationID,
+		Records:        records,
+	})
+	return &export, nil
+}
+
+// ListExports returns a user's data exports, newest first
+func (s *PrivacyService) ListExports(userID uuid.UUID) ([]models.DataExport, error) {
+	var exports []models.DataExport
+	err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&exports).Error
+	return exports, err
+}
+
+// collectRecords gathers everything the CMS knows about a user. Secrets like password hashes and
+// token hashes are left out by the models' JSON encoding.
+func (s *PrivacyService) collectRecords(user models.User) (map[string]interface{}, error) {
+	profile, err := NewProfileService(s.db).Get(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var identities []models.UserIdentity
+	var sessions []models.Session
+	var tokens []models.PersonalAccessToken
+	var auditLogs []models.AuditLog
+	queries := []struct {
+		dest  interface{}
+		query string
+	}{
+		{&identities, "user_id = ?"},
+		{&sessions, "user_id = ?"},
+		{&tokens, "user_id = ?"},
+		{&auditLogs, "actor_id = ?"},
+	}
+	for _, q := range queries {
+		if err := s.db.Where(q.query, user.ID).Order("created_at").Find(q.dest).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var enrollments []models.Enrollment
+	if err := s.db.Preload("Course").Where("user_id = ?", user.ID).Order("created_at").Find(&enrollments).Error; err != nil {
+		return nil, err
+	}
+	enrollmentRecords := make([]map[string]interface{}, 0, len(enrollments))
+	for _, enrollment := range enrollments {
+		enrollmentRecords = append(enrollmentRecords, map[string]interface{}{
+			"id":             enrollment.ID,
+			"course_id":      enrollment.CourseID,
+			"course_title":   enrollment.Course.Title,
+			"cohort_id":      enrollment.CohortID,
+			"status":         enrollment.Status,
+			"enrolled_at":    enrollment.EnrolledAt,
+			"completed_at":   enrollment.CompletedAt,
+			"last_access_at": enrollment.LastAccessAt,
+			"expires_at":     enrollment.ExpiresAt,
+			"progress":       enrollment.Progress,
+			"grade":          enrollment.Grade,
+		})
+	}
+
+	var orders []models.Order
+	if err := s.db.Preload("Course").Where("user_id = ?", user.ID).Order("created_at").Find(&orders).Error; err != nil {
+		return nil, err
+	}
+	orderRecords := make([]map[string]interface{}, 0, len(orders))
+	for _, order := range orders {
+		orderRecords = append(orderRecords, map[string]interface{}{
+			"id":             order.ID,
+			"course_id":      order.CourseID,
+			"course_title":   order.Course.Title,
+			"amount_cents":   order.AmountCents,
+			"discount_cents": order.DiscountCents,
+			"total_cents":    order.TotalCents,
+			"currency":       order.Currency,
+			"status":         order.Status,
+			"provider":       order.Provider,
+			"paid_at":        order.PaidAt,
+			"refunded_at":    order.RefundedAt,
+			"created_at":     order.CreatedAt,
+		})
+	}
+
+	var courses []models.Course
+	if err := s.db.Where("creator_id = ?", user.ID).Order("created_at").Find(&courses).Error; err != nil {
+		return nil, err
+	}
+	courseRecords := make([]map[string]interface{}, 0, len(courses))
+	for _, course := range courses {
+		courseRecords = append(courseRecords, map[string]interface{}{
+			"id":          course.ID,
+			"title":       course.Title,
+			"description": course.Description,
+			"price_cents": course.PriceCents,
+			"currency":    course.Currency,
+			"created_at":  course.CreatedAt,
+			"updated_at":  course.UpdatedAt,
+		})
+	}
+
+	var applications []models.InstructorApplication
+	if err := s.db.Where("user_id = ?", user.ID).Order("created_at").Find(&applications).Error; err != nil {
+		return nil, err
+	}
+	applicationRecords := make([]map[string]interface{}, 0, len(applications))
+	for _, application := range applications {
+		applicationRecords = append(applicationRecords, map[string]interface{}{
+			"id":             application.ID,
+			"motivation":     application.Motivation,
+			"attachments":    application.Attachments,
+			"status":         application.Status,
+			"review_comment": application.ReviewComment,
+			"reviewed_at":    application.ReviewedAt,
+			"created_at":     application.CreatedAt,
+		})
+	}
+
+	return map[string]interface{}{
+		"account":                 user,
+		"profile":                 profile,
+		"login_identities":        identities,
+		"sessions":                sessions,
+		"personal_access_tokens":  tokens,
+		"enrollments":             enrollmentRecords,
+		"orders":                  orderRecords,
+		"courses":                 courseRecords,
+		"instructor_applications": applicationRecords,
+		"activity":                auditLogs,
+	}, nil
+}
+
+// DeleteAccount erases a user's personal data in the CMS and asks content-delivery to erase
+// theirs. Records others depend on are kept: the user row stays, anonymized, so their orders
+// (kept for accounting) and the courses they created still have an owner; the audit log can't be
+// changed without breaking its hash chain, so its entries keep the user's ID and IP addresses. The
+// returned receipt completes once content-delivery reports back; until then RetryErasures asks again.
+func (s *PrivacyService) DeleteAccount(ctx context.Context, user models.User) (*models.AccountDeletion, error) {
+	if user.IsAdmin() {
+		return nil, ErrAdminErasure
+	}
+
+	now := time.Now()
+	deletion := models.AccountDeletion{
+		UserID:             user.ID,
+		Status:             models.AccountDeletionInProgress,
+		Steps:              map[string]models.ErasureStep{},
+		RequestedAt:        now,
+		RequestedErasureAt: &now,
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		erased := map[string]int{}
+		deletes := []struct {
+			kind  string
+			model interface{}
+			query string
+		}{
+			{"profile", &models.UserProfile{}, "user_id = ?"},
+			{"login_identities", &models.UserIdentity{}, "user_id = ?"},
+			{"sessions", &models.Session{}, "user_id = ?"},
+			{"refresh_tokens", &models.RefreshToken{}, "user_id = ?"},
+			{"personal_access_tokens", &models.PersonalAccessToken{}, "user_id = ?"},
+			{"account_tokens", &models.AccountToken{}, "user_id = ?"},
+			{"mfa_challenges", &models.MFAChallenge{}, "user_id = ?"},
+			{"recovery_codes", &models.RecoveryCode{}, "user_id = ?"},
+			{"enrollments", &models.Enrollment{}, "user_id = ?"},
+			{"instructor_applications", &models.InstructorApplication{}, "user_id = ?"},
+			{"data_exports", &models.DataExport{}, "user_id = ?"},
+		}
+		for _, d := range deletes {
+			result := tx.Where(d.query, user.ID).Delete(d.model)
+			if result.Error != nil {
+				return fmt.Errorf("failed to erase %s: %v", d.kind, result.Error)
+			}
+			erased[d.kind] = int(result.RowsAffected)
+		}
+		result := tx.Exec("DELETE FROM cohort_instructors WHERE user_id = ?", user.ID)
+		if result.Error != nil {
+			return fmt.Errorf("failed to erase cohort assignments: %v", result.Error)
+		}
+		erased["cohort_assignments"] = int(result.RowsAffected)
+
+		// Keep the row for the records that point to it, without anything that identifies the user
+		err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"email":                   fmt.Sprintf("deleted-%s@deleted.invalid", user.ID),
+			"name":                    "Deleted user",
+			"password":                "",
+			"email_verified":          false,
+			"email_verified_at":       nil,
+			"mfa_enabled":             false,
+			"mfa_secret":              "",
+			"deactivated_at":          now,
+			"password_reset_required": false,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to anonymize user: %v", err)
+		}
+
+		var orders, courses, auditEntries int64
+		tx.Model(&models.Order{}).Where("user_id = ?", user.ID).Count(&orders)
+		tx.Model(&models.Course{}).Where("creator_id = ?", user.ID).Count(&courses)
+		tx.Model(&models.AuditLog{}).Where("actor_id = ? OR target_id = ?", user.ID, user.ID.String()).Count(&auditEntries)
+		deletion.CompleteStep(models.ErasureServiceCMS, models.ErasureStep{
+			CompletedAt: &now,
+			Erased:      erased,
+			Kept: map[string]int{
+				"account":           1,
+				"orders":            int(orders),
+				"courses":           int(courses),
+				"audit_log_entries": int(auditEntries),
+			},
+		})
+		return tx.Create(&deletion).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The sessions are gone, so refreshing already fails; this rejects the access tokens right away
+	if s.tokens != nil {
+		if err := s.tokens.RevokeAllForUser(ctx, user.ID); err != nil {
+			log.Printf("Failed to revoke tokens of deleted user %s: %v", user.ID, err)
+		}
+	}
+	PublishEvent(s.broker, EventPrivacyErasureRequested, ErasureRequest{
+		DeletionID:     deletion.ID,
+		UserID:         user.ID,
+		OrganizationID: user.OrganizationID,
+	})
+	s.sendDeletionReceipt(ctx, user, deletion)
+
+	return &deletion, nil
+}
+
+// RetryErasures asks content-delivery again to erase the accounts it hasn't reported on within
+// erasureRetryInterval, since the request may have been lost on the way. Erasing twice is harmless.
+// It returns how many erasures were requested again.
+func (s *PrivacyService) RetryErasures(now time.Time) (int, error) {
+	var deletions []models.AccountDeletion
+	err := s.db.Where("status = ? AND (requested_erasure_at IS NULL OR requested_erasure_at < ?)",
+		models.AccountDeletionInProgress, now.Add(-erasureRetryInterval)).
+		Order("requested_at").Limit(100).Find(&deletions).Error
+	if err != nil {
+		return 0, err
+	}
+
+	retried := 0
+	for _, deletion := range deletions {
+		var user models.User
+		if err := s.db.Select("id", "organization_id").First(&user, "id = ?", deletion.UserID).Error; err != nil {
+			log.Printf("Failed to load the deleted user of deletion %s: %v", deletion.ID, err)
+			continue
+		}
+		if err := s.db.Model(&deletion).Update("requested_erasure_at", now).Error; err != nil {
+			return retried, err
+		}
+		PublishEvent(s.broker, EventPrivacyErasureRequested, ErasureRequest{
+			DeletionID:     deletion.ID,
+			UserID:         user.ID,
+			OrganizationID: user.OrganizationID,
+		})
+		retried++
+	}
+	return retried, nil
+}
+
+// sendDeletionReceipt emails the former user the link to their deletion receipt. It's the last
+// email sent to the address, which the CMS no longer knows afterwards.
+func (s *PrivacyService) sendDeletionReceipt(ctx context.Context, user models.User, deletion models.AccountDeletion) {
+	if s.mailer == nil {
+		return
+	}
+	err := s.mailer.Send(ctx, Email{
+		To:      user.Email,
+		Subject: "Your LearnVibe account was deleted",
+		Body: fmt.Sprintf("Hi %s,\n\nYour LearnVibe account and your personal data are being deleted, as you requested. "+
+			"The receipt below shows which data every part of LearnVibe erased, and when:\n\n%s/auth/deletions/%s\n\n"+
+			"We won't email you again.\n", user.Name, s.baseURL, deletion.ID),
+	})
+	if err != nil {
+		log.Printf("Failed to send the deletion receipt of user %s: %v", user.ID, err)
+	}
+}
+
+// GetDeletion returns the receipt of an account deletion
+func (s *PrivacyService) GetDeletion(id uuid.UUID) (*models.AccountDeletion, error) {
+	var deletion models.AccountDeletion
+	if err := s.db.First(&deletion, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &deletion, nil
+}
+
+// HandleEvent records the results content-delivery reports for data exports and account erasures
+func (s *PrivacyService) HandleEvent(payload MessagePayload) error {
+	// Data arrives as a generic map, so round-trip it into the result type
+	data, err := json.Marshal(payload.Data)
+	if err != nil {
+		return fmt.Errorf("failed to read privacy event: %v", err)
+	}
+
+	switch payload.EventType {
+	case EventPrivacyExportReady, EventPrivacyExportFailed:
+		var result DataExportResult
+		if err := json.Unmarshal(data, &result); err != nil {
+			return fmt.Errorf("failed to read privacy event: %v", err)
+		}
+		return s.completeExport(payload.EventType, result)
+	case EventPrivacyErasureCompleted:
+		var result ErasureResult
+		if err := json.Unmarshal(data, &result); err != nil {
+			return fmt.Errorf("failed to read privacy event: %v", err)
+		}
+		return s.completeErasure(result)
+	default:
+		log.Printf("Ignoring privacy event %q", payload.EventType)
+		return nil
+	}
+}
+
+// completeExport marks a data export ready or failed
+func (s *PrivacyService) completeExport(eventType string, result DataExportResult) error {
+	updates := map[string]interface{}{"status": models.DataExportFailed, "error": result.Error}
+	if eventType == EventPrivacyExportReady {
+		updates = map[string]interface{}{
+			"status":     models.DataExportReady,
+			"size_bytes": result.SizeBytes,
+			"ready_at":   time.Now(),
+			"expires_at": result.ExpiresAt,
+		}
+	}
+	// Exports of users deleted in the meantime are gone, there's nothing to update then
+	return s.db.Model(&models.DataExport{}).Where("id = ?", result.ExportID).Updates(updates).Error
+}
+
+// completeErasure records a service's erasure step on the deletion receipt
+func (s *PrivacyService) completeErasure(result ErasureResult) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var deletion models.AccountDeletion
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&deletion, "id = ?", result.DeletionID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Printf("Ignoring erasure result for unknown deletion %s", result.DeletionID)
+				return nil
+			}
+			return err
+		}
+		// A retried request is answered again, the first report is the one that counts
+		if deletion.Steps[result.Service].CompletedAt != nil {
+			return nil
+		}
+
+		deletion.CompleteStep(result.Service, models.ErasureStep{
+			CompletedAt: &result.CompletedAt,
+			Erased:      result.Erased,
+			Kept:        result.Kept,
+		})
+		return tx.Save(&deletion).Error
+	})
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxNameLength limits display names, which are shown next to every post and in course listings
+const maxNameLength = 100
+
+// ProfileError lists why a profile update was rejected, in words that can be shown to the user
+type ProfileError struct {
+	Problems []string
+}
+
+func (e *ProfileError) Error() string {
+	return "profile rejected: " + strings.Join(e.Problems, "; ")
+}
+
+// ProfileUpdate holds the profile fields to change; nil fields are left as they are
+type ProfileUpdate struct {
+	Name                    *string
+	Bio                     *string
+	Timezone                *string
+	Locale                  *string
+	NotificationPreferences *models.NotificationPreferences
+}
+
+// AvatarEvent is published by content-delivery when a user's avatar changes. A removed avatar has
+// no ID and no URLs.
+type AvatarEvent struct {
+	UserID    uuid.UUID         `json:"user_id"`
+	AvatarID  *uuid.UUID        `json:"avatar_id,omitempty"`
+	URLs      map[string]string `json:"urls,omitempty"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// ProfileService keeps the users' profiles
+type ProfileService struct {
+	db *gorm.DB
+}
+
+// NewProfileService creates a new profile service
+func NewProfileService(db *gorm.DB) *ProfileService {
+	return &ProfileService{db: db}
+}
+
+// Get returns a user's profile, or the default profile if they haven't set one up
+func (s *ProfileService) Get(userID uuid.UUID) (models.UserProfile, error) {
+	var profile models.UserProfile
+	err := s.db.First(&profile, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.DefaultUserProfile(userID), nil
+	}
+	return profile, err
+}
+
+// Update changes a user's name and profile. An invalid update returns a *ProfileError listing
+// every problem and changes nothing.
+func (s *ProfileService) Update(userID uuid.UUID, update ProfileUpdate) (*models.User, *models.UserProfile, error) {
+	if err := update.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	var user models.User
+	var profile models.UserProfile
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, "id = ?", userID).Error; err != nil {
+			return err
+		}
+		// Lock the profile so a concurrent avatar update isn't overwritten
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&profile, "user_id = ?", userID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			profile = models.DefaultUserProfile(userID)
+		} else if err != nil {
+			return err
+		}
+
+		if update.Name != nil && strings.TrimSpace(*update.Name) != user.Name {
+			user.Name = strings.TrimSpace(*update.Name)
+			if err := tx.Model(&user).Update("name", user.Name).Error; err != nil {
+				return err
+			}
+		}
+		if update.Bio != nil {
+			profile.Bio = strings.TrimSpace(*update.Bio)
+		}
+		if update.Timezone != nil {
+			profile.Timezone = *update.Timezone
+		}
+		if update.Locale != nil {
+			profile.Locale = *update.Locale
+		}
+		if update.NotificationPreferences != nil {
+			profile.NotificationPreferences = *update.NotificationPreferences
+		}
+		return tx.Save(&profile).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &user, &profile, nil
+}
+
+// validate checks the fields that are changed
+func (u ProfileUpdate) validate() error {
+	var problems []string
+	if u.Name != nil {
+		name := strings.TrimSpace(*u.Name)
+		if name == "" {
+			problems = append(problems, "Name can't be empty")
+		} else if utf8.RuneCountInString(name) > maxNameLength {
+			problems = append(problems, fmt.Sprintf("Name can be at most %d characters", maxNameLength))
+		}
+	}
+	if u.Bio != nil && utf8.RuneCountInString(strings.TrimSpace(*u.Bio)) > models.MaxBioLength {
+		problems = append(problems, fmt.Sprintf("Bio can be at most %d characters", models.MaxBioLength))
+	}
+	if u.Timezone != nil && !models.IsValidTimezone(*u.Timezone) {
+		problems = append(problems, "Timezone has to be an IANA timezone name, e.g. Europe/Berlin")
+	}
+	if u.Locale != nil && !models.IsValidLocale(*u.Locale) {
+		problems = append(problems, "Locale has to be a language tag, e.g. en or pt-BR")
+	}
+
+	if len(problems) > 0 {
+		return &ProfileError{Problems: problems}
+	}
+	return nil
+}
+
+// HandleAvatarEvent stores the avatar content-delivery reports for a user. Events can arrive out of
+// order, so an event older than the stored avatar is ignored.
+func (s *ProfileService) HandleAvatarEvent(payload MessagePayload) error {
+	// Data arrives as a generic map, so round-trip it into the event type
+	data, err := json.Marshal(payload.Data)
+	if err != nil {
+		return fmt.Errorf("failed to read avatar event: %v", err)
+	}
+
+	var event AvatarEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return fmt.Errorf("failed to read avatar event: %v", err)
+	}
+	if event.UserID == uuid.Nil {
+		return fmt.Errorf("avatar event without a user")
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// Users deleted in the meantime don't get a profile
+		var user models.User
+		if err := tx.Select("id").First(&user, "id = ?", event.UserID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		var profile models.UserProfile
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&profile, "user_id = ?", event.UserID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			profile = models.DefaultUserProfile(event.UserID)
+		} else if err != nil {
+			return err
+		}
+		if profile.AvatarUpdatedAt != nil && profile.AvatarUpdatedAt.After(event.UpdatedAt) {
+			return nil
+		}
+
+		profile.AvatarID = event.AvatarID
+		profile.AvatarURLs = event.URLs
+		profile.AvatarUpdatedAt = &event.UpdatedAt
+		return tx.Save(&profile).Error
+	})
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileUpdateValidate(t *testing.T) {
+	text := func(s string) *string { return &s }
+
+	assert.NoError(t, ProfileUpdate{}.validate())
+	assert.NoError(t, ProfileUpdate{
+		Name:     text("  Amara Okafor "),
+		Bio:      text(strings.Repeat("a", models.MaxBioLength)),
+		Timezone: text("Africa/Lagos"),
+		Locale:   text("en-NG"),
+	}.validate())
+
+	err := ProfileUpdate{
+		Name:     text("   "),
+		Bio:      text(strings.Repeat("a", models.MaxBioLength+1)),
+		Timezone: text("Lagos"),
+		Locale:   text("EN_ng"),
+	}.validate()
+	var profileErr *ProfileError
+	require.ErrorAs(t, err, &profileErr)
+	assert.Equal(t, []string{
+		"Name can't be empty",
+		"Bio can be at most 2000 characters",
+		"Timezone has to be an IANA timezone name, e.g. Europe/Berlin",
+		"Locale has to be a language tag, e.g. en or pt-BR",
+	}, profileErr.Problems)
+
+	err = ProfileUpdate{Name: text(strings.Repeat("n", maxNameLength+1))}.validate()
+	require.ErrorAs(t, err, &profileErr)
+	assert.Equal(t, []string{"Name can be at most 100 characters"}, profileErr.Problems)
+}
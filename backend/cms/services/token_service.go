@@ -0,0 +1,408 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"gorm.io/gorm"
+)
+
+// Refresh token errors
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+	ErrSessionNotFound     = errors.New("session not found")
+)
+
+// TokenPair is what clients get after logging in or refreshing
+type TokenPair struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+	FamilyID         uuid.UUID
+}
+
+// ClientInfo describes the client a refresh token was issued to
+type ClientInfo struct {
+	UserAgent string
+	IPAddress string
+}
+
+// TokenService issues short-lived access tokens and rotating refresh tokens
+type TokenService struct {
+	db          *gorm.DB
+	revocations *claims.RevocationList
+	keys        *KeySet
+	issuer      string
+	audience    string
+	accessTTL   time.Duration
+	refreshTTL  time.Duration
+}
+
+// NewTokenService creates a new token service
+func NewTokenService(db *gorm.DB, revocations *claims.RevocationList, keys *KeySet, issuer, audience string,
+	accessTTL, refreshTTL time.Duration) *TokenService {
+	return &TokenService{
+		db:          db,
+		revocations: revocations,
+		keys:        keys,
+		issuer:      issuer,
+		audience:    audience,
+		accessTTL:   accessTTL,
+		refreshTTL:  refreshTTL,
+	}
+}
+
+// IssueAccessToken signs a short-lived JWT for the user with the active signing key
+func (ts *TokenService) IssueAccessToken(user models.User) (string, time.Time, error) {
+	return ts.issueAccessToken(user, uuid.Nil)
+}
+
+// issueAccessToken signs an access token for the given session, or for no session if it's uuid.Nil
+func (ts *TokenService) issueAccessToken(user models.User, sessionID uuid.UUID) (string, time.Time, error) {
+	accessClaims := claims.NewAccessClaims(user.ID, user.Name, user.Email, string(user.Role), ts.issuer, ts.audience, ts.accessTTL)
+	accessClaims.EmailVerified = user.EmailVerified
+	accessClaims.OrganizationID = user.OrganizationID.String()
+	if sessionID != uuid.Nil {
+		accessClaims.SessionID = sessionID.String()
+	}
+
+	tokenString, err := ts.keys.Sign(accessClaims)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return tokenString, accessClaims.ExpiresAt.Time, nil
+}
+
+// IssueImpersonationToken signs an access token that lets an admin act as the target user. It
+// names the admin in the "act" claim, lasts ttl and can't be refreshed; read-only tokens only make
+// safe requests.
+func (ts *TokenService) IssueImpersonationToken(admin, target models.User, readOnly bool, ttl time.Duration) (string, *claims.AccessClaims, error) {
+	accessClaims := claims.NewAccessClaims(target.ID, target.Name, target.Email, string(target.Role), ts.issuer, ts.audience, ttl)
+	accessClaims.EmailVerified = target.EmailVerified
+	accessClaims.OrganizationID = target.OrganizationID.String()
+	accessClaims.Actor = &claims.Actor{Subject: admin.ID.String(), Email: admin.Email}
+	accessClaims.ReadOnly = readOnly
+
+	tokenString, err := ts.keys.Sign(accessClaims)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to sign token: %w", err)
+	}
+	return tokenString, accessClaims, nil
+}
+
+// IssueTokens starts a new session and refresh token family for the user, e.g. after a login
+func (ts *TokenService) IssueTokens(user models.User, client ClientInfo) (*TokenPair, error) {
+	var pair *TokenPair
+	err := ts.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		pair, err = ts.issue(tx, user, uuid.New(), client, nil)
+		return err
+	})
+	return pair, err
+}
+
+// Refresh exchanges a refresh token for a new token pair. The presented token is used up; if it
+// was already used (or revoked) the whole family is revoked because the token must have leaked.
+func (ts *TokenService) Refresh(ctx context.Context, rawToken string, client ClientInfo) (*TokenPair, error) {
+	var stored models.RefreshToken
+	if err := ts.db.Where("token_hash = ?", HashToken(rawToken)).First(&stored).Error; err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if stored.WasRotated() {
+		// A rotated token coming back means two parties hold it, so log everyone out
+		if err := ts.RevokeFamily(ctx, stored.FamilyID); err != nil {
+			return nil, err
+		}
+		log.Printf("Refresh token reuse detected for user %s, revoked family %s", stored.UserID, stored.FamilyID)
+		return nil, ErrRefreshTokenReused
+	}
+	if !stored.IsActive(time.Now()) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	var pair *TokenPair
+	var reused bool
+	err := ts.db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.First(&user, "id = ?", stored.UserID).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+		if !user.IsActive() || user.PasswordResetRequired {
+			return ErrInvalidRefreshToken
+		}
+
+		newID := uuid.New()
+
+		// Only one concurrent request can use the token, the other one is treated as reuse
+		now := time.Now()
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", stored.ID).
+			Updates(map[string]interface{}{"used_at": now, "replaced_by_id": newID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			reused = true
+			return ErrRefreshTokenReused
+		}
+
+		var err error
+		pair, err = ts.issue(tx, user, stored.FamilyID, client, &newID)
+		return err
+	})
+	if reused {
+		if err := ts.RevokeFamily(ctx, stored.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+// Revoke revokes the family of the given refresh token, e.g. on logout
+func (ts *TokenService) Revoke(ctx context.Context, rawToken string) error {
+	var stored models.RefreshToken
+	if err := ts.db.Where("token_hash = ?", HashToken(rawToken)).First(&stored).Error; err != nil {
+		return ErrInvalidRefreshToken
+	}
+	return ts.RevokeFamily(ctx, stored.FamilyID)
+}
+
+// RevokeFamily revokes every refresh token of a family and ends its session. The session's access
+// tokens are put on the revocation list, so the other services reject them right away.
+func (ts *TokenService) RevokeFamily(ctx context.Context, familyID uuid.UUID) error {
+	err := ts.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Model(&models.RefreshToken{}).
+			Where("family_id = ? AND revoked_at IS NULL", familyID).
+			Update("revoked_at", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.Session{}).
+			Where("id = ? AND revoked_at IS NULL", familyID).
+			Update("revoked_at", now).Error
+	})
+	if err != nil {
+		return err
+	}
+	if ts.revocations == nil {
+		return nil
+	}
+	// The family ID is the session ID access tokens carry
+	return ts.revocations.RevokeSession(ctx, familyID.String(), ts.accessTTL)
+}
+
+// ListSessions returns the sessions of a user that haven't been signed out or expired, most
+// recently used first
+func (ts *TokenService) ListSessions(userID uuid.UUID) ([]models.Session, error) {
+	var sessions []models.Session
+	err := ts.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession signs a user out of one of their sessions, see RevokeFamily
+func (ts *TokenService) RevokeSession(ctx context.Context, userID, sessionID uuid.UUID) error {
+	var session models.Session
+	if err := ts.db.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
+		return err
+	}
+
+	return ts.RevokeFamily(ctx, session.ID)
+}
+
+// RevokeSessions signs a user out of all their sessions except the given one, which may be uuid.Nil
+// to sign out everywhere. It returns how many sessions were signed out.
+func (ts *TokenService) RevokeSessions(ctx context.Context, userID, except uuid.UUID) (int, error) {
+	sessions, err := ts.ListSessions(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for _, session := range sessions {
+		if session.ID == except {
+			continue
+		}
+		if err := ts.RevokeSession(ctx, userID, session.ID); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+// RevokeAllForUser revokes every session, refresh token and personal access token of a user and
+// every access token issued to them so far
+func (ts *TokenService) RevokeAllForUser(ctx context.Context, userID uuid.UUID) error {
+	now := time.Now()
+	err := ts.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+	if err != nil {
+		return err
+	}
+	err = ts.db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+	if err != nil {
+		return err
+	}
+	if err := revokePersonalAccessTokens(ts.db, userID); err != nil {
+		return err
+	}
+
+	if ts.revocations == nil {
+		return nil
+	}
+	return ts.revocations.RevokeUser(ctx, userID.String(), ts.accessTTL)
+}
+
+// RevokeAccessTokensForUser revokes every access token issued to a user so far but keeps their
+// refresh tokens, so the next refresh picks up changes such as a new role without logging them out
+func (ts *TokenService) RevokeAccessTokensForUser(ctx context.Context, userID uuid.UUID) error {
+	if ts.revocations == nil {
+		return nil
+	}
+	return ts.revocations.RevokeUser(ctx, userID.String(), ts.accessTTL)
+}
+
+// RevokeAccessToken puts a still valid access token on the revocation list
+func (ts *TokenService) RevokeAccessToken(ctx context.Context, tokenString string) error {
+	if ts.revocations == nil {
+		return nil
+	}
+
+	accessClaims, err := ts.Verifier().Parse(tokenString)
+	if err != nil {
+		// Invalid or expired tokens don't need revoking
+		return nil
+	}
+	return ts.revocations.RevokeToken(ctx, accessClaims.ID, accessClaims.ExpiresAt.Time)
+}
+
+// Verifier returns the verifier for access tokens issued by this service
+func (ts *TokenService) Verifier() claims.Verifier {
+	return claims.Verifier{Keyfunc: ts.keys.Keyfunc, Issuer: ts.issuer, Audience: ts.audience}
+}
+
+// Keys returns the key set access tokens are signed with
+func (ts *TokenService) Keys() *KeySet {
+	return ts.keys
+}
+
+// AccessTTL returns how long access tokens are valid
+func (ts *TokenService) AccessTTL() time.Duration {
+	return ts.accessTTL
+}
+
+// RefreshTTL returns how long refresh tokens are valid
+func (ts *TokenService) RefreshTTL() time.Duration {
+	return ts.refreshTTL
+}
+
+// issue creates an access token and a new refresh token in the given family, and starts or
+// touches the session of the family
+func (ts *TokenService) issue(tx *gorm.DB, user models.User, familyID uuid.UUID, client ClientInfo, id *uuid.UUID) (*TokenPair, error) {
+	accessToken, accessExpiresAt, err := ts.issueAccessToken(user, familyID)
+	if err != nil {
+		return nil, err
+	}
+
+	rawToken, err := GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken := models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: HashToken(rawToken),
+		ExpiresAt: time.Now().Add(ts.refreshTTL),
+		UserAgent: client.UserAgent,
+		IPAddress: client.IPAddress,
+	}
+	if id != nil {
+		refreshToken.ID = *id
+	}
+	if err := tx.Create(&refreshToken).Error; err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	if err := touchSession(tx, user.ID, familyID, client, refreshToken.ExpiresAt); err != nil {
+		return nil, fmt.Errorf("failed to store session: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  accessExpiresAt,
+		RefreshToken:     rawToken,
+		RefreshExpiresAt: refreshToken.ExpiresAt,
+		FamilyID:         familyID,
+	}, nil
+}
+
+// touchSession records that a session was just used by the given client. Families started before
+// sessions were tracked get their session on their next refresh.
+func touchSession(tx *gorm.DB, userID, sessionID uuid.UUID, client ClientInfo, expiresAt time.Time) error {
+	now := time.Now()
+	result := tx.Model(&models.Session{}).
+		Where("id = ?", sessionID).
+		Updates(map[string]interface{}{
+			"last_seen_at": now,
+			"expires_at":   expiresAt,
+			"user_agent":   client.UserAgent,
+			"ip_address":   client.IPAddress,
+			"device":       models.DeviceName(client.UserAgent),
+		})
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+
+	return tx.Create(&models.Session{
+		ID:         sessionID,
+		UserID:     userID,
+		Device:     models.DeviceName(client.UserAgent),
+		UserAgent:  client.UserAgent,
+		IPAddress:  client.IPAddress,
+		LastSeenAt: now,
+		ExpiresAt:  expiresAt,
+	}).Error
+}
+
+// GenerateOpaqueToken returns a random URL-safe token of n random bytes
+func GenerateOpaqueToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex SHA-256 of a token, which is what gets stored in the database
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIssueAccessToken tests that access tokens are short-lived and carry a unique ID
+func TestIssueAccessToken(t *testing.T) {
+	key, err := GenerateSigningKey("test-key", claims.AlgorithmRS256)
+	assert.NoError(t, err)
+	keys := NewKeySet(key)
+	ts := NewTokenService(nil, nil, keys, claims.DefaultIssuer, claims.DefaultAudience, 15*time.Minute, 30*24*time.Hour)
+	user := models.User{ID: uuid.New(), Email: "test@example.com", Role: models.RoleStudent}
+
+	first, expiresAt, err := ts.IssueAccessToken(user)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), expiresAt, time.Minute)
+
+	token, _, err := jwt.NewParser().ParseUnverified(first, &claims.AccessClaims{})
+	assert.NoError(t, err)
+	assert.Equal(t, "test-key", token.Header["kid"])
+	assert.Equal(t, claims.AlgorithmRS256, token.Method.Alg())
+
+	accessClaims, err := ts.Verifier().Parse(first)
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, accessClaims.UserID())
+	assert.Equal(t, "student", accessClaims.Role)
+	assert.Equal(t, claims.Version, accessClaims.Version)
+	assert.Equal(t, claims.DefaultIssuer, accessClaims.Issuer)
+	assert.False(t, accessClaims.EmailVerified)
+	assert.NotEmpty(t, accessClaims.ID)
+
+	user.MarkEmailVerified(time.Now())
+	second, _, err := ts.IssueAccessToken(user)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	accessClaims, err = ts.Verifier().Parse(second)
+	assert.NoError(t, err)
+	assert.True(t, accessClaims.EmailVerified)
+}
+
+// TestHashToken tests that opaque tokens are random and only their hash is stored
+func TestHashToken(t *testing.T) {
+	a, err := GenerateOpaqueToken(32)
+	assert.NoError(t, err)
+	b, err := GenerateOpaqueToken(32)
+	assert.NoError(t, err)
+	assert.NotEqual(t, a, b)
+
+	assert.Len(t, HashToken(a), 64)
+	assert.Equal(t, HashToken(a), HashToken(a))
+	assert.NotEqual(t, HashToken(a), HashToken(b))
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHOTPVectors tests the code generation against the SHA-1 test vectors of RFC 6238
+func TestHOTPVectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, hotp(key, TOTPStep(time.Unix(tt.unix, 0)), 8))
+	}
+}
+
+// TestValidateTOTP tests that codes of neighbouring time steps are accepted and others aren't
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.NoError(t, err)
+	now := time.Now()
+
+	code, err := TOTPCode(secret, TOTPStep(now))
+	assert.NoError(t, err)
+	step, ok := ValidateTOTP(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, TOTPStep(now), step)
+
+	// One period of clock drift is fine
+	previous, _ := TOTPCode(secret, TOTPStep(now)-1)
+	step, ok = ValidateTOTP(secret, previous, now)
+	assert.True(t, ok)
+	assert.Equal(t, TOTPStep(now)-1, step)
+
+	// Older codes are rejected
+	old, _ := TOTPCode(secret, TOTPStep(now)-3)
+	_, ok = ValidateTOTP(secret, old, now)
+	assert.False(t, ok)
+
+	_, ok = ValidateTOTP(secret, "12345", now)
+	assert.False(t, ok)
+	_, ok = ValidateTOTP("not base32!", code, now)
+	assert.False(t, ok)
+}
+
+// TestTOTPProvisioningURI tests the URI authenticator apps scan
+func TestTOTPProvisioningURI(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.NoError(t, err)
+	_, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	assert.NoError(t, err)
+
+	uri := TOTPProvisioningURI("LearnVibe", "admin@example.com", secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/LearnVibe:admin@example.com?"))
+
+	parsed, err := url.Parse(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, secret, parsed.Query().Get("secret"))
+	assert.Equal(t, "LearnVibe", parsed.Query().Get("issuer"))
+	assert.Equal(t, "6", parsed.Query().Get("digits"))
+	assert.Equal(t, "30", parsed.Query().Get("period"))
+}
+
+// TestRecoveryCodes tests the recovery code format and how forgiving redeeming them is
+func TestRecoveryCodes(t *testing.T) {
+	code, err := GenerateRecoveryCode()
+	assert.NoError(t, err)
+	assert.Len(t, code, 11)
+	assert.Equal(t, "-", code[5:6])
+
+	other, err := GenerateRecoveryCode()
+	assert.NoError(t, err)
+	assert.NotEqual(t, code, other)
+
+	assert.Equal(t, code, normalizeRecoveryCode(strings.ToUpper(code)))
+	assert.Equal(t, code, normalizeRecoveryCode(" "+strings.ReplaceAll(code, "-", "")+" "))
+}
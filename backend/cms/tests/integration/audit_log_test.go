@@ -0,0 +1,90 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/controllers"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/middleware"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAuditLogChain records logins through the API, then tampers with the stored entries in the
+// ways someone with database access could and checks verification notices each of them
+func TestAuditLogChain(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := SetupTestDB()
+	cfg := GetTestConfig()
+
+	keys, _ := services.LoadKeySet("", "")
+	tokenService := services.NewTokenService(db, nil, keys, "", "", 15*time.Minute, 30*24*time.Hour)
+	mfaService := services.NewMFAService(db, "LearnVibe", 5*time.Minute)
+	audit := services.NewAuditService(db)
+	authController := controllers.NewAuthController(db, cfg, tokenService, nil, nil, mfaService, nil, nil, nil, nil, audit)
+
+	router := gin.New()
+	router.Use(middleware.RequestID())
+	router.POST("/auth/register", authController.Register)
+	router.POST("/auth/login", authController.Login)
+
+	send := func(path string, body map[string]string) {
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(middleware.RequestIDHeader, "audit-test")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	credentials := map[string]string{"name": "Audited User", "email": "audited@example.com", "password": "testpassword123"}
+
+	// Step 1: a registration, a failed login and a login are recorded in order
+	send("/auth/register", credentials)
+	send("/auth/login", map[string]string{"email": credentials["email"], "password": "wrong-password"})
+	send("/auth/login", credentials)
+
+	logs, total, err := audit.List(services.AuditFilter{RequestID: "audit-test"}, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), total)
+	assert.Equal(t, "auth.login", logs[0].Action)
+	assert.Equal(t, "auth.login_failed", logs[1].Action)
+	assert.Equal(t, "wrong_password", logs[1].Details["reason"])
+	assert.Equal(t, "register", logs[2].Details["method"])
+
+	verification, err := audit.Verify("")
+	require.NoError(t, err)
+	require.True(t, verification.Valid, verification.Error)
+	assert.Equal(t, int64(3), verification.Entries)
+	head := verification.HeadHash
+
+	// Step 2: editing an entry is noticed at that entry
+	require.NoError(t, db.Exec("UPDATE audit_logs SET ip_address = '198.51.100.1' WHERE sequence = 2").Error)
+	verification, err = audit.Verify("")
+	require.NoError(t, err)
+	assert.False(t, verification.Valid)
+	assert.Equal(t, int64(2), verification.BrokenAt)
+	require.NoError(t, db.Exec("UPDATE audit_logs SET ip_address = ? WHERE sequence = 2", logs[1].IPAddress).Error)
+
+	// Step 3: deleting the newest entry leaves a valid chain, but not one with the earlier head
+	require.NoError(t, db.Where("sequence = 3").Delete(&models.AuditLog{}).Error)
+	verification, err = audit.Verify("")
+	require.NoError(t, err)
+	assert.True(t, verification.Valid)
+	verification, err = audit.Verify(head)
+	require.NoError(t, err)
+	assert.False(t, verification.Valid)
+
+	// Step 4: deleting an entry in the middle is noticed where the gap is
+	require.NoError(t, db.Where("sequence = 1").Delete(&models.AuditLog{}).Error)
+	verification, err = audit.Verify("")
+	require.NoError(t, err)
+	assert.False(t, verification.Valid)
+	assert.Equal(t, int64(2), verification.BrokenAt)
+}
@@ -6,10 +6,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/controllers"
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/middleware"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -21,7 +23,12 @@ func TestAuthenticationFlow(t *testing.T) {
 	cfg := GetTestConfig()
 
 	// Create controllers
-	authController := controllers.NewAuthController(db, cfg)
+	keys, _ := services.LoadKeySet("", "")
+	tokenService := services.NewTokenService(db, nil, keys, "", "", 15*time.Minute, 30*24*time.Hour)
+	accountService := services.NewAccountService(db, services.NewOutboxMailer(t.TempDir(), cfg.MailFrom),
+		tokenService, nil, cfg.AppBaseURL, 48*time.Hour, time.Hour)
+	mfaService := services.NewMFAService(db, "LearnVibe", 5*time.Minute)
+	authController := controllers.NewAuthController(db, cfg, tokenService, accountService, nil, mfaService, nil, nil, nil, nil, nil)
 
 	// Create router
 	router := gin.New()
@@ -30,7 +37,7 @@ func TestAuthenticationFlow(t *testing.T) {
 	// Register routes
 	router.POST("/auth/register", authController.Register)
 	router.POST("/auth/login", authController.Login)
-	router.GET("/auth/profile", middleware.AuthMiddleware(cfg.JWTSecret), authController.GetCurrentUser)
+	router.GET("/auth/profile", middleware.AuthMiddleware(tokenService.Verifier(), nil, nil), authController.GetCurrentUser)
 
 	// Step 1: Register a new user
 	t.Run("Register user", func(t *testing.T) {
@@ -7,13 +7,16 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/config"
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/controllers"
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/routes"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -44,10 +47,14 @@ func setupTestDB() (*gorm.DB, error) {
 	}
 
 	// Migrate the test database
-	err = db.AutoMigrate(&models.User{}, &models.Course{}, &models.Enrollment{})
+	err = db.AutoMigrate(&models.Organization{}, &models.User{}, &models.Course{}, &models.Enrollment{}, &models.RefreshToken{}, &models.Session{}, &models.AccountToken{},
+		&models.MFAChallenge{}, &models.RecoveryCode{}, &models.MFAPolicy{})
 	if err != nil {
 		return nil, err
 	}
+	if err := models.EnsureDefaultOrganization(db); err != nil {
+		return nil, err
+	}
 
 	return db, nil
 }
@@ -59,12 +66,22 @@ func setupTestRouter(db *gorm.DB, cfg *config.Config) *gin.Engine {
 
 	// Initialize controllers
 	courseController := controllers.NewCourseController(db)
-	authController := controllers.NewAuthController(db, cfg)
-	enrollmentController := controllers.NewEnrollmentController(db)
+	keys, _ := services.LoadKeySet("", "")
+	tokenService := services.NewTokenService(db, nil, keys, "", "", 15*time.Minute, 30*24*time.Hour)
+	accountService := services.NewAccountService(db, services.NewOutboxMailer(filepath.Join(os.TempDir(), "learnvibe-outbox"), cfg.MailFrom),
+		tokenService, nil, cfg.AppBaseURL, 48*time.Hour, time.Hour)
+	mfaService := services.NewMFAService(db, "LearnVibe", 5*time.Minute)
+	authController := controllers.NewAuthController(db, cfg, tokenService, accountService, nil, mfaService, nil, nil, nil, nil, nil)
+	enrollmentController := controllers.NewEnrollmentController(db, nil, "")
+	cohortController := controllers.NewCohortController(db, nil)
+	paymentController := controllers.NewPaymentController(db, nil)
 	healthController := controllers.NewTestHealthController()
 
 	// Setup routes
-	routes.SetupRoutes(router, courseController, authController, enrollmentController, healthController, cfg)
+	auditService := services.NewAuditService(db)
+	adminController := controllers.NewAdminController(db, tokenService, accountService, auditService)
+	routes.SetupRoutes(router, courseController, authController, controllers.NewMFAController(db, mfaService),
+		controllers.NewPersonalAccessTokenController(db, services.NewPersonalAccessTokenService(db), ""), controllers.NewSessionController(db, tokenService), adminController, controllers.NewOrganizationController(db, tokenService), controllers.NewInstructorApplicationController(db, tokenService, nil), controllers.NewProfileController(db, nil), controllers.NewPrivacyController(db, services.NewPrivacyService(db, nil, tokenService, nil, ""), authController), enrollmentController, cohortController, paymentController, controllers.NewLTIController(db, cfg, services.NewLTIService(db, keys, nil), authController, nil), healthController, tokenService.Verifier(), nil, nil, auditService, cfg)
 
 	return router
 }
@@ -74,9 +91,7 @@ func TestMain(m *testing.M) {
 	var err error
 
 	// Load test configuration
-	testConfig = &config.Config{
-		JWTSecret: "integration-test-secret",
-	}
+	testConfig = &config.Config{}
 
 	// Setup test database
 	testDB, err = setupTestDB()
@@ -0,0 +1,158 @@
+package integration
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/controllers"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/middleware"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services/ltitest"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/policy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLTILaunchFlow launches a course from a simulated LMS: the learner is provisioned, enrolled
+// and logged in, and the grade an instructor gives them is sent back to the LMS
+func TestLTILaunchFlow(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := SetupTestDB()
+	cfg := GetTestConfig()
+	cfg.AppBaseURL = "http://app.example.com"
+
+	keys, _ := services.LoadKeySet("", "")
+	tokenService := services.NewTokenService(db, nil, keys, "", "", 15*time.Minute, 30*24*time.Hour)
+	authController := controllers.NewAuthController(db, cfg, tokenService, nil, nil, nil, nil, nil, nil, nil, nil)
+	ltiService := services.NewLTIService(db, keys, nil)
+	ltiController := controllers.NewLTIController(db, cfg, ltiService, authController, nil)
+	cohortController := controllers.NewCohortController(db, ltiService)
+
+	router := gin.New()
+	router.GET("/lti/login", ltiController.Login)
+	router.POST("/lti/launch", ltiController.Launch)
+	router.PUT("/api/enrollments/:id/grade", middleware.AuthMiddleware(tokenService.Verifier(), nil, nil),
+		middleware.RequirePermission(policy.CohortTeach), cohortController.SetEnrollmentGrade)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	cfg.LTIToolURL = server.URL
+
+	// The LMS, registered with the tool; it trusts the keys of our JWKS
+	lms := ltitest.NewPlatform("learnvibe-tool", "deployment-1")
+	defer lms.Close()
+	lms.ToolKeyfunc = keys.Keyfunc
+	require.NoError(t, db.Create(&models.LTIPlatform{
+		Name:          "University Moodle",
+		Issuer:        lms.Issuer(),
+		ClientID:      lms.ClientID,
+		DeploymentIDs: []string{lms.DeploymentID},
+		AuthLoginURL:  lms.AuthLoginURL(),
+		AuthTokenURL:  lms.AuthTokenURL(),
+		KeySetURL:     lms.KeySetURL(),
+	}).Error)
+
+	instructor := models.User{Name: "Course Author", Email: "author@example.com", Role: models.RoleInstructor, EmailVerified: true}
+	require.NoError(t, db.Create(&instructor).Error)
+	course := models.Course{Title: "Algorithms", CreatorID: instructor.ID}
+	require.NoError(t, db.Create(&course).Error)
+
+	launch := ltitest.Launch{
+		User:           ltitest.User{Subject: "moodle-42", Email: "lti.learner@example.com", Name: "LTI Learner"},
+		ResourceLinkID: "link-1",
+		ContextID:      "moodle-course-7",
+		Custom:         map[string]string{"course_id": strconv.Itoa(course.ID)},
+		Grades:         true,
+	}
+
+	// Step 1: the first launch provisions and enrolls the learner and sends them to the course, logged in
+	resp, err := lms.Launch(server.Client(), server.URL+"/lti/login", server.URL+"/lti/launch", launch)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "http://app.example.com/courses/"+strconv.Itoa(course.ID), resp.Header.Get("Location"))
+	loggedIn := false
+	for _, cookie := range resp.Cookies() {
+		loggedIn = loggedIn || cookie.Name == "auth_token" && cookie.Value != ""
+	}
+	assert.True(t, loggedIn)
+
+	var learner models.User
+	require.NoError(t, db.Where("email = ?", "lti.learner@example.com").First(&learner).Error)
+	assert.Equal(t, models.RoleStudent, learner.Role)
+	assert.True(t, learner.EmailVerified)
+	var enrollment models.Enrollment
+	require.NoError(t, db.Where("user_id = ? AND course_id = ?", learner.ID, course.ID).First(&enrollment).Error)
+	assert.Equal(t, models.EnrollmentStatusActive, enrollment.Status)
+
+	// Step 2: launching again logs the same user in without enrolling them twice
+	resp, err = lms.Launch(server.Client(), server.URL+"/lti/login", server.URL+"/lti/launch", launch)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	var count int64
+	db.Model(&models.User{}).Where("email = ?", "lti.learner@example.com").Count(&count)
+	assert.Equal(t, int64(1), count)
+	db.Model(&models.Enrollment{}).Where("user_id = ?", learner.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// Step 3: the instructor's grade is posted to the LMS line item of the link
+	token, _, err := tokenService.IssueAccessToken(instructor)
+	require.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodPut, server.URL+"/api/enrollments/"+enrollment.ID.String()+"/grade",
+		bytes.NewReader([]byte(`{"grade": 91}`)))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = server.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	require.Eventually(t, func() bool { return len(lms.Scores()) == 1 }, 5*time.Second, 50*time.Millisecond)
+	score := lms.Scores()[0]
+	assert.Equal(t, lms.LineItemURL("link-1"), score.LineItem)
+	assert.Equal(t, "moodle-42", score.UserID)
+	assert.Equal(t, float64(91), score.ScoreGiven)
+	assert.Equal(t, float64(100), score.ScoreMaximum)
+
+	// Step 4: instructors of the LMS course are provisioned as instructors and not enrolled
+	teacher := launch
+	teacher.User = ltitest.User{Subject: "moodle-43", Email: "lti.teacher@example.com", Name: "LTI Teacher"}
+	teacher.Roles = []string{ltitest.RoleInstructor}
+	resp, err = lms.Launch(server.Client(), server.URL+"/lti/login", server.URL+"/lti/launch", teacher)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	var provisioned models.User
+	require.NoError(t, db.Where("email = ?", "lti.teacher@example.com").First(&provisioned).Error)
+	assert.Equal(t, models.RoleInstructor, provisioned.Role)
+	db.Model(&models.Enrollment{}).Where("user_id = ?", provisioned.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	// Step 5: a link to a course of another organization doesn't launch
+	other := models.Organization{Name: "Other School", Slug: "other-school"}
+	require.NoError(t, db.Create(&other).Error)
+	foreign := models.Course{Title: "Not yours", CreatorID: instructor.ID, OrganizationID: other.ID}
+	require.NoError(t, db.Create(&foreign).Error)
+	stray := launch
+	stray.ResourceLinkID = "link-2"
+	stray.Custom = map[string]string{"course_id": strconv.Itoa(foreign.ID)}
+	resp, err = lms.Launch(server.Client(), server.URL+"/lti/login", server.URL+"/lti/launch", stray)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Step 6: an existing LearnVibe account isn't taken over by a launch with its email
+	impostor := launch
+	impostor.User = ltitest.User{Subject: "moodle-44", Email: "author@example.com", Name: "Not the author"}
+	resp, err = lms.Launch(server.Client(), server.URL+"/lti/login", server.URL+"/lti/launch", impostor)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+}
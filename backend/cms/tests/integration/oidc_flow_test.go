@@ -0,0 +1,189 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/controllers"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestOIDCLoginFlow logs in through a mock OpenID Connect provider, from the login redirect to the
+// token cookies
+func TestOIDCLoginFlow(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := SetupTestDB()
+	cfg := GetTestConfig()
+
+	provider := oidctest.NewServer("learnvibe", "secret")
+	defer provider.Close()
+	provider.User.Email = "oidc-user@example.com"
+
+	registry := services.NewOIDCRegistry(services.NewOIDCProvider(services.OIDCProviderConfig{
+		Name:         "mock",
+		DisplayName:  "Mock",
+		Issuer:       provider.Issuer(),
+		ClientID:     provider.ClientID,
+		ClientSecret: provider.ClientSecret,
+		RedirectURL:  "http://localhost:8080/auth/mock/callback",
+	}, provider.Client()))
+
+	keys, _ := services.LoadKeySet("", "")
+	tokenService := services.NewTokenService(db, nil, keys, "", "", 15*time.Minute, 30*24*time.Hour)
+	mfaService := services.NewMFAService(db, "LearnVibe", 5*time.Minute)
+	authController := controllers.NewAuthController(db, cfg, tokenService, nil, nil, mfaService, nil, registry,
+		services.NewIdentityService(db), nil, nil)
+
+	router := gin.New()
+	router.GET("/auth/:provider/login", authController.ProviderLogin)
+	router.GET("/auth/:provider/callback", authController.ProviderCallback)
+
+	// Step 1: the login endpoint redirects to the provider
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/mock/login?return_to=/dashboard", nil))
+	require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+	// Step 2: the provider logs the user in, and the callback redeems the code and logs them into the CMS
+	stateCookies := w.Result().Cookies()
+	w, callbackURL := followProvider(t, router, provider, w.Header().Get("Location"), stateCookies)
+	require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "/dashboard", w.Header().Get("Location"))
+
+	cookies := map[string]string{}
+	for _, cookie := range w.Result().Cookies() {
+		cookies[cookie.Name] = cookie.Value
+	}
+	assert.NotEmpty(t, cookies["auth_token"])
+	assert.NotEmpty(t, cookies["refresh_token"])
+
+	var user models.User
+	require.NoError(t, db.First(&user, "email = ?", "oidc-user@example.com").Error)
+	assert.True(t, user.EmailVerified)
+
+	// The state is single-use, so the callback can't be replayed
+	w = httptest.NewRecorder()
+	replay := httptest.NewRequest(http.MethodGet, callbackURL, nil)
+	for _, cookie := range stateCookies {
+		replay.AddCookie(cookie)
+	}
+	router.ServeHTTP(w, replay)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+// TestOIDCIdentityLinking checks that a provider login can't take over a password account with the
+// same email, and that the account owner can link and unlink the provider
+func TestOIDCIdentityLinking(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := SetupTestDB()
+	cfg := GetTestConfig()
+
+	provider := oidctest.NewServer("learnvibe", "secret")
+	defer provider.Close()
+	provider.User.Subject = "linked-subject"
+	provider.User.Email = "linking@example.com"
+
+	registry := services.NewOIDCRegistry(services.NewOIDCProvider(services.OIDCProviderConfig{
+		Name:         "mock",
+		Issuer:       provider.Issuer(),
+		ClientID:     provider.ClientID,
+		ClientSecret: provider.ClientSecret,
+		RedirectURL:  "http://localhost:8080/auth/mock/callback",
+	}, provider.Client()))
+
+	keys, _ := services.LoadKeySet("", "")
+	tokenService := services.NewTokenService(db, nil, keys, "", "", 15*time.Minute, 30*24*time.Hour)
+	mfaService := services.NewMFAService(db, "LearnVibe", 5*time.Minute)
+	authController := controllers.NewAuthController(db, cfg, tokenService, nil, nil, mfaService, nil, registry,
+		services.NewIdentityService(db), nil, nil)
+
+	owner := models.User{Email: "linking@example.com", Name: "Owner", Role: models.RoleStudent}
+	owner.MarkEmailVerified(time.Now())
+	require.NoError(t, owner.SetPassword("ownerpassword123"))
+	require.NoError(t, db.Create(&owner).Error)
+
+	// Stands in for the auth middleware
+	loggedIn := func(c *gin.Context) { c.Set("userID", owner.ID) }
+
+	router := gin.New()
+	router.GET("/auth/:provider/login", authController.ProviderLogin)
+	router.GET("/auth/:provider/callback", authController.ProviderCallback)
+	router.GET("/auth/identities", loggedIn, authController.GetIdentities)
+	router.POST("/auth/identities/:provider", loggedIn, authController.LinkIdentity)
+	router.DELETE("/auth/identities/:id", loggedIn, authController.UnlinkIdentity)
+
+	// A provider login with the owner's email doesn't get into their account
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/mock/login", nil))
+	w, _ = followProvider(t, router, provider, w.Header().Get("Location"), w.Result().Cookies())
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "link_required")
+
+	// The owner links the provider from their session
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/identities/mock?return_to=/settings", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var link map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &link))
+	w, _ = followProvider(t, router, provider, link["url"], w.Result().Cookies())
+	require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "/settings?linked=mock", w.Header().Get("Location"))
+
+	// Now the provider login gets into the owner's account
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/mock/login", nil))
+	w, _ = followProvider(t, router, provider, w.Header().Get("Location"), w.Result().Cookies())
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+	var identity models.UserIdentity
+	require.NoError(t, db.First(&identity, "provider = ? AND subject = ?", "mock", "linked-subject").Error)
+	assert.Equal(t, owner.ID, identity.UserID)
+
+	// The owner still has their password, so the provider can be unlinked
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/auth/identities/"+identity.ID.String(), nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// An account without a password can't unlink its only identity
+	provider.User = oidctest.User{Subject: "social-only", Email: "social-only@example.com", EmailVerified: true}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/mock/login", nil))
+	_, _ = followProvider(t, router, provider, w.Header().Get("Location"), w.Result().Cookies())
+	var socialOnly models.UserIdentity
+	require.NoError(t, db.First(&socialOnly, "subject = ?", "social-only").Error)
+	owner.ID = socialOnly.UserID
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/auth/identities/"+socialOnly.ID.String(), nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+// followProvider goes through the mock provider's authorization endpoint and returns the response
+// of the CMS callback with the callback URL. cookies are the ones set when the login started.
+func followProvider(t *testing.T, router *gin.Engine, provider *oidctest.Server, authURL string, cookies []*http.Cookie) (*httptest.ResponseRecorder, string) {
+	client := provider.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Get(authURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	router.ServeHTTP(w, req)
+	return w, callback.RequestURI()
+}
@@ -0,0 +1,167 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/controllers"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/middleware"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/policy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestOrganizationIsolation sets up two schools and checks that nobody in one of them, including
+// its org admin, can read or change the other's courses, enrollments or users
+func TestOrganizationIsolation(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := SetupTestDB()
+
+	keys, _ := services.LoadKeySet("", "")
+	tokenService := services.NewTokenService(db, nil, keys, "", "", 15*time.Minute, 30*24*time.Hour)
+	courseController := controllers.NewCourseController(db)
+	enrollmentController := controllers.NewEnrollmentController(db, nil, "")
+	cohortController := controllers.NewCohortController(db, nil)
+	organizationController := controllers.NewOrganizationController(db, tokenService)
+
+	router := gin.New()
+	api := router.Group("/api", middleware.AuthMiddleware(tokenService.Verifier(), nil, nil))
+	api.GET("/courses", courseController.GetCourses)
+	api.GET("/courses/:id", courseController.GetCourse)
+	api.POST("/courses", middleware.RequirePermission(policy.CourseCreate), courseController.CreateCourse)
+	api.PUT("/courses/:id", middleware.RequirePermission(policy.CourseUpdate), courseController.UpdateCourse)
+	api.POST("/courses/:id/enroll", enrollmentController.EnrollInCourse)
+	api.GET("/courses/:id/enrollments", middleware.RequirePermission(policy.CourseReadEnrollments), enrollmentController.GetCourseEnrollments)
+	api.GET("/courses/:id/cohorts", cohortController.GetCourseCohorts)
+	api.GET("/organization", organizationController.GetOrganization)
+	api.GET("/organization/users", middleware.RequirePermission(policy.OrganizationManage), organizationController.ListUsers)
+	api.PUT("/organization/users/:id/role", middleware.RequirePermission(policy.OrganizationManage), organizationController.ChangeRole)
+
+	// Two schools, each with an org admin, an instructor and a student
+	type school struct {
+		organization                  models.Organization
+		orgAdmin, instructor, student string
+		studentID                     string
+		course                        models.Course
+	}
+	newSchool := func(slug string) *school {
+		s := &school{organization: models.Organization{Name: slug, Slug: slug}}
+		require.NoError(t, db.Create(&s.organization).Error)
+
+		token := func(role models.Role) (string, models.User) {
+			user := models.User{Email: string(role) + "@" + slug + ".example.com", Name: string(role), Role: role,
+				OrganizationID: s.organization.ID}
+			user.MarkEmailVerified(time.Now())
+			require.NoError(t, db.Create(&user).Error)
+			accessToken, _, err := tokenService.IssueAccessToken(user)
+			require.NoError(t, err)
+			return accessToken, user
+		}
+		s.orgAdmin, _ = token(models.RoleOrgAdmin)
+		var instructor, student models.User
+		s.instructor, instructor = token(models.RoleInstructor)
+		s.student, student = token(models.RoleStudent)
+		s.studentID = student.ID.String()
+
+		s.course = models.Course{Title: slug + " course", CreatorID: instructor.ID, OrganizationID: s.organization.ID}
+		require.NoError(t, db.Create(&s.course).Error)
+		return s
+	}
+	north := newSchool("north-school")
+	south := newSchool("south-school")
+
+	request := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	coursePath := func(course models.Course, suffix string) string {
+		return fmt.Sprintf("/api/courses/%d%s", course.ID, suffix)
+	}
+
+	// Step 1: each school only lists its own courses
+	for _, s := range []*school{north, south} {
+		w := request(http.MethodGet, "/api/courses?pageSize=100", s.student, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		var courses []models.Course
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &courses))
+		require.Len(t, courses, 1)
+		assert.Equal(t, s.course.ID, courses[0].ID)
+	}
+
+	// Step 2: the other school's course doesn't exist for anyone of this school, org admins included
+	for _, token := range []string{north.student, north.instructor, north.orgAdmin} {
+		assert.Equal(t, http.StatusNotFound, request(http.MethodGet, coursePath(south.course, ""), token, nil).Code)
+		assert.Equal(t, http.StatusNotFound, request(http.MethodPut, coursePath(south.course, ""), token,
+			map[string]string{"title": "Taken over"}).Code)
+		assert.Equal(t, http.StatusNotFound, request(http.MethodPost, coursePath(south.course, "/enroll"), token, nil).Code)
+		assert.Equal(t, http.StatusNotFound, request(http.MethodGet, coursePath(south.course, "/cohorts"), token, nil).Code)
+	}
+	assert.Equal(t, http.StatusNotFound, request(http.MethodGet, coursePath(south.course, "/enrollments"), north.orgAdmin, nil).Code)
+
+	var unchanged models.Course
+	require.NoError(t, db.First(&unchanged, south.course.ID).Error)
+	assert.Equal(t, "south-school course", unchanged.Title)
+
+	// Step 3: the org admin manages their own school's courses
+	w := request(http.MethodPut, coursePath(north.course, ""), north.orgAdmin, map[string]string{"title": "Renamed"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, coursePath(north.course, "/enrollments"), north.orgAdmin, nil).Code)
+
+	// Step 4: created courses belong to the creator's school, whatever the request says
+	w = request(http.MethodPost, "/api/courses", north.instructor, map[string]interface{}{
+		"title":           "Smuggled",
+		"organization_id": south.organization.ID,
+	})
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created models.Course
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, north.organization.ID, created.OrganizationID)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodGet, coursePath(created, ""), south.student, nil).Code)
+
+	// Step 5: org admins only see and manage the users of their school
+	w = request(http.MethodGet, "/api/organization/users", north.orgAdmin, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var listed struct {
+		Users []models.User `json:"users"`
+		Total int64         `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	assert.Equal(t, int64(3), listed.Total)
+	for _, user := range listed.Users {
+		assert.Equal(t, north.organization.ID, user.OrganizationID)
+	}
+
+	w = request(http.MethodPut, "/api/organization/users/"+south.studentID+"/role", north.orgAdmin,
+		map[string]string{"role": "instructor"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = request(http.MethodPut, "/api/organization/users/"+north.studentID+"/role", north.orgAdmin,
+		map[string]string{"role": "admin"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = request(http.MethodPut, "/api/organization/users/"+north.studentID+"/role", north.orgAdmin,
+		map[string]string{"role": "instructor"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Students and instructors can't manage their school
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/api/organization/users", north.instructor, nil).Code)
+
+	// Step 6: everyone sees their own organization
+	w = request(http.MethodGet, "/api/organization", south.student, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var organization models.Organization
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &organization))
+	assert.Equal(t, south.organization.ID, organization.ID)
+}
@@ -0,0 +1,125 @@
+package integration
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/controllers"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/middleware"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPasswordChangeFlow registers with a password the policy rejects and then with a good one,
+// changes it on one device and checks the other device was signed out
+func TestPasswordChangeFlow(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := SetupTestDB()
+	cfg := GetTestConfig()
+
+	breachedHash := sha1.Sum([]byte("Summer2024!"))
+	breached, err := services.ReadBreachedPasswords(strings.NewReader(strings.ToUpper(hex.EncodeToString(breachedHash[:])) + ":4211\n"))
+	require.NoError(t, err)
+	passwords := services.NewPasswordPolicy(services.PasswordPolicyConfig{MinLength: 10, MinCharacterClasses: 3}, breached)
+
+	keys, _ := services.LoadKeySet("", "")
+	revocations := claims.NewRevocationList(nil, time.Minute, false)
+	tokenService := services.NewTokenService(db, revocations, keys, "", "", 15*time.Minute, 30*24*time.Hour)
+	accountService := services.NewAccountService(db, services.NewOutboxMailer(t.TempDir(), cfg.MailFrom),
+		tokenService, passwords, cfg.AppBaseURL, 48*time.Hour, time.Hour)
+	authController := controllers.NewAuthController(db, cfg, tokenService, accountService, passwords, nil, nil, nil, nil, nil, nil)
+
+	router := gin.New()
+	router.POST("/auth/register", authController.Register)
+	router.POST("/auth/login", authController.Login)
+	router.PUT("/auth/password", middleware.AuthMiddleware(tokenService.Verifier(), revocations, nil), authController.ChangePassword)
+	router.GET("/auth/me", middleware.AuthMiddleware(tokenService.Verifier(), revocations, nil), authController.GetCurrentUser)
+
+	request := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	var rejected struct {
+		Problems []string `json:"problems"`
+	}
+
+	// Step 1: passwords that are breached or contain the user's name are refused with the reasons
+	credentials := map[string]string{"name": "Nora Castillo", "email": "nora@example.com", "password": "Summer2024!"}
+	w := request(http.MethodPost, "/auth/register", "", credentials)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rejected))
+	assert.Equal(t, []string{"This password appeared in a data breach, choose another one"}, rejected.Problems)
+
+	credentials["password"] = "Castillo-2024"
+	w = request(http.MethodPost, "/auth/register", "", credentials)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Step 2: register on the laptop and log in on the phone
+	credentials["password"] = "Quiet-Harbor-17"
+	w = request(http.MethodPost, "/auth/register", "", credentials)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var onLaptop, onPhone struct {
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &onLaptop))
+	w = request(http.MethodPost, "/auth/login", "", credentials)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &onPhone))
+
+	// Step 3: the current password has to be right, and the new one has to pass the policy
+	w = request(http.MethodPut, "/auth/password", onLaptop.Token, map[string]string{
+		"current_password": "Wrong-Harbor-17", "new_password": "Amber-Lantern-42",
+	})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = request(http.MethodPut, "/auth/password", onLaptop.Token, map[string]string{
+		"current_password": "Quiet-Harbor-17", "new_password": "short",
+	})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rejected))
+	assert.Contains(t, rejected.Problems, "Use at least 10 characters")
+	w = request(http.MethodPut, "/auth/password", onLaptop.Token, map[string]string{
+		"current_password": "Quiet-Harbor-17", "new_password": "Quiet-Harbor-17",
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Step 4: changing it signs the phone out but keeps the laptop logged in
+	w = request(http.MethodPut, "/auth/password", onLaptop.Token, map[string]string{
+		"current_password": "Quiet-Harbor-17", "new_password": "Amber-Lantern-42",
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+	var changed struct {
+		RevokedSessions int `json:"revoked_sessions"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &changed))
+	assert.Equal(t, 1, changed.RevokedSessions)
+
+	w = request(http.MethodGet, "/auth/me", onPhone.Token, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = request(http.MethodGet, "/auth/me", onLaptop.Token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Step 5: only the new password logs in
+	w = request(http.MethodPost, "/auth/login", "", credentials)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	credentials["password"] = "Amber-Lantern-42"
+	w = request(http.MethodPost, "/auth/login", "", credentials)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
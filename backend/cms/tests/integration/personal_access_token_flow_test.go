@@ -0,0 +1,128 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/controllers"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/middleware"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPersonalAccessTokenFlow creates a personal access token, uses it within and outside its scopes,
+// introspects it and revokes it
+func TestPersonalAccessTokenFlow(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := SetupTestDB()
+
+	keys, _ := services.LoadKeySet("", "")
+	tokenService := services.NewTokenService(db, nil, keys, "", "", 15*time.Minute, 30*24*time.Hour)
+	pats := services.NewPersonalAccessTokenService(db)
+	tokenController := controllers.NewPersonalAccessTokenController(db, pats, "introspection-secret")
+	courseController := controllers.NewCourseController(db)
+
+	router := gin.New()
+	auth := middleware.AuthMiddleware(tokenService.Verifier(), nil, pats)
+	router.POST("/auth/introspect", tokenController.Introspect)
+	router.GET("/auth/tokens", auth, tokenController.ListTokens)
+	router.POST("/auth/tokens", auth, tokenController.CreateToken)
+	router.DELETE("/auth/tokens/:id", auth, tokenController.RevokeToken)
+	router.GET("/api/courses", auth, courseController.GetCourses)
+	router.POST("/api/courses", auth, courseController.CreateCourse)
+
+	user := models.User{Email: "scripts@example.com", Name: "Scripts", Role: models.RoleInstructor}
+	user.MarkEmailVerified(time.Now())
+	require.NoError(t, db.Create(&user).Error)
+	accessToken, _, err := tokenService.IssueAccessToken(user)
+	require.NoError(t, err)
+
+	request := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Step 1: create a read-only token
+	w := request(http.MethodPost, "/auth/tokens", accessToken, map[string]interface{}{
+		"name":   "Course export",
+		"scopes": []string{claims.ScopeCoursesRead},
+	})
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created struct {
+		Token               string                     `json:"token"`
+		PersonalAccessToken models.PersonalAccessToken `json:"personal_access_token"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.True(t, strings.HasPrefix(created.Token, claims.PersonalAccessTokenPrefix))
+	assert.WithinDuration(t, time.Now().Add(90*24*time.Hour), created.PersonalAccessToken.ExpiresAt, time.Minute)
+
+	var stored models.PersonalAccessToken
+	require.NoError(t, db.First(&stored, "id = ?", created.PersonalAccessToken.ID).Error)
+	assert.NotEqual(t, created.Token, stored.TokenHash)
+
+	// Unknown scopes and admin scopes for a non-admin are refused
+	w = request(http.MethodPost, "/auth/tokens", accessToken, map[string]interface{}{"name": "Bad", "scopes": []string{"courses:delete"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = request(http.MethodPost, "/auth/tokens", accessToken, map[string]interface{}{"name": "Admin", "scopes": []string{claims.ScopeAdminRead}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Step 2: the token works within its scopes and records its use
+	w = request(http.MethodGet, "/api/courses", created.Token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, db.First(&stored, "id = ?", created.PersonalAccessToken.ID).Error)
+	assert.NotNil(t, stored.LastUsedAt)
+
+	w = request(http.MethodPost, "/api/courses", created.Token, map[string]string{"title": "Scripted"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), claims.ScopeCoursesWrite)
+
+	// A personal access token can't manage tokens
+	w = request(http.MethodGet, "/auth/tokens", created.Token, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Step 3: other services introspect it with the shared secret
+	introspect := func(secret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/auth/introspect", strings.NewReader(url.Values{"token": {created.Token}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+secret)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusUnauthorized, introspect("wrong-secret").Code)
+
+	w = introspect("introspection-secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	var introspection claims.Introspection
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &introspection))
+	assert.True(t, introspection.Active)
+	assert.Equal(t, user.ID, introspection.UserID())
+	assert.Equal(t, []string{claims.ScopeCoursesRead}, introspection.Scopes())
+
+	// Step 4: a revoked token stops working
+	w = request(http.MethodDelete, "/auth/tokens/"+created.PersonalAccessToken.ID.String(), accessToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = request(http.MethodGet, "/api/courses", created.Token, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = introspect("introspection-secret")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &introspection))
+	assert.False(t, introspection.Active)
+}
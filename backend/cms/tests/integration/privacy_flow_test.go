@@ -0,0 +1,165 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/controllers"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/middleware"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPrivacyFlow exports a student's data, deletes their account and completes the deletion
+// with the result content-delivery reports
+func TestPrivacyFlow(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := SetupTestDB()
+
+	keys, _ := services.LoadKeySet("", "")
+	tokenService := services.NewTokenService(db, nil, keys, "", "", 15*time.Minute, 30*24*time.Hour)
+	authController := controllers.NewAuthController(db, GetTestConfig(), tokenService, nil, nil, nil, nil, nil, nil, nil, nil)
+	privacyService := services.NewPrivacyService(db, nil, tokenService, nil, "http://localhost:8000")
+	privacyController := controllers.NewPrivacyController(db, privacyService, authController)
+
+	router := gin.New()
+	auth := middleware.AuthMiddleware(tokenService.Verifier(), nil, nil)
+	router.POST("/auth/me/export", auth, privacyController.RequestExport)
+	router.GET("/auth/me/exports", auth, privacyController.ListExports)
+	router.DELETE("/auth/me", auth, privacyController.DeleteAccount)
+	router.GET("/auth/deletions/:id", privacyController.GetDeletion)
+
+	request := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	student := models.User{Email: "privacy-student@example.com", Name: "Privacy Student", Role: models.RoleStudent}
+	require.NoError(t, student.SetPassword("correct horse battery staple"))
+	require.NoError(t, db.Create(&student).Error)
+	token, _, err := tokenService.IssueAccessToken(student)
+	require.NoError(t, err)
+
+	instructor := models.User{Email: "privacy-instructor@example.com", Name: "Privacy Instructor", Role: models.RoleInstructor}
+	require.NoError(t, db.Create(&instructor).Error)
+	course := models.Course{Title: "Privacy Course", CreatorID: instructor.ID}
+	require.NoError(t, db.Create(&course).Error)
+	require.NoError(t, db.Create(&models.Enrollment{UserID: student.ID, CourseID: course.ID, Status: models.EnrollmentStatusActive}).Error)
+	require.NoError(t, db.Create(&models.Order{UserID: student.ID, CourseID: course.ID, TotalCents: 1999, Currency: "USD", Status: models.OrderStatusPaid}).Error)
+
+	// Step 1: an export is requested once, a second request waits for the first one
+	w := request(http.MethodPost, "/auth/me/export", token, nil)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var export struct {
+		ID          string `json:"id"`
+		Status      string `json:"status"`
+		DownloadURL string `json:"download_url"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &export))
+	assert.Equal(t, "pending", export.Status)
+
+	w = request(http.MethodPost, "/auth/me/export", token, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Step 2: content-delivery reports the archive ready, and the export links to the download
+	expiresAt := time.Now().Add(7 * 24 * time.Hour)
+	require.NoError(t, privacyService.HandleEvent(services.MessagePayload{
+		EventType: services.EventPrivacyExportReady,
+		Data:      map[string]interface{}{"export_id": export.ID, "size_bytes": 2048, "expires_at": expiresAt},
+	}))
+	w = request(http.MethodGet, "/auth/me/exports", token, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var exports struct {
+		Exports []struct {
+			Status      string `json:"status"`
+			SizeBytes   int64  `json:"size_bytes"`
+			DownloadURL string `json:"download_url"`
+		} `json:"exports"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &exports))
+	require.Len(t, exports.Exports, 1)
+	assert.Equal(t, "ready", exports.Exports[0].Status)
+	assert.Equal(t, int64(2048), exports.Exports[0].SizeBytes)
+	assert.Equal(t, "/api/exports/"+export.ID+"/download", exports.Exports[0].DownloadURL)
+
+	// Step 3: the deletion has to be confirmed with the password
+	w = request(http.MethodDelete, "/auth/me", token, map[string]string{"password": "wrong password"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = request(http.MethodDelete, "/auth/me", token, map[string]string{"password": "correct horse battery staple"})
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var receipt struct {
+		ID     string                        `json:"id"`
+		Status string                        `json:"status"`
+		Steps  map[string]models.ErasureStep `json:"steps"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &receipt))
+	assert.Equal(t, "in_progress", receipt.Status)
+	assert.Equal(t, 1, receipt.Steps[models.ErasureServiceCMS].Erased["enrollments"])
+	assert.Equal(t, 1, receipt.Steps[models.ErasureServiceCMS].Kept["orders"])
+
+	// The user is anonymized, their order is kept and their enrollment is gone
+	var deleted models.User
+	require.NoError(t, db.First(&deleted, "id = ?", student.ID).Error)
+	assert.Equal(t, "Deleted user", deleted.Name)
+	assert.NotContains(t, deleted.Email, "privacy-student")
+	assert.False(t, deleted.HasPassword())
+	assert.False(t, deleted.IsActive())
+	var orders, enrollments int64
+	db.Model(&models.Order{}).Where("user_id = ?", student.ID).Count(&orders)
+	db.Model(&models.Enrollment{}).Where("user_id = ?", student.ID).Count(&enrollments)
+	assert.Equal(t, int64(1), orders)
+	assert.Equal(t, int64(0), enrollments)
+
+	// Erasures content-delivery hasn't reported on are requested again, but not right away
+	retried, err := privacyService.RetryErasures(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, retried)
+	retried, err = privacyService.RetryErasures(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, retried)
+
+	// Step 4: the receipt completes once content-delivery reports its erasure
+	require.NoError(t, privacyService.HandleEvent(services.MessagePayload{
+		EventType: services.EventPrivacyErasureCompleted,
+		Data: map[string]interface{}{
+			"deletion_id": receipt.ID, "service": models.ErasureServiceContentDelivery, "completed_at": time.Now(),
+			"erased": map[string]int{"avatars": 1, "exports": 1}, "kept": map[string]int{"contents": 0},
+		},
+	}))
+	w = request(http.MethodGet, "/auth/deletions/"+receipt.ID, "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &receipt))
+	assert.Equal(t, "completed", receipt.Status)
+	assert.Equal(t, 1, receipt.Steps[models.ErasureServiceContentDelivery].Erased["avatars"])
+	assert.NotContains(t, w.Body.String(), "privacy-student")
+
+	// The answer to a retried request doesn't change the receipt, and completed erasures aren't retried
+	require.NoError(t, privacyService.HandleEvent(services.MessagePayload{
+		EventType: services.EventPrivacyErasureCompleted,
+		Data: map[string]interface{}{
+			"deletion_id": receipt.ID, "service": models.ErasureServiceContentDelivery, "completed_at": time.Now(),
+			"erased": map[string]int{"avatars": 0, "exports": 0},
+		},
+	}))
+	deletion, err := privacyService.GetDeletion(uuid.MustParse(receipt.ID))
+	require.NoError(t, err)
+	assert.Equal(t, 1, deletion.Steps[models.ErasureServiceContentDelivery].Erased["avatars"])
+	retried, err = privacyService.RetryErasures(time.Now().Add(2 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, retried)
+}
@@ -0,0 +1,147 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/controllers"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/middleware"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestProfileFlow edits a student's profile, applies avatar updates from content-delivery in the
+// wrong order and views an instructor's profile with their courses
+func TestProfileFlow(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := SetupTestDB()
+
+	keys, _ := services.LoadKeySet("", "")
+	tokenService := services.NewTokenService(db, nil, keys, "", "", 15*time.Minute, 30*24*time.Hour)
+	authController := controllers.NewAuthController(db, GetTestConfig(), tokenService, nil, nil, nil, nil, nil, nil, nil, nil)
+	profileService := services.NewProfileService(db)
+	profileController := controllers.NewProfileController(db, profileService)
+
+	router := gin.New()
+	router.GET("/auth/me", middleware.AuthMiddleware(tokenService.Verifier(), nil, nil), authController.GetCurrentUser)
+	router.PUT("/auth/me", middleware.AuthMiddleware(tokenService.Verifier(), nil, nil), profileController.UpdateProfile)
+	router.GET("/api/instructors/:id", middleware.AuthMiddleware(tokenService.Verifier(), nil, nil), profileController.GetInstructor)
+
+	request := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	newUser := func(email string, role models.Role) (models.User, string) {
+		user := models.User{Email: email, Name: "Profile User", Role: role}
+		require.NoError(t, db.Create(&user).Error)
+		token, _, err := tokenService.IssueAccessToken(user)
+		require.NoError(t, err)
+		return user, token
+	}
+	type profileResponse struct {
+		Name    string `json:"name"`
+		Profile struct {
+			Bio                     string                         `json:"bio"`
+			Timezone                string                         `json:"timezone"`
+			Locale                  string                         `json:"locale"`
+			NotificationPreferences models.NotificationPreferences `json:"notification_preferences"`
+			AvatarURLs              map[string]string              `json:"avatar_urls"`
+		} `json:"profile"`
+	}
+
+	student, studentToken := newUser("profile-student@example.com", models.RoleStudent)
+
+	// Step 1: a new user has the default profile
+	w := request(http.MethodGet, "/auth/me", studentToken, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var me profileResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &me))
+	assert.Equal(t, "UTC", me.Profile.Timezone)
+	assert.Equal(t, "en", me.Profile.Locale)
+	assert.Equal(t, models.DefaultNotificationPreferences(), me.Profile.NotificationPreferences)
+
+	// Step 2: invalid fields are refused together and nothing is changed
+	w = request(http.MethodPut, "/auth/me", studentToken, map[string]interface{}{
+		"name": "Rosa Delgado", "timezone": "Mars/Olympus_Mons", "locale": "english",
+	})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	var rejected struct {
+		Problems []string `json:"problems"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rejected))
+	assert.Len(t, rejected.Problems, 2)
+
+	// Step 3: only the fields sent are changed, including single notification preferences
+	w = request(http.MethodPut, "/auth/me", studentToken, map[string]interface{}{
+		"name": "Rosa Delgado", "bio": "Learning to paint", "timezone": "America/Mexico_City", "locale": "es-MX",
+		"notification_preferences": map[string]bool{"expiry_reminders": false},
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+	w = request(http.MethodPut, "/auth/me", studentToken, map[string]interface{}{"bio": "Learning to paint landscapes"})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &me))
+	assert.Equal(t, "Rosa Delgado", me.Name)
+	assert.Equal(t, "Learning to paint landscapes", me.Profile.Bio)
+	assert.Equal(t, "America/Mexico_City", me.Profile.Timezone)
+	assert.Equal(t, "es-MX", me.Profile.Locale)
+	assert.Equal(t, models.NotificationPreferences{CourseAnnouncements: true}, me.Profile.NotificationPreferences)
+
+	// Step 4: avatar events from content-delivery set the avatar, and a late older one is ignored
+	avatarEvent := func(updatedAt time.Time, url string) services.MessagePayload {
+		avatarID := uuid.New()
+		return services.MessagePayload{EventType: "avatar.updated", Data: map[string]interface{}{
+			"user_id": student.ID, "avatar_id": avatarID, "updated_at": updatedAt,
+			"urls": map[string]string{"small": url + "/small.png", "medium": url + "/medium.png", "large": url + "/large.png"},
+		}}
+	}
+	now := time.Now()
+	require.NoError(t, profileService.HandleAvatarEvent(avatarEvent(now, "http://localhost:8000/public/avatars/new")))
+	require.NoError(t, profileService.HandleAvatarEvent(avatarEvent(now.Add(-time.Minute), "http://localhost:8000/public/avatars/old")))
+
+	w = request(http.MethodGet, "/auth/me", studentToken, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &me))
+	assert.Equal(t, "http://localhost:8000/public/avatars/new/large.png", me.Profile.AvatarURLs["large"])
+	assert.Equal(t, "Learning to paint landscapes", me.Profile.Bio)
+
+	// Step 5: instructors have a profile page with their courses, students don't
+	instructor, instructorToken := newUser("profile-instructor@example.com", models.RoleInstructor)
+	w = request(http.MethodPut, "/auth/me", instructorToken, map[string]interface{}{"bio": "Oil painter and teacher"})
+	require.Equal(t, http.StatusOK, w.Code)
+	course := models.Course{Title: "Oil Painting Basics", CreatorID: instructor.ID}
+	require.NoError(t, db.Create(&course).Error)
+
+	w = request(http.MethodGet, "/api/instructors/"+instructor.ID.String(), studentToken, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var page struct {
+		Name    string `json:"name"`
+		Bio     string `json:"bio"`
+		Email   string `json:"email"`
+		Courses []struct {
+			ID    int    `json:"id"`
+			Title string `json:"title"`
+		} `json:"courses"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, "Oil painter and teacher", page.Bio)
+	assert.Empty(t, page.Email)
+	require.Len(t, page.Courses, 1)
+	assert.Equal(t, "Oil Painting Basics", page.Courses[0].Title)
+
+	w = request(http.MethodGet, "/api/instructors/"+student.ID.String(), instructorToken, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
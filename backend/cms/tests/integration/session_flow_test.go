@@ -0,0 +1,140 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/controllers"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/middleware"
+	"github.com/hesham-ashraf/LearnVibe/backend/cms/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSessionFlow logs in on two devices, signs one out from the other and checks the signed out
+// device can neither use its access token nor refresh it, then replays a rotated refresh token
+func TestSessionFlow(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := SetupTestDB()
+	cfg := GetTestConfig()
+
+	keys, _ := services.LoadKeySet("", "")
+	revocations := claims.NewRevocationList(nil, time.Minute, false)
+	tokenService := services.NewTokenService(db, revocations, keys, "", "", 15*time.Minute, 30*24*time.Hour)
+	accountService := services.NewAccountService(db, services.NewOutboxMailer(t.TempDir(), cfg.MailFrom),
+		tokenService, nil, cfg.AppBaseURL, 48*time.Hour, time.Hour)
+	mfaService := services.NewMFAService(db, "LearnVibe", 5*time.Minute)
+	authController := controllers.NewAuthController(db, cfg, tokenService, accountService, nil, mfaService, nil, nil, nil, nil, nil)
+	sessionController := controllers.NewSessionController(db, tokenService)
+
+	router := gin.New()
+	router.POST("/auth/register", authController.Register)
+	router.POST("/auth/login", authController.Login)
+	router.POST("/auth/refresh", authController.Refresh)
+	sessions := router.Group("/auth/sessions", middleware.AuthMiddleware(tokenService.Verifier(), revocations, nil))
+	sessions.GET("", sessionController.ListSessions)
+	sessions.DELETE("", sessionController.RevokeSessions)
+	sessions.DELETE("/:id", sessionController.RevokeSession)
+
+	request := func(method, path, token, userAgent string, body interface{}) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", userAgent)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	type tokens struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	credentials := map[string]string{"name": "Session User", "email": "sessions@example.com", "password": "testpassword123"}
+	const laptop = "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"
+	const phone = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1"
+
+	// Step 1: register on the laptop and log in on the phone
+	w := request(http.MethodPost, "/auth/register", "", laptop, credentials)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var onLaptop, onPhone tokens
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &onLaptop))
+
+	w = request(http.MethodPost, "/auth/login", "", phone, credentials)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &onPhone))
+
+	// Step 2: both sessions are listed, with their devices and the current one flagged
+	w = request(http.MethodGet, "/auth/sessions", onLaptop.Token, laptop, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var listed struct {
+		Sessions []struct {
+			ID      string `json:"id"`
+			Device  string `json:"device"`
+			Current bool   `json:"current"`
+		} `json:"sessions"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	require.Len(t, listed.Sessions, 2)
+	phoneSession := ""
+	for _, session := range listed.Sessions {
+		if session.Device == "Safari on iOS" {
+			phoneSession = session.ID
+			assert.False(t, session.Current)
+		} else {
+			assert.Equal(t, "Firefox on Linux", session.Device)
+			assert.True(t, session.Current)
+		}
+	}
+	require.NotEmpty(t, phoneSession)
+
+	// Step 3: sign the phone out from the laptop
+	w = request(http.MethodDelete, "/auth/sessions/"+phoneSession, onLaptop.Token, laptop, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The phone's access token and refresh token stop working right away
+	w = request(http.MethodGet, "/auth/sessions", onPhone.Token, phone, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = request(http.MethodPost, "/auth/refresh", "", phone, map[string]string{"refresh_token": onPhone.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// The laptop is still logged in, and refreshing keeps it in the same session
+	w = request(http.MethodPost, "/auth/refresh", "", laptop, map[string]string{"refresh_token": onLaptop.RefreshToken})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &onLaptop))
+	w = request(http.MethodGet, "/auth/sessions", onLaptop.Token, laptop, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	require.Len(t, listed.Sessions, 1)
+	assert.True(t, listed.Sessions[0].Current)
+
+	// Step 4: signing out everywhere ends the current session too
+	w = request(http.MethodDelete, "/auth/sessions", onLaptop.Token, laptop, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = request(http.MethodGet, "/auth/sessions", onLaptop.Token, laptop, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Step 5: a refresh token coming back after rotation ends its session, including the access
+	// token the rotation issued
+	w = request(http.MethodPost, "/auth/login", "", laptop, credentials)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &onLaptop))
+	stolen := onLaptop.RefreshToken
+	w = request(http.MethodPost, "/auth/refresh", "", laptop, map[string]string{"refresh_token": stolen})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &onLaptop))
+
+	w = request(http.MethodPost, "/auth/refresh", "", phone, map[string]string{"refresh_token": stolen})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = request(http.MethodGet, "/auth/sessions", onLaptop.Token, laptop, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
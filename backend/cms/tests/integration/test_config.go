@@ -8,6 +8,7 @@ import (
 
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/config"
 	"github.com/hesham-ashraf/LearnVibe/backend/cms/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -62,11 +63,24 @@ func SetupTestDB() *gorm.DB {
 		log.Fatalf("Failed to connect to test database: %v", err)
 	}
 
+	if err := models.RegisterTenantCallbacks(db); err != nil {
+		log.Fatalf("Failed to register organization callbacks: %v", err)
+	}
+
 	// Migrate the schema
-	db.AutoMigrate(&models.User{})
+	db.AutoMigrate(&models.Organization{}, &models.User{}, &models.Course{}, &models.CourseContent{}, &models.Cohort{}, &models.Enrollment{}, &models.RefreshToken{}, &models.Session{}, &models.AccountToken{}, &models.MFAChallenge{}, &models.RecoveryCode{}, &models.MFAPolicy{}, &models.UserIdentity{}, &models.PersonalAccessToken{}, &models.AuditLog{}, &models.LTIPlatform{}, &models.LTIResourceLink{}, &models.UserProfile{}, &models.Coupon{}, &models.Order{}, &models.InstructorApplication{}, &models.DataExport{}, &models.AccountDeletion{})
 
 	// Clean up old test data
 	db.Exec("TRUNCATE TABLE users CASCADE")
+	db.Exec("TRUNCATE TABLE user_identities")
+	db.Exec("TRUNCATE TABLE sessions")
+	db.Exec("TRUNCATE TABLE audit_logs")
+	db.Exec("TRUNCATE TABLE lti_platforms, lti_resource_links")
+	db.Exec("TRUNCATE TABLE user_profiles")
+	db.Exec("TRUNCATE TABLE data_exports, account_deletions")
+	db.Exec("TRUNCATE TABLE courses CASCADE")
+	db.Exec("DELETE FROM organizations WHERE id <> ?", claims.DefaultOrganizationID)
+	models.EnsureDefaultOrganization(db)
 
 	return db
 }
@@ -74,9 +88,10 @@ func SetupTestDB() *gorm.DB {
 // GetTestConfig returns a config for testing
 func GetTestConfig() *config.Config {
 	return &config.Config{
-		JWTSecret:          "test-jwt-secret",
 		GoogleClientID:     "test-client-id",
 		GoogleClientSecret: "test-client-secret",
 		GoogleRedirectURL:  "http://localhost:8080/auth/google/callback",
+		AppBaseURL:         "http://localhost:8000",
+		MailFrom:           "LearnVibe <no-reply@learnvibe.local>",
 	}
 }
@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/content-delivery/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/content-delivery/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+)
+
+// AvatarController handles uploading and serving profile pictures
+type AvatarController struct {
+	db        *models.Database
+	storage   *services.StorageService
+	broker    *services.MessageBroker
+	publicURL string
+}
+
+// NewAvatarController creates a new avatar controller. Avatars are served under publicURL, the
+// address clients reach the service at; the CMS learns about new avatars through the broker.
+func NewAvatarController(db *models.Database, storage *services.StorageService, broker *services.MessageBroker,
+	publicURL string) *AvatarController {
+	return &AvatarController{
+		db:        db,
+		storage:   storage,
+		broker:    broker,
+		publicURL: strings.TrimSuffix(publicURL, "/"),
+	}
+}
+
+// UploadAvatar replaces the user's avatar with an uploaded image, resized to every avatar size
+func (ac *AvatarController) UploadAvatar(c *gin.Context) {
+	subject := currentSubject(c)
+	if subject.ID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	// Leave room for the multipart headers around the image
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, services.MaxAvatarBytes+64<<10)
+	file, _, err := c.Request.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Avatars can be at most %d MB", services.MaxAvatarBytes>>20)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is required"})
+		return
+	}
+	defer file.Close()
+
+	images, err := services.ProcessAvatar(file)
+	switch {
+	case errors.Is(err, services.ErrUnsupportedImage):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Avatars have to be GIF, JPEG or PNG images"})
+		return
+	case errors.Is(err, services.ErrImageTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "The image is too large"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process the image"})
+		return
+	}
+
+	// Store every size, and remove the stored ones again if one fails
+	avatar := models.Avatar{ID: uuid.New(), UserID: subject.ID, OrganizationID: subject.OrganizationID}
+	if avatar.OrganizationID == uuid.Nil {
+		avatar.OrganizationID = claims.DefaultOrganizationID
+	}
+	ctx := c.Request.Context()
+	var stored []string
+	for size, image := range images {
+		objectName := ac.objectName(avatar, size)
+		if err := ac.storage.UploadFile(ctx, objectName, bytes.NewReader(image), int64(len(image)), "image/png"); err != nil {
+			ac.deleteFiles(ctx, stored)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload avatar"})
+			return
+		}
+		stored = append(stored, objectName)
+	}
+	if err := ac.db.DB.Create(&avatar).Error; err != nil {
+		ac.deleteFiles(ctx, stored)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save avatar"})
+		return
+	}
+
+	// The new avatar replaces the previous ones
+	ac.deleteAvatars(ctx, subject.ID, avatar.ID)
+
+	urls := ac.avatarURLs(avatar)
+	services.PublishAvatarEvent(ac.broker, services.AvatarEvent{
+		UserID:    subject.ID,
+		AvatarID:  &avatar.ID,
+		URLs:      urls,
+		UpdatedAt: avatar.CreatedAt,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"avatar_id": avatar.ID,
+		"urls":      urls,
+	})
+}
+
+// DeleteAvatar removes the user's avatar
+func (ac *AvatarController) DeleteAvatar(c *gin.Context) {
+	subject := currentSubject(c)
+	if subject.ID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	ac.deleteAvatars(c.Request.Context(), subject.ID, uuid.Nil)
+	services.PublishAvatarEvent(ac.broker, services.AvatarEvent{
+		UserID:    subject.ID,
+		UpdatedAt: time.Now(),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Avatar removed"})
+}
+
+// GetAvatar serves one size of an avatar. Avatar URLs never change their image, so they can be
+// cached for good.
+func (ac *AvatarController) GetAvatar(c *gin.Context) {
+	avatarID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid avatar ID"})
+		return
+	}
+	size := strings.TrimSuffix(c.Param("size"), ".png")
+	if _, ok := services.AvatarSizes[size]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Avatar not found"})
+		return
+	}
+
+	var avatar models.Avatar
+	if err := ac.db.DB.First(&avatar, "id = ?", avatarID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Avatar not found"})
+		return
+	}
+
+	file, fileSize, err := ac.storage.GetFile(c.Request.Context(), ac.objectName(avatar, size))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Avatar not found"})
+		return
+	}
+	defer file.Close()
+
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.DataFromReader(http.StatusOK, fileSize, "image/png", file, nil)
+}
+
+// objectName returns where one size of an avatar is stored
+func (ac *AvatarController) objectName(avatar models.Avatar, size string) string {
+	return ac.storage.ObjectName(avatar.OrganizationID, fmt.Sprintf("avatars/%s/%s.png", avatar.ID, size))
+}
+
+// avatarURLs returns the public URL of every size of an avatar
+func (ac *AvatarController) avatarURLs(avatar models.Avatar) map[string]string {
+	urls := make(map[string]string, len(services.AvatarSizes))
+	for size := range services.AvatarSizes {
+		urls[size] = fmt.Sprintf("%s/public/avatars/%s/%s.png", ac.publicURL, avatar.ID, size)
+	}
+	return urls
+}
+
+// deleteAvatars removes a user's avatars except the one with the ID keep, and returns how many it
+// removed. Files that can't be removed are only logged, they can't be reached once the avatar's
+// record is gone.
+func (ac *AvatarController) deleteAvatars(ctx context.Context, userID, keep uuid.UUID) (int, error) {
+	var avatars []models.Avatar
+	if err := ac.db.DB.Where("user_id = ? AND id <> ?", userID, keep).Find(&avatars).Error; err != nil {
+		log.Printf("Failed to find old avatars of user %s: %v", userID, err)
+		return 0, err
+	}
+	deleted := 0
+	for _, avatar := range avatars {
+		if err := ac.db.DB.Delete(&avatar).Error; err != nil {
+			log.Printf("Failed to delete avatar %s: %v", avatar.ID, err)
+			return deleted, err
+		}
+		deleted++
+		var files []string
+		for size := range services.AvatarSizes {
+			files = append(files, ac.objectName(avatar, size))
+		}
+		ac.deleteFiles(ctx, files)
+	}
+	return deleted, nil
+}
+
+// deleteFiles removes stored files, logging the ones that can't be removed
+func (ac *AvatarController) deleteFiles(ctx context.Context, objectNames []string) {
+	for _, objectName := range objectNames {
+		if err := ac.storage.DeleteFile(ctx, objectName); err != nil {
+			log.Printf("Failed to delete %s: %v", objectName, err)
+		}
+	}
+}
@@ -11,19 +11,27 @@ import (
 	"github.com/google/uuid"
 	"github.com/hesham-ashraf/LearnVibe/backend/content-delivery/models"
 	"github.com/hesham-ashraf/LearnVibe/backend/content-delivery/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/policy"
+	"gorm.io/gorm"
 )
 
 // ContentController handles content-related requests
 type ContentController struct {
 	db      *models.Database
 	storage *services.StorageService
+	access  *services.EnrollmentAccessService
+	broker  *services.MessageBroker
 }
 
-// NewContentController creates a new content controller
-func NewContentController(db *models.Database, storage *services.StorageService) *ContentController {
+// NewContentController creates a new content controller. Deletions are sent to the CMS audit log
+// through the broker, which may be nil.
+func NewContentController(db *models.Database, storage *services.StorageService, access *services.EnrollmentAccessService,
+	broker *services.MessageBroker) *ContentController {
 	return &ContentController{
 		db:      db,
 		storage: storage,
+		access:  access,
+		broker:  broker,
 	}
 }
 
@@ -43,7 +51,7 @@ func (cc *ContentController) UploadContent(c *gin.Context) {
 		return
 	}
 
-	courseID, err := uuid.Parse(courseIDStr)
+	courseID, err := strconv.Atoi(courseIDStr)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid course ID"})
 		return
@@ -89,24 +97,26 @@ func (cc *ContentController) UploadContent(c *gin.Context) {
 		contentType = models.ContentTypeArchive
 	}
 
-	// Create a new content record
+	// Create a new content record in the uploader's organization
 	contentID := uuid.New()
+	organizationID := currentSubject(c).OrganizationID
 	content := models.Content{
-		ID:          contentID,
-		CourseID:    courseID,
-		UploadedBy:  userID.(uuid.UUID),
-		Title:       title,
-		Description: description,
-		FileName:    fileName,
-		FilePath:    fmt.Sprintf("%s/%s%s", courseID, contentID, fileExt),
-		FileSize:    fileSize,
-		FileType:    contentType,
-		MimeType:    fileHeader.Header.Get("Content-Type"),
-		IsPublic:    isPublic,
+		ID:             contentID,
+		CourseID:       courseID,
+		UploadedBy:     userID.(uuid.UUID),
+		OrganizationID: organizationID,
+		Title:          title,
+		Description:    description,
+		FileName:       fileName,
+		FilePath:       cc.storage.ObjectName(organizationID, fmt.Sprintf("%d/%s%s", courseID, contentID, fileExt)),
+		FileSize:       fileSize,
+		FileType:       contentType,
+		MimeType:       fileHeader.Header.Get("Content-Type"),
+		IsPublic:       isPublic,
 	}
 
 	// Save content metadata to database
-	if err := cc.db.DB.Create(&content).Error; err != nil {
+	if err := cc.tenantDB(c).Create(&content).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save content metadata"})
 		return
 	}
@@ -133,9 +143,9 @@ func (cc *ContentController) GetContent(c *gin.Context) {
 		return
 	}
 
-	// Get content from database
+	// Get content from database, content of other organizations doesn't exist for the user
 	var content models.Content
-	result := cc.db.DB.First(&content, contentID)
+	result := cc.tenantDB(c).First(&content, contentID)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Content not found"})
 		return
@@ -182,14 +192,14 @@ func (cc *ContentController) GetContentDownloadURL(c *gin.Context) {
 		// Use cached content if available
 		// In a real implementation, we would unmarshal the JSON
 		// For now, just get from DB
-		result := cc.db.DB.First(&content, contentID)
+		result := cc.tenantDB(c).First(&content, contentID)
 		if result.Error != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Content not found"})
 			return
 		}
 	} else {
 		// Get content from database
-		result := cc.db.DB.First(&content, contentID)
+		result := cc.tenantDB(c).First(&content, contentID)
 		if result.Error != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Content not found"})
 			return
@@ -198,12 +208,26 @@ func (cc *ContentController) GetContentDownloadURL(c *gin.Context) {
 
 	// Check permissions (if not public)
 	if !content.IsPublic {
-		_, exists := c.Get("userID")
+		userID, exists := c.Get("userID")
 		if !exists {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required for non-public content"})
 			return
 		}
 
+		// Students lose access to licensed content once their enrollment expires
+		userRole, _ := c.Get("userRole")
+		if role, _ := userRole.(string); !policy.Grants(role, policy.ContentReadAnyCourse) {
+			expired, err := cc.access.HasExpired(ctx, userID.(uuid.UUID), content.CourseID)
+			if err != nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to verify course access"})
+				return
+			}
+			if expired {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Your access to this course has expired"})
+				return
+			}
+		}
+
 		// TODO: Add permission check to verify user has access to this course
 	}
 
@@ -240,18 +264,17 @@ func (cc *ContentController) DeleteContent(c *gin.Context) {
 		return
 	}
 
-	// Get content from database
+	// Get content from database, content of other organizations doesn't exist for the user
 	var content models.Content
-	result := cc.db.DB.First(&content, contentID)
+	result := cc.tenantDB(c).First(&content, contentID)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Content not found"})
 		return
 	}
 
-	// Check permissions
-	userID, exists := c.Get("userID")
-	userRole, _ := c.Get("userRole")
-	if !exists || (content.UploadedBy != userID.(uuid.UUID) && userRole != "admin") {
+	// Check permissions - only the uploader or an admin can delete content
+	if err := policy.Authorize(currentSubject(c), policy.ContentDelete,
+		policy.OwnedBy(content.UploadedBy).InOrganization(content.OrganizationID)); err != nil {
 		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to delete this content"})
 		return
 	}
@@ -271,5 +294,48 @@ func (cc *ContentController) DeleteContent(c *gin.Context) {
 	cacheKey := fmt.Sprintf("content:%s", contentID)
 	cc.db.Redis.Del(ctx, cacheKey)
 
+	subject := currentSubject(c)
+	services.PublishAuditEvent(cc.broker, services.AuditEvent{
+		ActorID:    subject.ID,
+		Action:     "content.delete",
+		TargetType: "content",
+		TargetID:   content.ID.String(),
+		Details:    map[string]interface{}{"service": "content-delivery"},
+		Before: map[string]interface{}{
+			"title":           content.Title,
+			"file_name":       content.FileName,
+			"course_id":       content.CourseID,
+			"uploaded_by":     content.UploadedBy,
+			"organization_id": content.OrganizationID,
+		},
+		IPAddress: c.ClientIP(),
+		RequestID: c.GetHeader("X-Request-ID"),
+	})
+
 	c.JSON(http.StatusOK, gin.H{"message": "Content deleted successfully"})
-}
\ No newline at end of file
+}
+
+// currentSubject returns the user making the request, as set by the auth middleware
+func currentSubject(c *gin.Context) policy.Subject {
+	var subject policy.Subject
+	if userID, ok := c.Get("userID"); ok {
+		subject.ID, _ = userID.(uuid.UUID)
+	}
+	if role, ok := c.Get("userRole"); ok {
+		subject.Role, _ = role.(string)
+	}
+	if organizationID, ok := c.Get("organizationID"); ok {
+		subject.OrganizationID, _ = organizationID.(uuid.UUID)
+	}
+	return subject
+}
+
+// tenantDB returns the database limited to the current user's organization. Platform admins see
+// every organization; anonymous callers only get public content, which isn't limited.
+func (cc *ContentController) tenantDB(c *gin.Context) *gorm.DB {
+	subject := currentSubject(c)
+	if subject.ID == uuid.Nil || policy.Grants(subject.Role, policy.AdminAccess) {
+		return cc.db.DB
+	}
+	return models.ForOrganization(cc.db.DB, subject.OrganizationID)
+}
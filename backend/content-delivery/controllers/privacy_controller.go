@@ -0,0 +1,324 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/content-delivery/models"
+	"github.com/hesham-ashraf/LearnVibe/backend/content-delivery/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"gorm.io/gorm"
+)
+
+// exportDownloadExpiry is how long a download link of an export archive works
+const exportDownloadExpiry = 15 * time.Minute
+
+// PrivacyController builds the personal data exports and erases the data of deleted accounts, as
+// the CMS asks for it, and lets users download their exports
+type PrivacyController struct {
+	db        *models.Database
+	storage   *services.StorageService
+	access    *services.EnrollmentAccessService
+	avatars   *AvatarController
+	broker    *services.MessageBroker
+	exportTTL time.Duration
+}
+
+// NewPrivacyController creates a new privacy controller. Export archives can be downloaded for
+// exportTTL; avatars are erased through the avatar controller.
+func NewPrivacyController(db *models.Database, storage *services.StorageService, access *services.EnrollmentAccessService,
+	avatars *AvatarController, broker *services.MessageBroker, exportTTL time.Duration) *PrivacyController {
+	return &PrivacyController{
+		db:        db,
+		storage:   storage,
+		access:    access,
+		avatars:   avatars,
+		broker:    broker,
+		exportTTL: exportTTL,
+	}
+}
+
+// DownloadExport returns a short-lived download link to one of the user's export archives
+func (pc *PrivacyController) DownloadExport(c *gin.Context) {
+	userID := currentSubject(c).ID
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	exportID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid export ID"})
+		return
+	}
+
+	// Exports are only ever shown to their owner
+	var export models.DataExport
+	if err := pc.db.DB.First(&export, "id = ? AND user_id = ?", exportID, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+		return
+	}
+	if export.IsExpired(time.Now()) {
+		c.JSON(http.StatusGone, gin.H{"error": "The export has expired, request a new one"})
+		return
+	}
+
+	url, err := pc.storage.GetPresignedURL(c.Request.Context(), export.ObjectName, exportDownloadExpiry)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate download URL"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"url":        url,
+		"expires":    fmt.Sprintf("%d minutes", int(exportDownloadExpiry.Minutes())),
+		"size_bytes": export.SizeBytes,
+	})
+}
+
+// HandleEvent builds the exports and erases the data the CMS asks for
+func (pc *PrivacyController) HandleEvent(payload services.MessagePayload) error {
+	// Data arrives as a generic map, so round-trip it into the request type
+	data, err := json.Marshal(payload.Data)
+	if err != nil {
+		return fmt.Errorf("failed to read privacy event: %v", err)
+	}
+
+	switch payload.EventType {
+	case services.EventPrivacyExportRequested:
+		var req services.DataExportRequest
+		if err := json.Unmarshal(data, &req); err != nil || req.ExportID == uuid.Nil || req.UserID == uuid.Nil {
+			// Nothing we can answer to, drop the message instead of requeueing it forever
+			log.Printf("Dropping invalid export request: %v", err)
+			return nil
+		}
+		pc.exportData(req)
+		return nil
+	case services.EventPrivacyErasureRequested:
+		var req services.ErasureRequest
+		if err := json.Unmarshal(data, &req); err != nil || req.DeletionID == uuid.Nil || req.UserID == uuid.Nil {
+			log.Printf("Dropping invalid erasure request: %v", err)
+			return nil
+		}
+		// Errors requeue the request, erasing again is harmless
+		return pc.eraseData(req)
+	default:
+		log.Printf("Ignoring privacy event %q", payload.EventType)
+		return nil
+	}
+}
+
+// exportData builds an export archive and tells the CMS whether it's ready. Failures are reported
+// instead of retried, the user can request another export.
+func (pc *PrivacyController) exportData(req services.DataExportRequest) {
+	// A redelivered request finds its archive already built
+	var export models.DataExport
+	if err := pc.db.DB.First(&export, "id = ?", req.ExportID).Error; err != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+		defer cancel()
+
+		built, err := pc.buildExport(ctx, req)
+		if err != nil {
+			log.Printf("Failed to build export %s: %v", req.ExportID, err)
+			services.PublishPrivacyEvent(pc.broker, services.EventPrivacyExportFailed, services.DataExportResult{
+				ExportID: req.ExportID,
+				Error:    "The archive couldn't be built, please try again later",
+			})
+			return
+		}
+		export = *built
+	}
+
+	services.PublishPrivacyEvent(pc.broker, services.EventPrivacyExportReady, services.DataExportResult{
+		ExportID:  export.ID,
+		SizeBytes: export.SizeBytes,
+		ExpiresAt: &export.ExpiresAt,
+	})
+}
+
+// buildExport writes the CMS's records and the user's files to an archive and stores it. The
+// archive is assembled in a temporary file, uploads can be large.
+func (pc *PrivacyController) buildExport(ctx context.Context, req services.DataExportRequest) (*models.DataExport, error) {
+	file, err := os.CreateTemp("", "export-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	archive, err := services.NewExportArchive(file, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	kinds := make([]string, 0, len(req.Records))
+	for kind := range req.Records {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		if err := archive.AddJSON(path.Join("cms", path.Base(kind)+".json"), req.Records[kind]); err != nil {
+			return nil, err
+		}
+	}
+
+	var contents []models.Content
+	if err := pc.db.DB.Where("uploaded_by = ?", req.UserID).Order("created_at").Find(&contents).Error; err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(contents)
+	if err != nil {
+		return nil, err
+	}
+	if err := archive.AddJSON("content-delivery/contents.json", data); err != nil {
+		return nil, err
+	}
+	for _, content := range contents {
+		name := path.Base(content.FileName)
+		if name == "." || name == "/" {
+			name = "file"
+		}
+		if err := pc.addStoredFile(ctx, archive, path.Join("content-delivery/files", content.ID.String(), name), content.FilePath); err != nil {
+			return nil, err
+		}
+	}
+
+	var avatar models.Avatar
+	err = pc.db.DB.Where("user_id = ?", req.UserID).Order("created_at DESC").First(&avatar).Error
+	if err == nil {
+		if err := pc.addStoredFile(ctx, archive, "content-delivery/avatar.png", pc.avatars.objectName(avatar, "large")); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	export := models.DataExport{
+		ID:             req.ExportID,
+		UserID:         req.UserID,
+		OrganizationID: req.OrganizationID,
+		SizeBytes:      size,
+		ExpiresAt:      time.Now().Add(pc.exportTTL),
+	}
+	if export.OrganizationID == uuid.Nil {
+		export.OrganizationID = claims.DefaultOrganizationID
+	}
+	export.ObjectName = pc.storage.ObjectName(export.OrganizationID, fmt.Sprintf("exports/%s.zip", export.ID))
+	if err := pc.storage.UploadFile(ctx, export.ObjectName, file, size, "application/zip"); err != nil {
+		return nil, err
+	}
+	if err := pc.db.DB.Create(&export).Error; err != nil {
+		pc.deleteArchive(ctx, export.ObjectName)
+		return nil, err
+	}
+	return &export, nil
+}
+
+// addStoredFile copies a stored file into the archive
+func (pc *PrivacyController) addStoredFile(ctx context.Context, archive *services.ExportArchive, name, objectName string) error {
+	file, _, err := pc.storage.GetFile(ctx, objectName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return archive.AddFile(name, file)
+}
+
+// eraseData erases a deleted user's avatars, exports and enrollment access, and reports it to the
+// CMS. The contents the user uploaded are course material and stay with their courses.
+func (pc *PrivacyController) eraseData(req services.ErasureRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	avatars, err := pc.avatars.deleteAvatars(ctx, req.UserID, uuid.Nil)
+	if err != nil {
+		return fmt.Errorf("failed to erase avatars: %v", err)
+	}
+	exports, err := pc.deleteExports(ctx, pc.db.DB.Where("user_id = ?", req.UserID))
+	if err != nil {
+		return fmt.Errorf("failed to erase exports: %v", err)
+	}
+	access, err := pc.access.DeleteUser(ctx, req.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to erase enrollment access: %v", err)
+	}
+	var contents int64
+	if err := pc.db.DB.Model(&models.Content{}).Where("uploaded_by = ?", req.UserID).Count(&contents).Error; err != nil {
+		return err
+	}
+
+	services.PublishPrivacyEvent(pc.broker, services.EventPrivacyErasureCompleted, services.ErasureResult{
+		DeletionID:  req.DeletionID,
+		Service:     services.ErasureServiceName,
+		CompletedAt: time.Now(),
+		Erased:      map[string]int{"avatars": avatars, "exports": exports, "enrollment_access": access},
+		Kept:        map[string]int{"contents": int(contents)},
+	})
+	return nil
+}
+
+// deleteExports deletes the exports the query finds with their archives, and returns how many it
+// deleted
+func (pc *PrivacyController) deleteExports(ctx context.Context, query *gorm.DB) (int, error) {
+	var exports []models.DataExport
+	if err := query.Find(&exports).Error; err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, export := range exports {
+		if err := pc.db.DB.Delete(&export).Error; err != nil {
+			return deleted, err
+		}
+		deleted++
+		pc.deleteArchive(ctx, export.ObjectName)
+	}
+	return deleted, nil
+}
+
+// deleteArchive removes a stored export archive. Failures are only logged, the archive can't be
+// downloaded once its export is gone.
+func (pc *PrivacyController) deleteArchive(ctx context.Context, objectName string) {
+	if err := pc.storage.DeleteFile(ctx, objectName); err != nil {
+		log.Printf("Failed to delete %s: %v", objectName, err)
+	}
+}
+
+// StartExportCleanup deletes expired export archives every interval until ctx is done
+func (pc *PrivacyController) StartExportCleanup(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				deleted, err := pc.deleteExports(ctx, pc.db.DB.Where("expires_at <= ?", time.Now()))
+				if err != nil {
+					log.Printf("Failed to delete expired exports: %v", err)
+				} else if deleted > 0 {
+					log.Printf("Deleted %d expired exports", deleted)
+				}
+			}
+		}
+	}()
+}
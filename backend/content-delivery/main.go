@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"log"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hesham-ashraf/LearnVibe/backend/content-delivery/config"
@@ -10,6 +12,7 @@ import (
 	"github.com/hesham-ashraf/LearnVibe/backend/content-delivery/models"
 	"github.com/hesham-ashraf/LearnVibe/backend/content-delivery/routes"
 	"github.com/hesham-ashraf/LearnVibe/backend/content-delivery/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
 )
 
 func main() {
@@ -81,8 +84,47 @@ func main() {
 		logger.Info("Successfully connected to MinIO storage service", nil)
 	}
 
+	// Keep track of enrollment access windows published by the CMS; other enrollment events are dropped
+	accessService := services.NewEnrollmentAccessService(db.Redis, cfg.EnrollmentAccessURL, cfg.IntrospectionSecret)
+	if messageBroker != nil {
+		if err := messageBroker.ConsumeMessages("content-delivery.enrollment-access", "enrollment.*", accessService.HandleEvent); err != nil {
+			log.Printf("Warning: Failed to consume enrollment events: %v", err)
+		}
+	}
+
+	// Tokens are signed by the CMS, fetch its public keys
+	jwks := claims.NewJWKSClient(cfg.JWKSURL, time.Duration(cfg.JWKSCacheTTL)*time.Second)
+	if err := jwks.Refresh(context.Background()); err != nil {
+		log.Printf("Warning: Failed to fetch JWKS from %s, will retry when verifying tokens: %v", cfg.JWKSURL, err)
+	}
+
+	// Tokens revoked by the CMS are shared through Redis. Requests come in through the gateway,
+	// which rejects tokens while Redis is down, so lookup failures here let the token through.
+	revocations := claims.NewRevocationList(db.Redis, time.Duration(cfg.RevocationCacheTTL)*time.Second, false)
+
+	// Personal access tokens are opaque, the CMS says who they belong to
+	var introspector *claims.IntrospectionClient
+	if cfg.IntrospectionSecret != "" {
+		introspector = claims.NewIntrospectionClient(cfg.IntrospectionURL, cfg.IntrospectionSecret,
+			time.Duration(cfg.IntrospectionCacheTTL)*time.Second)
+	} else {
+		log.Printf("Warning: INTROSPECTION_SECRET is not set, personal access tokens will be rejected")
+	}
+
 	// Initialize controllers
-	contentController := controllers.NewContentController(db, storageService)
+	contentController := controllers.NewContentController(db, storageService, accessService, messageBroker)
+	avatarController := controllers.NewAvatarController(db, storageService, messageBroker, cfg.PublicURL)
+	// Personal data exports and erasures the CMS asks for
+	privacyController := controllers.NewPrivacyController(db, storageService, accessService, avatarController, messageBroker,
+		time.Duration(cfg.ExportTTL)*time.Hour)
+	if messageBroker != nil {
+		if err := messageBroker.ConsumeMessages("content-delivery.privacy", "privacy.request.*", privacyController.HandleEvent); err != nil {
+			log.Printf("Warning: Failed to consume privacy events: %v", err)
+		}
+	}
+	cleanupCtx, stopCleanup := context.WithCancel(context.Background())
+	defer stopCleanup()
+	privacyController.StartExportCleanup(cleanupCtx, time.Hour)
 	healthController := controllers.NewHealthController(db, messageBroker, logger)
 
 	// Initialize router
@@ -95,9 +137,11 @@ func main() {
 	if logger != nil {
 		router.Use(middleware.RequestLoggerMiddleware(logger))
 	}
+	// Requests made by admins acting as other users go to the audit log
+	router.Use(middleware.AuditImpersonation(messageBroker))
 
 	// Setup routes
-	routes.SetupRoutes(router, contentController, healthController, cfg)
+	routes.SetupRoutes(router, contentController, avatarController, privacyController, healthController, jwks, revocations, introspector, cfg)
 
 	// Start the server
 	if logger != nil {
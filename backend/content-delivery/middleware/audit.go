@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hesham-ashraf/LearnVibe/backend/content-delivery/services"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+)
+
+// AuditImpersonation sends every request made with an impersonation token to the CMS audit log,
+// under the impersonating admin, whatever its outcome
+func AuditImpersonation(broker *services.MessageBroker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		value, ok := c.Get("impersonation")
+		if !ok {
+			return
+		}
+		accessClaims, ok := value.(*claims.AccessClaims)
+		if !ok {
+			return
+		}
+
+		services.PublishAuditEvent(broker, services.AuditEvent{
+			ActorID:    accessClaims.ActorID(),
+			Action:     "impersonation.request",
+			TargetType: "user",
+			TargetID:   accessClaims.Subject,
+			Details: map[string]interface{}{
+				"service":  "content-delivery",
+				"method":   c.Request.Method,
+				"path":     c.Request.URL.Path,
+				"status":   c.Writer.Status(),
+				"token_id": accessClaims.ID,
+			},
+			IPAddress: c.ClientIP(),
+			RequestID: c.GetHeader("X-Request-ID"),
+		})
+	}
+}
@@ -1,25 +1,19 @@
 package middleware
 
 import (
-	"errors"
+	"log"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/policy"
 )
 
-// Claims defines the structure for JWT token claims
-type Claims struct {
-	UserID uuid.UUID `json:"user_id"`
-	Role   string    `json:"role"`
-	jwt.RegisteredClaims
-}
-
-// AuthMiddleware verifies the JWT token and sets user info in the context
-func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
+// AuthMiddleware verifies the JWT token against the CMS JWKS, rejects revoked tokens and sets user info in the context.
+// Personal access tokens are checked with the CMS introspection endpoint and only pass on the routes their scopes allow.
+func AuthMiddleware(verifier claims.Verifier, revocations *claims.RevocationList,
+	introspector *claims.IntrospectionClient) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the Authorization header
 		authHeader := c.GetHeader("Authorization")
@@ -37,86 +31,106 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 			return
 		}
 
-		// Extract the token
-		tokenString := headerParts[1]
-
-		// Parse and validate the token
-		claims := &Claims{}
-
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, errors.New("unexpected signing method")
-			}
-			return []byte(jwtSecret), nil
-		})
+		if claims.IsPersonalAccessToken(headerParts[1]) {
+			authenticatePersonalAccessToken(c, introspector, headerParts[1])
+			return
+		}
 
-		// Handle token parsing errors
+		// Parse and validate the token (signature, expiry, issuer, audience and claim version)
+		accessClaims, err := verifier.Parse(headerParts[1])
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
 			return
 		}
 
-		// Check if token is valid
-		if !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		// Check the shared revocation list (logout, role change, admin action)
+		if revocations != nil && revocations.IsRevoked(c.Request.Context(), accessClaims.ID, accessClaims.SessionID, accessClaims.Subject, accessClaims.IssuedAtTime()) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
 			c.Abort()
 			return
 		}
 
-		// Check token expiration
-		if claims.ExpiresAt.Time.Before(time.Now()) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token expired"})
-			c.Abort()
-			return
+		// Impersonation tokens die with the admin's tokens, and can't do everything the user can
+		if accessClaims.IsImpersonation() {
+			if revocations != nil && revocations.IsRevoked(c.Request.Context(), "", "", accessClaims.Actor.Subject, accessClaims.IssuedAtTime()) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+				c.Abort()
+				return
+			}
+			// Set before the check, so refused requests are recorded in the audit log too
+			c.Set("impersonation", accessClaims)
+			if !accessClaims.AllowsRequest(c.Request.Method, c.Request.URL.Path) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Impersonation tokens can't be used for this request"})
+				c.Abort()
+				return
+			}
 		}
 
 		// Set user ID and role in context for future handlers
-		c.Set("userID", claims.UserID)
-		c.Set("userRole", claims.Role)
+		c.Set("userID", accessClaims.UserID())
+		c.Set("userRole", accessClaims.Role)
+		c.Set("organizationID", accessClaims.OrgID())
 
 		c.Next()
 	}
 }
 
-// InstructorOrAdmin middleware ensures the user is an instructor or admin
-func InstructorOrAdmin() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Get user role from context (set by AuthMiddleware)
-		role, exists := c.Get("userRole")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-			c.Abort()
-			return
-		}
+// authenticatePersonalAccessToken asks the CMS about a personal access token and checks the scope the route needs
+func authenticatePersonalAccessToken(c *gin.Context, introspector *claims.IntrospectionClient, token string) {
+	if introspector == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Personal access tokens are not accepted"})
+		c.Abort()
+		return
+	}
 
-		// Check if user has instructor or admin role
-		userRole := role.(string)
-		if userRole != "instructor" && userRole != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "This operation requires instructor or admin privileges"})
-			c.Abort()
-			return
-		}
+	introspection, err := introspector.Introspect(c.Request.Context(), token)
+	if err != nil {
+		log.Printf("Failed to introspect personal access token: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to check token"})
+		c.Abort()
+		return
+	}
+	if !introspection.Active {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid, expired or revoked token"})
+		c.Abort()
+		return
+	}
 
-		c.Next()
+	scope, ok := claims.RequiredScope(c.Request.Method, c.Request.URL.Path)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Personal access tokens can't be used on this endpoint"})
+		c.Abort()
+		return
 	}
+	if !claims.HasScope(introspection.Scopes(), scope) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Token is missing the " + scope + " scope", "required_scope": scope})
+		c.Abort()
+		return
+	}
+
+	c.Set("userID", introspection.UserID())
+	c.Set("userRole", introspection.Role)
+	c.Set("organizationID", introspection.OrgID())
+
+	c.Next()
 }
 
-// AdminOnly middleware ensures the user is an admin
-func AdminOnly() gin.HandlerFunc {
+// RequirePermission turns away users whose role can never perform the action. Permissions limited
+// to the user's own content are checked again by the handler once the content is loaded.
+func RequirePermission(action policy.Action) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get user role from context (set by AuthMiddleware)
-		role, exists := c.Get("userRole")
+		userRole, exists := c.Get("userRole")
 		if !exists {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 			c.Abort()
 			return
 		}
 
-		// Check if user has admin role
-		if role != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "This operation requires admin privileges"})
+		role, _ := userRole.(string)
+		if !policy.Grants(role, action) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to do this"})
 			c.Abort()
 			return
 		}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"gorm.io/gorm"
+)
+
+// Avatar is a user's profile picture, stored in every avatar size. Users have at most one; a new
+// upload replaces the old one, and the CMS keeps the URLs of the current one in the user's profile.
+type Avatar struct {
+	ID             uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID         uuid.UUID `gorm:"type:uuid;index" json:"user_id"`
+	OrganizationID uuid.UUID `gorm:"type:uuid;index" json:"organization_id"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// BeforeCreate hook to set UUID before avatar creation
+func (a *Avatar) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	if a.OrganizationID == uuid.Nil {
+		a.OrganizationID = claims.DefaultOrganizationID
+	}
+	return nil
+}
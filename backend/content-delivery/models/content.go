@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
 	"gorm.io/gorm"
 )
 
@@ -21,22 +22,23 @@ const (
 
 // Content represents a media content item in the system
 type Content struct {
-	ID          uuid.UUID   `gorm:"type:uuid;primaryKey" json:"id"`
-	CourseID    uuid.UUID   `gorm:"type:uuid;index" json:"course_id"`
-	UploadedBy  uuid.UUID   `gorm:"type:uuid;index" json:"uploaded_by"`
-	Title       string      `json:"title"`
-	Description string      `json:"description"`
-	FileName    string      `json:"file_name"`
-	FilePath    string      `json:"file_path"`
-	FileSize    int64       `json:"file_size"`
-	FileType    ContentType `gorm:"type:varchar(20)" json:"file_type"`
-	MimeType    string      `json:"mime_type"`
-	Duration    *int        `json:"duration,omitempty"` // For video/audio in seconds
-	IsPublic    bool        `gorm:"default:false" json:"is_public"`
-	Downloads   int         `gorm:"default:0" json:"downloads"`
-	Views       int         `gorm:"default:0" json:"views"`
-	CreatedAt   time.Time   `json:"created_at"`
-	UpdatedAt   time.Time   `json:"updated_at"`
+	ID             uuid.UUID   `gorm:"type:uuid;primaryKey" json:"id"`
+	CourseID       int         `gorm:"index" json:"course_id"`
+	UploadedBy     uuid.UUID   `gorm:"type:uuid;index" json:"uploaded_by"`
+	OrganizationID uuid.UUID   `gorm:"type:uuid;index" json:"organization_id"` // The uploader's organization, content is never served to other organizations
+	Title          string      `json:"title"`
+	Description    string      `json:"description"`
+	FileName       string      `json:"file_name"`
+	FilePath       string      `json:"file_path"`
+	FileSize       int64       `json:"file_size"`
+	FileType       ContentType `gorm:"type:varchar(20)" json:"file_type"`
+	MimeType       string      `json:"mime_type"`
+	Duration       *int        `json:"duration,omitempty"` // For video/audio in seconds
+	IsPublic       bool        `gorm:"default:false" json:"is_public"`
+	Downloads      int         `gorm:"default:0" json:"downloads"`
+	Views          int         `gorm:"default:0" json:"views"`
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
 }
 
 // BeforeCreate hook to set UUID before content creation
@@ -44,6 +46,9 @@ func (c *Content) BeforeCreate(tx *gorm.DB) error {
 	if c.ID == uuid.Nil {
 		c.ID = uuid.New()
 	}
+	if c.OrganizationID == uuid.Nil {
+		c.OrganizationID = claims.DefaultOrganizationID
+	}
 	return nil
 }
 
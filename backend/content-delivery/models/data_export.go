@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"gorm.io/gorm"
+)
+
+// DataExport is the archive of a user's personal data, built when the CMS asks for it. Its ID is the
+// one the CMS gave the export; the archive is deleted once it expires.
+type DataExport struct {
+	ID             uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID         uuid.UUID `gorm:"type:uuid;index" json:"user_id"`
+	OrganizationID uuid.UUID `gorm:"type:uuid;index" json:"organization_id"`
+	ObjectName     string    `json:"-"` // Where the archive is stored
+	SizeBytes      int64     `json:"size_bytes"`
+	CreatedAt      time.Time `json:"created_at"`
+	ExpiresAt      time.Time `gorm:"index" json:"expires_at"`
+}
+
+// BeforeCreate hook to set the organization before data export creation
+func (e *DataExport) BeforeCreate(tx *gorm.DB) error {
+	if e.OrganizationID == uuid.Nil {
+		e.OrganizationID = claims.DefaultOrganizationID
+	}
+	return nil
+}
+
+// IsExpired checks if the archive can no longer be downloaded at the given time
+func (e *DataExport) IsExpired(at time.Time) bool {
+	return !at.Before(e.ExpiresAt)
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/go-redis/redis/v8"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
 	"github.com/joho/godotenv"
 	"github.com/sony/gobreaker"
 	"gorm.io/driver/postgres"
@@ -100,6 +101,10 @@ func initPostgres() (*gorm.DB, error) {
 		return nil, fmt.Errorf("database connection failed: %v", err)
 	}
 
+	if err := RegisterTenantCallbacks(db); err != nil {
+		return nil, fmt.Errorf("error registering organization callbacks: %v", err)
+	}
+
 	return db, nil
 }
 
@@ -158,15 +163,91 @@ func initRedis() (*redis.Client, error) {
 	return rdb, nil
 }
 
+// courseIDMapTable is where an operator maps the UUID course IDs of contents uploaded before
+// contents were keyed by the CMS's integer course IDs
+const courseIDMapTable = "content_course_id_map"
+
+// migrateContentCourseIDs converts contents.course_id from the UUID it used to be to the CMS's integer
+// course ID. The old IDs can't be derived from anything, so existing contents are only converted
+// with a mapping in content_course_id_map (old_course_id uuid, course_id bigint); without one, or
+// while a content's course isn't mapped, the service refuses to start instead of losing the link.
+func migrateContentCourseIDs(db *gorm.DB) error {
+	var courseIDType string
+	db.Raw("SELECT data_type FROM information_schema.columns WHERE table_name = 'contents' AND column_name = 'course_id'").Scan(&courseIDType)
+	if courseIDType != "uuid" {
+		return nil
+	}
+
+	var contents int64
+	if err := db.Table("contents").Count(&contents).Error; err != nil {
+		return err
+	}
+	if contents > 0 {
+		if !db.Migrator().HasTable(courseIDMapTable) {
+			return fmt.Errorf("%d contents still have UUID course IDs; create %s (old_course_id uuid, course_id bigint) "+
+				"with the CMS course ID of every old course ID and restart", contents, courseIDMapTable)
+		}
+		var unmapped int64
+		err := db.Table("contents").
+			Where("NOT EXISTS (SELECT 1 FROM " + courseIDMapTable + " m WHERE m.old_course_id = contents.course_id AND m.course_id IS NOT NULL)").
+			Count(&unmapped).Error
+		if err != nil {
+			return err
+		}
+		if unmapped > 0 {
+			return fmt.Errorf("%d contents have course IDs that aren't mapped in %s; map them and restart", unmapped, courseIDMapTable)
+		}
+	}
+
+	log.Println("Converting contents.course_id from uuid to bigint...")
+	return db.Transaction(func(tx *gorm.DB) error {
+		statements := []string{"ALTER TABLE contents ADD COLUMN course_id_new bigint"}
+		if contents > 0 {
+			statements = append(statements, "UPDATE contents SET course_id_new = m.course_id FROM "+courseIDMapTable+
+				" m WHERE m.old_course_id = contents.course_id")
+		}
+		statements = append(statements,
+			"ALTER TABLE contents DROP COLUMN course_id",
+			"ALTER TABLE contents RENAME COLUMN course_id_new TO course_id",
+			"ALTER TABLE contents ALTER COLUMN course_id SET NOT NULL",
+		)
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 // MigrateDB performs database migrations with retry logic
 func MigrateDB(db *gorm.DB) {
 	// Migrate models in the correct order to avoid foreign key issues
 	log.Println("Starting database migration...")
 
+	if err := migrateContentCourseIDs(db); err != nil {
+		log.Fatal("Error converting contents.course_id:", err)
+	}
+
 	log.Println("Migrating Content model...")
 	if err := db.AutoMigrate(&Content{}); err != nil {
 		log.Fatal("Error migrating Content model:", err)
 	}
 
+	// Content uploaded before organizations existed belongs to the default one
+	if err := db.Exec("UPDATE contents SET organization_id = ? WHERE organization_id IS NULL", claims.DefaultOrganizationID).Error; err != nil {
+		log.Fatal("Error assigning contents to the default organization:", err)
+	}
+
+	log.Println("Migrating Avatar model...")
+	if err := db.AutoMigrate(&Avatar{}); err != nil {
+		log.Fatal("Error migrating Avatar model:", err)
+	}
+
+	log.Println("Migrating DataExport model...")
+	if err := db.AutoMigrate(&DataExport{}); err != nil {
+		log.Fatal("Error migrating DataExport model:", err)
+	}
+
 	log.Println("Database migration completed successfully!")
-}
\ No newline at end of file
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// dryRunDB returns a DB that builds statements without running them
+func dryRunDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost dbname=learnvibe_content_test"}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	require.NoError(t, err)
+	require.NoError(t, RegisterTenantCallbacks(db))
+	return db
+}
+
+func TestForOrganizationScopesContent(t *testing.T) {
+	db := dryRunDB(t)
+	organizationID := uuid.New()
+	scoped := ForOrganization(db, organizationID)
+
+	tests := []struct {
+		name string
+		run  func(tx *gorm.DB) *gorm.DB
+	}{
+		{"first by id", func(tx *gorm.DB) *gorm.DB { return tx.First(&Content{}, uuid.New()) }},
+		{"find", func(tx *gorm.DB) *gorm.DB { return tx.Where("course_id = ?", 7).Find(&[]Content{}) }},
+		{"update", func(tx *gorm.DB) *gorm.DB { return tx.Model(&Content{ID: uuid.New()}).Update("views", 1) }},
+		{"delete", func(tx *gorm.DB) *gorm.DB { return tx.Delete(&Content{ID: uuid.New()}) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt := tt.run(scoped).Statement
+			assert.Contains(t, stmt.SQL.String(), "organization_id")
+			assert.Contains(t, stmt.Vars, organizationID)
+		})
+	}
+}
+
+func TestForOrganizationAssignsUploads(t *testing.T) {
+	db := dryRunDB(t)
+	organizationID := uuid.New()
+
+	content := Content{Title: "Lecture 1"}
+	require.NoError(t, ForOrganization(db, organizationID).Create(&content).Error)
+	assert.Equal(t, organizationID, content.OrganizationID)
+
+	// Content can't be uploaded into another organization
+	other := Content{Title: "Lecture 2", OrganizationID: uuid.New()}
+	assert.ErrorIs(t, ForOrganization(db, organizationID).Create(&other).Error, ErrWrongOrganization)
+}
@@ -5,16 +5,24 @@ import (
 	"github.com/hesham-ashraf/LearnVibe/backend/content-delivery/config"
 	"github.com/hesham-ashraf/LearnVibe/backend/content-delivery/controllers"
 	"github.com/hesham-ashraf/LearnVibe/backend/content-delivery/middleware"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/policy"
 )
 
 // SetupRoutes configures all the routes for the application
-func SetupRoutes(router *gin.Engine, contentController *controllers.ContentController, healthController *controllers.HealthController, cfg *config.Config) {
+func SetupRoutes(router *gin.Engine, contentController *controllers.ContentController, avatarController *controllers.AvatarController,
+	privacyController *controllers.PrivacyController, healthController *controllers.HealthController,
+	jwks *claims.JWKSClient, revocations *claims.RevocationList, introspector *claims.IntrospectionClient, cfg *config.Config) {
 	// Health check
 	router.GET("/health", healthController.CheckHealth)
 
 	// API routes (protected)
 	api := router.Group("/api")
-	api.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+	api.Use(middleware.AuthMiddleware(claims.Verifier{
+		Keyfunc:  jwks.Keyfunc,
+		Issuer:   cfg.JWTIssuer,
+		Audience: cfg.JWTAudience,
+	}, revocations, introspector))
 	{
 		// Content routes
 		content := api.Group("/content")
@@ -23,15 +31,18 @@ func SetupRoutes(router *gin.Engine, contentController *controllers.ContentContr
 			content.GET("/:id", contentController.GetContent)
 			content.GET("/:id/download", contentController.GetContentDownloadURL)
 
-			// Routes restricted to instructors and admins
-			instructorRoutes := content.Group("")
-			instructorRoutes.Use(middleware.InstructorOrAdmin())
-			{
-				instructorRoutes.POST("", contentController.UploadContent)
-				instructorRoutes.DELETE("/:id", contentController.DeleteContent)
-			}
+			// Routes restricted to instructors and admins; deleting is limited to the uploader or an admin
+			content.POST("", middleware.RequirePermission(policy.ContentUpload), contentController.UploadContent)
+			content.DELETE("/:id", middleware.RequirePermission(policy.ContentDelete), contentController.DeleteContent)
 		}
 
+		// The user's own avatar
+		api.POST("/avatar", avatarController.UploadAvatar)
+		api.DELETE("/avatar", avatarController.DeleteAvatar)
+
+		// The user's personal data exports, requested at the CMS
+		api.GET("/exports/:id/download", privacyController.DownloadExport)
+
 		// For direct public access to content without authentication
 		// This would typically be used for publicly available content
 		// or for content that's served through signed URLs
@@ -40,6 +51,9 @@ func SetupRoutes(router *gin.Engine, contentController *controllers.ContentContr
 			// These routes would be implemented in a real system
 			// They would likely validate signed URLs to control access
 			public.GET("/content/:id", contentController.GetContent)
+
+			// Avatars are shown next to names everywhere, anyone with the URL may see them
+			public.GET("/avatars/:id/:size", avatarController.GetAvatar)
 		}
 	}
 }
@@ -0,0 +1,119 @@
+package routes
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/content-delivery/config"
+	"github.com/hesham-ashraf/LearnVibe/backend/content-delivery/controllers"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/policy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// routeMatrix lists every route with the roles that may reach its handler; nil means everyone,
+// including anonymous callers. Whether the user uploaded the content is checked by the handler.
+var routeMatrix = map[string][]string{
+	"GET /health":                   nil,
+	"GET /api/content/:id":          {policy.RoleStudent, policy.RoleInstructor, policy.RoleOrgAdmin, policy.RoleAdmin},
+	"GET /api/content/:id/download": {policy.RoleStudent, policy.RoleInstructor, policy.RoleOrgAdmin, policy.RoleAdmin},
+	"POST /api/content":             {policy.RoleInstructor, policy.RoleOrgAdmin, policy.RoleAdmin},
+	"DELETE /api/content/:id":       {policy.RoleInstructor, policy.RoleOrgAdmin, policy.RoleAdmin},
+	"GET /public/content/:id":       nil,
+	"POST /api/avatar":              {policy.RoleStudent, policy.RoleInstructor, policy.RoleOrgAdmin, policy.RoleAdmin},
+	"DELETE /api/avatar":            {policy.RoleStudent, policy.RoleInstructor, policy.RoleOrgAdmin, policy.RoleAdmin},
+	"GET /api/exports/:id/download": {policy.RoleStudent, policy.RoleInstructor, policy.RoleOrgAdmin, policy.RoleAdmin},
+	"GET /public/avatars/:id/:size": nil,
+}
+
+var pathParam = regexp.MustCompile(`:[A-Za-z]+`)
+
+// TestRouteAuthorizationMatrix calls every route as every role and checks the auth and permission
+// middlewares let exactly the right users through to the handler
+func TestRouteAuthorizationMatrix(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	public, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(claims.JSONWebKeySet{Keys: []claims.JSONWebKey{{
+			KeyType:   "OKP",
+			KeyID:     "ed-key",
+			Use:       "sig",
+			Algorithm: claims.AlgorithmEdDSA,
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(public),
+		}}})
+	}))
+	defer jwksServer.Close()
+
+	// The controllers have no database or storage, so a handler that's reached panics. Whether it was
+	// reached is all that matters: the middlewares abort the requests they refuse.
+	var reached bool
+	var matchedRoute string
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		defer func() {
+			recover()
+			reached = !c.IsAborted()
+			matchedRoute = c.Request.Method + " " + c.FullPath()
+		}()
+		c.Next()
+	})
+	SetupRoutes(router, &controllers.ContentController{}, &controllers.AvatarController{}, &controllers.PrivacyController{}, controllers.NewHealthController(nil, nil, nil),
+		claims.NewJWKSClient(jwksServer.URL, time.Minute), nil, nil,
+		&config.Config{JWTIssuer: claims.DefaultIssuer, JWTAudience: claims.DefaultAudience})
+
+	// Every route is in the matrix, and the matrix has no stale routes
+	registered := map[string]bool{}
+	for _, route := range router.Routes() {
+		key := route.Method + " " + route.Path
+		registered[key] = true
+		assert.Contains(t, routeMatrix, key, "route isn't in the authorization matrix")
+	}
+	for key := range routeMatrix {
+		assert.True(t, registered[key], "%s is in the matrix but not registered", key)
+	}
+
+	for _, role := range []string{"", policy.RoleStudent, policy.RoleInstructor, policy.RoleOrgAdmin, policy.RoleAdmin} {
+		token := ""
+		if role != "" {
+			accessClaims := claims.NewAccessClaims(uuid.New(), "Caller", "caller@example.com", role,
+				claims.DefaultIssuer, claims.DefaultAudience, time.Hour)
+			jwtToken := jwt.NewWithClaims(jwt.SigningMethodEdDSA, accessClaims)
+			jwtToken.Header["kid"] = "ed-key"
+			token, err = jwtToken.SignedString(key)
+			require.NoError(t, err)
+		}
+
+		for _, route := range router.Routes() {
+			key := route.Method + " " + route.Path
+			t.Run(role+" "+key, func(t *testing.T) {
+				req := httptest.NewRequest(route.Method, pathParam.ReplaceAllString(route.Path, uuid.NewString()), nil)
+				if token != "" {
+					req.Header.Set("Authorization", "Bearer "+token)
+				}
+				reached = false
+				router.ServeHTTP(httptest.NewRecorder(), req)
+
+				allowed := routeMatrix[key] == nil
+				for _, r := range routeMatrix[key] {
+					allowed = allowed || r == role
+				}
+				assert.Equal(t, key, matchedRoute)
+				assert.Equal(t, allowed, reached)
+			})
+		}
+	}
+}
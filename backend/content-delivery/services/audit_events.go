@@ -0,0 +1,41 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventAuditRecorded is the routing key of the audit events the CMS records in its audit log
+const EventAuditRecorded = "audit.recorded"
+
+// AuditEvent describes a security relevant action for the CMS audit log
+type AuditEvent struct {
+	ActorID    uuid.UUID              `json:"actor_id"`
+	Action     string                 `json:"action"`
+	TargetType string                 `json:"target_type"`
+	TargetID   string                 `json:"target_id"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+	Before     map[string]interface{} `json:"before,omitempty"`
+	After      map[string]interface{} `json:"after,omitempty"`
+	IPAddress  string                 `json:"ip_address"`
+	RequestID  string                 `json:"request_id,omitempty"`
+}
+
+// PublishAuditEvent sends an audit event to the CMS if the broker is available.
+// Failures are logged and not returned, so callers never fail a request on them.
+func PublishAuditEvent(mb *MessageBroker, event AuditEvent) {
+	if mb == nil {
+		log.Printf("Message broker unavailable, audit event %q for %s %s not recorded", event.Action, event.TargetType, event.TargetID)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := mb.PublishMessage(ctx, EventAuditRecorded, event); err != nil {
+		log.Printf("Failed to publish audit event %q: %v", event.Action, err)
+	}
+}
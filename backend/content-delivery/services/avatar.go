@@ -0,0 +1,132 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif" // Avatars may be uploaded as GIF, JPEG or PNG
+	_ "image/jpeg"
+	"image/png"
+	"io"
+)
+
+// AvatarSizes are the square sizes, in pixels, every avatar is resized to
+var AvatarSizes = map[string]int{
+	"small":  64,
+	"medium": 128,
+	"large":  256,
+}
+
+// MaxAvatarBytes is the largest avatar upload accepted
+const MaxAvatarBytes = 5 << 20
+
+// maxAvatarPixels limits the dimensions of uploads, so a small file can't decode to a huge image
+const maxAvatarPixels = 25_000_000
+
+var (
+	// ErrUnsupportedImage is returned for uploads that aren't a GIF, JPEG or PNG image
+	ErrUnsupportedImage = errors.New("unsupported image format")
+	// ErrImageTooLarge is returned for uploads over MaxAvatarBytes or with more than maxAvatarPixels pixels
+	ErrImageTooLarge = errors.New("image dimensions are too large")
+)
+
+// ProcessAvatar decodes an uploaded image, crops it to a centered square and returns it resized to
+// each of the AvatarSizes, encoded as PNG
+func ProcessAvatar(r io.Reader) (map[string][]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxAvatarBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxAvatarBytes {
+		return nil, ErrImageTooLarge
+	}
+
+	// Check the dimensions before decoding the pixels
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width == 0 || config.Height == 0 {
+		return nil, ErrUnsupportedImage
+	}
+	if int64(config.Width)*int64(config.Height) > maxAvatarPixels {
+		return nil, ErrImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+
+	square := cropSquare(img)
+	avatars := make(map[string][]byte, len(AvatarSizes))
+	for name, size := range AvatarSizes {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, resize(square, size)); err != nil {
+			return nil, err
+		}
+		avatars[name] = buf.Bytes()
+	}
+	return avatars, nil
+}
+
+// cropSquare returns the largest centered square of img as an RGBA image
+func cropSquare(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	origin := image.Pt(bounds.Min.X+(bounds.Dx()-side)/2, bounds.Min.Y+(bounds.Dy()-side)/2)
+
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, origin, draw.Src)
+	return square
+}
+
+// resize scales a square image to size x size pixels. Every target pixel is the average of the
+// source area it covers, weighted by how much of each source pixel falls inside it, which keeps
+// downscaled avatars smooth. Upscaling repeats the nearest source pixels.
+func resize(src *image.RGBA, size int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	scale := float64(src.Bounds().Dx()) / float64(size)
+	spans := make([][]spanWeight, size)
+	for i := range spans {
+		spans[i] = sourceSpan(float64(i)*scale, float64(i+1)*scale)
+	}
+
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			var r, g, b, a, total float64
+			for _, row := range spans[y] {
+				for _, col := range spans[x] {
+					weight := row.weight * col.weight
+					offset := src.PixOffset(col.index, row.index)
+					r += float64(src.Pix[offset]) * weight
+					g += float64(src.Pix[offset+1]) * weight
+					b += float64(src.Pix[offset+2]) * weight
+					a += float64(src.Pix[offset+3]) * weight
+					total += weight
+				}
+			}
+			offset := dst.PixOffset(x, y)
+			dst.Pix[offset] = uint8(r/total + 0.5)
+			dst.Pix[offset+1] = uint8(g/total + 0.5)
+			dst.Pix[offset+2] = uint8(b/total + 0.5)
+			dst.Pix[offset+3] = uint8(a/total + 0.5)
+		}
+	}
+	return dst
+}
+
+// spanWeight is a source pixel covered by a target pixel and how much of it is covered
+type spanWeight struct {
+	index  int
+	weight float64
+}
+
+// sourceSpan returns the source pixels between the coordinates start and end
+func sourceSpan(start, end float64) []spanWeight {
+	var span []spanWeight
+	for i := int(start); float64(i) < end; i++ {
+		weight := min(end, float64(i+1)) - max(start, float64(i))
+		if weight > 0 {
+			span = append(span, spanWeight{index: i, weight: weight})
+		}
+	}
+	return span
+}
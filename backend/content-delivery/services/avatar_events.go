@@ -0,0 +1,36 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventAvatarUpdated is the routing key of the events telling the CMS a user's avatar changed
+const EventAvatarUpdated = "avatar.updated"
+
+// AvatarEvent describes a user's new avatar. A removed avatar has no ID and no URLs.
+type AvatarEvent struct {
+	UserID    uuid.UUID         `json:"user_id"`
+	AvatarID  *uuid.UUID        `json:"avatar_id,omitempty"`
+	URLs      map[string]string `json:"urls,omitempty"` // By size name, see AvatarSizes
+	UpdatedAt time.Time         `json:"updated_at"`     // Lets the CMS ignore events that arrive out of order
+}
+
+// PublishAvatarEvent sends an avatar event to the CMS if the broker is available.
+// Failures are logged and not returned, so callers never fail a request on them.
+func PublishAvatarEvent(mb *MessageBroker, event AvatarEvent) {
+	if mb == nil {
+		log.Printf("Message broker unavailable, avatar of user %s not updated in the CMS", event.UserID)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := mb.PublishMessage(ctx, EventAvatarUpdated, event); err != nil {
+		log.Printf("Failed to publish avatar event for user %s: %v", event.UserID, err)
+	}
+}
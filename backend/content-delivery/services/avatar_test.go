@@ -0,0 +1,125 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encodePNG encodes an image filled by fill as PNG
+func encodePNG(t *testing.T, width, height int, fill func(x, y int) color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, fill(x, y))
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestProcessAvatarResizesToEverySize(t *testing.T) {
+	upload := encodePNG(t, 600, 400, func(x, y int) color.Color {
+		return color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255}
+	})
+
+	avatars, err := ProcessAvatar(bytes.NewReader(upload))
+	require.NoError(t, err)
+	require.Len(t, avatars, len(AvatarSizes))
+	for name, size := range AvatarSizes {
+		img, err := png.Decode(bytes.NewReader(avatars[name]))
+		require.NoError(t, err, name)
+		assert.Equal(t, image.Rect(0, 0, size, size), img.Bounds(), name)
+	}
+}
+
+func TestProcessAvatarCropsToTheCenter(t *testing.T) {
+	// A wide image with red sides and a green square in the middle
+	upload := encodePNG(t, 300, 100, func(x, y int) color.Color {
+		if x < 100 || x >= 200 {
+			return color.RGBA{R: 255, A: 255}
+		}
+		return color.RGBA{G: 255, A: 255}
+	})
+
+	avatars, err := ProcessAvatar(bytes.NewReader(upload))
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(avatars["small"]))
+	require.NoError(t, err)
+	for _, point := range []image.Point{{0, 0}, {63, 0}, {32, 32}, {0, 63}, {63, 63}} {
+		r, g, _, _ := img.At(point.X, point.Y).RGBA()
+		assert.Zero(t, r, "red at %v", point)
+		assert.Equal(t, uint32(0xffff), g, "not green at %v", point)
+	}
+}
+
+func TestProcessAvatarAveragesWhenDownscaling(t *testing.T) {
+	// Black and white stripes one pixel wide average to grey
+	upload := encodePNG(t, 512, 512, func(x, y int) color.Color {
+		if x%2 == 0 {
+			return color.Gray{Y: 255}
+		}
+		return color.Gray{Y: 0}
+	})
+
+	avatars, err := ProcessAvatar(bytes.NewReader(upload))
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(avatars["small"]))
+	require.NoError(t, err)
+	r, _, _, _ := img.At(10, 10).RGBA()
+	assert.InDelta(t, 0x8000, r, 0x200)
+}
+
+func TestProcessAvatarUpscalesSmallImages(t *testing.T) {
+	upload := encodePNG(t, 2, 2, func(x, y int) color.Color {
+		return color.RGBA{B: 255, A: 255}
+	})
+
+	avatars, err := ProcessAvatar(bytes.NewReader(upload))
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(avatars["large"]))
+	require.NoError(t, err)
+	assert.Equal(t, 256, img.Bounds().Dx())
+	_, _, b, _ := img.At(200, 100).RGBA()
+	assert.Equal(t, uint32(0xffff), b)
+}
+
+func TestProcessAvatarRejectsOtherFiles(t *testing.T) {
+	_, err := ProcessAvatar(bytes.NewReader([]byte("%PDF-1.7 not an image")))
+	assert.ErrorIs(t, err, ErrUnsupportedImage)
+
+	_, err = ProcessAvatar(bytes.NewReader(nil))
+	assert.ErrorIs(t, err, ErrUnsupportedImage)
+}
+
+func TestProcessAvatarRejectsHugeDimensions(t *testing.T) {
+	// A tiny PNG whose header claims 100000 x 100000 pixels is refused before decoding
+	header := make([]byte, 13)
+	binary.BigEndian.PutUint32(header[0:], 100000)
+	binary.BigEndian.PutUint32(header[4:], 100000)
+	header[8] = 8 // bit depth
+	header[9] = 2 // truecolor
+
+	var upload bytes.Buffer
+	upload.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&upload, binary.BigEndian, uint32(len(header)))
+	chunk := append([]byte("IHDR"), header...)
+	upload.Write(chunk)
+	binary.Write(&upload, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+
+	_, err := ProcessAvatar(&upload)
+	assert.ErrorIs(t, err, ErrImageTooLarge)
+}
+
+func TestProcessAvatarRejectsLargeUploads(t *testing.T) {
+	_, err := ProcessAvatar(bytes.NewReader(make([]byte, MaxAvatarBytes+1)))
+	assert.ErrorIs(t, err, ErrImageTooLarge)
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// Routing keys of the CMS enrollment events this service listens to
+const (
+	EventEnrollmentAccessUpdated = "enrollment.access_updated"
+	EventEnrollmentExpired       = "enrollment.expired"
+)
+
+// EnrollmentAccessEvent describes the access window of an enrollment as published by the CMS
+type EnrollmentAccessEvent struct {
+	EnrollmentID uuid.UUID  `json:"enrollment_id"`
+	UserID       uuid.UUID  `json:"user_id"`
+	CourseID     int        `json:"course_id"`
+	Status       string     `json:"status"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+}
+
+// IsExpired checks if the enrollment no longer grants access at the given time
+func (e *EnrollmentAccessEvent) IsExpired(at time.Time) bool {
+	if e.Status == "expired" {
+		return true
+	}
+	return e.ExpiresAt != nil && !at.Before(*e.ExpiresAt)
+}
+
+// EnrollmentAccessService keeps a Redis copy of enrollment access windows published by the CMS, and
+// asks the CMS about the ones it missed the events of
+type EnrollmentAccessService struct {
+	redis      *redis.Client
+	cmsURL     string
+	secret     string
+	httpClient *http.Client
+}
+
+// NewEnrollmentAccessService creates a new enrollment access service. cmsURL is the CMS's
+// enrollment access endpoint, authenticated with secret; without a secret access windows missing from
+// Redis can't be checked.
+func NewEnrollmentAccessService(rdb *redis.Client, cmsURL, secret string) *EnrollmentAccessService {
+	return &EnrollmentAccessService{
+		redis:      rdb,
+		cmsURL:     cmsURL,
+		secret:     secret,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// accessKey returns the Redis key holding the access window of a user in a course
+func accessKey(userID uuid.UUID, courseID int) string {
+	return fmt.Sprintf("enrollment_access:%s:%d", userID, courseID)
+}
+
+// HandleEvent stores the access window carried by an enrollment event. Other enrollment events are
+// dropped, only the decoded access window is stored.
+func (s *EnrollmentAccessService) HandleEvent(payload MessagePayload) error {
+	if payload.EventType != EventEnrollmentAccessUpdated && payload.EventType != EventEnrollmentExpired {
+		return nil
+	}
+
+	// Data arrives as a generic map, so round-trip it into the event type
+	data, err := json.Marshal(payload.Data)
+	if err != nil {
+		return fmt.Errorf("failed to read enrollment event: %v", err)
+	}
+
+	var event EnrollmentAccessEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return fmt.Errorf("failed to decode enrollment event: %v", err)
+	}
+	if event.UserID == uuid.Nil || event.CourseID == 0 {
+		// Nothing we can key on, drop the message instead of requeueing it forever
+		return nil
+	}
+
+	access, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode enrollment access: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return s.redis.Set(ctx, accessKey(event.UserID, event.CourseID), access, 0).Err()
+}
+
+// HasExpired checks if the user's access to the course has expired. Access windows missing from
+// Redis, because the broker was down or a message was lost, are asked from the CMS; when it can't
+// be asked HasExpired fails instead of granting access. Users who aren't enrolled are not expired.
+func (s *EnrollmentAccessService) HasExpired(ctx context.Context, userID uuid.UUID, courseID int) (bool, error) {
+	data, err := s.redis.Get(ctx, accessKey(userID, courseID)).Bytes()
+	if err == redis.Nil {
+		access, err := s.fetchAccess(ctx, userID, courseID)
+		if err != nil {
+			return false, err
+		}
+		if access == nil {
+			return false, nil
+		}
+		s.storeAccess(ctx, *access)
+		return access.IsExpired(time.Now()), nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read enrollment access: %v", err)
+	}
+
+	var event EnrollmentAccessEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return false, fmt.Errorf("failed to decode enrollment access: %v", err)
+	}
+
+	return event.IsExpired(time.Now()), nil
+}
+
+// fetchAccess asks the CMS about the access window of a user in a course. It returns nil when the
+// user isn't enrolled.
+func (s *EnrollmentAccessService) fetchAccess(ctx context.Context, userID uuid.UUID, courseID int) (*EnrollmentAccessEvent, error) {
+	if s.secret == "" {
+		return nil, errors.New("enrollment access unknown and INTROSPECTION_SECRET isn't set to ask the CMS")
+	}
+
+	query := url.Values{"user_id": {userID.String()}, "course_id": {strconv.Itoa(courseID)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.cmsURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.secret)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ask the CMS about enrollment access: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to ask the CMS about enrollment access: CMS answered %s", resp.Status)
+	}
+
+	var access EnrollmentAccessEvent
+	if err := json.NewDecoder(resp.Body).Decode(&access); err != nil {
+		return nil, fmt.Errorf("failed to decode enrollment access: %v", err)
+	}
+	return &access, nil
+}
+
+// storeAccess keeps the CMS's answer until the next enrollment event replaces it, unless an event
+// arrived meanwhile. Failures only cost another request to the CMS.
+func (s *EnrollmentAccessService) storeAccess(ctx context.Context, access EnrollmentAccessEvent) {
+	data, err := json.Marshal(access)
+	if err != nil {
+		return
+	}
+	s.redis.SetNX(ctx, accessKey(access.UserID, access.CourseID), data, 0)
+}
+
+// DeleteUser forgets the access windows of a user in every course, and returns how many it forgot
+func (s *EnrollmentAccessService) DeleteUser(ctx context.Context, userID uuid.UUID) (int, error) {
+	var keys []string
+	iter := s.redis.Scan(ctx, 0, fmt.Sprintf("enrollment_access:%s:*", userID), 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return 0, fmt.Errorf("failed to find enrollment access: %v", err)
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	deleted, err := s.redis.Del(ctx, keys...).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete enrollment access: %v", err)
+	}
+	return int(deleted), nil
+}
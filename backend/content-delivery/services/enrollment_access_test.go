@@ -0,0 +1,58 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchAccess(t *testing.T) {
+	userID := uuid.New()
+	expiresAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer service-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("user_id") != userID.String() || r.URL.Query().Get("course_id") != "7" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(EnrollmentAccessEvent{UserID: userID, CourseID: 7, Status: "active", ExpiresAt: &expiresAt})
+	}))
+	t.Cleanup(server.Close)
+	ctx := context.Background()
+
+	access, err := NewEnrollmentAccessService(nil, server.URL, "service-secret").fetchAccess(ctx, userID, 7)
+	require.NoError(t, err)
+	require.NotNil(t, access)
+	assert.True(t, access.IsExpired(time.Now()))
+
+	// Users who aren't enrolled have nothing to expire
+	access, err = NewEnrollmentAccessService(nil, server.URL, "service-secret").fetchAccess(ctx, userID, 8)
+	assert.NoError(t, err)
+	assert.Nil(t, access)
+
+	// Without an answer from the CMS access can't be decided
+	_, err = NewEnrollmentAccessService(nil, server.URL, "wrong-secret").fetchAccess(ctx, userID, 7)
+	assert.Error(t, err)
+	_, err = NewEnrollmentAccessService(nil, server.URL, "").fetchAccess(ctx, userID, 7)
+	assert.Error(t, err)
+}
+
+// TestHandleEventDropsOtherEvents tests that only access changes are stored; the service has no
+// Redis here, so storing anything would fail
+func TestHandleEventDropsOtherEvents(t *testing.T) {
+	service := NewEnrollmentAccessService(nil, "", "")
+	err := service.HandleEvent(MessagePayload{EventType: "enrollment.expiry_reminder", Data: map[string]interface{}{
+		"user_id": uuid.New(), "course_id": 7, "email": "student@example.com",
+	}})
+	assert.NoError(t, err)
+}
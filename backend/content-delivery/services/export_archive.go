@@ -0,0 +1,66 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// exportReadme opens every data export archive
+const exportReadme = `This archive holds the personal data LearnVibe keeps about you, as of %s.
+
+cms/               Your account, profile, login methods, sessions, enrollments, orders,
+                   courses, instructor applications, access tokens and activity, one JSON
+                   file each
+content-delivery/  The files you uploaded with their details (contents.json), and your
+                   avatar
+
+Secrets like your password and your tokens are never exported.
+`
+
+// ExportArchive writes a user's data export as a zip archive
+type ExportArchive struct {
+	zip *zip.Writer
+}
+
+// NewExportArchive starts an export archive on w, beginning with a README that explains its layout
+func NewExportArchive(w io.Writer, createdAt time.Time) (*ExportArchive, error) {
+	archive := &ExportArchive{zip: zip.NewWriter(w)}
+	readme := fmt.Sprintf(exportReadme, createdAt.UTC().Format(time.RFC1123))
+	if err := archive.AddFile("README.txt", bytes.NewReader([]byte(readme))); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// AddJSON adds a JSON document, indented so it can be read without tools
+func (a *ExportArchive) AddJSON(name string, data json.RawMessage) error {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, data, "", "  "); err != nil {
+		return fmt.Errorf("invalid JSON for %s: %v", name, err)
+	}
+	return a.AddFile(name, &indented)
+}
+
+// AddFile adds a file read from r. Names have to stay inside the archive, so unpacking it can't
+// write anywhere else.
+func (a *ExportArchive) AddFile(name string, r io.Reader) error {
+	if !filepath.IsLocal(name) {
+		return fmt.Errorf("invalid file name in export: %q", name)
+	}
+	w, err := a.zip.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// Close finishes the archive. It doesn't close the underlying writer.
+func (a *ExportArchive) Close() error {
+	return a.zip.Close()
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readArchive returns the files of a zip archive by name
+func readArchive(t *testing.T, data []byte) map[string]string {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	files := map[string]string{}
+	for _, file := range reader.File {
+		r, err := file.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(r)
+		require.NoError(t, err)
+		r.Close()
+		files[file.Name] = string(content)
+	}
+	return files
+}
+
+func TestExportArchive(t *testing.T) {
+	var buf bytes.Buffer
+	archive, err := NewExportArchive(&buf, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.NoError(t, archive.AddJSON("cms/account.json", json.RawMessage(`{"name":"Rosa","email":"rosa@example.com"}`)))
+	require.NoError(t, archive.AddFile("content-delivery/files/1/notes.txt", strings.NewReader("my notes")))
+	require.NoError(t, archive.Close())
+
+	files := readArchive(t, buf.Bytes())
+	require.Len(t, files, 3)
+	assert.Contains(t, files["README.txt"], "Fri, 01 Mar 2024 12:00:00 UTC")
+	assert.Equal(t, "{\n  \"name\": \"Rosa\",\n  \"email\": \"rosa@example.com\"\n}", files["cms/account.json"])
+	assert.Equal(t, "my notes", files["content-delivery/files/1/notes.txt"])
+}
+
+func TestExportArchiveRejectsNamesOutsideTheArchive(t *testing.T) {
+	archive, err := NewExportArchive(io.Discard, time.Now())
+	require.NoError(t, err)
+
+	for _, name := range []string{"../notes.txt", "/etc/passwd", "files/../../notes.txt", ""} {
+		assert.Error(t, archive.AddFile(name, strings.NewReader("x")), name)
+	}
+	assert.Error(t, archive.AddJSON("cms/broken.json", json.RawMessage(`{"name":`)))
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Routing keys of the privacy events. The CMS asks for exports and erasures with the request
+// events, this service answers with the result events.
+const (
+	EventPrivacyExportRequested  = "privacy.request.export"
+	EventPrivacyErasureRequested = "privacy.request.erasure"
+	EventPrivacyExportReady      = "privacy.result.export_ready"
+	EventPrivacyExportFailed     = "privacy.result.export_failed"
+	EventPrivacyErasureCompleted = "privacy.result.erasure_completed"
+)
+
+// ErasureServiceName is the name this service reports its erasure step under
+const ErasureServiceName = "content-delivery"
+
+// DataExportRequest asks for an export archive of a user's data. Records holds the CMS's part of the
+// data, by kind of record.
+type DataExportRequest struct {
+	ExportID       uuid.UUID                  `json:"export_id"`
+	UserID         uuid.UUID                  `json:"user_id"`
+	OrganizationID uuid.UUID                  `json:"organization_id"`
+	Records        map[string]json.RawMessage `json:"records"`
+}
+
+// DataExportResult tells the CMS whether an export archive is ready for download
+type DataExportResult struct {
+	ExportID  uuid.UUID  `json:"export_id"`
+	SizeBytes int64      `json:"size_bytes,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Error     string     `json:"error,omitempty"` // Why the export failed, shown to the user
+}
+
+// ErasureRequest asks for a deleted user's personal data to be erased
+type ErasureRequest struct {
+	DeletionID     uuid.UUID `json:"deletion_id"`
+	UserID         uuid.UUID `json:"user_id"`
+	OrganizationID uuid.UUID `json:"organization_id"`
+}
+
+// ErasureResult tells the CMS what this service erased, for the user's deletion receipt
+type ErasureResult struct {
+	DeletionID  uuid.UUID      `json:"deletion_id"`
+	Service     string         `json:"service"`
+	CompletedAt time.Time      `json:"completed_at"`
+	Erased      map[string]int `json:"erased"` // Number of records deleted, by kind
+	Kept        map[string]int `json:"kept"`   // Number of records kept, by kind
+}
+
+// PublishPrivacyEvent sends the result of an export or erasure to the CMS if the broker is
+// available. Failures are logged and not returned.
+func PublishPrivacyEvent(mb *MessageBroker, routingKey string, result interface{}) {
+	if mb == nil {
+		log.Printf("Message broker unavailable, %s event not sent to the CMS", routingKey)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := mb.PublishMessage(ctx, routingKey, result); err != nil {
+		log.Printf("Failed to publish %s event: %v", routingKey, err)
+	}
+}
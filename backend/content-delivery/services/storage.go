@@ -6,9 +6,11 @@ import (
 	"io"
 	"log"
 	"net/url"
+	"path"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
+	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/sony/gobreaker"
@@ -90,6 +92,12 @@ func NewStorageService(endpoint, accessKey, secretKey, bucketName string, useSSL
 	}, nil
 }
 
+// ObjectName returns the name to store an organization's object under. Every organization has its
+// own prefix, so objects of different organizations can't collide or be listed together.
+func (s *StorageService) ObjectName(organizationID uuid.UUID, name string) string {
+	return path.Join("organizations", organizationID.String(), name)
+}
+
 // UploadFile uploads a file to the storage service
 func (s *StorageService) UploadFile(ctx context.Context, objectName string, reader io.Reader, size int64, contentType string) error {
 	// Circuit breaker for upload operation
@@ -122,6 +130,23 @@ func (s *StorageService) UploadFile(ctx context.Context, objectName string, read
 	return nil
 }
 
+// GetFile opens a stored file for reading and returns its size; the caller closes the reader
+func (s *StorageService) GetFile(ctx context.Context, objectName string) (io.ReadCloser, int64, error) {
+	object, err := s.client.GetObject(ctx, s.bucketName, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get file: %v", err)
+	}
+
+	// GetObject is lazy, Stat finds out whether the file exists
+	info, err := object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, 0, fmt.Errorf("failed to get file: %w", err)
+	}
+
+	return object, info.Size, nil
+}
+
 // GetPresignedURL generates a presigned URL for downloading a file
 func (s *StorageService) GetPresignedURL(ctx context.Context, objectName string, expiry time.Duration) (string, error) {
 	// Circuit breaker for presigned URL generation
@@ -5,6 +5,7 @@ import (
 	"os"
 	"strconv"
 
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
 	"github.com/joho/godotenv"
 )
 
@@ -16,7 +17,10 @@ type Config struct {
 	HTTPSPort   string
 
 	// JWT settings for validation
-	JWTSecret string
+	JWKSURL      string
+	JWKSCacheTTL int // in seconds
+	JWTIssuer    string
+	JWTAudience  string
 
 	// Rate limiting
 	RateLimitRequests int
@@ -35,6 +39,17 @@ type Config struct {
 
 	// Request timeouts
 	RequestTimeout int // in seconds
+
+	// Redis settings (token revocation list)
+	RedisURL             string
+	RedisPass            string
+	RevocationCacheTTL   int  // in seconds
+	RevocationFailClosed bool // reject tokens while the revocation list can't be read
+
+	// Personal access token introspection at the CMS
+	IntrospectionURL      string
+	IntrospectionSecret   string
+	IntrospectionCacheTTL int // in seconds
 }
 
 // LoadConfig loads configuration from environment variables
@@ -47,7 +62,6 @@ func LoadConfig() (*Config, error) {
 		Port:                      getEnv("PORT", "8000"),
 		EnableHTTPS:               getEnvAsBool("ENABLE_HTTPS", false),
 		HTTPSPort:                 getEnv("HTTPS_PORT", "8443"),
-		JWTSecret:                 getEnv("JWT_SECRET", "your-secret-key"),
 		RateLimitRequests:         getEnvAsInt("RATE_LIMIT_REQUESTS", 100),
 		RateLimitDuration:         getEnvAsInt("RATE_LIMIT_DURATION", 60),
 		CircuitBreakerMaxRequests: uint32(getEnvAsInt("CIRCUIT_BREAKER_MAX_REQUESTS", 5)),
@@ -58,12 +72,20 @@ func LoadConfig() (*Config, error) {
 		CMSHealthEndpoint:         getEnv("CMS_HEALTH_ENDPOINT", "/health"),
 		ContentHealthEndpoint:     getEnv("CONTENT_HEALTH_ENDPOINT", "/health"),
 		RequestTimeout:            getEnvAsInt("REQUEST_TIMEOUT", 30),
+		JWKSCacheTTL:              getEnvAsInt("JWKS_CACHE_TTL", 300),
+		JWTIssuer:                 getEnv("JWT_ISSUER", claims.DefaultIssuer),
+		JWTAudience:               getEnv("JWT_AUDIENCE", claims.DefaultAudience),
+		RedisURL:                  getEnv("REDIS_URL", "localhost:6379"),
+		RedisPass:                 getEnv("REDIS_PASSWORD", ""),
+		RevocationCacheTTL:        getEnvAsInt("REVOCATION_CACHE_TTL", 10),
+		RevocationFailClosed:      getEnvAsBool("REVOCATION_FAIL_CLOSED", true),
+		IntrospectionSecret:       getEnv("INTROSPECTION_SECRET", ""),
+		IntrospectionCacheTTL:     getEnvAsInt("INTROSPECTION_CACHE_TTL", 30),
 	}
 
-	// Validate required settings
-	if config.JWTSecret == "" {
-		log.Println("WARNING: JWT_SECRET is not set! Using default value for development only!")
-	}
+	// Tokens are verified with the keys the CMS publishes
+	config.JWKSURL = getEnv("JWKS_URL", config.CMSServiceURL+"/.well-known/jwks.json")
+	config.IntrospectionURL = getEnv("INTROSPECTION_URL", config.CMSServiceURL+"/auth/introspect")
 
 	return config, nil
 }
@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 	"github.com/hesham-ashraf/LearnVibe/backend/gateway/config"
 	"github.com/hesham-ashraf/LearnVibe/backend/gateway/health"
 	"github.com/hesham-ashraf/LearnVibe/backend/gateway/proxy"
 	"github.com/hesham-ashraf/LearnVibe/backend/gateway/routes"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
 )
 
 func main() {
@@ -28,6 +32,40 @@ func main() {
 	// Initialize health checker
 	healthChecker := health.NewHealthChecker(cfg)
 
+	// Connect to Redis for the token revocation list written by the CMS
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisURL,
+		Password: cfg.RedisPass,
+	})
+	// Failing closed keeps the client so that requests are let through again once Redis is back
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		if cfg.RevocationFailClosed {
+			log.Printf("Warning: Failed to connect to Redis, tokens will be rejected until it is reachable: %v", err)
+		} else {
+			log.Printf("Warning: Failed to connect to Redis, revoked tokens won't be rejected: %v", err)
+			rdb = nil
+		}
+	}
+	if rdb != nil {
+		defer rdb.Close()
+	}
+	revocations := claims.NewRevocationList(rdb, time.Duration(cfg.RevocationCacheTTL)*time.Second, cfg.RevocationFailClosed)
+
+	// Tokens are signed by the CMS, fetch its public keys
+	jwks := claims.NewJWKSClient(cfg.JWKSURL, time.Duration(cfg.JWKSCacheTTL)*time.Second)
+	if err := jwks.Refresh(context.Background()); err != nil {
+		log.Printf("Warning: Failed to fetch JWKS from %s, will retry when verifying tokens: %v", cfg.JWKSURL, err)
+	}
+
+	// Personal access tokens are opaque, the CMS says who they belong to
+	var introspector *claims.IntrospectionClient
+	if cfg.IntrospectionSecret != "" {
+		introspector = claims.NewIntrospectionClient(cfg.IntrospectionURL, cfg.IntrospectionSecret,
+			time.Duration(cfg.IntrospectionCacheTTL)*time.Second)
+	} else {
+		log.Printf("Warning: INTROSPECTION_SECRET is not set, personal access tokens will be rejected")
+	}
+
 	// Setup Gin
 	if gin.Mode() == gin.ReleaseMode {
 		gin.SetMode(gin.ReleaseMode)
@@ -35,7 +73,7 @@ func main() {
 	router := gin.Default()
 
 	// Setup routes
-	routes.SetupRoutes(router, serviceProxy, healthChecker, cfg)
+	routes.SetupRoutes(router, serviceProxy, healthChecker, jwks, revocations, introspector, cfg)
 
 	// Create certs directory if it doesn't exist
 	if cfg.EnableHTTPS {
@@ -1,28 +1,33 @@
 package middleware
 
 import (
-	"errors"
+	"log"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
 )
 
-// Claims defines the structure for JWT token claims
-type Claims struct {
-	UserID string `json:"user_id"`
-	Role   string `json:"role"`
-	jwt.RegisteredClaims
-}
-
-// TokenValidationMiddleware validates JWT tokens and sets claims in context
-func TokenValidationMiddleware(jwtSecret string) gin.HandlerFunc {
+// TokenValidationMiddleware validates JWT tokens against the CMS JWKS, rejects revoked tokens and sets claims in context.
+// Personal access tokens are checked with the CMS introspection endpoint and only pass on the routes their scopes allow.
+func TokenValidationMiddleware(verifier claims.Verifier, revocations *claims.RevocationList,
+	introspector *claims.IntrospectionClient) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Skip validation for auth endpoints
+		// Identity headers are only ever set by the gateway itself
+		c.Request.Header.Del("X-User-ID")
+		c.Request.Header.Del("X-User-Role")
+		c.Request.Header.Del("X-Organization-ID")
+		c.Request.Header.Del("X-Impersonator-ID")
+
+		// Skip validation for auth endpoints, the JWKS, payment webhooks (signed by the provider),
+		// LTI launches (signed by the platform) and avatars, which browsers load without a token
 		if strings.HasPrefix(c.Request.URL.Path, "/auth") ||
-			strings.HasPrefix(c.Request.URL.Path, "/health") {
+			strings.HasPrefix(c.Request.URL.Path, "/health") ||
+			strings.HasPrefix(c.Request.URL.Path, "/.well-known") ||
+			strings.HasPrefix(c.Request.URL.Path, "/payments") ||
+			strings.HasPrefix(c.Request.URL.Path, "/lti/") ||
+			strings.HasPrefix(c.Request.URL.Path, "/public/avatars/") {
 			c.Next()
 			return
 		}
@@ -41,45 +46,87 @@ func TokenValidationMiddleware(jwtSecret string) gin.HandlerFunc {
 			return
 		}
 
-		// Extract the token
-		tokenString := headerParts[1]
-
-		// Parse and validate the token
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, errors.New("unexpected signing method")
-			}
-			return []byte(jwtSecret), nil
-		})
+		if claims.IsPersonalAccessToken(headerParts[1]) {
+			validatePersonalAccessToken(c, introspector, headerParts[1])
+			return
+		}
 
-		// Handle token parsing errors
+		// Parse and validate the token (signature, expiry, issuer, audience and claim version)
+		accessClaims, err := verifier.Parse(headerParts[1])
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			return
 		}
 
-		// Check if token is valid
-		if !token.Valid {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		// Check the shared revocation list (logout, role change, admin action)
+		if revocations != nil && revocations.IsRevoked(c.Request.Context(), accessClaims.ID, accessClaims.SessionID, accessClaims.Subject, accessClaims.IssuedAtTime()) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
 			return
 		}
 
-		// Check token expiration
-		if claims.ExpiresAt.Time.Before(time.Now()) {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token expired"})
-			return
+		// Impersonation tokens die with the admin's tokens, and can't do everything the user can.
+		// The services record them in the audit log, the gateway logs every request it lets through.
+		if accessClaims.IsImpersonation() {
+			if revocations != nil && revocations.IsRevoked(c.Request.Context(), "", "", accessClaims.Actor.Subject, accessClaims.IssuedAtTime()) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+				return
+			}
+			if !accessClaims.AllowsRequest(c.Request.Method, c.Request.URL.Path) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Impersonation tokens can't be used for this request"})
+				return
+			}
+			log.Printf("Admin %s impersonating user %s: %s %s", accessClaims.Actor.Subject, accessClaims.Subject, c.Request.Method, c.Request.URL.Path)
+			c.Request.Header.Set("X-Impersonator-ID", accessClaims.Actor.Subject)
 		}
 
 		// Set claims in context for future handlers
-		c.Set("userID", claims.UserID)
-		c.Set("userRole", claims.Role)
+		c.Set("userID", accessClaims.Subject)
+		c.Set("userRole", accessClaims.Role)
 
-		// Forward the authorization header to downstream services
-		c.Request.Header.Set("X-User-ID", claims.UserID)
-		c.Request.Header.Set("X-User-Role", claims.Role)
+		// Forward the identity to downstream services
+		c.Request.Header.Set("X-User-ID", accessClaims.Subject)
+		c.Request.Header.Set("X-User-Role", accessClaims.Role)
+		c.Request.Header.Set("X-Organization-ID", accessClaims.OrgID().String())
 
 		c.Next()
 	}
 }
+
+// validatePersonalAccessToken asks the CMS about a personal access token and checks the scope the route needs
+func validatePersonalAccessToken(c *gin.Context, introspector *claims.IntrospectionClient, token string) {
+	if introspector == nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Personal access tokens are not accepted"})
+		return
+	}
+
+	introspection, err := introspector.Introspect(c.Request.Context(), token)
+	if err != nil {
+		log.Printf("Failed to introspect personal access token: %v", err)
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to check token"})
+		return
+	}
+	if !introspection.Active {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid, expired or revoked token"})
+		return
+	}
+
+	scope, ok := claims.RequiredScope(c.Request.Method, c.Request.URL.Path)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Personal access tokens can't be used on this endpoint"})
+		return
+	}
+	if !claims.HasScope(introspection.Scopes(), scope) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Token is missing the " + scope + " scope", "required_scope": scope})
+		return
+	}
+
+	c.Set("userID", introspection.Subject)
+	c.Set("userRole", introspection.Role)
+
+	// Forward the identity to downstream services
+	c.Request.Header.Set("X-User-ID", introspection.Subject)
+	c.Request.Header.Set("X-User-Role", introspection.Role)
+	c.Request.Header.Set("X-Organization-ID", introspection.OrgID().String())
+
+	c.Next()
+}
@@ -0,0 +1,360 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
+	"github.com/stretchr/testify/assert"
+)
+
+// testKeys are the RS256 and EdDSA keys served by the fake CMS JWKS endpoint
+type testKeys struct {
+	rsaKey *rsa.PrivateKey
+	edKey  ed25519.PrivateKey
+}
+
+func newTestJWKS(t *testing.T) (*testKeys, *httptest.Server) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	jwks := claims.JSONWebKeySet{Keys: []claims.JSONWebKey{
+		{
+			KeyType:   "RSA",
+			KeyID:     "rsa-key",
+			Use:       "sig",
+			Algorithm: claims.AlgorithmRS256,
+			N:         base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+		},
+		{
+			KeyType:   "OKP",
+			KeyID:     "ed-key",
+			Use:       "sig",
+			Algorithm: claims.AlgorithmEdDSA,
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(edPublic),
+		},
+	}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwks)
+	}))
+	t.Cleanup(server.Close)
+
+	return &testKeys{rsaKey: rsaKey, edKey: edKey}, server
+}
+
+// sign signs the claims the same way the CMS does, with the kid in the header
+func (k *testKeys) sign(t *testing.T, alg string, c jwt.Claims) string {
+	var token *jwt.Token
+	var key interface{}
+	switch alg {
+	case claims.AlgorithmRS256:
+		token = jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+		token.Header["kid"] = "rsa-key"
+		key = k.rsaKey
+	default:
+		token = jwt.NewWithClaims(jwt.SigningMethodEdDSA, c)
+		token.Header["kid"] = "ed-key"
+		key = k.edKey
+	}
+	signed, err := token.SignedString(key)
+	assert.NoError(t, err)
+	return signed
+}
+
+func TestTokenValidationMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keys, server := newTestJWKS(t)
+	jwks := claims.NewJWKSClient(server.URL, time.Minute)
+	verifier := claims.Verifier{Keyfunc: jwks.Keyfunc, Issuer: claims.DefaultIssuer, Audience: claims.DefaultAudience}
+
+	router := gin.New()
+	router.Use(TokenValidationMiddleware(verifier, nil, nil))
+	router.GET("/api/courses", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"user_id":   c.Request.Header.Get("X-User-ID"),
+			"user_role": c.Request.Header.Get("X-User-Role"),
+			"org_id":    c.Request.Header.Get("X-Organization-ID"),
+		})
+	})
+
+	userID := uuid.New()
+	valid := func() *claims.AccessClaims {
+		return claims.NewAccessClaims(userID, "Test User", "test@example.com", "instructor", claims.DefaultIssuer, claims.DefaultAudience, time.Hour)
+	}
+
+	tests := []struct {
+		name       string
+		token      func() string
+		wantStatus int
+	}{
+		{"valid RS256 token", func() string { return keys.sign(t, claims.AlgorithmRS256, valid()) }, http.StatusOK},
+		{"valid EdDSA token", func() string { return keys.sign(t, claims.AlgorithmEdDSA, valid()) }, http.StatusOK},
+		{"wrong audience", func() string {
+			c := valid()
+			c.Audience = jwt.ClaimStrings{"another-api"}
+			return keys.sign(t, claims.AlgorithmRS256, c)
+		}, http.StatusUnauthorized},
+		{"wrong issuer", func() string {
+			c := valid()
+			c.Issuer = "someone-else"
+			return keys.sign(t, claims.AlgorithmEdDSA, c)
+		}, http.StatusUnauthorized},
+		{"unsupported claims version", func() string {
+			c := valid()
+			c.Version = claims.Version + 1
+			return keys.sign(t, claims.AlgorithmRS256, c)
+		}, http.StatusUnauthorized},
+		{"legacy user_id claim", func() string {
+			return keys.sign(t, claims.AlgorithmRS256, jwt.MapClaims{
+				"user_id": userID.String(),
+				"role":    "instructor",
+				"exp":     time.Now().Add(time.Hour).Unix(),
+			})
+		}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/courses", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token())
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus == http.StatusOK {
+				var body map[string]string
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, userID.String(), body["user_id"])
+				assert.Equal(t, "instructor", body["user_role"])
+				assert.Equal(t, claims.DefaultOrganizationID.String(), body["org_id"])
+			}
+		})
+	}
+}
+
+// TestTokenValidationMiddlewareLTILaunches tests that LTI launches, which come from the learning
+// platform without a token, reach the CMS
+func TestTokenValidationMiddlewareLTILaunches(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	_, server := newTestJWKS(t)
+	jwks := claims.NewJWKSClient(server.URL, time.Minute)
+	verifier := claims.Verifier{Keyfunc: jwks.Keyfunc, Issuer: claims.DefaultIssuer, Audience: claims.DefaultAudience}
+
+	router := gin.New()
+	router.Use(TokenValidationMiddleware(verifier, nil, nil))
+	router.POST("/lti/launch", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST("/ltifake", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/lti/launch", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ltifake", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+// TestTokenValidationMiddlewareAvatars tests that avatars, which browsers load in image tags
+// without a token, reach content-delivery while uploading one still needs a token
+func TestTokenValidationMiddlewareAvatars(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	_, server := newTestJWKS(t)
+	jwks := claims.NewJWKSClient(server.URL, time.Minute)
+	verifier := claims.Verifier{Keyfunc: jwks.Keyfunc, Issuer: claims.DefaultIssuer, Audience: claims.DefaultAudience}
+
+	router := gin.New()
+	router.Use(TokenValidationMiddleware(verifier, nil, nil))
+	router.GET("/public/avatars/:id/:size", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST("/api/avatar", func(c *gin.Context) { c.Status(http.StatusCreated) })
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/public/avatars/"+uuid.NewString()+"/small.png", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/avatar", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+// TestTokenValidationMiddlewareRevokedSession tests that tokens of a session signed out in the CMS
+// are rejected while the user's other sessions keep working
+func TestTokenValidationMiddlewareRevokedSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keys, server := newTestJWKS(t)
+	jwks := claims.NewJWKSClient(server.URL, time.Minute)
+	verifier := claims.Verifier{Keyfunc: jwks.Keyfunc, Issuer: claims.DefaultIssuer, Audience: claims.DefaultAudience}
+	revocations := claims.NewRevocationList(nil, time.Minute, false)
+
+	router := gin.New()
+	router.Use(TokenValidationMiddleware(verifier, revocations, nil))
+	router.GET("/api/courses", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	userID := uuid.New()
+	sessionToken := func(sessionID string) string {
+		c := claims.NewAccessClaims(userID, "Test User", "test@example.com", "student", claims.DefaultIssuer, claims.DefaultAudience, time.Hour)
+		c.SessionID = sessionID
+		return keys.sign(t, claims.AlgorithmRS256, c)
+	}
+	signedOut, other := uuid.NewString(), uuid.NewString()
+	assert.NoError(t, revocations.RevokeSession(context.Background(), signedOut, time.Hour))
+
+	for token, wantStatus := range map[string]int{
+		sessionToken(signedOut): http.StatusUnauthorized,
+		sessionToken(other):     http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/courses", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, wantStatus, w.Code)
+	}
+}
+
+func TestTokenValidationMiddlewareStripsSpoofedIdentity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(TokenValidationMiddleware(claims.Verifier{}, nil, nil))
+	router.GET("/auth/me", func(c *gin.Context) {
+		c.String(http.StatusOK, c.Request.Header.Get("X-User-ID"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+	req.Header.Set("X-User-ID", uuid.New().String())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestTokenValidationMiddlewarePersonalAccessTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID := uuid.New()
+	cms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := claims.Introspection{Active: false}
+		if r.PostFormValue("token") == "lvp_scripts" {
+			result = claims.Introspection{Active: true, Subject: userID.String(), Role: "instructor", Scope: claims.ScopeCoursesRead}
+		}
+		json.NewEncoder(w).Encode(result)
+	}))
+	t.Cleanup(cms.Close)
+
+	router := gin.New()
+	router.Use(TokenValidationMiddleware(claims.Verifier{}, nil, claims.NewIntrospectionClient(cms.URL, "secret", time.Minute)))
+	handler := func(c *gin.Context) {
+		c.String(http.StatusOK, c.Request.Header.Get("X-User-ID"))
+	}
+	router.GET("/api/courses", handler)
+	router.POST("/api/courses", handler)
+	router.GET("/api/instructor-applications/mine", handler)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		wantStatus int
+	}{
+		{"scope granted", http.MethodGet, "/api/courses", "lvp_scripts", http.StatusOK},
+		{"scope missing", http.MethodPost, "/api/courses", "lvp_scripts", http.StatusForbidden},
+		{"route without scopes", http.MethodGet, "/api/instructor-applications/mine", "lvp_scripts", http.StatusForbidden},
+		{"revoked token", http.MethodGet, "/api/courses", "lvp_revoked", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, userID.String(), w.Body.String())
+			}
+		})
+	}
+
+	// Without an introspection client personal access tokens are refused
+	router = gin.New()
+	router.Use(TokenValidationMiddleware(claims.Verifier{}, nil, nil))
+	router.GET("/api/courses", handler)
+	req := httptest.NewRequest(http.MethodGet, "/api/courses", nil)
+	req.Header.Set("Authorization", "Bearer lvp_scripts")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestTokenValidationMiddlewareImpersonation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keys, server := newTestJWKS(t)
+	jwks := claims.NewJWKSClient(server.URL, time.Minute)
+	verifier := claims.Verifier{Keyfunc: jwks.Keyfunc, Issuer: claims.DefaultIssuer, Audience: claims.DefaultAudience}
+
+	router := gin.New()
+	router.Use(TokenValidationMiddleware(verifier, nil, nil))
+	handler := func(c *gin.Context) {
+		c.String(http.StatusOK, c.Request.Header.Get("X-Impersonator-ID"))
+	}
+	router.GET("/api/courses", handler)
+	router.POST("/api/courses", handler)
+
+	adminID := uuid.New().String()
+	impersonation := func(readOnly bool) string {
+		c := claims.NewAccessClaims(uuid.New(), "Test User", "test@example.com", "student", claims.DefaultIssuer, claims.DefaultAudience, time.Hour)
+		c.Actor = &claims.Actor{Subject: adminID, Email: "admin@example.com"}
+		c.ReadOnly = readOnly
+		return keys.sign(t, claims.AlgorithmRS256, c)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		token      string
+		wantStatus int
+	}{
+		{"read-only token reads", http.MethodGet, impersonation(true), http.StatusOK},
+		{"read-only token can't write", http.MethodPost, impersonation(true), http.StatusForbidden},
+		{"writable token writes", http.MethodPost, impersonation(false), http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/courses", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			req.Header.Set("X-Impersonator-ID", uuid.New().String())
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, adminID, w.Body.String())
+			}
+		})
+	}
+}
@@ -8,14 +8,21 @@ import (
 	"github.com/hesham-ashraf/LearnVibe/backend/gateway/health"
 	"github.com/hesham-ashraf/LearnVibe/backend/gateway/middleware"
 	"github.com/hesham-ashraf/LearnVibe/backend/gateway/proxy"
+	"github.com/hesham-ashraf/LearnVibe/backend/shared/claims"
 )
 
 // SetupRoutes configures all the routes for the API Gateway
-func SetupRoutes(router *gin.Engine, serviceProxy *proxy.ServiceProxy, healthChecker *health.HealthChecker, cfg *config.Config) {
+func SetupRoutes(router *gin.Engine, serviceProxy *proxy.ServiceProxy, healthChecker *health.HealthChecker,
+	jwks *claims.JWKSClient, revocations *claims.RevocationList, introspector *claims.IntrospectionClient,
+	cfg *config.Config) {
 	// Middleware
 	router.Use(middleware.CORSMiddleware())
 	router.Use(middleware.RateLimitMiddleware(cfg.RateLimitRequests, cfg.RateLimitDuration))
-	router.Use(middleware.TokenValidationMiddleware(cfg.JWTSecret))
+	router.Use(middleware.TokenValidationMiddleware(claims.Verifier{
+		Keyfunc:  jwks.Keyfunc,
+		Issuer:   cfg.JWTIssuer,
+		Audience: cfg.JWTAudience,
+	}, revocations, introspector))
 
 	// Health check endpoint
 	router.GET("/health", healthChecker.CheckAllServices)
@@ -23,10 +30,20 @@ func SetupRoutes(router *gin.Engine, serviceProxy *proxy.ServiceProxy, healthChe
 	// Auth routes - proxy to CMS service
 	router.Group("/auth/*path").Use(serviceProxy.ProxyCMSRequest())
 
+	// JWKS - proxy to CMS service
+	router.Group("/.well-known/*path").Use(serviceProxy.ProxyCMSRequest())
+
+	// Payment webhooks - proxy to CMS service
+	router.Group("/payments/*path").Use(serviceProxy.ProxyCMSRequest())
+
+	// LTI launches from learning platforms - proxy to CMS service
+	router.Group("/lti/*path").Use(serviceProxy.ProxyCMSRequest())
+
 	// CMS API routes
 	cmsRoutes := []string{
 		"/api/courses",
 		"/api/enrollments",
+		"/api/orders",
 		"/api/admin",
 	}
 
@@ -37,7 +54,10 @@ func SetupRoutes(router *gin.Engine, serviceProxy *proxy.ServiceProxy, healthChe
 	// Content API routes
 	contentRoutes := []string{
 		"/api/content",
+		"/api/avatar",
+		"/api/exports",
 		"/public/content",
+		"/public/avatars",
 	}
 
 	for _, route := range contentRoutes {
@@ -49,7 +69,8 @@ func SetupRoutes(router *gin.Engine, serviceProxy *proxy.ServiceProxy, healthChe
 		path := c.Request.URL.Path
 
 		// Decide where to proxy based on the path
-		if strings.HasPrefix(path, "/api/content") || strings.HasPrefix(path, "/public/content") {
+		if strings.HasPrefix(path, "/api/content") || strings.HasPrefix(path, "/public/content") ||
+			strings.HasPrefix(path, "/api/avatar") || strings.HasPrefix(path, "/api/exports") || strings.HasPrefix(path, "/public/avatars") {
 			serviceProxy.ProxyContentRequest()(c)
 		} else {
 			// Default to CMS service
@@ -14,8 +14,7 @@ type TestConfig struct {
 // LoadTestConfig loads a configuration for testing
 func LoadTestConfig() (*TestConfig, error) {
 	// Set testing environment variables
-	os.Setenv("PORT", "8099") // Use different port for tests
-	os.Setenv("JWT_SECRET", "test-secret-key")
+	os.Setenv("PORT", "8099")                             // Use different port for tests
 	os.Setenv("CMS_SERVICE_URL", "http://localhost:8098") // Mock service URLs
 	os.Setenv("CONTENT_SERVICE_URL", "http://localhost:8097")
 	os.Setenv("ENABLE_HTTPS", "false") // Disable HTTPS for testing
@@ -35,7 +34,6 @@ func LoadTestConfig() (*TestConfig, error) {
 func ResetConfig() {
 	// Unset all environment variables used in tests
 	os.Unsetenv("PORT")
-	os.Unsetenv("JWT_SECRET")
 	os.Unsetenv("CMS_SERVICE_URL")
 	os.Unsetenv("CONTENT_SERVICE_URL")
 	os.Unsetenv("ENABLE_HTTPS")
@@ -0,0 +1,144 @@
+// Package claims defines the access token claims issued by the CMS and verified by every service.
+// Issuer and verifiers share this type so that they can never disagree on where the user ID lives.
+package claims
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Version is the version of the claim layout written into the "ver" claim.
+// Bump it when a claim changes meaning, and keep MinVersion at the oldest layout verifiers still accept.
+const (
+	Version    = 1
+	MinVersion = 1
+)
+
+// Defaults for the "iss" and "aud" claims
+const (
+	DefaultIssuer   = "learnvibe-cms"
+	DefaultAudience = "learnvibe-api"
+)
+
+// DefaultOrganizationID is the organization of single-school deployments. Everything created before
+// organizations existed belongs to it, and so do tokens without an "org" claim.
+var DefaultOrganizationID = uuid.MustParse("00000000-0000-0000-0000-000000000001")
+
+// ClockSkew is how far the clocks of the issuer and a verifier may drift apart
+const ClockSkew = 30 * time.Second
+
+// Algorithms access tokens may be signed with
+var SigningAlgorithms = []string{AlgorithmRS256, AlgorithmEdDSA}
+
+// Claim validation errors
+var (
+	ErrUnsupportedVersion = errors.New("unsupported token version")
+	ErrInvalidSubject     = errors.New("token subject is not a user ID")
+	ErrInvalidActor       = errors.New("token actor is not a user ID")
+)
+
+// AccessClaims are the claims of a LearnVibe access token. The user ID is always the "sub" claim.
+type AccessClaims struct {
+	Version int    `json:"ver"`
+	Name    string `json:"name,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Role    string `json:"role"`
+	// EmailVerified is false until the user confirmed their email address, which limits what they may do
+	EmailVerified bool `json:"email_verified,omitempty"`
+	// OrganizationID is the organization (school) the user belongs to; every service only shows them its data
+	OrganizationID string `json:"org,omitempty"`
+	// SessionID is the login the token was issued for; signing out of a session revokes its tokens everywhere
+	SessionID string `json:"sid,omitempty"`
+	// Actor is the admin impersonating the subject, only set on impersonation tokens
+	Actor *Actor `json:"act,omitempty"`
+	// ReadOnly tokens may only make safe requests, impersonation tokens are read-only unless the admin asked otherwise
+	ReadOnly bool `json:"read_only,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// NewAccessClaims builds the claims of a new access token for a user
+func NewAccessClaims(userID uuid.UUID, name, email, role, issuer, audience string, ttl time.Duration) *AccessClaims {
+	now := time.Now()
+	return &AccessClaims{
+		Version: Version,
+		Name:    name,
+		Email:   email,
+		Role:    role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Subject:   userID.String(),
+			Issuer:    issuer,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+}
+
+// UserID returns the user ID held in the subject
+func (c *AccessClaims) UserID() uuid.UUID {
+	id, _ := uuid.Parse(c.Subject)
+	return id
+}
+
+// OrgID returns the organization of the user, the default organization if the token has none
+func (c *AccessClaims) OrgID() uuid.UUID {
+	id, err := uuid.Parse(c.OrganizationID)
+	if err != nil || id == uuid.Nil {
+		return DefaultOrganizationID
+	}
+	return id
+}
+
+// IssuedAtTime returns when the token was issued, or the zero time if it doesn't say
+func (c *AccessClaims) IssuedAtTime() time.Time {
+	if c.IssuedAt == nil {
+		return time.Time{}
+	}
+	return c.IssuedAt.Time
+}
+
+// Validate is called by the JWT parser after the registered claims were checked
+func (c *AccessClaims) Validate() error {
+	if c.Version < MinVersion || c.Version > Version {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, c.Version)
+	}
+	if _, err := uuid.Parse(c.Subject); err != nil {
+		return ErrInvalidSubject
+	}
+	if c.Actor != nil {
+		if _, err := uuid.Parse(c.Actor.Subject); err != nil {
+			return ErrInvalidActor
+		}
+	}
+	return nil
+}
+
+// Verifier parses access tokens, checking the signature, issuer, audience and claim version
+type Verifier struct {
+	Keyfunc  jwt.Keyfunc
+	Issuer   string
+	Audience string
+}
+
+// Parse verifies an access token and returns its claims
+func (v Verifier) Parse(tokenString string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, v.Keyfunc,
+		jwt.WithValidMethods(SigningAlgorithms),
+		jwt.WithIssuer(v.Issuer),
+		jwt.WithAudience(v.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(ClockSkew),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
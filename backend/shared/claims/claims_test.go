@@ -0,0 +1,109 @@
+package claims
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifierParse(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	verifier := Verifier{
+		Keyfunc:  func(*jwt.Token) (interface{}, error) { return public, nil },
+		Issuer:   DefaultIssuer,
+		Audience: DefaultAudience,
+	}
+	userID := uuid.New()
+
+	sign := func(c jwt.Claims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, c).SignedString(private)
+		assert.NoError(t, err)
+		return token
+	}
+	valid := func() *AccessClaims {
+		return NewAccessClaims(userID, "Test User", "test@example.com", "student", DefaultIssuer, DefaultAudience, time.Hour)
+	}
+
+	tests := []struct {
+		name    string
+		token   func() string
+		wantErr bool
+	}{
+		{"valid token", func() string { return sign(valid()) }, false},
+		{"wrong issuer", func() string {
+			c := valid()
+			c.Issuer = "someone-else"
+			return sign(c)
+		}, true},
+		{"wrong audience", func() string {
+			c := valid()
+			c.Audience = jwt.ClaimStrings{"another-api"}
+			return sign(c)
+		}, true},
+		{"unsupported version", func() string {
+			c := valid()
+			c.Version = Version + 1
+			return sign(c)
+		}, true},
+		{"missing version", func() string {
+			c := valid()
+			c.Version = 0
+			return sign(c)
+		}, true},
+		{"user ID outside sub", func() string {
+			return sign(jwt.MapClaims{
+				"ver": Version, "user_id": userID.String(), "iss": DefaultIssuer, "aud": DefaultAudience,
+				"iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix(),
+			})
+		}, true},
+		{"expired", func() string {
+			return sign(NewAccessClaims(userID, "", "", "student", DefaultIssuer, DefaultAudience, -time.Minute))
+		}, true},
+		{"actor ID outside act.sub", func() string {
+			c := valid()
+			c.Actor = &Actor{Subject: "admin@example.com"}
+			return sign(c)
+		}, true},
+		{"HMAC signed", func() string {
+			token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, valid()).SignedString([]byte("secret"))
+			return token
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifier.Parse(tt.token())
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, userID, claims.UserID())
+			assert.Equal(t, "student", claims.Role)
+			assert.Equal(t, Version, claims.Version)
+			assert.NotEmpty(t, claims.ID)
+		})
+	}
+}
+
+func TestOrgID(t *testing.T) {
+	orgID := uuid.New()
+	c := NewAccessClaims(uuid.New(), "Test", "test@example.com", "student", DefaultIssuer, DefaultAudience, time.Hour)
+	assert.Equal(t, DefaultOrganizationID, c.OrgID())
+
+	c.OrganizationID = orgID.String()
+	assert.Equal(t, orgID, c.OrgID())
+
+	// A token that doesn't name a valid organization gets the default one, never no organization
+	c.OrganizationID = "not-a-uuid"
+	assert.Equal(t, DefaultOrganizationID, c.OrgID())
+	c.OrganizationID = uuid.Nil.String()
+	assert.Equal(t, DefaultOrganizationID, c.OrgID())
+}
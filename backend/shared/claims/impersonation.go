@@ -0,0 +1,51 @@
+package claims
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// Impersonation tokens last DefaultImpersonationMinutes unless the admin asks otherwise, and never
+// more than MaxImpersonationMinutes. They can't be refreshed.
+const (
+	DefaultImpersonationMinutes = 15
+	MaxImpersonationMinutes     = 60
+)
+
+// Actor is the "act" claim (RFC 8693) of an impersonation token: the admin who really makes the
+// requests on behalf of the subject
+type Actor struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+}
+
+// IsImpersonation checks if the token was minted for an admin acting as the subject
+func (c *AccessClaims) IsImpersonation() bool {
+	return c.Actor != nil
+}
+
+// ActorID returns the user ID of the impersonating admin, or uuid.Nil for ordinary tokens
+func (c *AccessClaims) ActorID() uuid.UUID {
+	if c.Actor == nil {
+		return uuid.Nil
+	}
+	id, _ := uuid.Parse(c.Actor.Subject)
+	return id
+}
+
+// AllowsRequest checks if the token may be used for a request. Impersonation tokens never reach
+// the /auth routes that change the account, its credentials or its tokens, and read-only ones only
+// make safe requests anywhere.
+func (c *AccessClaims) AllowsRequest(method, path string) bool {
+	if !c.IsImpersonation() {
+		return true
+	}
+	if method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions {
+		return true
+	}
+	if c.ReadOnly {
+		return false
+	}
+	return !matchesPathPrefix("/auth", path)
+}
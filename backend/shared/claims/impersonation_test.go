@@ -0,0 +1,57 @@
+package claims
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowsRequest(t *testing.T) {
+	adminID := uuid.New()
+	ordinary := NewAccessClaims(uuid.New(), "Test", "test@example.com", "student", DefaultIssuer, DefaultAudience, time.Hour)
+	impersonation := func(readOnly bool) *AccessClaims {
+		c := NewAccessClaims(uuid.New(), "Test", "test@example.com", "student", DefaultIssuer, DefaultAudience, time.Hour)
+		c.Actor = &Actor{Subject: adminID.String()}
+		c.ReadOnly = readOnly
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		claims *AccessClaims
+		method string
+		path   string
+		want   bool
+	}{
+		{"ordinary token writes", ordinary, http.MethodPost, "/api/courses/1/enroll", true},
+		{"ordinary token changes password", ordinary, http.MethodPut, "/auth/password", true},
+		{"read-only impersonation reads", impersonation(true), http.MethodGet, "/api/enrollments", true},
+		{"read-only impersonation reads the user", impersonation(true), http.MethodGet, "/auth/me", true},
+		{"read-only impersonation writes", impersonation(true), http.MethodPut, "/api/enrollments/1/progress", false},
+		{"read-only impersonation deletes content", impersonation(true), http.MethodDelete, "/api/content/1", false},
+		{"impersonation writes", impersonation(false), http.MethodPut, "/api/enrollments/1/progress", true},
+		{"impersonation changes password", impersonation(false), http.MethodPut, "/auth/password", false},
+		{"impersonation mints tokens", impersonation(false), http.MethodPost, "/auth/tokens", false},
+		{"impersonation deletes account", impersonation(false), http.MethodDelete, "/auth/me", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.claims.AllowsRequest(tt.method, tt.path))
+		})
+	}
+}
+
+func TestActorID(t *testing.T) {
+	c := NewAccessClaims(uuid.New(), "Test", "test@example.com", "student", DefaultIssuer, DefaultAudience, time.Hour)
+	assert.False(t, c.IsImpersonation())
+	assert.Equal(t, uuid.Nil, c.ActorID())
+
+	adminID := uuid.New()
+	c.Actor = &Actor{Subject: adminID.String()}
+	assert.True(t, c.IsImpersonation())
+	assert.Equal(t, adminID, c.ActorID())
+}
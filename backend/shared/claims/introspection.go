@@ -0,0 +1,132 @@
+package claims
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Introspection is the CMS's answer about a personal access token (RFC 7662). Only active tokens
+// carry the other fields.
+type Introspection struct {
+	Active        bool   `json:"active"`
+	Subject       string `json:"sub,omitempty"`
+	Role          string `json:"role,omitempty"`
+	EmailVerified bool   `json:"email_verified,omitempty"`
+	Organization  string `json:"org,omitempty"`
+	Scope         string `json:"scope,omitempty"` // Space-separated
+	TokenID       string `json:"token_id,omitempty"`
+	ExpiresAt     int64  `json:"exp,omitempty"`
+}
+
+// Scopes returns the granted scopes
+func (i *Introspection) Scopes() []string {
+	return strings.Fields(i.Scope)
+}
+
+// UserID returns the user ID held in the subject
+func (i *Introspection) UserID() uuid.UUID {
+	id, _ := uuid.Parse(i.Subject)
+	return id
+}
+
+// OrgID returns the organization of the user, the default organization if the answer has none
+func (i *Introspection) OrgID() uuid.UUID {
+	id, err := uuid.Parse(i.Organization)
+	if err != nil || id == uuid.Nil {
+		return DefaultOrganizationID
+	}
+	return id
+}
+
+// introspectionCacheEntry is a cached introspection result
+type introspectionCacheEntry struct {
+	result    Introspection
+	expiresAt time.Time
+}
+
+// IntrospectionClient asks the CMS about personal access tokens. Answers are cached for a short
+// time, so a revoked token keeps working for at most the cache TTL.
+type IntrospectionClient struct {
+	url        string
+	secret     string
+	cacheTTL   time.Duration
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	cache map[string]introspectionCacheEntry
+}
+
+// NewIntrospectionClient creates a new introspection client. secret authenticates the service to
+// the CMS introspection endpoint.
+func NewIntrospectionClient(introspectionURL, secret string, cacheTTL time.Duration) *IntrospectionClient {
+	return &IntrospectionClient{
+		url:        introspectionURL,
+		secret:     secret,
+		cacheTTL:   cacheTTL,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		cache:      make(map[string]introspectionCacheEntry),
+	}
+}
+
+// Introspect returns what the CMS knows about a personal access token
+func (c *IntrospectionClient) Introspect(ctx context.Context, token string) (*Introspection, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+
+	c.mu.Lock()
+	entry, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		result := entry.result
+		return &result, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+c.secret)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to introspect token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to introspect token: CMS answered %s", resp.Status)
+	}
+
+	var result Introspection
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode introspection: %v", err)
+	}
+	// An expiring token isn't cached past its expiry
+	expiresAt := time.Now().Add(c.cacheTTL)
+	if result.Active && result.ExpiresAt > 0 && time.Unix(result.ExpiresAt, 0).Before(expiresAt) {
+		expiresAt = time.Unix(result.ExpiresAt, 0)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.cache) > 10000 {
+		now := time.Now()
+		for k, e := range c.cache {
+			if now.After(e.expiresAt) {
+				delete(c.cache, k)
+			}
+		}
+	}
+	c.cache[key] = introspectionCacheEntry{result: result, expiresAt: expiresAt}
+	return &result, nil
+}
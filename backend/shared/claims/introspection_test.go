@@ -0,0 +1,53 @@
+package claims
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntrospectionClient(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		result := Introspection{Active: false}
+		if r.PostFormValue("token") == "lvp_valid" {
+			result = Introspection{Active: true, Subject: "2b1f5a8e-0d5c-4d0e-9a0a-8f0d6f7a9c11", Role: "instructor", Scope: "courses:read courses:write"}
+		}
+		_ = json.NewEncoder(w).Encode(result)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	client := NewIntrospectionClient(server.URL, "secret", time.Minute)
+
+	result, err := client.Introspect(ctx, "lvp_valid")
+	require.NoError(t, err)
+	assert.True(t, result.Active)
+	assert.Equal(t, "instructor", result.Role)
+	assert.Equal(t, []string{ScopeCoursesRead, ScopeCoursesWrite}, result.Scopes())
+	assert.Equal(t, "2b1f5a8e-0d5c-4d0e-9a0a-8f0d6f7a9c11", result.UserID().String())
+
+	result, err = client.Introspect(ctx, "lvp_unknown")
+	require.NoError(t, err)
+	assert.False(t, result.Active)
+
+	// Both answers are cached
+	_, _ = client.Introspect(ctx, "lvp_valid")
+	_, _ = client.Introspect(ctx, "lvp_unknown")
+	assert.Equal(t, 2, calls)
+
+	// A wrong secret is an error, not an inactive token
+	_, err = NewIntrospectionClient(server.URL, "wrong", time.Minute).Introspect(ctx, "lvp_valid")
+	assert.Error(t, err)
+}
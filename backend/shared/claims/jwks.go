@@ -0,0 +1,190 @@
+package claims
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing algorithms accepted for access tokens
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// ErrUnknownSigningKey is returned when a token's kid isn't in the JWKS, even after refetching it
+var ErrUnknownSigningKey = errors.New("unknown signing key")
+
+// JSONWebKey is the public part of a signing key as published by the CMS at /.well-known/jwks.json
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`   // RSA modulus
+	E         string `json:"e,omitempty"`   // RSA exponent
+	Curve     string `json:"crv,omitempty"` // OKP curve
+	X         string `json:"x,omitempty"`   // OKP public key
+}
+
+// JSONWebKeySet is a JWKS document
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// verificationKey is a parsed public key
+type verificationKey struct {
+	algorithm string
+	public    interface{}
+}
+
+// JWKSClient fetches and caches the CMS signing keys. The JWKS is refetched when the cache is
+// older than cacheTTL, and right away (at most every minRefetch) when a token carries a kid we
+// don't know yet, which is how newly rotated keys are picked up.
+type JWKSClient struct {
+	url        string
+	httpClient *http.Client
+	cacheTTL   time.Duration
+	minRefetch time.Duration
+
+	mu          sync.RWMutex
+	keys        map[string]verificationKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+}
+
+// NewJWKSClient creates a new JWKS client
+func NewJWKSClient(url string, cacheTTL time.Duration) *JWKSClient {
+	return &JWKSClient{
+		url:        url,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		cacheTTL:   cacheTTL,
+		minRefetch: 10 * time.Second,
+		keys:       make(map[string]verificationKey),
+	}
+}
+
+// Keyfunc returns the public key matching the token's kid, for use with jwt.Parse
+func (c *JWKSClient) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid")
+	}
+
+	key, ok := c.lookup(kid)
+	if !ok {
+		return nil, ErrUnknownSigningKey
+	}
+	if token.Method.Alg() != key.algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+// lookup finds a key by kid, refreshing the cache when needed
+func (c *JWKSClient) lookup(kid string) (verificationKey, bool) {
+	c.mu.RLock()
+	key, ok := c.keys[kid]
+	fresh := time.Since(c.fetchedAt) < c.cacheTTL
+	c.mu.RUnlock()
+	if ok && fresh {
+		return key, true
+	}
+
+	if err := c.Refresh(context.Background()); err != nil {
+		// Keep using the keys we have if the CMS is unreachable
+		log.Printf("Warning: Failed to refresh JWKS: %v", err)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	key, ok = c.keys[kid]
+	return key, ok
+}
+
+// Refresh fetches the JWKS again, unless it was attempted very recently
+func (c *JWKSClient) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	if time.Since(c.lastAttempt) < c.minRefetch {
+		c.mu.Unlock()
+		return nil
+	}
+	c.lastAttempt = time.Now()
+	c.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("JWKS endpoint returned %d", resp.StatusCode)
+	}
+
+	var set JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("failed to decode JWKS: %v", err)
+	}
+
+	keys := make(map[string]verificationKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		key, err := parseJWK(jwk)
+		if err != nil {
+			log.Printf("Warning: Skipping JWKS key %q: %v", jwk.KeyID, err)
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys = keys
+	c.fetchedAt = time.Now()
+	return nil
+}
+
+// PublicKey returns the public key the JWK describes
+func (k JSONWebKey) PublicKey() (interface{}, error) {
+	key, err := parseJWK(k)
+	return key.public, err
+}
+
+// parseJWK turns an RSA or Ed25519 JWK into a public key
+func parseJWK(jwk JSONWebKey) (verificationKey, error) {
+	switch {
+	case jwk.KeyType == "RSA" && jwk.Algorithm == AlgorithmRS256:
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return verificationKey{}, fmt.Errorf("invalid modulus: %v", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return verificationKey{}, fmt.Errorf("invalid exponent: %v", err)
+		}
+		public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return verificationKey{algorithm: AlgorithmRS256, public: public}, nil
+	case jwk.KeyType == "OKP" && jwk.Curve == "Ed25519" && jwk.Algorithm == AlgorithmEdDSA:
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return verificationKey{}, errors.New("invalid Ed25519 public key")
+		}
+		return verificationKey{algorithm: AlgorithmEdDSA, public: ed25519.PublicKey(x)}, nil
+	default:
+		return verificationKey{}, fmt.Errorf("unsupported key type %s/%s", jwk.KeyType, jwk.Algorithm)
+	}
+}
@@ -0,0 +1,54 @@
+package claims
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWKSClientKeyfunc(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_ = json.NewEncoder(w).Encode(JSONWebKeySet{Keys: []JSONWebKey{{
+			KeyType:   "OKP",
+			KeyID:     "key-1",
+			Use:       "sig",
+			Algorithm: AlgorithmEdDSA,
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(public),
+		}}})
+	}))
+	defer server.Close()
+
+	client := NewJWKSClient(server.URL, time.Hour)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{"sub": "user"})
+	token.Header["kid"] = "key-1"
+	signed, err := token.SignedString(private)
+	require.NoError(t, err)
+
+	parsed, err := jwt.Parse(signed, client.Keyfunc, jwt.WithValidMethods(SigningAlgorithms))
+	require.NoError(t, err)
+	assert.True(t, parsed.Valid)
+	assert.Equal(t, 1, calls)
+
+	// A kid that isn't published is rejected
+	token.Header["kid"] = "key-2"
+	signed, err = token.SignedString(private)
+	require.NoError(t, err)
+	_, err = jwt.Parse(signed, client.Keyfunc, jwt.WithValidMethods(SigningAlgorithms))
+	assert.ErrorIs(t, err, ErrUnknownSigningKey)
+}
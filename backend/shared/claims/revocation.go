@@ -0,0 +1,190 @@
+package claims
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis key prefixes of the token revocation list, shared by every service
+const (
+	revokedTokenPrefix   = "revoked:jti:"
+	revokedSessionPrefix = "revoked:sid:"
+	revokedUserPrefix    = "revoked:user:"
+)
+
+// revocationCacheEntry is a cached revocation lookup
+type revocationCacheEntry struct {
+	value     int64 // 1 for a revoked token or session, the cutoff time for a user, 0 when not revoked
+	expiresAt time.Time
+}
+
+// RevocationList keeps the list of revoked access tokens in Redis.
+// Single tokens are revoked by their jti and sessions by their sid; revoking a user revokes every
+// token issued to them up to now. Lookups are cached locally for a short time so that not every request hits Redis.
+// When Redis can't be reached, failClosed decides whether tokens are treated as revoked or as valid.
+type RevocationList struct {
+	redis      *redis.Client
+	cacheTTL   time.Duration
+	failClosed bool
+
+	mu    sync.Mutex
+	cache map[string]revocationCacheEntry
+}
+
+// NewRevocationList creates a new token revocation list.
+// With a nil Redis client only revocations made by this process are seen. With failClosed every
+// token is rejected while Redis lookups fail, otherwise the failure is logged and tokens are let through.
+func NewRevocationList(rdb *redis.Client, cacheTTL time.Duration, failClosed bool) *RevocationList {
+	return &RevocationList{
+		redis:      rdb,
+		cacheTTL:   cacheTTL,
+		failClosed: failClosed,
+		cache:      make(map[string]revocationCacheEntry),
+	}
+}
+
+// RevokeToken revokes a single token until it expires
+func (l *RevocationList) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if jti == "" || ttl <= 0 {
+		return nil
+	}
+
+	l.store(revokedTokenPrefix+jti, 1)
+	if l.redis == nil {
+		return nil
+	}
+	if err := l.redis.Set(ctx, revokedTokenPrefix+jti, 1, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to revoke token: %v", err)
+	}
+	return nil
+}
+
+// RevokeSession revokes every token issued for a session (one login). maxTokenTTL is the longest
+// lifetime of an access token, after which the entry is no longer needed.
+func (l *RevocationList) RevokeSession(ctx context.Context, sessionID string, maxTokenTTL time.Duration) error {
+	if sessionID == "" {
+		return nil
+	}
+
+	l.store(revokedSessionPrefix+sessionID, 1)
+	if l.redis == nil {
+		return nil
+	}
+	if err := l.redis.Set(ctx, revokedSessionPrefix+sessionID, 1, maxTokenTTL).Err(); err != nil {
+		return fmt.Errorf("failed to revoke session: %v", err)
+	}
+	return nil
+}
+
+// RevokeUser revokes every token issued to the user until now. maxTokenTTL is the longest
+// lifetime of an access token, after which the entry is no longer needed.
+func (l *RevocationList) RevokeUser(ctx context.Context, userID string, maxTokenTTL time.Duration) error {
+	cutoff := time.Now().Unix()
+
+	l.store(revokedUserPrefix+userID, cutoff)
+	if l.redis == nil {
+		return nil
+	}
+	if err := l.redis.Set(ctx, revokedUserPrefix+userID, cutoff, maxTokenTTL).Err(); err != nil {
+		return fmt.Errorf("failed to revoke user tokens: %v", err)
+	}
+	return nil
+}
+
+// IsRevoked checks if a token was revoked, either by its jti, because its session was signed out or
+// because all tokens of its user were. When Redis can't be read the failure is logged and the
+// token counts as revoked if the list fails closed.
+func (l *RevocationList) IsRevoked(ctx context.Context, jti, sessionID, userID string, issuedAt time.Time) bool {
+	revoked, err := l.isRevoked(ctx, jti, sessionID, userID, issuedAt)
+	if err != nil {
+		if l.failClosed {
+			log.Printf("Warning: Failed to check token revocation, rejecting the token: %v", err)
+			return true
+		}
+		log.Printf("Warning: Failed to check token revocation, accepting the token: %v", err)
+		return false
+	}
+	return revoked
+}
+
+// isRevoked runs the lookups behind IsRevoked and stops at the first Redis error
+func (l *RevocationList) isRevoked(ctx context.Context, jti, sessionID, userID string, issuedAt time.Time) (bool, error) {
+	if jti != "" {
+		value, err := l.lookup(ctx, revokedTokenPrefix+jti)
+		if err != nil || value != 0 {
+			return value != 0, err
+		}
+	}
+	if sessionID != "" {
+		value, err := l.lookup(ctx, revokedSessionPrefix+sessionID)
+		if err != nil || value != 0 {
+			return value != 0, err
+		}
+	}
+	if userID != "" {
+		cutoff, err := l.lookup(ctx, revokedUserPrefix+userID)
+		if err != nil {
+			return false, err
+		}
+		if cutoff != 0 && !issuedAt.After(time.Unix(cutoff, 0)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// lookup reads a revocation entry from the local cache, falling back to Redis. Failed Redis
+// lookups aren't cached.
+func (l *RevocationList) lookup(ctx context.Context, key string) (int64, error) {
+	l.mu.Lock()
+	entry, ok := l.cache[key]
+	l.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.value, nil
+	}
+
+	if l.redis == nil {
+		return 0, nil
+	}
+
+	value, err := l.redis.Get(ctx, key).Result()
+	if err == redis.Nil {
+		l.store(key, 0)
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid revocation entry %s: %v", key, err)
+	}
+	l.store(key, parsed)
+	return parsed, nil
+}
+
+// store caches a revocation entry
+func (l *RevocationList) store(key string, value int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.cache[key] = revocationCacheEntry{value: value, expiresAt: now.Add(l.cacheTTL)}
+
+	// Drop stale entries now and then so the cache doesn't grow forever
+	if len(l.cache) > 10000 {
+		for k, e := range l.cache {
+			if now.After(e.expiresAt) {
+				delete(l.cache, k)
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package claims
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevocationListLocal(t *testing.T) {
+	ctx := context.Background()
+	list := NewRevocationList(nil, time.Minute, true)
+	issuedAt := time.Now().Add(-time.Minute)
+
+	assert.False(t, list.IsRevoked(ctx, "jti-1", "sid-1", "user-1", issuedAt))
+
+	require.NoError(t, list.RevokeToken(ctx, "jti-1", time.Now().Add(time.Hour)))
+	assert.True(t, list.IsRevoked(ctx, "jti-1", "", "", issuedAt))
+
+	require.NoError(t, list.RevokeSession(ctx, "sid-1", time.Hour))
+	assert.True(t, list.IsRevoked(ctx, "jti-2", "sid-1", "", issuedAt))
+
+	require.NoError(t, list.RevokeUser(ctx, "user-1", time.Hour))
+	assert.True(t, list.IsRevoked(ctx, "jti-2", "sid-2", "user-1", issuedAt))
+	assert.False(t, list.IsRevoked(ctx, "jti-2", "sid-2", "user-1", time.Now().Add(time.Minute)))
+}
+
+// TestRevocationListRedisDown tests that failClosed decides what happens when Redis can't be read
+func TestRevocationListRedisDown(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", DialTimeout: 100 * time.Millisecond, MaxRetries: -1})
+	defer rdb.Close()
+	issuedAt := time.Now()
+
+	assert.True(t, NewRevocationList(rdb, time.Minute, true).IsRevoked(ctx, "jti-1", "sid-1", "user-1", issuedAt))
+	assert.False(t, NewRevocationList(rdb, time.Minute, false).IsRevoked(ctx, "jti-1", "sid-1", "user-1", issuedAt))
+}
@@ -0,0 +1,52 @@
+package claims
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequiredScope(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		scope  string
+		ok     bool
+	}{
+		{http.MethodGet, "/api/courses", ScopeCoursesRead, true},
+		{http.MethodGet, "/api/courses/12/cohorts", ScopeCoursesRead, true},
+		{http.MethodPost, "/api/courses", ScopeCoursesWrite, true},
+		{http.MethodPost, "/api/courses/12/enroll", ScopeEnrollmentsWrite, true},
+		{http.MethodGet, "/api/courses/12/enrollments", ScopeEnrollmentsRead, true},
+		{http.MethodPost, "/api/courses/12/checkout", ScopeOrdersWrite, true},
+		{http.MethodPut, "/api/enrollments/abc/progress", ScopeEnrollmentsWrite, true},
+		{http.MethodGet, "/api/orders", ScopeOrdersRead, true},
+		{http.MethodGet, "/api/content/abc/download", ScopeContentRead, true},
+		{http.MethodGet, "/public/content/abc", ScopeContentRead, true},
+		{http.MethodDelete, "/api/admin/coupons/1", ScopeAdminWrite, true},
+		{http.MethodGet, "/api/coursesx", "", false},
+		{http.MethodGet, "/api/instructor-applications/mine", "", false},
+		{http.MethodPost, "/auth/tokens", "", false},
+		{http.MethodGet, "/auth/me", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			scope, ok := RequiredScope(tt.method, tt.path)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.scope, scope)
+			if ok {
+				assert.True(t, IsValidScope(scope))
+			}
+		})
+	}
+}
+
+func TestPersonalAccessTokenHelpers(t *testing.T) {
+	assert.True(t, IsPersonalAccessToken("lvp_abc"))
+	assert.False(t, IsPersonalAccessToken("eyJhbGciOiJSUzI1NiJ9.e30.sig"))
+
+	assert.False(t, IsValidScope("courses:delete"))
+	assert.True(t, HasScope([]string{ScopeCoursesRead, ScopeOrdersRead}, ScopeOrdersRead))
+	assert.False(t, HasScope([]string{ScopeCoursesWrite}, ScopeCoursesRead))
+}
@@ -0,0 +1,194 @@
+// Package policy decides what users may do. Every role's permissions are declared in one place,
+// and permissions on a single resource can be limited to the users it belongs to.
+package policy
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// ErrForbidden is returned when the policy doesn't allow an action
+var ErrForbidden = errors.New("not allowed")
+
+// Roles, the same as the CMS user roles and the role claim of access tokens
+const (
+	RoleStudent    = "student"
+	RoleInstructor = "instructor"
+	RoleOrgAdmin   = "org_admin" // Runs one organization
+	RoleAdmin      = "admin"     // Runs the platform, across organizations
+)
+
+// Action is something a user does, named resource:verb
+type Action string
+
+// Actions checked by the CMS and content-delivery. Reading courses, enrolling and other actions
+// every authenticated user may take aren't listed.
+const (
+	CourseCreate          Action = "course:create"
+	CourseUpdate          Action = "course:update" // Includes adding and removing course contents
+	CourseDelete          Action = "course:delete"
+	CourseReadEnrollments Action = "course:read-enrollments"
+	CohortManage          Action = "cohort:manage" // Cohorts and moving students between them
+	CohortTeach           Action = "cohort:teach"  // Due dates, gradebook and grades
+	EnrollmentRead        Action = "enrollment:read"
+	EnrollmentUpdate      Action = "enrollment:update" // Progress and dropping the course
+	OrderRead             Action = "order:read"
+	ContentUpload         Action = "content:upload"
+	ContentDelete         Action = "content:delete"
+	ContentReadAnyCourse  Action = "content:read-any-course" // Content of courses without a current enrollment
+	OrganizationManage    Action = "organization:manage"     // The users of the organization
+	AdminAccess           Action = "admin:access"            // Everything under /api/admin
+)
+
+// Subject is the user performing an action
+type Subject struct {
+	ID             uuid.UUID
+	Role           string
+	OrganizationID uuid.UUID
+}
+
+// Resource describes who the resource an action is performed on belongs to
+type Resource struct {
+	OwnerID        uuid.UUID   // Course creator, enrolled student, buyer or uploader
+	ManagerIDs     []uuid.UUID // Users who look after the resource without owning it, such as cohort instructors
+	OrganizationID uuid.UUID   // Organization the resource belongs to
+}
+
+// OwnedBy returns a resource belonging to a user
+func OwnedBy(ownerID uuid.UUID) Resource {
+	return Resource{OwnerID: ownerID}
+}
+
+// ManagedBy returns the resource with further users looking after it
+func (r Resource) ManagedBy(managerIDs ...uuid.UUID) Resource {
+	r.ManagerIDs = append(append([]uuid.UUID{}, r.ManagerIDs...), managerIDs...)
+	return r
+}
+
+// InOrganization returns the resource as part of an organization
+func (r Resource) InOrganization(organizationID uuid.UUID) Resource {
+	r.OrganizationID = organizationID
+	return r
+}
+
+// Condition limits a permission to some resources
+type Condition func(subject Subject, resource Resource) bool
+
+// IsOwner allows the action on the subject's own resources
+func IsOwner(subject Subject, resource Resource) bool {
+	return subject.ID != uuid.Nil && subject.ID == resource.OwnerID
+}
+
+// IsOwnerOrManager allows the action on resources the subject owns or looks after
+func IsOwnerOrManager(subject Subject, resource Resource) bool {
+	if IsOwner(subject, resource) {
+		return true
+	}
+	for _, id := range resource.ManagerIDs {
+		if subject.ID != uuid.Nil && subject.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// InSameOrganization allows the action on resources of the subject's organization
+func InSameOrganization(subject Subject, resource Resource) bool {
+	return subject.OrganizationID != uuid.Nil && subject.OrganizationID == resource.OrganizationID
+}
+
+// Permission allows an action, on every resource or only on those its condition holds for
+type Permission struct {
+	Action Action
+	When   Condition // nil allows the action on every resource
+}
+
+// Policy maps every role to its permissions
+type Policy map[string][]Permission
+
+// Default is the LearnVibe policy
+var Default = Policy{
+	RoleStudent: {
+		{Action: EnrollmentRead, When: IsOwner},
+		{Action: EnrollmentUpdate, When: IsOwner},
+		{Action: OrderRead, When: IsOwner},
+	},
+	RoleInstructor: {
+		{Action: CourseCreate},
+		{Action: CourseUpdate, When: IsOwner},
+		{Action: CourseDelete, When: IsOwner},
+		{Action: CourseReadEnrollments, When: IsOwner},
+		{Action: CohortManage, When: IsOwner},
+		{Action: CohortTeach, When: IsOwnerOrManager},
+		{Action: EnrollmentRead, When: IsOwnerOrManager},
+		{Action: EnrollmentUpdate, When: IsOwner},
+		{Action: OrderRead, When: IsOwner},
+		{Action: ContentUpload},
+		{Action: ContentDelete, When: IsOwner},
+		{Action: ContentReadAnyCourse},
+	},
+	RoleOrgAdmin: {
+		{Action: CourseCreate},
+		{Action: CourseUpdate, When: InSameOrganization},
+		{Action: CourseDelete, When: InSameOrganization},
+		{Action: CourseReadEnrollments, When: InSameOrganization},
+		{Action: CohortManage, When: InSameOrganization},
+		{Action: CohortTeach, When: InSameOrganization},
+		{Action: EnrollmentRead, When: InSameOrganization},
+		{Action: EnrollmentUpdate, When: IsOwner},
+		{Action: OrderRead, When: InSameOrganization},
+		{Action: ContentUpload},
+		{Action: ContentDelete, When: InSameOrganization},
+		{Action: ContentReadAnyCourse},
+		{Action: OrganizationManage},
+	},
+	RoleAdmin: {
+		{Action: CourseCreate},
+		{Action: CourseUpdate},
+		{Action: CourseDelete},
+		{Action: CourseReadEnrollments},
+		{Action: CohortManage},
+		{Action: CohortTeach},
+		{Action: EnrollmentRead},
+		{Action: EnrollmentUpdate, When: IsOwner},
+		{Action: OrderRead},
+		{Action: ContentUpload},
+		{Action: ContentDelete},
+		{Action: ContentReadAnyCourse},
+		{Action: OrganizationManage},
+		{Action: AdminAccess},
+	},
+}
+
+// Authorize checks if the subject may perform the action on the resource
+func (p Policy) Authorize(subject Subject, action Action, resource Resource) error {
+	for _, permission := range p[subject.Role] {
+		if permission.Action == action && (permission.When == nil || permission.When(subject, resource)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q can't %s", ErrForbidden, subject.Role, action)
+}
+
+// Grants checks if a role may perform the action on at least some resources. Routes use it to
+// turn away roles that can never perform their action before loading anything.
+func (p Policy) Grants(role string, action Action) bool {
+	for _, permission := range p[role] {
+		if permission.Action == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize checks the action against the default policy
+func Authorize(subject Subject, action Action, resource Resource) error {
+	return Default.Authorize(subject, action, resource)
+}
+
+// Grants checks the role against the default policy
+func Grants(role string, action Action) bool {
+	return Default.Grants(role, action)
+}
@@ -0,0 +1,89 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorize(t *testing.T) {
+	owner := uuid.New()
+	manager := uuid.New()
+	other := uuid.New()
+	resource := OwnedBy(owner).ManagedBy(manager)
+
+	tests := []struct {
+		name    string
+		subject Subject
+		action  Action
+		allowed bool
+	}{
+		{"instructor creates a course", Subject{ID: other, Role: RoleInstructor}, CourseCreate, true},
+		{"student can't create a course", Subject{ID: other, Role: RoleStudent}, CourseCreate, false},
+		{"instructor updates their course", Subject{ID: owner, Role: RoleInstructor}, CourseUpdate, true},
+		{"instructor can't update another's course", Subject{ID: other, Role: RoleInstructor}, CourseUpdate, false},
+		{"admin updates any course", Subject{ID: other, Role: RoleAdmin}, CourseUpdate, true},
+		{"cohort instructor teaches", Subject{ID: manager, Role: RoleInstructor}, CohortTeach, true},
+		{"cohort instructor can't manage the cohorts", Subject{ID: manager, Role: RoleInstructor}, CohortManage, false},
+		{"student reads their enrollment", Subject{ID: owner, Role: RoleStudent}, EnrollmentRead, true},
+		{"student can't read an enrollment they manage", Subject{ID: manager, Role: RoleStudent}, EnrollmentRead, false},
+		{"admin can't update another's progress", Subject{ID: other, Role: RoleAdmin}, EnrollmentUpdate, false},
+		{"admin reads any order", Subject{ID: other, Role: RoleAdmin}, OrderRead, true},
+		{"instructor can't use the admin routes", Subject{ID: owner, Role: RoleInstructor}, AdminAccess, false},
+		{"unknown role", Subject{ID: owner, Role: "superuser"}, EnrollmentRead, false},
+		{"anonymous subject owns nothing", Subject{Role: RoleStudent}, OrderRead, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Authorize(tt.subject, tt.action, resource)
+			if tt.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, ErrForbidden))
+			}
+		})
+	}
+
+	// A resource without an owner belongs to nobody
+	assert.Error(t, Authorize(Subject{Role: RoleInstructor}, CourseUpdate, Resource{}))
+}
+
+func TestGrants(t *testing.T) {
+	assert.True(t, Grants(RoleInstructor, CourseUpdate))
+	assert.True(t, Grants(RoleStudent, OrderRead))
+	assert.False(t, Grants(RoleStudent, ContentUpload))
+	assert.False(t, Grants(RoleInstructor, AdminAccess))
+	assert.True(t, Grants(RoleAdmin, AdminAccess))
+	assert.False(t, Grants("", CourseCreate))
+}
+
+func TestManagedByDoesNotShareManagers(t *testing.T) {
+	base := OwnedBy(uuid.New()).ManagedBy(uuid.New())
+	first := base.ManagedBy(uuid.New())
+	second := base.ManagedBy(uuid.New())
+	assert.NotEqual(t, first.ManagerIDs[1], second.ManagerIDs[1])
+	assert.Len(t, base.ManagerIDs, 1)
+}
+
+func TestAuthorizeOrganizationAdmin(t *testing.T) {
+	school := uuid.New()
+	otherSchool := uuid.New()
+	course := OwnedBy(uuid.New()).InOrganization(school)
+
+	orgAdmin := Subject{ID: uuid.New(), Role: RoleOrgAdmin, OrganizationID: school}
+	assert.NoError(t, Authorize(orgAdmin, CourseUpdate, course))
+	assert.NoError(t, Authorize(orgAdmin, EnrollmentRead, course))
+	assert.True(t, Grants(RoleOrgAdmin, OrganizationManage))
+
+	// Nothing of another organization, and nothing of the platform
+	outsider := Subject{ID: uuid.New(), Role: RoleOrgAdmin, OrganizationID: otherSchool}
+	assert.Error(t, Authorize(outsider, CourseUpdate, course))
+	assert.Error(t, Authorize(outsider, OrderRead, course))
+	assert.Error(t, Authorize(Subject{ID: uuid.New(), Role: RoleOrgAdmin}, CourseUpdate, OwnedBy(uuid.New())))
+	assert.False(t, Grants(RoleOrgAdmin, AdminAccess))
+
+	// Platform admins aren't limited to an organization
+	assert.NoError(t, Authorize(Subject{ID: uuid.New(), Role: RoleAdmin, OrganizationID: otherSchool}, CourseUpdate, course))
+}